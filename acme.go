@@ -0,0 +1,785 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// acmeServer is a minimal RFC 8555 ACME server backed by the local CA. All
+// state except issued certificates is kept in memory, and challenges are
+// approved automatically for any name allowed by acmeAllow.
+type acmeServer struct {
+	m *mkcert
+
+	mu       sync.Mutex
+	nonces   map[string]bool
+	accounts map[string]*acmeAccount // by ID
+	orders   map[string]*acmeOrder   // by ID
+	certs    map[string][]byte       // PEM chains by order ID
+	issued   map[string]string       // account IDs by certificate serial
+}
+
+type acmeAccount struct {
+	id         string
+	thumbprint string
+	key        crypto.PublicKey
+	contact    []string
+}
+
+type acmeIdentifier struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type acmeOrder struct {
+	id          string
+	accountID   string
+	identifiers []acmeIdentifier
+	status      string
+	expires     time.Time
+}
+
+type acmeProblem struct {
+	Type   string `json:"type"`
+	Detail string `json:"detail"`
+	status int
+}
+
+func (p *acmeProblem) Error() string { return p.Detail }
+
+func acmeError(typ string, status int, format string, v ...interface{}) *acmeProblem {
+	return &acmeProblem{Type: "urn:ietf:params:acme:error:" + typ, Detail: fmt.Sprintf(format, v...), status: status}
+}
+
+func (m *mkcert) serveACME() {
+	if _, key := m.issuer(); key == nil {
+		fatalCode(exitFailure, "ca_key_missing", "ERROR: can't issue certificates because the CA key (rootCA-key.pem) is missing")
+	}
+
+	s := &acmeServer{
+		m:        m,
+		nonces:   make(map[string]bool),
+		accounts: make(map[string]*acmeAccount),
+		orders:   make(map[string]*acmeOrder),
+		certs:    make(map[string][]byte),
+		issued:   make(map[string]string),
+	}
+
+	addr := m.listenAddr
+	if addr == "" {
+		addr = "127.0.0.1:14000"
+	}
+	if !isLoopbackAddr(addr) && len(m.acmeAllow) == 0 {
+		log.Fatalf("ERROR: -acme issues certificates for any name without challenges, so it can only listen on %q with -acme-allow", addr)
+	}
+	srv := &http.Server{
+		Addr:    addr,
+		Handler: s,
+		TLSConfig: &tls.Config{
+			Certificates: []tls.Certificate{m.tlsCertificate([]string{"localhost", "127.0.0.1", "::1"})},
+		},
+	}
+
+	log.Printf("Serving an ACME directory for the local CA at %sdirectory 📡", serveURL("localhost", addr))
+	if len(m.acmeAllow) > 0 {
+		log.Printf("Only certificates for %q will be issued ℹ️", m.acmeAllow)
+	} else {
+		log.Printf("Certificates will be issued for any name, use -acme-allow to restrict them ℹ️")
+	}
+	fatalIfErr(srv.ListenAndServeTLS("", ""), "failed to run the ACME server")
+}
+
+// isLoopbackAddr reports whether addr only listens on the loopback interface.
+// An empty host listens on all the interfaces.
+func isLoopbackAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func (s *acmeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	base := "https://" + r.Host + "/"
+	w.Header().Set("Link", fmt.Sprintf("<%sdirectory>;rel=\"index\"", base))
+	w.Header().Set("Cache-Control", "no-store")
+
+	if r.URL.Path == "/directory" {
+		s.writeJSON(w, http.StatusOK, map[string]interface{}{
+			"newNonce":   base + "new-nonce",
+			"newAccount": base + "new-account",
+			"newOrder":   base + "new-order",
+			"revokeCert": base + "revoke-cert",
+			"keyChange":  base + "key-change",
+		})
+		return
+	}
+	if r.URL.Path == "/new-nonce" {
+		w.Header().Set("Replay-Nonce", s.newNonce())
+		if r.Method == http.MethodHead {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(http.StatusNoContent)
+		}
+		return
+	}
+	if r.Method != http.MethodPost {
+		s.writeProblem(w, acmeError("malformed", http.StatusMethodNotAllowed, "only POST is allowed"))
+		return
+	}
+
+	payload, account, jwk, err := s.verifyJWS(r, base)
+	if err != nil {
+		s.writeProblem(w, err)
+		return
+	}
+
+	dir, id := path.Split(r.URL.Path)
+	switch {
+	case r.URL.Path == "/new-account":
+		err = s.newAccount(w, base, payload, jwk)
+	case account == nil:
+		err = acmeError("malformed", http.StatusBadRequest, "requests must be signed with an account key ID")
+	case r.URL.Path == "/new-order":
+		err = s.newOrder(w, base, account, payload)
+	case r.URL.Path == "/revoke-cert":
+		err = s.revokeCert(w, account, payload)
+	case dir == "/account/":
+		err = s.writeAccount(w, base, account, id)
+	case dir == "/order/":
+		err = s.writeOrder(w, http.StatusOK, base, account, id)
+	case dir == "/authz/":
+		err = s.writeAuthz(w, base, account, id)
+	case dir == "/chall/":
+		err = s.writeChallenge(w, base, account, id)
+	case dir == "/finalize/":
+		err = s.finalize(w, base, account, id, payload)
+	case dir == "/cert/":
+		err = s.writeCert(w, account, id)
+	default:
+		err = acmeError("malformed", http.StatusNotFound, "unknown resource %q", r.URL.Path)
+	}
+	if err != nil {
+		s.writeProblem(w, err)
+	}
+}
+
+func (s *acmeServer) newNonce() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	nonce := randomToken()
+	s.nonces[nonce] = true
+	return nonce
+}
+
+// randomToken returns a random hex string, used for nonces and object IDs.
+// Hex is a subset of the base64url alphabet required for nonces, and never
+// includes the dashes used to separate ID components.
+func randomToken() string {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	fatalIfErr(err, "failed to generate random token")
+	return hex.EncodeToString(b)
+}
+
+func (s *acmeServer) writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Replay-Nonce", s.newNonce())
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func (s *acmeServer) writeProblem(w http.ResponseWriter, err error) {
+	var p *acmeProblem
+	if !errors.As(err, &p) {
+		p = acmeError("serverInternal", http.StatusInternalServerError, "%s", err)
+	}
+	verbosef("ACME error: %s", p.Detail)
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.Header().Set("Replay-Nonce", s.newNonce())
+	w.WriteHeader(p.status)
+	json.NewEncoder(w).Encode(p)
+}
+
+type jwsMessage struct {
+	Protected string `json:"protected"`
+	Payload   string `json:"payload"`
+	Signature string `json:"signature"`
+}
+
+type jwsHeader struct {
+	Alg   string          `json:"alg"`
+	Nonce string          `json:"nonce"`
+	URL   string          `json:"url"`
+	KID   string          `json:"kid"`
+	JWK   json.RawMessage `json:"jwk"`
+}
+
+// verifyJWS checks the signature, nonce and URL of a flattened JWS request,
+// returning its payload and either the account it's signed by, or the
+// embedded key for new-account requests.
+func (s *acmeServer) verifyJWS(r *http.Request, base string) ([]byte, *acmeAccount, crypto.PublicKey, error) {
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		return nil, nil, nil, acmeError("malformed", http.StatusBadRequest, "failed to read request")
+	}
+	var msg jwsMessage
+	if err := json.Unmarshal(body, &msg); err != nil {
+		return nil, nil, nil, acmeError("malformed", http.StatusBadRequest, "invalid JWS: %s", err)
+	}
+	headerJSON, err := base64.RawURLEncoding.DecodeString(msg.Protected)
+	if err != nil {
+		return nil, nil, nil, acmeError("malformed", http.StatusBadRequest, "invalid JWS protected header")
+	}
+	var header jwsHeader
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		return nil, nil, nil, acmeError("malformed", http.StatusBadRequest, "invalid JWS protected header: %s", err)
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(msg.Payload)
+	if err != nil {
+		return nil, nil, nil, acmeError("malformed", http.StatusBadRequest, "invalid JWS payload")
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(msg.Signature)
+	if err != nil {
+		return nil, nil, nil, acmeError("malformed", http.StatusBadRequest, "invalid JWS signature")
+	}
+
+	s.mu.Lock()
+	validNonce := s.nonces[header.Nonce]
+	delete(s.nonces, header.Nonce)
+	s.mu.Unlock()
+	if !validNonce {
+		return nil, nil, nil, acmeError("badNonce", http.StatusBadRequest, "invalid or reused nonce")
+	}
+	if header.URL != base+strings.TrimPrefix(r.URL.Path, "/") {
+		return nil, nil, nil, acmeError("unauthorized", http.StatusUnauthorized, "JWS url %q doesn't match the request", header.URL)
+	}
+
+	var account *acmeAccount
+	var key crypto.PublicKey
+	switch {
+	case header.KID != "" && len(header.JWK) == 0:
+		s.mu.Lock()
+		account = s.accounts[strings.TrimPrefix(header.KID, base+"account/")]
+		s.mu.Unlock()
+		if account == nil {
+			return nil, nil, nil, acmeError("accountDoesNotExist", http.StatusBadRequest, "unknown account %q", header.KID)
+		}
+		key = account.key
+	case header.KID == "" && len(header.JWK) != 0:
+		key, err = parseJWK(header.JWK)
+		if err != nil {
+			return nil, nil, nil, acmeError("badPublicKey", http.StatusBadRequest, "%s", err)
+		}
+	default:
+		return nil, nil, nil, acmeError("malformed", http.StatusBadRequest, "exactly one of jwk and kid must be set")
+	}
+
+	if err := verifyJWSSignature(header.Alg, key, []byte(msg.Protected+"."+msg.Payload), sig); err != nil {
+		return nil, nil, nil, acmeError("malformed", http.StatusBadRequest, "%s", err)
+	}
+	return payload, account, key, nil
+}
+
+func parseJWK(data []byte) (crypto.PublicKey, error) {
+	var jwk struct {
+		Kty string `json:"kty"`
+		Crv string `json:"crv"`
+		X   string `json:"x"`
+		Y   string `json:"y"`
+		N   string `json:"n"`
+		E   string `json:"e"`
+	}
+	if err := json.Unmarshal(data, &jwk); err != nil {
+		return nil, err
+	}
+	decode := func(s string) *big.Int {
+		b, _ := base64.RawURLEncoding.DecodeString(s)
+		return new(big.Int).SetBytes(b)
+	}
+	switch jwk.Kty {
+	case "RSA":
+		return &rsa.PublicKey{N: decode(jwk.N), E: int(decode(jwk.E).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", jwk.Crv)
+		}
+		pub := &ecdsa.PublicKey{Curve: curve, X: decode(jwk.X), Y: decode(jwk.Y)}
+		if !curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, errors.New("invalid EC public key")
+		}
+		return pub, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", jwk.Kty)
+	}
+}
+
+func verifyJWSSignature(alg string, key crypto.PublicKey, signed, sig []byte) error {
+	switch alg {
+	case "RS256":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return errors.New("RS256 requires an RSA key")
+		}
+		h := sha256.Sum256(signed)
+		if rsa.VerifyPKCS1v15(pub, crypto.SHA256, h[:], sig) != nil {
+			return errors.New("invalid JWS signature")
+		}
+		return nil
+	case "ES256", "ES384":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return errors.New(alg + " requires an ECDSA key")
+		}
+		hash := crypto.SHA256
+		if alg == "ES384" {
+			hash = crypto.SHA384
+		}
+		h := hash.New()
+		h.Write(signed)
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if len(sig) != 2*size {
+			return errors.New("invalid JWS signature length")
+		}
+		r, s := new(big.Int).SetBytes(sig[:size]), new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(pub, h.Sum(nil), r, s) {
+			return errors.New("invalid JWS signature")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported JWS algorithm %q", alg)
+	}
+}
+
+func keyThumbprint(key crypto.PublicKey) string {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	fatalIfErr(err, "failed to encode public key")
+	h := sha256.Sum256(der)
+	return hex.EncodeToString(h[:])
+}
+
+func (s *acmeServer) newAccount(w http.ResponseWriter, base string, payload []byte, jwk crypto.PublicKey) error {
+	if jwk == nil {
+		return acmeError("malformed", http.StatusBadRequest, "new-account requests must embed a jwk")
+	}
+	var req struct {
+		Contact              []string `json:"contact"`
+		TermsOfServiceAgreed bool     `json:"termsOfServiceAgreed"`
+		OnlyReturnExisting   bool     `json:"onlyReturnExisting"`
+	}
+	if err := json.Unmarshal(payload, &req); err != nil {
+		return acmeError("malformed", http.StatusBadRequest, "invalid new-account request: %s", err)
+	}
+
+	thumbprint := keyThumbprint(jwk)
+	s.mu.Lock()
+	var account *acmeAccount
+	for _, a := range s.accounts {
+		if a.thumbprint == thumbprint {
+			account = a
+		}
+	}
+	status := http.StatusOK
+	if account == nil && !req.OnlyReturnExisting {
+		account = &acmeAccount{id: randomToken(), thumbprint: thumbprint, key: jwk, contact: req.Contact}
+		s.accounts[account.id] = account
+		status = http.StatusCreated
+	}
+	s.mu.Unlock()
+	if account == nil {
+		return acmeError("accountDoesNotExist", http.StatusBadRequest, "no account exists for this key")
+	}
+
+	w.Header().Set("Location", base+"account/"+account.id)
+	s.writeJSON(w, status, s.accountObject(base, account))
+	return nil
+}
+
+func (s *acmeServer) accountObject(base string, account *acmeAccount) map[string]interface{} {
+	return map[string]interface{}{
+		"status":  "valid",
+		"contact": account.contact,
+		"orders":  base + "account/" + account.id + "/orders",
+	}
+}
+
+func (s *acmeServer) writeAccount(w http.ResponseWriter, base string, account *acmeAccount, id string) error {
+	if id != account.id {
+		return acmeError("unauthorized", http.StatusForbidden, "account mismatch")
+	}
+	s.writeJSON(w, http.StatusOK, s.accountObject(base, account))
+	return nil
+}
+
+func (s *acmeServer) newOrder(w http.ResponseWriter, base string, account *acmeAccount, payload []byte) error {
+	var req struct {
+		Identifiers []acmeIdentifier `json:"identifiers"`
+	}
+	if err := json.Unmarshal(payload, &req); err != nil {
+		return acmeError("malformed", http.StatusBadRequest, "invalid new-order request: %s", err)
+	}
+	if len(req.Identifiers) == 0 {
+		return acmeError("malformed", http.StatusBadRequest, "no identifiers in the order")
+	}
+	for i, ident := range req.Identifiers {
+		if ident.Type != "dns" && ident.Type != "ip" {
+			return acmeError("unsupportedIdentifier", http.StatusBadRequest, "unsupported identifier type %q", ident.Type)
+		}
+		if ident.Type == "ip" && net.ParseIP(ident.Value) == nil {
+			return acmeError("malformed", http.StatusBadRequest, "invalid IP address %q", ident.Value)
+		}
+		if ident.Type == "dns" {
+			// Like the names on the command line, but only hostnames.
+			host, err := normalizeHost(strings.ToLower(ident.Value))
+			if err != nil || net.ParseIP(host) != nil || strings.ContainsAny(host, "@:/") {
+				return acmeError("rejectedIdentifier", http.StatusBadRequest, "%q is not a valid hostname", ident.Value)
+			}
+			req.Identifiers[i].Value, ident.Value = host, host
+		}
+		if !s.m.acmeAllowed(ident.Value) {
+			return acmeError("rejectedIdentifier", http.StatusForbidden, "%q is not allowed by -acme-allow", ident.Value)
+		}
+	}
+	// The client can't be asked to confirm, like on the command line.
+	if public := publicDomains(identifierValues(req.Identifiers)); len(public) > 0 && !s.m.allowPublic {
+		return acmeError("rejectedIdentifier", http.StatusForbidden, "%q are public domains, which are only allowed with -allow-public", public)
+	}
+
+	// Authorizations are valid from the start, so the order is immediately
+	// ready to be finalized.
+	order := &acmeOrder{
+		id:          randomToken(),
+		accountID:   account.id,
+		identifiers: req.Identifiers,
+		status:      "ready",
+		expires:     time.Now().Add(24 * time.Hour),
+	}
+	s.mu.Lock()
+	s.orders[order.id] = order
+	s.mu.Unlock()
+
+	log.Printf("New ACME order for %q", identifierValues(order.identifiers))
+	w.Header().Set("Location", base+"order/"+order.id)
+	return s.writeOrder(w, http.StatusCreated, base, account, order.id)
+}
+
+func identifierValues(identifiers []acmeIdentifier) []string {
+	var values []string
+	for _, ident := range identifiers {
+		values = append(values, ident.Value)
+	}
+	return values
+}
+
+// acmeAllowed reports whether name matches one of the -acme-allow patterns.
+// A pattern like "*.test" matches any subdomain of "test", at any depth.
+func (m *mkcert) acmeAllowed(name string) bool {
+	if len(m.acmeAllow) == 0 {
+		return true
+	}
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	for _, pattern := range m.acmeAllow {
+		pattern = strings.ToLower(pattern)
+		if pattern == name {
+			return true
+		}
+		if strings.HasPrefix(pattern, "*.") && strings.HasSuffix(name, pattern[1:]) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *acmeServer) lookupOrder(account *acmeAccount, id string) (*acmeOrder, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	order := s.orders[id]
+	if order == nil || order.accountID != account.id {
+		return nil, acmeError("malformed", http.StatusNotFound, "unknown order %q", id)
+	}
+	return order, nil
+}
+
+func (s *acmeServer) writeOrder(w http.ResponseWriter, status int, base string, account *acmeAccount, id string) error {
+	order, err := s.lookupOrder(account, id)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	obj := map[string]interface{}{
+		"status":      order.status,
+		"expires":     order.expires.UTC().Format(time.RFC3339),
+		"identifiers": order.identifiers,
+		"finalize":    base + "finalize/" + order.id,
+	}
+	var authzs []string
+	for i := range order.identifiers {
+		authzs = append(authzs, fmt.Sprintf("%sauthz/%s-%d", base, order.id, i))
+	}
+	obj["authorizations"] = authzs
+	if order.status == "valid" {
+		obj["certificate"] = base + "cert/" + order.id
+	}
+	s.mu.Unlock()
+	s.writeJSON(w, status, obj)
+	return nil
+}
+
+// lookupAuthz resolves authorization and challenge IDs, which are the order
+// ID followed by the identifier index.
+func (s *acmeServer) lookupAuthz(account *acmeAccount, id string) (*acmeOrder, acmeIdentifier, error) {
+	i := strings.LastIndex(id, "-")
+	if i < 0 {
+		return nil, acmeIdentifier{}, acmeError("malformed", http.StatusNotFound, "unknown authorization %q", id)
+	}
+	order, err := s.lookupOrder(account, id[:i])
+	if err != nil {
+		return nil, acmeIdentifier{}, err
+	}
+	var n int
+	if _, err := fmt.Sscanf(id[i+1:], "%d", &n); err != nil || n < 0 || n >= len(order.identifiers) {
+		return nil, acmeIdentifier{}, acmeError("malformed", http.StatusNotFound, "unknown authorization %q", id)
+	}
+	return order, order.identifiers[n], nil
+}
+
+func (s *acmeServer) challengeObjects(base, id string) []map[string]interface{} {
+	var challenges []map[string]interface{}
+	for _, typ := range acmeChallengeTypes {
+		challenges = append(challenges, map[string]interface{}{
+			"type":      typ,
+			"url":       base + "chall/" + id + "-" + typ,
+			"token":     base64.RawURLEncoding.EncodeToString([]byte(id)),
+			"status":    "valid",
+			"validated": time.Now().UTC().Format(time.RFC3339),
+		})
+	}
+	return challenges
+}
+
+func (s *acmeServer) writeAuthz(w http.ResponseWriter, base string, account *acmeAccount, id string) error {
+	order, ident, err := s.lookupAuthz(account, id)
+	if err != nil {
+		return err
+	}
+	obj := map[string]interface{}{
+		"status":     "valid",
+		"expires":    order.expires.UTC().Format(time.RFC3339),
+		"identifier": ident,
+		"challenges": s.challengeObjects(base, id),
+	}
+	if strings.HasPrefix(ident.Value, "*.") {
+		obj["identifier"] = acmeIdentifier{Type: ident.Type, Value: ident.Value[2:]}
+		obj["wildcard"] = true
+	}
+	s.writeJSON(w, http.StatusOK, obj)
+	return nil
+}
+
+// acmeChallengeTypes are offered for every authorization, already valid.
+var acmeChallengeTypes = []string{"http-01", "dns-01", "tls-alpn-01"}
+
+func (s *acmeServer) writeChallenge(w http.ResponseWriter, base string, account *acmeAccount, id string) error {
+	for _, typ := range acmeChallengeTypes {
+		if !strings.HasSuffix(id, "-"+typ) {
+			continue
+		}
+		authzID := strings.TrimSuffix(id, "-"+typ)
+		if _, _, err := s.lookupAuthz(account, authzID); err != nil {
+			return err
+		}
+		for _, c := range s.challengeObjects(base, authzID) {
+			if c["type"] == typ {
+				w.Header().Add("Link", fmt.Sprintf("<%sauthz/%s>;rel=\"up\"", base, authzID))
+				s.writeJSON(w, http.StatusOK, c)
+				return nil
+			}
+		}
+	}
+	return acmeError("malformed", http.StatusNotFound, "unknown challenge %q", id)
+}
+
+func (s *acmeServer) finalize(w http.ResponseWriter, base string, account *acmeAccount, id string, payload []byte) error {
+	order, err := s.lookupOrder(account, id)
+	if err != nil {
+		return err
+	}
+	// Mark the order as processing under the lock, so that concurrent
+	// finalize requests can't issue two certificates for it. If this one
+	// fails, the order can be finalized again.
+	s.mu.Lock()
+	if order.status != "ready" {
+		status := order.status
+		s.mu.Unlock()
+		return acmeError("orderNotReady", http.StatusForbidden, "order is %s, not ready", status)
+	}
+	order.status = "processing"
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		if order.status == "processing" {
+			order.status = "ready"
+		}
+		s.mu.Unlock()
+	}()
+
+	var req struct {
+		CSR string `json:"csr"`
+	}
+	if err := json.Unmarshal(payload, &req); err != nil {
+		return acmeError("malformed", http.StatusBadRequest, "invalid finalize request: %s", err)
+	}
+	csrDER, err := base64.RawURLEncoding.DecodeString(req.CSR)
+	if err != nil {
+		return acmeError("badCSR", http.StatusBadRequest, "invalid CSR encoding")
+	}
+	csr, err := x509.ParseCertificateRequest(csrDER)
+	if err != nil {
+		return acmeError("badCSR", http.StatusBadRequest, "invalid CSR: %s", err)
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return acmeError("badCSR", http.StatusBadRequest, "invalid CSR signature: %s", err)
+	}
+
+	hosts := identifierValues(order.identifiers)
+	requested := make(map[string]bool)
+	for _, h := range hosts {
+		requested[strings.ToLower(h)] = true
+	}
+	csrNames := append([]string{}, csr.DNSNames...)
+	for _, ip := range csr.IPAddresses {
+		csrNames = append(csrNames, ip.String())
+	}
+	for _, name := range csrNames {
+		if !requested[strings.ToLower(name)] {
+			return acmeError("badCSR", http.StatusBadRequest, "CSR name %q is not in the order", name)
+		}
+	}
+
+	issuerCert, issuerKey := s.m.issuer()
+	expiration := time.Now().AddDate(0, 0, 90)
+	if expiration.After(issuerCert.NotAfter) {
+		expiration = issuerCert.NotAfter
+	}
+	tpl := s.m.leafTemplate(hosts, expiration)
+	tpl.SignatureAlgorithm = s.m.signatureAlgorithm(issuerKey)
+	s.m.applyStrictProfile(tpl, csr.PublicKey, issuerCert)
+	cert, err := x509.CreateCertificate(rand.Reader, tpl, issuerCert, csr.PublicKey, issuerKey)
+	if err != nil {
+		return err
+	}
+	leaf, err := x509.ParseCertificate(cert)
+	if err != nil {
+		return err
+	}
+
+	// The index is written without holding s.mu, so that the other requests
+	// don't wait for the disk or for the CAROOT lock.
+	entry := s.m.issuedEntry(leaf, "", "", "")
+	entry.FromCSR = true
+	if err := s.m.appendIndex(entry); err != nil {
+		return acmeError("serverInternal", http.StatusInternalServerError, "%s", err)
+	}
+	s.m.logIssuance("acme", leaf)
+
+	s.mu.Lock()
+	chain := append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert}), s.m.chainPEM()...)
+	s.certs[order.id] = append(chain, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.m.caCert.Raw})...)
+	order.status = "valid"
+	s.issued[serialString(leaf)] = account.id
+	s.mu.Unlock()
+
+	log.Printf("Issued a certificate for %q via ACME, expiring on %s ✅", hosts, leaf.NotAfter.Format("2 January 2006"))
+	w.Header().Set("Location", base+"order/"+order.id)
+	return s.writeOrder(w, http.StatusOK, base, account, order.id)
+}
+
+func (s *acmeServer) writeCert(w http.ResponseWriter, account *acmeAccount, id string) error {
+	if _, err := s.lookupOrder(account, id); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	chain := s.certs[id]
+	s.mu.Unlock()
+	if chain == nil {
+		return acmeError("malformed", http.StatusNotFound, "no certificate for order %q", id)
+	}
+	w.Header().Set("Content-Type", "application/pem-certificate-chain")
+	w.Header().Set("Replay-Nonce", s.newNonce())
+	w.Write(chain)
+	return nil
+}
+
+func (s *acmeServer) revokeCert(w http.ResponseWriter, account *acmeAccount, payload []byte) error {
+	var req struct {
+		Certificate string `json:"certificate"`
+	}
+	if err := json.Unmarshal(payload, &req); err != nil {
+		return acmeError("malformed", http.StatusBadRequest, "invalid revocation request: %s", err)
+	}
+	der, err := base64.RawURLEncoding.DecodeString(req.Certificate)
+	if err != nil {
+		return acmeError("malformed", http.StatusBadRequest, "invalid certificate encoding")
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil || !s.m.issuedByLocalCA(cert) {
+		return acmeError("malformed", http.StatusBadRequest, "the certificate was not issued by this CA")
+	}
+
+	// Only the account that ordered a certificate can revoke it. The ones
+	// issued before the server started can be revoked with -revoke.
+	s.mu.Lock()
+	if s.issued[serialString(cert)] != account.id {
+		s.mu.Unlock()
+		return acmeError("unauthorized", http.StatusForbidden, "the certificate was not issued to this account")
+	}
+	s.mu.Unlock()
+	_, err = s.m.revokeSerial(serialString(cert), true)
+	if err == errAlreadyRevoked {
+		return acmeError("alreadyRevoked", http.StatusBadRequest, "the certificate was already revoked")
+	}
+	if err != nil {
+		return acmeError("serverInternal", http.StatusInternalServerError, "%s", err)
+	}
+	log.Printf("Revoked the certificate with serial %s via ACME ⛔️", serialString(cert))
+
+	w.Header().Set("Replay-Nonce", s.newNonce())
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
@@ -0,0 +1,81 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"time"
+)
+
+// adoptCA imports an existing CA certificate and key, created for example by
+// OpenSSL or easy-rsa, as the local CA. The key can be in the same file as
+// the certificate, or in keyPath.
+func (m *mkcert) adoptCA(certPath, keyPath string) {
+	defer m.lockCAROOT()()
+	// saveVaultCA already refuses to overwrite an existing CA.
+	if m.vaultPath == "" && pathExists(filepath.Join(m.CAROOT, rootName)) {
+		log.Fatalf("ERROR: there is already a local CA in %q, use a different CAROOT or -ca profile", m.CAROOT)
+	}
+
+	data, err := ioutil.ReadFile(certPath)
+	fatalIfErr(err, "failed to read the CA certificate")
+	cert, key, err := parseCertAndKey(data)
+	fatalIfErr(err, "failed to parse the CA certificate")
+	if cert == nil {
+		log.Fatalf("ERROR: %q does not contain a certificate", certPath)
+	}
+	if keyPath != "" {
+		keyData, err := ioutil.ReadFile(keyPath)
+		fatalIfErr(err, "failed to read the CA key")
+		_, key, err = parseCertAndKey(keyData)
+		fatalIfErr(err, "failed to parse the CA key")
+	}
+	if key == nil {
+		log.Fatalln("ERROR: the CA key is missing, pass it as \"mkcert -adopt-ca CERT KEY\"")
+	}
+
+	if !cert.BasicConstraintsValid || !cert.IsCA {
+		log.Fatalf("ERROR: %q is not a CA certificate", certPath)
+	}
+	if cert.KeyUsage != 0 && cert.KeyUsage&x509.KeyUsageCertSign == 0 {
+		log.Fatalf("ERROR: %q is not allowed to sign certificates", certPath)
+	}
+	if time.Now().After(cert.NotAfter) {
+		log.Fatalf("ERROR: %q expired on %s", certPath, cert.NotAfter.Format("2 January 2006"))
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok || !signer.Public().(interface{ Equal(crypto.PublicKey) bool }).Equal(cert.PublicKey) {
+		log.Fatalln("ERROR: the CA key does not match the certificate")
+	}
+	privDER, err := x509.MarshalPKCS8PrivateKey(key)
+	fatalIfErr(err, "failed to encode the CA key")
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privDER})
+	if m.vaultPath != "" {
+		m.saveVaultCA(certPEM, keyPEM)
+	} else {
+		verbosef("Writing %s and %s", filepath.Join(m.CAROOT, rootKeyName), filepath.Join(m.CAROOT, rootName))
+		fatalIfErr(writeFileAtomic(filepath.Join(m.CAROOT, rootKeyName), keyPEM, 0400), "failed to save CA key")
+		fatalIfErr(writeFileAtomic(filepath.Join(m.CAROOT, rootName), certPEM, 0644), "failed to save CA certificate")
+	}
+	log.Printf("Adopted %q as the local CA 💥\n", cert.Subject.CommonName)
+
+	if !bytes.Equal(cert.RawIssuer, cert.RawSubject) || cert.CheckSignatureFrom(cert) != nil {
+		log.Printf("Warning: it's not a root CA, so clients will also need to trust its issuer ⚠️")
+	}
+	if cert.MaxPathLenZero {
+		log.Printf("Note: its path length constraint doesn't allow intermediate CAs (see -inter).")
+	}
+	if !m.installMode {
+		logCode("install_hint", "Run \"mkcert -install\" to trust it, if it's not already ✨")
+	}
+}
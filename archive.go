@@ -0,0 +1,102 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// archiveFile is a file added to the -archive bundle.
+type archiveFile struct {
+	name        string
+	description string
+	data        []byte
+	private     bool
+}
+
+// isArchiveName reports whether path has one of the extensions -archive
+// supports.
+func isArchiveName(path string) bool {
+	path = strings.ToLower(path)
+	return strings.HasSuffix(path, ".zip") || strings.HasSuffix(path, ".tar.gz") || strings.HasSuffix(path, ".tgz")
+}
+
+// writeArchive saves the certificate, key, intermediate and local CA, and a
+// README with their fingerprints, in a zip or gzipped tar file at path.
+func (m *mkcert) writeArchive(path string, leaf *x509.Certificate, certFile, keyFile string, certPEM, privPEM []byte) {
+	certDescription := "certificate"
+	if len(m.chainCerts()) > 0 {
+		certDescription = "certificate, followed by the intermediate CA"
+	}
+	files := []archiveFile{
+		{name: filepath.Base(certFile), description: certDescription, data: certPEM},
+		{name: filepath.Base(keyFile), description: "private key", data: privPEM, private: true},
+	}
+	if m.keyPassword != "" {
+		files[1].description = "private key, encrypted with a password"
+	}
+	if chain := m.chainPEM(); len(chain) > 0 {
+		chain = append(chain, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: m.caCert.Raw})...)
+		files = append(files, archiveFile{name: "chain.pem", description: "intermediate and local CA", data: chain})
+	}
+	files = append(files, archiveFile{name: rootName, description: "local CA, to be trusted by the clients",
+		data: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: m.caCert.Raw})})
+
+	readme := &bytes.Buffer{}
+	fmt.Fprintf(readme, "Certificate for %s\n", strings.Join(certificateHosts(leaf), ", "))
+	fmt.Fprintf(readme, "Generated by mkcert on %s, valid until %s.\n\n", time.Now().Format("2006-01-02"), leaf.NotAfter.Local().Format("2006-01-02"))
+	w := tabwriter.NewWriter(readme, 0, 0, 2, ' ', 0)
+	for _, f := range files {
+		fmt.Fprintf(w, "%s\t%s\n", f.name, f.description)
+	}
+	w.Flush()
+	fmt.Fprintf(readme, "\nCertificate SHA-256 fingerprint: %s\n", fingerprint(leaf.Raw))
+	fmt.Fprintf(readme, "Certificate SPKI SHA-256 hash:   %s\n", spkiHash(leaf))
+	fmt.Fprintf(readme, "Local CA SHA-256 fingerprint:    %s\n", fingerprint(m.caCert.Raw))
+	fmt.Fprintf(readme, "Local CA subject:                %s\n", m.caCert.Subject)
+	files = append(files, archiveFile{name: "README.txt", data: readme.Bytes()})
+
+	buf := &bytes.Buffer{}
+	if strings.HasSuffix(strings.ToLower(path), ".zip") {
+		zw := zip.NewWriter(buf)
+		for _, f := range files {
+			hdr := &zip.FileHeader{Name: f.name, Method: zip.Deflate, Modified: time.Now()}
+			hdr.SetMode(0644)
+			if f.private {
+				hdr.SetMode(0600)
+			}
+			fw, err := zw.CreateHeader(hdr)
+			fatalIfErr(err, "failed to create the archive")
+			_, err = fw.Write(f.data)
+			fatalIfErr(err, "failed to create the archive")
+		}
+		fatalIfErr(zw.Close(), "failed to create the archive")
+	} else {
+		zw := gzip.NewWriter(buf)
+		tw := tar.NewWriter(zw)
+		for _, f := range files {
+			hdr := &tar.Header{Name: f.name, Mode: 0644, Size: int64(len(f.data)), ModTime: time.Now(), Typeflag: tar.TypeReg}
+			if f.private {
+				hdr.Mode = 0600
+			}
+			fatalIfErr(tw.WriteHeader(hdr), "failed to create the archive")
+			_, err := tw.Write(f.data)
+			fatalIfErr(err, "failed to create the archive")
+		}
+		fatalIfErr(tw.Close(), "failed to create the archive")
+		fatalIfErr(zw.Close(), "failed to create the archive")
+	}
+	fatalIfErr(writeOutput(path, buf.Bytes(), 0600), "failed to save the archive")
+}
@@ -0,0 +1,192 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// auditCAROOT checks that the CA keys and other secrets in the CAROOT are
+// only accessible to the current user, that there are no copies of them
+// lying around, and that the keys of the issued certificates are not world
+// readable. With -fix, it restricts the permissions and deletes the leftover
+// temporary files, but it never deletes anything else or changes owners.
+//
+// Windows protects the CAROOT with the ACLs of the user profile instead of
+// permission bits, so there only the stray files are checked.
+func (m *mkcert) auditCAROOT() {
+	if m.vaultPath != "" {
+		log.Fatalln("ERROR: -audit is not supported with a Vault CAROOT")
+	}
+	c := &checker{}
+	var strays []string
+
+	info, err := os.Stat(m.CAROOT)
+	fatalIfErr(err, "failed to read the CAROOT")
+	m.auditOwner(c, m.CAROOT, info)
+	if checkPermissions() {
+		m.auditPerm(c, m.CAROOT, info, 0022, "writable by other users")
+	}
+
+	var paths []string
+	entries, err := ioutil.ReadDir(m.CAROOT)
+	fatalIfErr(err, "failed to read the CAROOT")
+	for _, e := range entries {
+		if !e.IsDir() {
+			paths = append(paths, filepath.Join(m.CAROOT, e.Name()))
+		}
+	}
+	// The other directories are CA profiles, audited with -ca.
+	for _, dir := range []string{retiredDir, interDir} {
+		filepath.Walk(filepath.Join(m.CAROOT, dir), func(path string, info os.FileInfo, err error) error {
+			if err == nil && !info.IsDir() {
+				paths = append(paths, path)
+			}
+			return nil
+		})
+	}
+
+	for _, path := range paths {
+		info, err := os.Lstat(path)
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		name := filepath.Base(path)
+		m.auditOwner(c, path, info)
+		switch {
+		case strings.HasPrefix(name, ".") && strings.Contains(name, ".tmp"):
+			// Left behind by writeFileAtomic if mkcert was interrupted.
+			if m.auditFix && wipeAll(path) == nil {
+				c.check(true, "Deleted the leftover temporary file %q", path)
+			} else {
+				c.check(false, "%q is a leftover temporary file, and might contain a key", path)
+			}
+		case isSecretFile(name):
+			if checkPermissions() {
+				m.auditPerm(c, path, info, 0077, "accessible by other users")
+			}
+		case isBackupName(name) || containsPrivateKey(path):
+			strays = append(strays, path)
+			if checkPermissions() {
+				m.auditPerm(c, path, info, 0077, "accessible by other users")
+			}
+		}
+	}
+
+	if checkPermissions() {
+		for _, path := range m.issuedKeyFiles() {
+			info, err := os.Stat(path)
+			if err != nil {
+				continue // moved or deleted since it was issued
+			}
+			m.auditPerm(c, path, info, 0007, "world readable")
+		}
+	}
+
+	for _, path := range strays {
+		c.check(false, "%q looks like a copy of a key, delete it or move it out of the CAROOT", path)
+	}
+	if c.failed {
+		if m.auditFix {
+			log.Fatalln("\nERROR: some problems can't be fixed automatically, see above")
+		}
+		log.Fatalln("\nERROR: the CAROOT failed the audit, run \"mkcert -audit -fix\" to fix the permissions")
+	}
+	log.Printf("\n%q passed the audit 🎉\n\n", m.CAROOT)
+}
+
+// auditPerm checks that none of the bad permission bits are set on path,
+// and clears them with -fix.
+func (m *mkcert) auditPerm(c *checker, path string, info os.FileInfo, bad os.FileMode, problem string) {
+	mode := info.Mode().Perm()
+	if mode&bad == 0 {
+		verbosef("%q has mode %#o", path, mode)
+		return
+	}
+	if m.auditFix {
+		if err := os.Chmod(path, mode&^bad); err != nil {
+			c.check(false, "%q is %s (mode %#o), and it can't be fixed: %s", path, problem, mode, err)
+			return
+		}
+		c.check(true, "Changed the mode of %q from %#o to %#o", path, mode, mode&^bad)
+		return
+	}
+	c.check(false, "%q is %s (mode %#o)", path, problem, mode)
+}
+
+// auditOwner checks that path is owned by the current user. Files created by
+// "sudo mkcert" are a common cause of this, and need to be fixed with chown.
+func (m *mkcert) auditOwner(c *checker, path string, info os.FileInfo) {
+	uid, ok := fileOwner(info)
+	if !ok || uid == os.Geteuid() {
+		return
+	}
+	c.check(false, "%q is owned by user %d instead of %d, fix it with \"sudo chown\"", path, uid, os.Geteuid())
+}
+
+// issuedKeyFiles returns the files with the keys of the certificates in the
+// index: the key files, the PKCS#12 bundles, and the certificate files that
+// also contain the key.
+func (m *mkcert) issuedKeyFiles() []string {
+	var paths []string
+	seen := make(map[string]bool)
+	for _, entry := range m.loadIndex() {
+		files := []string{entry.KeyFile, entry.P12File}
+		if entry.CertFile != "" && (entry.CertFile == entry.KeyFile || containsPrivateKey(entry.CertFile)) {
+			files = append(files, entry.CertFile)
+		}
+		for _, path := range files {
+			if path == "" || path == "-" || seen[path] {
+				continue
+			}
+			seen[path] = true
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+func checkPermissions() bool {
+	return runtime.GOOS != "windows"
+}
+
+// isSecretFile reports whether name is one of the files mkcert keeps secrets
+// in: PEM keys, key URIs that might include a PIN, and encrypted key blobs.
+func isSecretFile(name string) bool {
+	for _, suffix := range []string{"-key.pem", "-key.uri", ".dpapi", ".priv"} {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// isBackupName reports whether name looks like a backup made by hand or by
+// an editor.
+func isBackupName(name string) bool {
+	lower := strings.ToLower(name)
+	for _, suffix := range []string{"~", ".bak", ".backup", ".old", ".orig", ".save", ".swp", ".copy"} {
+		if strings.HasSuffix(lower, suffix) {
+			return true
+		}
+	}
+	return strings.Contains(lower, " copy") || strings.Contains(lower, " (")
+}
+
+// containsPrivateKey reports whether the file at path contains a PEM private
+// key, like a key copied with a different name.
+func containsPrivateKey(path string) bool {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return false
+	}
+	return bytes.Contains(data, []byte("PRIVATE KEY-----"))
+}
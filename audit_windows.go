@@ -0,0 +1,12 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import "os"
+
+// fileOwner is not supported on Windows, where files are owned by SIDs.
+func fileOwner(info os.FileInfo) (uid int, ok bool) {
+	return 0, false
+}
@@ -0,0 +1,209 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/term"
+)
+
+// backupMagic starts every backup file, and is authenticated along with the
+// archive. It's followed by a 16 bytes scrypt salt, a 12 bytes AES-GCM nonce,
+// and the encrypted gzipped tar of the CAROOT.
+const backupMagic = "mkcert CAROOT backup v1\n"
+
+// backupKey derives the backup encryption key with the same scrypt work
+// factor as age, which takes about a second.
+func backupKey(password string, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(password), salt, 1<<18, 8, 1, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// backupPassword returns the -key-pass password, or asks for one.
+func (m *mkcert) backupPassword(confirm bool) string {
+	if m.keyPassword != "" {
+		return m.keyPassword
+	}
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		log.Fatalln("ERROR: set the backup password with -key-pass")
+	}
+	fmt.Fprint(os.Stderr, "Backup password: ")
+	password, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	fatalIfErr(err, "failed to read the password")
+	if len(password) == 0 {
+		log.Fatalln("ERROR: the backup password is empty")
+	}
+	if confirm {
+		fmt.Fprint(os.Stderr, "Confirm password: ")
+		again, err := term.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Fprintln(os.Stderr)
+		fatalIfErr(err, "failed to read the password")
+		if !bytes.Equal(password, again) {
+			log.Fatalln("ERROR: the passwords don't match")
+		}
+	}
+	return string(password)
+}
+
+// backup saves the whole CAROOT, including the CA key, the index and the
+// intermediates, to a password encrypted archive.
+func (m *mkcert) backup() {
+	if !pathExists(filepath.Join(m.CAROOT, rootName)) {
+		fatalCode(exitCAMissing, "ca_missing", "ERROR: there is no local CA in %q to back up", m.CAROOT)
+	}
+
+	buf := &bytes.Buffer{}
+	zw := gzip.NewWriter(buf)
+	tw := tar.NewWriter(zw)
+	var files int
+	err := filepath.Walk(m.CAROOT, func(path string, info os.FileInfo, err error) error {
+		if err != nil || path == m.CAROOT {
+			return err
+		}
+		if !info.Mode().IsRegular() && !info.IsDir() || path == filepath.Join(m.CAROOT, lockName) {
+			return nil
+		}
+		rel, err := filepath.Rel(m.CAROOT, path)
+		if err != nil {
+			return err
+		}
+		hdr, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		verbosef("Adding %s", path)
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		files++
+		_, err = tw.Write(data)
+		return err
+	})
+	fatalIfErr(err, "failed to archive the CAROOT")
+	fatalIfErr(tw.Close(), "failed to archive the CAROOT")
+	fatalIfErr(zw.Close(), "failed to archive the CAROOT")
+
+	password := m.backupPassword(true)
+	salt := make([]byte, 16)
+	_, err = rand.Read(salt)
+	fatalIfErr(err, "failed to generate the backup salt")
+	aead, err := backupKey(password, salt)
+	fatalIfErr(err, "failed to derive the backup key")
+	nonce := make([]byte, aead.NonceSize())
+	_, err = rand.Read(nonce)
+	fatalIfErr(err, "failed to generate the backup nonce")
+
+	out := append([]byte(backupMagic), salt...)
+	out = append(out, nonce...)
+	out = aead.Seal(out, nonce, buf.Bytes(), []byte(backupMagic))
+	fatalIfErr(writeOutput(m.backupPath, out, 0600), "failed to save the backup")
+
+	log.Printf("Backed up %d files from %q to %s 🔐", files, m.CAROOT, outputName(m.backupPath))
+	uri, _ := ioutil.ReadFile(filepath.Join(m.CAROOT, rootKeyURIName))
+	switch {
+	case m.vaultPath != "":
+		log.Printf("The CA key stays in Vault, and is not in the backup ℹ️")
+	case strings.HasPrefix(string(uri), tpmScheme):
+		log.Printf("The CA key stays in the TPM, so the backup can only be restored on this machine ℹ️")
+	case strings.HasPrefix(string(uri), keyringScheme):
+		log.Printf("The CA key stays in %s, and is not in the backup ℹ️", keyringDescription)
+	case strings.HasPrefix(string(uri), pkcs11Scheme):
+		log.Printf("The CA key stays in the PKCS#11 token, and is not in the backup ℹ️")
+	default:
+		log.Printf("It contains the CA key, so keep it and its password safe ⚠️")
+	}
+}
+
+// restore extracts a backup made with -backup into the CAROOT, which must not
+// already contain a local CA.
+func (m *mkcert) restore() {
+	defer m.lockCAROOT()()
+	if pathExists(filepath.Join(m.CAROOT, rootName)) {
+		log.Fatalf("ERROR: there is already a local CA in %q, use a different CAROOT or -ca profile", m.CAROOT)
+	}
+	data, err := ioutil.ReadFile(m.restorePath)
+	fatalIfErr(err, "failed to read the backup")
+	if !bytes.HasPrefix(data, []byte(backupMagic)) || len(data) < len(backupMagic)+16+12 {
+		log.Fatalf("ERROR: %q is not an mkcert backup", m.restorePath)
+	}
+	data = data[len(backupMagic):]
+	salt, data := data[:16], data[16:]
+
+	aead, err := backupKey(m.backupPassword(false), salt)
+	fatalIfErr(err, "failed to derive the backup key")
+	nonce, data := data[:aead.NonceSize()], data[aead.NonceSize():]
+	archive, err := aead.Open(nil, nonce, data, []byte(backupMagic))
+	if err != nil {
+		log.Fatalln("ERROR: failed to decrypt the backup: wrong password or corrupted file")
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(archive))
+	fatalIfErr(err, "failed to read the backup")
+	tr := tar.NewReader(zr)
+	var files int
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		fatalIfErr(err, "failed to read the backup")
+		name := filepath.Clean(filepath.FromSlash(hdr.Name))
+		if filepath.IsAbs(name) || name == ".." || strings.HasPrefix(name, ".."+string(filepath.Separator)) {
+			fatalIfErr(errors.New("invalid path "+hdr.Name), "failed to read the backup")
+		}
+		path := filepath.Join(m.CAROOT, name)
+		if rel, err := filepath.Rel(m.CAROOT, path); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			fatalIfErr(errors.New("invalid path "+hdr.Name), "failed to read the backup")
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			fatalIfErr(os.MkdirAll(path, 0755), "failed to restore the backup")
+		case tar.TypeReg:
+			fatalIfErr(os.MkdirAll(filepath.Dir(path), 0755), "failed to restore the backup")
+			contents, err := ioutil.ReadAll(tr)
+			fatalIfErr(err, "failed to read the backup")
+			verbosef("Writing %s", path)
+			err = writeFileAtomic(path, contents, os.FileMode(hdr.Mode).Perm())
+			fatalIfErr(err, "failed to restore the backup")
+			files++
+		}
+	}
+
+	log.Printf("Restored %d files to %q 💥", files, m.CAROOT)
+	if !m.installMode {
+		logCode("install_hint", "Run \"mkcert -install\" to trust the restored local CA ✨")
+	}
+}
@@ -0,0 +1,75 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+)
+
+// batchEntry is a certificate in a -batch manifest. Relative paths are
+// resolved against the directory of the manifest.
+type batchEntry struct {
+	Hosts    []string `json:"hosts"`
+	CSR      string   `json:"csr"`
+	ECDSA    bool     `json:"ecdsa"`
+	Client   bool     `json:"client"`
+	PKCS12   bool     `json:"pkcs12"`
+	CertFile string   `json:"cert_file"`
+	KeyFile  string   `json:"key_file"`
+	P12File  string   `json:"p12_file"`
+}
+
+// makeBatch generates all the certificates described in the manifest at
+// m.batchPath, with the command line options as defaults.
+func (m *mkcert) makeBatch() {
+	data, err := ioutil.ReadFile(m.batchPath)
+	fatalIfErr(err, "failed to read the batch manifest")
+	var entries []batchEntry
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	fatalIfErr(dec.Decode(&entries), "failed to parse the batch manifest")
+	if len(entries) == 0 {
+		log.Fatalf("ERROR: the batch manifest %q lists no certificates", m.batchPath)
+	}
+
+	// Check all the entries before generating anything.
+	for i, e := range entries {
+		if (len(e.Hosts) == 0) == (e.CSR == "") {
+			log.Fatalf("ERROR: certificate #%d in the batch manifest must have either hosts or a csr", i+1)
+		}
+		if e.CSR != "" && (e.ECDSA || e.PKCS12 || e.KeyFile != "" || e.P12File != "") {
+			log.Fatalf("ERROR: certificate #%d in the batch manifest can only set cert_file and client with csr", i+1)
+		}
+		entries[i].Hosts = validateHosts(e.Hosts)
+	}
+	var hosts []string
+	for _, e := range entries {
+		hosts = append(hosts, e.Hosts...)
+	}
+	m.checkPublicDomains(hosts)
+
+	dir := filepath.Dir(m.batchPath)
+	resolve := func(path string) string {
+		if path == "" || path == "-" || filepath.IsAbs(path) {
+			return path
+		}
+		return filepath.Join(dir, path)
+	}
+	for _, e := range entries {
+		c := *m
+		c.ecdsa, c.client, c.pkcs12 = c.ecdsa || e.ECDSA, c.client || e.Client, c.pkcs12 || e.PKCS12
+		c.certFile, c.keyFile, c.p12File = resolve(e.CertFile), resolve(e.KeyFile), resolve(e.P12File)
+		if e.CSR != "" {
+			c.csrPath = resolve(e.CSR)
+			c.makeCertFromCSR()
+		} else {
+			c.makeCert(e.Hosts)
+		}
+	}
+}
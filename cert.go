@@ -14,7 +14,10 @@ import (
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/asn1"
+	"encoding/json"
 	"encoding/pem"
+	"errors"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"math/big"
@@ -29,6 +32,7 @@ import (
 	"strings"
 	"time"
 
+	"golang.org/x/net/idna"
 	pkcs12 "software.sslmate.com/src/go-pkcs12"
 )
 
@@ -46,20 +50,241 @@ func init() {
 	}
 }
 
+// errCAKeyMissing is returned by issueCert and issueCertFromCSR when the CA
+// only has its certificate.
+var errCAKeyMissing = errors.New("the CA key (rootCA-key.pem) is missing")
+
+// csrError is an issueCertFromCSR error caused by the CSR itself.
+type csrError struct{ error }
+
+func (e csrError) Unwrap() error { return e.error }
+
 func (m *mkcert) makeCert(hosts []string) {
-	if m.caKey == nil {
-		log.Fatalln("ERROR: can't create new certificates because the CA key (rootCA-key.pem) is missing")
+	exitIfIssueErr(m.issueCert(hosts))
+}
+
+func (m *mkcert) makeCertFromCSR() {
+	exitIfIssueErr(m.issueCertFromCSR())
+}
+
+// exitIfIssueErr exits with the exit code and log code for an error of
+// issueCert or issueCertFromCSR.
+func exitIfIssueErr(err error) {
+	var csrErr csrError
+	switch {
+	case err == nil:
+	case errors.Is(err, errCAKeyMissing):
+		fatalCode(exitCAMissing, "ca_key_missing", "ERROR: can't create new certificates because %s", err)
+	case errors.As(err, &csrErr):
+		fatalCode(exitCSRInvalid, "csr_invalid", "ERROR: %s", err)
+	default:
+		exitf(exitCode, "ERROR: %s", err)
 	}
+}
 
-	priv, err := m.generateKey(false)
-	fatalIfErr(err, "failed to generate certificate key")
+// issueCert issues a certificate for hosts, and saves it along with its key.
+// Errors are returned, or for the less likely ones logged as fatal by the
+// helpers, which reissue recovers from with catchFatal.
+func (m *mkcert) issueCert(hosts []string) error {
+	issuerCert, issuerKey := m.issuer()
+	if issuerKey == nil {
+		return errCAKeyMissing
+	}
+
+	certFile, keyFile, p12File := m.fileNames(hosts)
+	if m.keyIn != "" {
+		keyFile = m.keyIn
+	}
+	if m.layoutDir() != "" {
+		if err := os.MkdirAll(m.layoutDir(), 0755); err != nil {
+			return fmt.Errorf("failed to create the configuration directory: %w", err)
+		}
+	}
+
+	priv, reused := m.leafKey(keyFile, p12File)
 	pub := priv.(crypto.Signer).Public()
+	switch {
+	case m.kubernetes || (reused && m.reuseKey):
+		// With -reuse-key, replacing the certificate is the point.
+	case m.archivePath != "":
+		m.checkOverwrite("", m.archivePath)
+	case m.pkcs12:
+		m.checkOverwrite(p12File, p12File)
+	case reused || certFile == keyFile:
+		m.checkOverwrite(certFile, certFile)
+	default:
+		m.checkOverwrite(certFile, certFile, keyFile)
+	}
 
-	// Certificates last for 2 years and 3 months, which is always less than
-	// 825 days, the limit that macOS/iOS apply to all certificates,
-	// including custom roots. See https://support.apple.com/en-us/HT210176.
-	expiration := time.Now().AddDate(2, 3, 0)
+	expiration := m.leafNotAfter(issuerCert)
+
+	tpl := m.leafTemplate(hosts, expiration)
+	if len(m.upns) > 0 {
+		ext, err := marshalSANsWithUPNs(tpl, m.upns)
+		if err != nil {
+			return fmt.Errorf("failed to encode the subject alternative names: %w", err)
+		}
+		tpl.ExtraExtensions = append(withoutExtension(tpl.ExtraExtensions, oidExtensionSubjectAltName), ext)
+	}
 
+	tpl.SignatureAlgorithm = m.signatureAlgorithm(issuerKey)
+	m.applyStrictProfile(tpl, pub, issuerCert)
+	cert, err := x509.CreateCertificate(rand.Reader, tpl, issuerCert, pub, issuerKey)
+	if err != nil {
+		return fmt.Errorf("failed to generate certificate: %w", err)
+	}
+	leaf, err := x509.ParseCertificate(cert)
+	if err != nil {
+		return fmt.Errorf("failed to parse generated certificate: %w", err)
+	}
+	m.checkNameConstraints(leaf)
+
+	if m.kubernetes {
+		certPEM := append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert}), m.chainPEM()...)
+		privDER, err := x509.MarshalPKCS8PrivateKey(priv)
+		if err != nil {
+			return fmt.Errorf("failed to encode certificate key: %w", err)
+		}
+		privPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privDER})
+		m.kubernetesSecret(hosts, leaf, certPEM, privPEM)
+		return nil
+	}
+
+	if !m.pkcs12 {
+		certPEM := append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert}), m.chainPEM()...)
+		privDER, err := x509.MarshalPKCS8PrivateKey(priv)
+		if err != nil {
+			return fmt.Errorf("failed to encode certificate key: %w", err)
+		}
+		privPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privDER})
+		if m.keyPassword != "" {
+			encDER, err := encryptPKCS8(privDER, m.keyPassword)
+			if err != nil {
+				return fmt.Errorf("failed to encrypt certificate key: %w", err)
+			}
+			privPEM = pem.EncodeToMemory(&pem.Block{Type: "ENCRYPTED PRIVATE KEY", Bytes: encDER})
+		}
+		if dbProfiles[m.profile].traditionalKey {
+			privPEM, err = traditionalKeyPEM(priv)
+			if err != nil {
+				return fmt.Errorf("failed to encode certificate key: %w", err)
+			}
+		}
+
+		if m.archivePath != "" {
+			m.writeArchive(m.archivePath, leaf, certFile, keyFile, certPEM, privPEM)
+		} else if certFile == keyFile {
+			err = writeOutput(keyFile, append(certPEM, privPEM...), 0600)
+			if err != nil {
+				return fmt.Errorf("failed to save certificate and key: %w", err)
+			}
+		} else {
+			err = writeOutput(certFile, m.encodeCertFile(leaf, certPEM), 0644)
+			if err != nil {
+				return fmt.Errorf("failed to save certificate: %w", err)
+			}
+			if !reused {
+				err = writeOutput(keyFile, privPEM, 0600)
+				if err != nil {
+					return fmt.Errorf("failed to save certificate key: %w", err)
+				}
+			}
+		}
+		if m.layoutDir() != "" {
+			m.writeLayoutConfig(hosts, certFile, keyFile)
+		}
+		if m.profile != "" {
+			m.finishProfile(certFile, keyFile)
+		}
+	} else {
+		password := "changeit"
+		if m.keyPassword != "" {
+			password = m.keyPassword
+		}
+		var caCerts []*x509.Certificate
+		switch m.p12Chain {
+		case "full":
+			caCerts = append(m.chainCerts(), m.caCert)
+		case "no-root":
+			caCerts = m.chainCerts()
+		}
+		var pfxData []byte
+		if m.p12Name != "" {
+			pfxData, err = encodePKCS12(priv, append([]*x509.Certificate{leaf}, caCerts...), password, m.p12Name)
+		} else {
+			pfxData, err = pkcs12.Encode(rand.Reader, priv, leaf, caCerts, password)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to generate PKCS#12: %w", err)
+		}
+		err = writeOutput(p12File, pfxData, 0600)
+		if err != nil {
+			return fmt.Errorf("failed to save PKCS#12: %w", err)
+		}
+	}
+
+	if !m.pkcs12 {
+		p12File = ""
+	} else {
+		certFile, keyFile = "", ""
+	}
+	if m.archivePath != "" {
+		certFile, keyFile = "", ""
+	}
+	if err := m.recordIssued(leaf, certFile, keyFile, p12File); err != nil {
+		return err
+	}
+	m.logIssuance("direct", leaf)
+
+	if m.jsonOutput {
+		m.printJSON(leaf, certFile, keyFile, p12File)
+		return nil
+	}
+
+	m.printHosts(hosts)
+
+	if m.archivePath != "" {
+		log.Printf("\nThe certificate, key, local CA and a README are in the archive at %s ✅\n\n", outputName(m.archivePath))
+		if m.keyPassword != "" {
+			log.Printf("The key is encrypted with the password set by -key-pass 🔐\n\n")
+		}
+	} else if !m.pkcs12 {
+		if certFile == keyFile {
+			logCode("cert_saved", "\nThe certificate and key are at %s ✅\n\n", outputName(certFile))
+		} else if reused {
+			logCode("cert_saved", "\nThe certificate is at %s, for the existing key at %s ✅\n\n", outputName(certFile), outputName(keyFile))
+		} else {
+			logCode("cert_saved", "\nThe certificate is at %s and the key at %s ✅\n\n", outputName(certFile), outputName(keyFile))
+		}
+		if m.keyPassword != "" {
+			log.Printf("The key is encrypted with the password set by -key-pass 🔐\n\n")
+		}
+	} else {
+		logCode("cert_saved", "\nThe PKCS#12 bundle is at %s ✅\n", outputName(p12File))
+		if m.keyPassword == "" {
+			log.Printf("\nThe legacy PKCS#12 encryption password is the often hardcoded default \"changeit\" ℹ️\n\n")
+		} else {
+			log.Printf("\nThe PKCS#12 bundle is encrypted with the password set by -key-pass ℹ️\n\n")
+		}
+	}
+
+	m.printIntermediate()
+
+	printFingerprints(leaf)
+	logCode("cert_expiration", "\nIt will expire on %s 🗓\n\n", m.expirationDate(expiration))
+
+	if m.layoutDir() != "" {
+		m.printLayoutHint(certFile)
+	}
+	if m.profile != "" {
+		m.printProfileConfig(certFile, keyFile)
+	}
+	return nil
+}
+
+// leafTemplate returns the template for a certificate for hosts, with the
+// names, usages and subject derived from the command line options.
+func (m *mkcert) leafTemplate(hosts []string, notAfter time.Time) *x509.Certificate {
 	tpl := &x509.Certificate{
 		SerialNumber: randomSerialNumber(),
 		Subject: pkix.Name{
@@ -69,31 +294,32 @@ func (m *mkcert) makeCert(hosts []string) {
 			CommonName:         hosts[0],
 		},
 
-		NotBefore: time.Now(), NotAfter: expiration,
+		NotBefore: m.leafNotBefore(), NotAfter: notAfter,
 
 		KeyUsage: x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
-	}
 
-	for _, h := range hosts {
-		if ip := net.ParseIP(h); ip != nil {
-			tpl.IPAddresses = append(tpl.IPAddresses, ip)
-		} else if email, err := mail.ParseAddress(h); err == nil && email.Address == h {
-			tpl.EmailAddresses = append(tpl.EmailAddresses, h)
-		} else if uriName, err := url.Parse(h); err == nil && uriName.Scheme != "" && uriName.Host != "" {
-			tpl.URIs = append(tpl.URIs, uriName)
-		} else {
-			tpl.DNSNames = append(tpl.DNSNames, h)
-		}
+		ExtraExtensions: m.leafExts,
 	}
-
-	if m.client {
-		tpl.ExtKeyUsage = append(tpl.ExtKeyUsage, x509.ExtKeyUsageClientAuth)
+	m.addRevocationURLs(tpl)
+	if m.keyUsage != 0 {
+		tpl.KeyUsage = m.keyUsage
 	}
-	if len(tpl.IPAddresses) > 0 || len(tpl.DNSNames) > 0 || len(tpl.URIs) > 0 {
-		tpl.ExtKeyUsage = append(tpl.ExtKeyUsage, x509.ExtKeyUsageServerAuth)
-	}
-	if len(tpl.EmailAddresses) > 0 {
-		tpl.ExtKeyUsage = append(tpl.ExtKeyUsage, x509.ExtKeyUsageEmailProtection)
+
+	addSANs(tpl, hosts)
+
+	if m.extKeyUsage != nil || m.unknownExtKeyUsage != nil {
+		tpl.ExtKeyUsage = append(tpl.ExtKeyUsage, m.extKeyUsage...)
+		tpl.UnknownExtKeyUsage = m.unknownExtKeyUsage
+	} else {
+		if m.client {
+			tpl.ExtKeyUsage = append(tpl.ExtKeyUsage, x509.ExtKeyUsageClientAuth)
+		}
+		if len(tpl.IPAddresses) > 0 || len(tpl.DNSNames) > 0 || len(tpl.URIs) > 0 {
+			tpl.ExtKeyUsage = append(tpl.ExtKeyUsage, x509.ExtKeyUsageServerAuth)
+		}
+		if len(tpl.EmailAddresses) > 0 {
+			tpl.ExtKeyUsage = append(tpl.ExtKeyUsage, x509.ExtKeyUsageEmailProtection)
+		}
 	}
 
 	// IIS (the main target of PKCS #12 files), only shows the deprecated
@@ -101,58 +327,120 @@ func (m *mkcert) makeCert(hosts []string) {
 	if m.pkcs12 {
 		tpl.Subject.CommonName = hosts[0]
 	}
+	m.overrideSubject(&tpl.Subject)
 
-	cert, err := x509.CreateCertificate(rand.Reader, tpl, m.caCert, pub, m.caKey)
-	fatalIfErr(err, "failed to generate certificate")
+	return tpl
+}
 
-	certFile, keyFile, p12File := m.fileNames(hosts)
+// overrideSubject applies the -cn, -org, -ou and -country options to subject.
+func (m *mkcert) overrideSubject(subject *pkix.Name) {
+	if m.subjectCN != "" {
+		subject.CommonName = m.subjectCN
+	}
+	if m.subjectOrg != "" {
+		subject.Organization = []string{m.subjectOrg}
+	}
+	if m.subjectOU != "" {
+		subject.OrganizationalUnit = []string{m.subjectOU}
+	}
+	if m.subjectCountry != "" {
+		subject.Country = []string{m.subjectCountry}
+	}
+}
 
-	if !m.pkcs12 {
-		certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert})
-		privDER, err := x509.MarshalPKCS8PrivateKey(priv)
-		fatalIfErr(err, "failed to encode certificate key")
-		privPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privDER})
+// cloneCert reads the certificate at path, and returns its names, setting the
+// extended key usages (unless set with -eku) and key type of the new
+// certificate to match it.
+func (m *mkcert) cloneCert(path string) []string {
+	data, err := ioutil.ReadFile(path)
+	fatalIfErr(err, "failed to read the -from-cert certificate")
+	certs, err := parseCertificates(data)
+	fatalIfErr(err, "failed to parse the -from-cert certificate")
+	cert := certs[0]
 
-		if certFile == keyFile {
-			err = ioutil.WriteFile(keyFile, append(certPEM, privPEM...), 0600)
-			fatalIfErr(err, "failed to save certificate and key")
-		} else {
-			err = ioutil.WriteFile(certFile, certPEM, 0644)
-			fatalIfErr(err, "failed to save certificate")
-			err = ioutil.WriteFile(keyFile, privPEM, 0600)
-			fatalIfErr(err, "failed to save certificate key")
-		}
-	} else {
-		domainCert, _ := x509.ParseCertificate(cert)
-		pfxData, err := pkcs12.Encode(rand.Reader, priv, domainCert, []*x509.Certificate{m.caCert}, "changeit")
-		fatalIfErr(err, "failed to generate PKCS#12")
-		err = ioutil.WriteFile(p12File, pfxData, 0644)
-		fatalIfErr(err, "failed to save PKCS#12")
+	hosts := certificateHosts(cert)
+	if len(hosts) == 0 && cert.Subject.CommonName != "" {
+		hosts = []string{cert.Subject.CommonName}
+	}
+	if len(hosts) == 0 {
+		log.Fatalf("ERROR: the certificate in %q has no names to copy", path)
 	}
 
-	m.printHosts(hosts)
+	if m.extKeyUsage == nil && m.unknownExtKeyUsage == nil {
+		m.extKeyUsage = cert.ExtKeyUsage
+		m.unknownExtKeyUsage = cert.UnknownExtKeyUsage
+	}
+	switch cert.PublicKeyAlgorithm {
+	case x509.ECDSA:
+		m.ecdsa = true
+	case x509.RSA:
+	default:
+		log.Printf("Warning: mkcert can't generate %s keys like the one of %q, so the new certificate has an RSA key ⚠️", cert.PublicKeyAlgorithm, path)
+	}
+	return hosts
+}
 
-	if !m.pkcs12 {
-		if certFile == keyFile {
-			log.Printf("\nThe certificate and key are at \"%s\" ✅\n\n", certFile)
-		} else {
-			log.Printf("\nThe certificate is at \"%s\" and the key at \"%s\" ✅\n\n", certFile, keyFile)
+// certResult is the output of -json.
+type certResult struct {
+	Hosts     []string          `json:"hosts"`
+	Unicode   map[string]string `json:"unicode_names,omitempty"` // punycode hosts to their Unicode form
+	Serial    string            `json:"serial"`
+	NotBefore time.Time         `json:"not_before"`
+	NotAfter  time.Time         `json:"not_after"`
+	SHA256    string            `json:"sha256_fingerprint"`
+	SPKI      string            `json:"spki_sha256"`
+	CertFile  string            `json:"cert_file,omitempty"`
+	KeyFile   string            `json:"key_file,omitempty"`
+	P12File   string            `json:"p12_file,omitempty"`
+	Archive   string            `json:"archive_file,omitempty"`
+}
+
+func (m *mkcert) printJSON(cert *x509.Certificate, certFile, keyFile, p12File string) {
+	hosts := certificateHosts(cert)
+	var unicodeNames map[string]string
+	for _, h := range hosts {
+		if u := unicodeName(h); u != "" {
+			if unicodeNames == nil {
+				unicodeNames = make(map[string]string)
+			}
+			unicodeNames[h] = u
 		}
-	} else {
-		log.Printf("\nThe PKCS#12 bundle is at \"%s\" ✅\n", p12File)
-		log.Printf("\nThe legacy PKCS#12 encryption password is the often hardcoded default \"changeit\" ℹ️\n\n")
 	}
+	out, err := json.MarshalIndent(certResult{
+		Hosts:     hosts,
+		Unicode:   unicodeNames,
+		Serial:    serialString(cert),
+		NotBefore: cert.NotBefore.UTC(),
+		NotAfter:  cert.NotAfter.UTC(),
+		SHA256:    fingerprint(cert.Raw),
+		SPKI:      spkiHash(cert),
+		CertFile:  absPath(certFile),
+		KeyFile:   absPath(keyFile),
+		P12File:   absPath(p12File),
+		Archive:   absPath(m.archivePath),
+	}, "", "\t")
+	fatalIfErr(err, "failed to encode JSON output")
+	fmt.Printf("%s\n", out)
+}
 
-	log.Printf("It will expire on %s 🗓\n\n", expiration.Format("2 January 2006"))
+// printFingerprints prints the hashes that pinning configurations and
+// firewall policies usually ask for.
+func printFingerprints(cert *x509.Certificate) {
+	logCode("fingerprint", "SHA-256 fingerprint: %s", fingerprint(cert.Raw))
+	logCode("fingerprint", "SPKI SHA-256 hash:   %s", spkiHash(cert))
 }
 
 func (m *mkcert) printHosts(hosts []string) {
 	secondLvlWildcardRegexp := regexp.MustCompile(`(?i)^\*\.[0-9a-z_-]+$`)
-	log.Printf("\nCreated a new certificate valid for the following names 📜")
+	logCode("cert_created", "\nCreated a new certificate valid for the following names 📜")
 	for _, h := range hosts {
-		log.Printf(" - %q", h)
+		if u := unicodeName(h); u != "" {
+			logCode("cert_name", " - %q (%s)", h, u)
+		} else {
+			logCode("cert_name", " - %q", h)
+		}
 		if secondLvlWildcardRegexp.MatchString(h) {
-			log.Printf("   Warning: many browsers don't support second-level wildcards like %q ⚠️", h)
+			logCode("wildcard_unsupported", "   Warning: many browsers don't support second-level wildcards like %q ⚠️", h)
 		}
 	}
 
@@ -164,6 +452,93 @@ func (m *mkcert) printHosts(hosts []string) {
 	}
 }
 
+// unicodeName returns the Unicode form of an internationalized hostname
+// converted to punycode, like "münchen.test" for "xn--mnchen-3ya.test",
+// or "" for any other host.
+func unicodeName(host string) string {
+	if !strings.Contains(strings.ToLower(host), "xn--") {
+		return ""
+	}
+	u, err := idna.ToUnicode(host)
+	if err != nil || u == host {
+		return ""
+	}
+	return u
+}
+
+// leafKey returns the key for a new certificate. With -key-in, that's the key
+// at keyFile. With -reuse-key, it's the existing one at keyFile (or in
+// p12File, with -pkcs12) if there is one.
+func (m *mkcert) leafKey(keyFile, p12File string) (key crypto.PrivateKey, reused bool) {
+	if m.keyIn != "" {
+		data, err := ioutil.ReadFile(keyFile)
+		fatalIfErr(err, "failed to read the -key-in key")
+		signer, err := parsePrivateKey(data, m.keyPassword)
+		fatalIfErr(err, "failed to read the key in "+keyFile)
+		return signer, true
+	}
+	if m.reuseKey {
+		path := keyFile
+		if m.pkcs12 {
+			path = p12File
+		}
+		data, err := ioutil.ReadFile(path)
+		switch {
+		case err == nil && m.pkcs12:
+			password := "changeit"
+			if m.keyPassword != "" {
+				password = m.keyPassword
+			}
+			key, _, _, err = pkcs12.DecodeChain(data, password)
+			fatalIfErr(err, "failed to read the existing key in "+path)
+			verbosef("Reusing the key in %s", path)
+			return key, true
+		case err == nil:
+			signer, err := parsePrivateKey(data, m.keyPassword)
+			fatalIfErr(err, "failed to read the existing key in "+path)
+			verbosef("Reusing the key in %s", path)
+			return signer, true
+		case !os.IsNotExist(err):
+			fatalIfErr(err, "failed to read the existing key")
+		}
+		verbosef("There is no key at %s to reuse, generating a new one", path)
+	}
+	key, err := m.generateKey(false)
+	fatalIfErr(err, "failed to generate certificate key")
+	return key, false
+}
+
+// signatureAlgorithms maps the -sig-alg names to the algorithms for RSA and
+// ECDSA keys. The PSS ones are only available for RSA keys.
+var signatureAlgorithms = map[string][2]x509.SignatureAlgorithm{
+	"sha256":     {x509.SHA256WithRSA, x509.ECDSAWithSHA256},
+	"sha384":     {x509.SHA384WithRSA, x509.ECDSAWithSHA384},
+	"sha512":     {x509.SHA512WithRSA, x509.ECDSAWithSHA512},
+	"sha256-pss": {x509.SHA256WithRSAPSS, x509.UnknownSignatureAlgorithm},
+	"sha384-pss": {x509.SHA384WithRSAPSS, x509.UnknownSignatureAlgorithm},
+	"sha512-pss": {x509.SHA512WithRSAPSS, x509.UnknownSignatureAlgorithm},
+}
+
+// signatureAlgorithm returns the -sig-alg algorithm for signing with key, or
+// UnknownSignatureAlgorithm to let crypto/x509 pick the default.
+func (m *mkcert) signatureAlgorithm(key crypto.PrivateKey) x509.SignatureAlgorithm {
+	if m.sigAlg == "" || key == nil {
+		return x509.UnknownSignatureAlgorithm
+	}
+	var alg x509.SignatureAlgorithm
+	pub := key.(crypto.Signer).Public()
+	switch pub.(type) {
+	case *rsa.PublicKey:
+		alg = signatureAlgorithms[m.sigAlg][0]
+	case *ecdsa.PublicKey:
+		alg = signatureAlgorithms[m.sigAlg][1]
+	}
+	if alg == x509.UnknownSignatureAlgorithm {
+		log.Fatalf("ERROR: -sig-alg %s can't be used with the %s key of the CA", m.sigAlg, publicKeyDescription(pub))
+	}
+	return alg
+}
+
 func (m *mkcert) generateKey(rootCA bool) (crypto.PrivateKey, error) {
 	if m.ecdsa {
 		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
@@ -174,21 +549,173 @@ func (m *mkcert) generateKey(rootCA bool) (crypto.PrivateKey, error) {
 	return rsa.GenerateKey(rand.Reader, 2048)
 }
 
+// leafNotBefore returns the start of the validity of new certificates, which
+// is now unless set in the past with -not-before.
+func (m *mkcert) leafNotBefore() time.Time {
+	if !m.notBefore.IsZero() {
+		return m.notBefore
+	}
+	return time.Now()
+}
+
+// leafNotAfter returns the expiration of new certificates, which is set by
+// -validity or -valid-until, but never after the one of the issuer.
+func (m *mkcert) leafNotAfter(issuer *x509.Certificate) time.Time {
+	// Certificates last for 2 years and 3 months, which is always less than
+	// 825 days, the limit that macOS/iOS apply to all certificates,
+	// including custom roots. See https://support.apple.com/en-us/HT210176.
+	expiration := time.Now().AddDate(2, 3, 0)
+	if m.validity != 0 {
+		expiration = time.Now().Add(m.validity)
+	}
+	if !m.validUntil.IsZero() {
+		expiration = m.validUntil
+	}
+	if expiration.Sub(m.leafNotBefore()) > 825*24*time.Hour {
+		logCode("validity_too_long", "Warning: macOS and iOS reject certificates valid for more than 825 days ⚠️")
+	}
+	if expiration.After(issuer.NotAfter) {
+		verbosef("Limiting the expiration to the one of %q", issuer.Subject.CommonName)
+		expiration = issuer.NotAfter
+	}
+	return expiration
+}
+
+// expirationDate formats t for the messages printed after issuing a
+// certificate, with the time for short-lived ones.
+func (m *mkcert) expirationDate(t time.Time) string {
+	if m.validity != 0 && m.validity < 24*time.Hour {
+		return t.Local().Format("2 January 2006 15:04:05 MST")
+	}
+	return t.Format("2 January 2006")
+}
+
+// addRevocationURLs sets the Authority Information Access and CRL
+// Distribution Points of tpl, if any were configured.
+func (m *mkcert) addRevocationURLs(tpl *x509.Certificate) {
+	if m.ocspURL != "" {
+		tpl.OCSPServer = []string{m.ocspURL}
+	}
+	if m.issuerURL != "" {
+		tpl.IssuingCertificateURL = []string{m.issuerURL}
+	}
+	if m.crlURL != "" {
+		tpl.CRLDistributionPoints = []string{m.crlURL}
+	}
+}
+
+var oidUPN = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 20, 2, 3}
+
+// marshalSANsWithUPNs returns a Subject Alternative Name extension with the
+// names in tpl, and upns as Microsoft User Principal Name otherName entries,
+// which crypto/x509 can't generate. The extension takes the place of the one
+// crypto/x509 would add.
+func marshalSANsWithUPNs(tpl *x509.Certificate, upns []string) (pkix.Extension, error) {
+	// GeneralName tags, from RFC 5280, Section 4.2.1.6.
+	const (
+		nameTypeOther = 0
+		nameTypeEmail = 1
+		nameTypeDNS   = 2
+		nameTypeURI   = 6
+		nameTypeIP    = 7
+	)
+	var names []asn1.RawValue
+	for _, name := range tpl.DNSNames {
+		names = append(names, asn1.RawValue{Tag: nameTypeDNS, Class: asn1.ClassContextSpecific, Bytes: []byte(name)})
+	}
+	for _, email := range tpl.EmailAddresses {
+		names = append(names, asn1.RawValue{Tag: nameTypeEmail, Class: asn1.ClassContextSpecific, Bytes: []byte(email)})
+	}
+	for _, ip := range tpl.IPAddresses {
+		if ip4 := ip.To4(); ip4 != nil {
+			ip = ip4
+		}
+		names = append(names, asn1.RawValue{Tag: nameTypeIP, Class: asn1.ClassContextSpecific, Bytes: ip})
+	}
+	for _, uri := range tpl.URIs {
+		names = append(names, asn1.RawValue{Tag: nameTypeURI, Class: asn1.ClassContextSpecific, Bytes: []byte(uri.String())})
+	}
+	for _, upn := range upns {
+		value, err := asn1.MarshalWithParams(upn, "utf8")
+		if err != nil {
+			return pkix.Extension{}, err
+		}
+		otherName, err := asn1.Marshal(struct {
+			TypeID asn1.ObjectIdentifier
+			Value  asn1.RawValue
+		}{oidUPN, asn1.RawValue{Tag: 0, Class: asn1.ClassContextSpecific, IsCompound: true, Bytes: value}})
+		if err != nil {
+			return pkix.Extension{}, err
+		}
+		// Turn the SEQUENCE into an [0] IMPLICIT otherName.
+		var seq asn1.RawValue
+		if _, err := asn1.Unmarshal(otherName, &seq); err != nil {
+			return pkix.Extension{}, err
+		}
+		names = append(names, asn1.RawValue{Tag: nameTypeOther, Class: asn1.ClassContextSpecific, IsCompound: true, Bytes: seq.Bytes})
+	}
+	value, err := asn1.Marshal(names)
+	return pkix.Extension{Id: oidExtensionSubjectAltName, Value: value}, err
+}
+
+// addSANs adds each host to the matching Subject Alternative Name field.
+func addSANs(tpl *x509.Certificate, hosts []string) {
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			tpl.IPAddresses = append(tpl.IPAddresses, ip)
+		} else if email, err := mail.ParseAddress(h); err == nil && email.Address == h {
+			tpl.EmailAddresses = append(tpl.EmailAddresses, h)
+		} else if uriName, err := url.Parse(h); err == nil && uriName.Scheme != "" && uriName.Host != "" {
+			tpl.URIs = append(tpl.URIs, uriName)
+		} else {
+			tpl.DNSNames = append(tpl.DNSNames, h)
+		}
+	}
+}
+
+// nameTemplateData is the data available to -name-template.
+type nameTemplateData struct {
+	FirstHost string   // the first name, with "*" and ":" replaced
+	Hosts     []string // all the names, as given
+	Extra     int      // the number of names after the first one
+	Client    bool     // whether -client is set
+	Date      string   // the current date, like "2006-01-02"
+}
+
 func (m *mkcert) fileNames(hosts []string) (certFile, keyFile, p12File string) {
-	defaultName := strings.Replace(hosts[0], ":", "_", -1)
-	defaultName = strings.Replace(defaultName, "*", "_wildcard", -1)
+	firstHost := strings.Replace(hosts[0], ":", "_", -1)
+	firstHost = strings.Replace(firstHost, "*", "_wildcard", -1)
+	defaultName := firstHost
 	if len(hosts) > 1 {
 		defaultName += "+" + strconv.Itoa(len(hosts)-1)
 	}
 	if m.client {
 		defaultName += "-client"
 	}
+	if m.nameTemplate != nil {
+		var name strings.Builder
+		err := m.nameTemplate.Execute(&name, nameTemplateData{
+			FirstHost: firstHost, Hosts: hosts, Extra: len(hosts) - 1,
+			Client: m.client, Date: time.Now().Format("2006-01-02"),
+		})
+		fatalIfErr(err, "failed to apply the -name-template")
+		if name.Len() == 0 {
+			log.Fatalln("ERROR: the -name-template produced an empty file name")
+		}
+		defaultName = name.String()
+	}
 
 	certFile = "./" + defaultName + ".pem"
+	if m.p7b {
+		certFile = "./" + defaultName + ".p7b"
+	}
+	keyFile = "./" + defaultName + "-key.pem"
+	if m.profile != "" {
+		certFile, keyFile = m.profileFileNames()
+	}
 	if m.certFile != "" {
 		certFile = m.certFile
 	}
-	keyFile = "./" + defaultName + "-key.pem"
 	if m.keyFile != "" {
 		keyFile = m.keyFile
 	}
@@ -197,9 +724,78 @@ func (m *mkcert) fileNames(hosts []string) (certFile, keyFile, p12File string) {
 		p12File = m.p12File
 	}
 
+	if m.layoutDir() != "" {
+		certFile, keyFile = m.layoutFileNames(defaultName)
+	}
+
+	if m.stdout {
+		certFile, p12File = "-", "-"
+		if m.stdoutKey {
+			keyFile = "-"
+		}
+	}
+
 	return
 }
 
+// encodeCertFile returns the contents of the certificate file for leaf, which
+// is certPEM unless -p7b is set.
+func (m *mkcert) encodeCertFile(leaf *x509.Certificate, certPEM []byte) []byte {
+	if !m.p7b {
+		return certPEM
+	}
+	certs := append([]*x509.Certificate{leaf}, m.chainCerts()...)
+	p7b, err := certsOnlyPKCS7(append(certs, m.caCert))
+	fatalIfErr(err, "failed to encode the PKCS #7 bundle")
+	return p7b
+}
+
+// checkOverwrite fails if any of paths already exists, unless -force is set or
+// certFile holds an expired certificate, which is safe to replace along with
+// its key.
+func (m *mkcert) checkOverwrite(certFile string, paths ...string) {
+	if m.force {
+		return
+	}
+	if data, err := ioutil.ReadFile(certFile); certFile != "" && err == nil {
+		if certs, err := parseCertificates(data); err == nil && time.Now().After(certs[0].NotAfter) {
+			return
+		}
+	}
+	for _, path := range paths {
+		if path != "-" && pathExists(path) {
+			fatalCode(exitFailure, "file_exists", "ERROR: %q already exists, use -force to overwrite it", path)
+		}
+	}
+}
+
+// writeOutput writes data to path, or to standard output if path is "-".
+func writeOutput(path string, data []byte, perm os.FileMode) error {
+	if path == "-" {
+		_, err := os.Stdout.Write(data)
+		return err
+	}
+	verbosef("Writing %s", path)
+	return writeFileAtomic(path, data, perm)
+}
+
+// outputName describes path for the messages printed after writing to it.
+func outputName(path string) string {
+	if path == "-" {
+		return "standard output"
+	}
+	return strconv.Quote(path)
+}
+
+func (m *mkcert) printIntermediate() {
+	if m.interCert != nil {
+		log.Printf("It was signed by the intermediate CA %q, which follows it in the certificate file ⛓\n\n", m.interCert.Subject.CommonName)
+	}
+	if m.crossCert != nil {
+		log.Printf("It's followed by the local CA cross-signed by the previous one, for clients that only trust that ⛓\n\n")
+	}
+}
+
 func randomSerialNumber() *big.Int {
 	serialNumberLimit := new(big.Int).Lsh(big.NewInt(1), 128)
 	serialNumber, err := rand.Int(rand.Reader, serialNumberLimit)
@@ -207,32 +803,91 @@ func randomSerialNumber() *big.Int {
 	return serialNumber
 }
 
-func (m *mkcert) makeCertFromCSR() {
-	if m.caKey == nil {
-		log.Fatalln("ERROR: can't create new certificates because the CA key (rootCA-key.pem) is missing")
+// expandCSRPaths returns the CSR files in paths, replacing each directory
+// with the ".csr" and ".req" files it contains.
+func expandCSRPaths(paths []string) []string {
+	var files []string
+	for _, path := range paths {
+		if path == "-" {
+			if len(paths) > 1 {
+				log.Fatalln("ERROR: can't read a CSR from stdin along with other CSRs")
+			}
+			return paths
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			fatalCode(exitCSRInvalid, "csr_invalid", "ERROR: failed to read the CSR: %s", err)
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		entries, err := ioutil.ReadDir(path)
+		fatalIfErr(err, "failed to read the CSR directory")
+		var found bool
+		for _, e := range entries {
+			switch strings.ToLower(filepath.Ext(e.Name())) {
+			case ".csr", ".req":
+				if !e.IsDir() {
+					files = append(files, filepath.Join(path, e.Name()))
+					found = true
+				}
+			}
+		}
+		if !found {
+			log.Fatalf("ERROR: there are no .csr or .req files in %q", path)
+		}
 	}
+	return files
+}
 
-	csrPEMBytes, err := ioutil.ReadFile(m.csrPath)
-	fatalIfErr(err, "failed to read the CSR")
-	csrPEM, _ := pem.Decode(csrPEMBytes)
-	if csrPEM == nil {
-		log.Fatalln("ERROR: failed to read the CSR: unexpected content")
+// readCSR reads a PEM or DER certificate request from path, or from the
+// standard input if path is "-".
+func readCSR(path string) (*x509.CertificateRequest, error) {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		data, err = ioutil.ReadFile(path)
+	}
+	if err != nil {
+		return nil, err
 	}
-	if csrPEM.Type != "CERTIFICATE REQUEST" &&
-		csrPEM.Type != "NEW CERTIFICATE REQUEST" {
-		log.Fatalln("ERROR: failed to read the CSR: expected CERTIFICATE REQUEST, got " + csrPEM.Type)
+	if csrPEM, _ := pem.Decode(data); csrPEM != nil {
+		if csrPEM.Type != "CERTIFICATE REQUEST" &&
+			csrPEM.Type != "NEW CERTIFICATE REQUEST" {
+			return nil, errors.New("expected CERTIFICATE REQUEST, got " + csrPEM.Type)
+		}
+		data = csrPEM.Bytes
+	} else if len(data) == 0 || data[0] != 0x30 { // ASN.1 SEQUENCE
+		return nil, errors.New("unexpected content, expected a PEM or DER CSR")
 	}
-	csr, err := x509.ParseCertificateRequest(csrPEM.Bytes)
-	fatalIfErr(err, "failed to parse the CSR")
-	fatalIfErr(csr.CheckSignature(), "invalid CSR signature")
+	return x509.ParseCertificateRequest(data)
+}
 
-	expiration := time.Now().AddDate(2, 3, 0)
+// issueCertFromCSR is like issueCert, for the CSR at m.csrPath.
+func (m *mkcert) issueCertFromCSR() error {
+	issuerCert, issuerKey := m.issuer()
+	if issuerKey == nil {
+		return errCAKeyMissing
+	}
+
+	csr, err := readCSR(m.csrPath)
+	if err != nil {
+		return csrError{fmt.Errorf("failed to read the CSR: %w", err)}
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return csrError{fmt.Errorf("invalid CSR signature: %w", err)}
+	}
+
+	expiration := m.leafNotAfter(issuerCert)
 	tpl := &x509.Certificate{
 		SerialNumber:    randomSerialNumber(),
 		Subject:         csr.Subject,
 		ExtraExtensions: csr.Extensions, // includes requested SANs, KUs and EKUs
 
-		NotBefore: time.Now(), NotAfter: expiration,
+		NotBefore: m.leafNotBefore(), NotAfter: expiration,
 
 		// If the CSR does not request a SAN extension, fix it up for them as
 		// the Common Name field does not work in modern browsers. Otherwise,
@@ -245,44 +900,109 @@ func (m *mkcert) makeCertFromCSR() {
 		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
 	}
 
-	if m.client {
-		tpl.ExtKeyUsage = append(tpl.ExtKeyUsage, x509.ExtKeyUsageClientAuth)
+	if len(m.addSANs) > 0 {
+		// Rebuild the SAN extension from the parsed CSR names, so that the
+		// -add-san ones can be merged in.
+		sanExt := findExtension(csr.Extensions, oidExtensionSubjectAltName)
+		if sanExt != nil && !m.dropSANs && hasOtherNameSAN(sanExt) {
+			return csrError{errors.New("the CSR requests otherName SANs (like UPNs) that can't be merged with -add-san, use -drop-sans to replace them")}
+		}
+		tpl.ExtraExtensions = withoutExtension(tpl.ExtraExtensions, oidExtensionSubjectAltName)
+		switch {
+		case m.dropSANs || sanExt == nil && csr.Subject.CommonName == "":
+			tpl.DNSNames = nil
+		case sanExt != nil:
+			tpl.DNSNames, tpl.IPAddresses = csr.DNSNames, csr.IPAddresses
+			tpl.EmailAddresses, tpl.URIs = csr.EmailAddresses, csr.URIs
+		}
+		// Otherwise, keep the Common Name fallback from above.
+		addSANs(tpl, validateHosts(m.addSANs))
 	}
-	if len(csr.EmailAddresses) > 0 {
-		tpl.ExtKeyUsage = append(tpl.ExtKeyUsage, x509.ExtKeyUsageEmailProtection)
+	m.addRevocationURLs(tpl)
+	m.overrideSubject(&tpl.Subject)
+	if m.keyUsage != 0 {
+		// Replace the usages requested by the CSR with the -key-usage ones.
+		tpl.ExtraExtensions = withoutExtension(tpl.ExtraExtensions, oidExtensionKeyUsage)
+		tpl.KeyUsage = m.keyUsage
 	}
 
-	cert, err := x509.CreateCertificate(rand.Reader, tpl, m.caCert, csr.PublicKey, m.caKey)
-	fatalIfErr(err, "failed to generate certificate")
-	c, err := x509.ParseCertificate(cert)
-	fatalIfErr(err, "failed to parse generated certificate")
+	for _, ext := range m.leafExts {
+		// Replace any extension of the same type requested by the CSR.
+		tpl.ExtraExtensions = append(withoutExtension(tpl.ExtraExtensions, ext.Id), ext)
+	}
+
+	if m.extKeyUsage != nil || m.unknownExtKeyUsage != nil {
+		// Replace the usages requested by the CSR with the -eku ones.
+		tpl.ExtraExtensions = withoutExtension(tpl.ExtraExtensions, oidExtensionExtKeyUsage)
+		tpl.ExtKeyUsage, tpl.UnknownExtKeyUsage = m.extKeyUsage, m.unknownExtKeyUsage
+	} else {
+		if m.client {
+			tpl.ExtKeyUsage = append(tpl.ExtKeyUsage, x509.ExtKeyUsageClientAuth)
+		}
+		if len(tpl.EmailAddresses) > 0 || len(csr.EmailAddresses) > 0 {
+			tpl.ExtKeyUsage = append(tpl.ExtKeyUsage, x509.ExtKeyUsageEmailProtection)
+		}
+	}
 
-	var hosts []string
-	hosts = append(hosts, c.DNSNames...)
-	hosts = append(hosts, c.EmailAddresses...)
-	for _, ip := range c.IPAddresses {
-		hosts = append(hosts, ip.String())
+	tpl.SignatureAlgorithm = m.signatureAlgorithm(issuerKey)
+	m.applyStrictProfile(tpl, csr.PublicKey, issuerCert)
+	cert, err := x509.CreateCertificate(rand.Reader, tpl, issuerCert, csr.PublicKey, issuerKey)
+	if err != nil {
+		return fmt.Errorf("failed to generate certificate: %w", err)
 	}
-	for _, uri := range c.URIs {
-		hosts = append(hosts, uri.String())
+	c, err := x509.ParseCertificate(cert)
+	if err != nil {
+		return fmt.Errorf("failed to parse generated certificate: %w", err)
 	}
+	m.checkNameConstraints(c)
+
+	// The names requested by the CSR, and the -add-san ones.
+	hosts := certificateHosts(c)
+	m.checkPublicDomains(hosts)
 	certFile, _, _ := m.fileNames(hosts)
+	m.checkOverwrite(certFile, certFile)
 
-	err = ioutil.WriteFile(certFile, pem.EncodeToMemory(
-		&pem.Block{Type: "CERTIFICATE", Bytes: cert}), 0644)
-	fatalIfErr(err, "failed to save certificate")
+	err = writeOutput(certFile, m.encodeCertFile(c, append(pem.EncodeToMemory(
+		&pem.Block{Type: "CERTIFICATE", Bytes: cert}), m.chainPEM()...)), 0644)
+	if err != nil {
+		return fmt.Errorf("failed to save certificate: %w", err)
+	}
+
+	if err := m.recordIssued(c, certFile, "", ""); err != nil {
+		return err
+	}
+	m.logIssuance("csr", c)
+
+	if m.jsonOutput {
+		m.printJSON(c, certFile, "", "")
+		return nil
+	}
 
 	m.printHosts(hosts)
 
-	log.Printf("\nThe certificate is at \"%s\" ✅\n\n", certFile)
+	logCode("cert_saved", "\nThe certificate is at %s ✅\n\n", outputName(certFile))
+
+	m.printIntermediate()
 
-	log.Printf("It will expire on %s 🗓\n\n", expiration.Format("2 January 2006"))
+	printFingerprints(c)
+	logCode("cert_expiration", "\nIt will expire on %s 🗓\n\n", m.expirationDate(expiration))
+	return nil
 }
 
 // loadCA will load or create the CA at CAROOT.
 func (m *mkcert) loadCA() {
+	if m.vaultPath != "" {
+		m.loadVaultCA()
+		return
+	}
 	if !pathExists(filepath.Join(m.CAROOT, rootName)) {
-		m.newCA()
+		// Another mkcert might be creating the CA at the same time, and
+		// only one of them should.
+		unlock := m.lockCAROOT()
+		if !pathExists(filepath.Join(m.CAROOT, rootName)) {
+			m.newCA()
+		}
+		unlock()
 	}
 
 	certPEMBlock, err := ioutil.ReadFile(filepath.Join(m.CAROOT, rootName))
@@ -293,7 +1013,13 @@ func (m *mkcert) loadCA() {
 	}
 	m.caCert, err = x509.ParseCertificate(certDERBlock.Bytes)
 	fatalIfErr(err, "failed to parse the CA certificate")
+	m.loadCrossCert()
 
+	if uri, err := ioutil.ReadFile(filepath.Join(m.CAROOT, rootKeyURIName)); err == nil {
+		m.caKey, err = m.openKeyURI(strings.TrimSpace(string(uri)), m.caCert.PublicKey)
+		fatalIfErr(err, "failed to open the CA key")
+		return
+	}
 	if !pathExists(filepath.Join(m.CAROOT, rootKeyName)) {
 		return // keyless mode, where only -install works
 	}
@@ -308,23 +1034,45 @@ func (m *mkcert) loadCA() {
 	fatalIfErr(err, "failed to parse the CA key")
 }
 
+// openKeyURI returns the CA key at uri, whose public key is pub.
+func (m *mkcert) openKeyURI(uri string, pub crypto.PublicKey) (crypto.Signer, error) {
+	switch {
+	case strings.HasPrefix(uri, pkcs11Scheme):
+		return openPKCS11Key(uri, pub)
+	case strings.HasPrefix(uri, keyringScheme):
+		return openKeyringKey(m.CAROOT, uri, pub)
+	case strings.HasPrefix(uri, tpmScheme):
+		name, err := tpmKeyName(uri)
+		if err != nil {
+			return nil, err
+		}
+		return openTPMKey(m.CAROOT, name, pub)
+	default:
+		return nil, fmt.Errorf("unsupported key URI %q", uri)
+	}
+}
+
 func (m *mkcert) newCA() {
-	priv, err := m.generateKey(true)
+	var priv crypto.PrivateKey
+	var err error
+	if m.tpm {
+		name := newTPMKeyName()
+		log.Printf("Generating the CA key in the TPM, this might take a while ⏳")
+		priv, err = newTPMKey(m.CAROOT, name, m.ecdsa)
+		m.caKeyURI = tpmScheme + name
+	} else if m.yubikeySlot != "" {
+		priv, err = m.newYubiKeyKey()
+	} else if m.caKeyURI != "" {
+		priv, err = m.newPKCS11Key(m.caKeyURI)
+	} else {
+		priv, err = m.generateKey(true)
+	}
 	fatalIfErr(err, "failed to generate the CA key")
 	pub := priv.(crypto.Signer).Public()
 
-	spkiASN1, err := x509.MarshalPKIXPublicKey(pub)
+	skid, err := subjectKeyID(pub)
 	fatalIfErr(err, "failed to encode public key")
 
-	var spki struct {
-		Algorithm        pkix.AlgorithmIdentifier
-		SubjectPublicKey asn1.BitString
-	}
-	_, err = asn1.Unmarshal(spkiASN1, &spki)
-	fatalIfErr(err, "failed to decode public key")
-
-	skid := sha1.Sum(spki.SubjectPublicKey.Bytes)
-
 	tpl := &x509.Certificate{
 		SerialNumber: randomSerialNumber(),
 		Subject: pkix.Name{
@@ -337,32 +1085,122 @@ func (m *mkcert) newCA() {
 			// https://github.com/FiloSottile/mkcert/issues/47
 			CommonName: userFullName + " - RootCA",
 		},
-		SubjectKeyId: skid[:],
+		SubjectKeyId: skid,
 
 		NotAfter:  time.Now().AddDate(10, 0, 0),
 		NotBefore: time.Now(),
 
-		KeyUsage: x509.KeyUsageCertSign,
+		KeyUsage: x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
 
+		// Allow a single level of intermediates, see -use-inter.
 		BasicConstraintsValid: true,
 		IsCA:                  true,
-		MaxPathLenZero:        true,
+		MaxPathLen:            1,
+	}
+	tpl.ExtraExtensions = m.rootExts
+	if len(m.rootDomains) > 0 || len(m.rootRanges) > 0 {
+		tpl.PermittedDNSDomainsCritical = true
+		tpl.PermittedDNSDomains = m.rootDomains
+		tpl.PermittedIPRanges = m.rootRanges
+	}
+	if m.caProfile != "" {
+		// Tell the profiles apart in the trust store UIs.
+		tpl.Subject.CommonName = userFullName + " - " + m.caProfile + " RootCA"
 	}
 
+	tpl.SignatureAlgorithm = m.signatureAlgorithm(priv)
 	cert, err := x509.CreateCertificate(rand.Reader, tpl, tpl, pub, priv)
 	fatalIfErr(err, "failed to generate CA certificate")
 
-	privDER, err := x509.MarshalPKCS8PrivateKey(priv)
-	fatalIfErr(err, "failed to encode CA key")
-	err = ioutil.WriteFile(filepath.Join(m.CAROOT, rootKeyName), pem.EncodeToMemory(
-		&pem.Block{Type: "PRIVATE KEY", Bytes: privDER}), 0400)
-	fatalIfErr(err, "failed to save CA key")
+	switch {
+	case m.caKeyURI != "":
+		verbosef("Writing %s and %s", filepath.Join(m.CAROOT, rootKeyURIName), filepath.Join(m.CAROOT, rootName))
+		err = writeFileAtomic(filepath.Join(m.CAROOT, rootKeyURIName), []byte(m.caKeyURI+"\n"), 0600)
+		fatalIfErr(err, "failed to save the CA key URI")
+
+		err = writeFileAtomic(filepath.Join(m.CAROOT, rootName), pem.EncodeToMemory(
+			&pem.Block{Type: "CERTIFICATE", Bytes: cert}), 0644)
+		fatalIfErr(err, "failed to save CA certificate")
+
+		if m.tpm {
+			logCode("ca_created", "Created a new local CA, with its non-exportable key in the TPM 💥\n")
+		} else if m.yubikeySlot != "" {
+			m.importYubiKeyCert()
+			logCode("ca_created", "Created a new local CA, with its key in the YubiKey slot %s 💥\n", m.yubikeySlot)
+		} else {
+			logCode("ca_created", "Created a new local CA, with its key in the PKCS#11 token 💥\n")
+		}
+	case m.keyring:
+		privDER, err := x509.MarshalPKCS8PrivateKey(priv)
+		fatalIfErr(err, "failed to encode CA key")
+		m.saveKeyringKey(privDER)
+
+		err = writeFileAtomic(filepath.Join(m.CAROOT, rootName), pem.EncodeToMemory(
+			&pem.Block{Type: "CERTIFICATE", Bytes: cert}), 0644)
+		fatalIfErr(err, "failed to save CA certificate")
+
+		logCode("ca_created", "Created a new local CA, with its key in %s 💥\n", keyringDescription)
+	case m.vaultPath != "":
+		privDER, err := x509.MarshalPKCS8PrivateKey(priv)
+		fatalIfErr(err, "failed to encode CA key")
+		m.saveVaultCA(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert}),
+			pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privDER}))
+		logCode("ca_created", "Created a new local CA in Vault at %q 💥\n", vaultScheme+m.vaultPath)
+	default:
+		privDER, err := x509.MarshalPKCS8PrivateKey(priv)
+		fatalIfErr(err, "failed to encode CA key")
+		verbosef("Writing %s and %s", filepath.Join(m.CAROOT, rootKeyName), filepath.Join(m.CAROOT, rootName))
+		err = writeFileAtomic(filepath.Join(m.CAROOT, rootKeyName), pem.EncodeToMemory(
+			&pem.Block{Type: "PRIVATE KEY", Bytes: privDER}), 0400)
+		fatalIfErr(err, "failed to save CA key")
+
+		err = writeFileAtomic(filepath.Join(m.CAROOT, rootName), pem.EncodeToMemory(
+			&pem.Block{Type: "CERTIFICATE", Bytes: cert}), 0644)
+		fatalIfErr(err, "failed to save CA certificate")
 
-	err = ioutil.WriteFile(filepath.Join(m.CAROOT, rootName), pem.EncodeToMemory(
-		&pem.Block{Type: "CERTIFICATE", Bytes: cert}), 0644)
-	fatalIfErr(err, "failed to save CA certificate")
+		logCode("ca_created", "Created a new local CA 💥\n")
+	}
+	caCert, err := x509.ParseCertificate(cert)
+	fatalIfErr(err, "failed to parse the new CA certificate")
+	printFingerprints(caCert)
+	if tpl.PermittedDNSDomainsCritical {
+		log.Printf("It can only issue certificates for %s 🔒\n", constraintsDescription(tpl))
+	}
+}
 
-	log.Printf("Created a new local CA 💥\n")
+// subjectKeyID returns the SHA-1 hash of the public key bit string, the
+// Subject Key Identifier method (1) of RFC 5280, Section 4.2.1.2.
+func subjectKeyID(pub crypto.PublicKey) ([]byte, error) {
+	spkiASN1, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return nil, err
+	}
+	var spki struct {
+		Algorithm        pkix.AlgorithmIdentifier
+		SubjectPublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(spkiASN1, &spki); err != nil {
+		return nil, err
+	}
+	skid := sha1.Sum(spki.SubjectPublicKey.Bytes)
+	return skid[:], nil
+}
+
+// applyStrictProfile adds to a leaf template what the -strict RFC 5280
+// profile requires beyond what crypto/x509 does by default: a Subject Key
+// Identifier, and an Authority Key Identifier matching the issuer one. The
+// latter is set automatically, as long as the issuer has a Subject Key
+// Identifier, which might not be the case of an adopted CA.
+func (m *mkcert) applyStrictProfile(tpl *x509.Certificate, pub crypto.PublicKey, issuer *x509.Certificate) {
+	if !m.strict {
+		return
+	}
+	skid, err := subjectKeyID(pub)
+	fatalIfErr(err, "failed to encode public key")
+	tpl.SubjectKeyId = skid
+	if len(issuer.SubjectKeyId) == 0 {
+		log.Printf("Warning: the CA %q has no Subject Key Identifier, so the certificate can't have an Authority Key Identifier ⚠️", issuer.Subject.CommonName)
+	}
 }
 
 func (m *mkcert) caUniqueName() string {
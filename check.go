@@ -0,0 +1,60 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strings"
+)
+
+// checkFiles checks a certificate file, and optionally its key, offline: the
+// certificate must chain to the local CA (through any intermediates in the
+// same file), be currently valid, and cover the -check-name names, and the
+// key must match it.
+func (m *mkcert) checkFiles(keyPath string) {
+	data, err := ioutil.ReadFile(m.checkPath)
+	fatalIfErr(err, "failed to read the certificate")
+	certs, err := parseCertificates(data)
+	fatalIfErr(err, "failed to parse the certificate")
+	leaf := certs[0]
+
+	fmt.Printf("Subject:     %s\n", leaf.Subject)
+	fmt.Printf("Issuer:      %s\n", leaf.Issuer)
+	fmt.Printf("Names:       %s\n\n", strings.Join(certificateHosts(leaf), ", "))
+
+	c := &checker{}
+	m.checkLeaf(c, leaf, append(certs[1:], m.chainCerts()...), m.checkNames, x509.ExtKeyUsageAny)
+
+	// The key can also be in the certificate file, like with -cert-file and
+	// -key-file set to the same path.
+	if keyPath != "" {
+		data, err = ioutil.ReadFile(keyPath)
+		fatalIfErr(err, "failed to read the key")
+	}
+	_, key, err := parseCertAndKey(data)
+	switch {
+	case err != nil:
+		c.check(false, "The key can't be parsed: %s", err)
+	case key == nil && keyPath != "":
+		c.check(false, "There is no private key in %q", keyPath)
+	case key != nil:
+		signer, ok := key.(crypto.Signer)
+		matches := ok && signer.Public().(interface{ Equal(crypto.PublicKey) bool }).Equal(leaf.PublicKey)
+		if matches {
+			c.check(true, "The key matches the certificate")
+		} else {
+			c.check(false, "The key doesn't match the certificate")
+		}
+	}
+
+	if c.failed {
+		log.Fatalln("\nERROR:", m.checkPath, "failed the checks")
+	}
+	log.Printf("\n%s passed all the checks 🎉\n\n", m.checkPath)
+}
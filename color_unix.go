@@ -0,0 +1,14 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !windows
+
+package main
+
+import "os"
+
+// enableColors reports whether f can render ANSI colors.
+func enableColors(f *os.File) bool {
+	return true
+}
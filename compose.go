@@ -0,0 +1,168 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// composeService is the part of a Compose service that names it on the
+// network.
+type composeService struct {
+	ContainerName string          `json:"container_name"`
+	Hostname      string          `json:"hostname"`
+	Domainname    string          `json:"domainname"`
+	Labels        json.RawMessage `json:"labels"`
+	Networks      json.RawMessage `json:"networks"`
+}
+
+// composeHostsLabel lists additional names for a service, separated by
+// commas or spaces.
+const composeHostsLabel = "mkcert.hosts"
+
+// traefikHostRegexp matches the names in Traefik router rules like
+// "Host(`a.test`) || Host(`b.test`, `c.test`)".
+var traefikHostRegexp = regexp.MustCompile("Host(?:SNI)?\\(([^)]*)\\)")
+
+// makeComposeCerts generates a certificate for each service of the Compose
+// file at m.composePath, or only for the ones named in services, saving
+// them in "certs/SERVICE/" next to it.
+func (m *mkcert) makeComposeCerts(services []string) {
+	all := loadComposeServices(m.composePath)
+	if len(services) == 0 {
+		for name := range all {
+			services = append(services, name)
+		}
+		sort.Strings(services)
+	}
+	for _, name := range services {
+		if _, ok := all[name]; !ok {
+			log.Fatalf("ERROR: there is no service %q in %q", name, m.composePath)
+		}
+	}
+
+	dir := filepath.Dir(m.composePath)
+	for _, name := range services {
+		hosts := validateHosts(all[name].hosts(name))
+		certDir := filepath.Join(dir, "certs", name)
+		fatalIfErr(os.MkdirAll(certDir, 0755), "failed to create the certificates directory")
+		c := *m
+		c.certFile, c.keyFile = filepath.Join(certDir, "cert.pem"), filepath.Join(certDir, "key.pem")
+		c.makeCert(hosts)
+	}
+
+	log.Printf("Add these volumes to the Compose file to mount the certificates and keys at \"/certs\" 👇\n\n")
+	fmt.Println("services:")
+	for _, name := range services {
+		fmt.Printf("  %s:\n    volumes:\n      - ./certs/%s:/certs:ro\n", name, name)
+	}
+}
+
+// loadComposeServices parses the services of a Compose file. JSON files are
+// read directly, while YAML ones are converted by "docker compose config",
+// which also applies any override files and variables.
+func loadComposeServices(path string) map[string]composeService {
+	data, err := ioutil.ReadFile(path)
+	fatalIfErr(err, "failed to read the Compose file")
+	if !json.Valid(data) {
+		if !binaryExists("docker") {
+			log.Fatalf("ERROR: reading the YAML Compose file %q requires \"docker compose\"", path)
+		}
+		cmd := exec.Command("docker", "compose", "-f", path, "config", "--format", "json")
+		verbosef("Running %q", cmd.Args)
+		data, err = cmd.Output()
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			log.Fatalf("ERROR: \"docker compose config\" failed: %s", strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		fatalIfErr(err, "failed to run \"docker compose config\"")
+	}
+	var project struct {
+		Services map[string]composeService `json:"services"`
+	}
+	fatalIfErr(json.Unmarshal(data, &project), "failed to parse the Compose file")
+	if len(project.Services) == 0 {
+		log.Fatalf("ERROR: the Compose file %q has no services", path)
+	}
+	return project.Services
+}
+
+// hosts returns the names the service named name is reachable at: the
+// service and container names, the hostname, the network aliases, and the
+// names in the mkcert.hosts label and in Traefik Host rules.
+func (s composeService) hosts(name string) []string {
+	hosts := []string{name, s.ContainerName}
+	if s.Hostname != "" && s.Domainname != "" {
+		hosts = append(hosts, s.Hostname+"."+s.Domainname)
+	}
+	hosts = append(hosts, s.Hostname)
+
+	// Networks are a list of names, or a map of names to their options.
+	var networks map[string]*struct {
+		Aliases []string `json:"aliases"`
+	}
+	if json.Unmarshal(s.Networks, &networks) == nil {
+		var names []string
+		for n := range networks {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		for _, n := range names {
+			if networks[n] != nil {
+				hosts = append(hosts, networks[n].Aliases...)
+			}
+		}
+	}
+
+	// Labels are a map, or a list of "key=value" strings.
+	labels := make(map[string]string)
+	if json.Unmarshal(s.Labels, &labels) != nil {
+		var list []string
+		json.Unmarshal(s.Labels, &list)
+		for _, l := range list {
+			kv := strings.SplitN(l, "=", 2)
+			if len(kv) == 2 {
+				labels[kv[0]] = kv[1]
+			}
+		}
+	}
+	var keys []string
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		switch {
+		case k == composeHostsLabel:
+			hosts = append(hosts, strings.FieldsFunc(labels[k], func(r rune) bool {
+				return r == ',' || r == ' '
+			})...)
+		case strings.HasPrefix(k, "traefik.") && strings.HasSuffix(k, ".rule"):
+			for _, match := range traefikHostRegexp.FindAllStringSubmatch(labels[k], -1) {
+				for _, h := range strings.Split(match[1], ",") {
+					hosts = append(hosts, strings.Trim(strings.TrimSpace(h), "`\"'"))
+				}
+			}
+		}
+	}
+
+	var unique []string
+	seen := make(map[string]bool)
+	for _, h := range hosts {
+		if h != "" && !seen[strings.ToLower(h)] {
+			seen[strings.ToLower(h)] = true
+			unique = append(unique, h)
+		}
+	}
+	return unique
+}
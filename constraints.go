@@ -0,0 +1,88 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+var constraintDomainRe = regexp.MustCompile(`(?i)^[0-9a-z_-]+(\.[0-9a-z_-]+)*$`)
+
+// parseNameConstraints parses the comma-separated list of -root-constrain or
+// -inter-constrain,
+// where each entry is a domain (which includes its subdomains), an IP
+// address, or a CIDR range.
+func parseNameConstraints(spec string) (domains []string, ranges []*net.IPNet, err error) {
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if _, ipNet, err := net.ParseCIDR(entry); err == nil {
+			ranges = append(ranges, ipNet)
+			continue
+		}
+		if ip := net.ParseIP(entry); ip != nil {
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			ranges = append(ranges, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		domain, err := idna.Lookup.ToASCII(strings.TrimPrefix(entry, "."))
+		if err != nil || !constraintDomainRe.MatchString(domain) {
+			return nil, nil, fmt.Errorf("%q is not a valid domain, IP address or CIDR range", entry)
+		}
+		domains = append(domains, domain)
+	}
+	if len(domains) == 0 && len(ranges) == 0 {
+		return nil, nil, errors.New("no names specified")
+	}
+	return domains, ranges, nil
+}
+
+// checkNameConstraints fails if the local CA or the intermediate in use is
+// name constrained and leaf is outside its constraints, as clients would
+// reject it anyway.
+func (m *mkcert) checkNameConstraints(leaf *x509.Certificate) {
+	constrained := m.caCert
+	if m.interCert != nil && (len(m.interCert.PermittedDNSDomains) > 0 || len(m.interCert.PermittedIPRanges) > 0) {
+		constrained = m.interCert
+	}
+	if len(constrained.PermittedDNSDomains) == 0 && len(constrained.PermittedIPRanges) == 0 {
+		return
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(m.caCert)
+	intermediates := x509.NewCertPool()
+	if m.interCert != nil {
+		intermediates.AddCert(m.interCert)
+	}
+	_, err := leaf.Verify(x509.VerifyOptions{
+		Roots: roots, Intermediates: intermediates,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err, ok := err.(x509.CertificateInvalidError); ok && err.Reason == x509.CANotAuthorizedForThisName {
+		log.Fatalf("ERROR: the CA %q is constrained to %s, so it can't issue this certificate: %s",
+			constrained.Subject.CommonName, constraintsDescription(constrained), err.Detail)
+	}
+}
+
+func constraintsDescription(cert *x509.Certificate) string {
+	names := append([]string{}, cert.PermittedDNSDomains...)
+	for _, r := range cert.PermittedIPRanges {
+		names = append(names, r.String())
+	}
+	return fmt.Sprintf("%q", names)
+}
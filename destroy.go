@@ -0,0 +1,183 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// destroyCA uninstalls the local CA, and the retired ones, from the trust
+// stores, deletes their keys from the TPM or the keyring, and wipes the
+// CAROOT, after asking for confirmation unless -force is set.
+//
+// The CA profiles in subdirectories of the CAROOT are left alone.
+func (m *mkcert) destroyCA() {
+	if m.vaultPath != "" {
+		log.Fatalln("ERROR: -destroy is not supported with a Vault CAROOT, delete the CA from Vault instead")
+	}
+	if !pathExists(filepath.Join(m.CAROOT, rootName)) {
+		fatalCode(exitCAMissing, "ca_missing", "ERROR: there is no local CA in %q", m.CAROOT)
+	}
+	paths := m.destroyPaths()
+
+	log.Printf("This will uninstall the local CA from the trust stores, and delete it for good:")
+	for _, path := range paths {
+		log.Printf(" - %q", path)
+	}
+	log.Printf("Certificates issued by it will stop working everywhere ⚠️\n\n")
+	if !m.force {
+		if !term.IsTerminal(int(os.Stdin.Fd())) {
+			log.Fatalln("ERROR: refusing to destroy the local CA without -force")
+		}
+		w := &wizard{in: bufio.NewReader(os.Stdin)}
+		if !w.confirm("Destroy the local CA?", false) {
+			log.Fatalln("ERROR: nothing was deleted")
+		}
+		log.Println()
+	}
+
+	unlock := m.lockCAROOT()
+	m.caCert = readCACert(filepath.Join(m.CAROOT, rootName))
+	m.loadCrossCert()
+	m.uninstall()
+	retired, _ := filepath.Glob(filepath.Join(m.CAROOT, retiredDir, "rootCA-*.pem"))
+	for _, path := range retired {
+		if strings.HasSuffix(path, "-key.pem") {
+			continue
+		}
+		log.Printf("Uninstalling the retired local CA %q", filepath.Base(path))
+		m.caCert, m.crossCert = readCACert(path), nil
+		m.uninstall()
+	}
+
+	keyURIs := []string{filepath.Join(m.CAROOT, rootKeyURIName)}
+	retiredURIs, _ := filepath.Glob(filepath.Join(m.CAROOT, retiredDir, "*-key.uri"))
+	for _, path := range append(keyURIs, retiredURIs...) {
+		if uri, err := ioutil.ReadFile(path); err == nil {
+			m.destroyKeyURI(strings.TrimSpace(string(uri)))
+		}
+	}
+
+	for _, path := range paths {
+		if filepath.Base(path) == lockName {
+			continue
+		}
+		fatalIfErr(wipeAll(path), "failed to delete "+path)
+	}
+	unlock()
+	fatalIfErr(wipeAll(filepath.Join(m.CAROOT, lockName)), "failed to delete the CAROOT lock")
+	os.Remove(m.CAROOT) // only if empty, it might contain CA profiles
+
+	log.Printf("The local CA was destroyed 🔥\n\n")
+}
+
+// destroyPaths returns the files and directories of the local CA in the
+// CAROOT, which are all the files and the directories mkcert creates.
+func (m *mkcert) destroyPaths() []string {
+	entries, err := ioutil.ReadDir(m.CAROOT)
+	fatalIfErr(err, "failed to read the CAROOT")
+	var paths []string
+	for _, e := range entries {
+		if e.IsDir() && e.Name() != retiredDir && e.Name() != interDir {
+			continue
+		}
+		paths = append(paths, filepath.Join(m.CAROOT, e.Name()))
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// destroyKeyURI deletes a CA key that is not in the CAROOT, if mkcert can.
+// Keys in PKCS#11 tokens and YubiKeys are left in place, since the slot or
+// object might be shared, but their certificate is gone with the CAROOT.
+func (m *mkcert) destroyKeyURI(uri string) {
+	switch {
+	case strings.HasPrefix(uri, keyringScheme):
+		name := strings.TrimPrefix(uri, keyringScheme)
+		verbosef("Deleting %s from %s", name, keyringDescription)
+		if err := deleteKeyringSecret(m.CAROOT, name); err != nil {
+			log.Printf("Warning: failed to delete the CA key %q from %s: %s ⚠️", name, keyringDescription, err)
+		}
+	case strings.HasPrefix(uri, tpmScheme):
+		name, err := tpmKeyName(uri)
+		if err == nil {
+			verbosef("Deleting %s from the TPM", name)
+			err = deleteTPMKey(m.CAROOT, name)
+		}
+		if err != nil {
+			log.Printf("Warning: failed to delete the CA key %q from the TPM: %s ⚠️", uri, err)
+		}
+	case strings.HasPrefix(uri, pkcs11Scheme):
+		log.Printf("Note: the CA key is still in the token at %q, delete it there if it's not needed anymore ⚠️", uri)
+	}
+}
+
+// readCACert reads a CA certificate, failing if it can't.
+func readCACert(path string) *x509.Certificate {
+	certPEM, err := ioutil.ReadFile(path)
+	fatalIfErr(err, "failed to read the CA certificate")
+	block, _ := pem.Decode(certPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		log.Fatalf("ERROR: failed to read the CA certificate %q: unexpected content", path)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	fatalIfErr(err, "failed to parse the CA certificate")
+	return cert
+}
+
+// wipeAll overwrites every file at path with random bytes before deleting
+// it, like os.RemoveAll. On SSDs and copy-on-write or journaling filesystems
+// the old blocks might survive anyway, so this is best effort.
+func wipeAll(path string) error {
+	err := filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		verbosef("Wiping %s", p)
+		return overwriteFile(p, info.Size())
+	})
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(path)
+}
+
+func overwriteFile(path string, size int64) error {
+	// The CA key is read-only.
+	if err := os.Chmod(path, 0600); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	if _, err := io.CopyN(f, rand.Reader, size); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
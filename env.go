@@ -0,0 +1,163 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// Where the system trust store can't be used, like in containers or without
+// root privileges, most runtimes can still be pointed to a CA file with an
+// environment variable. Some add it to their default roots, but most replace
+// them, so those get a bundle of the system roots followed by the local CA.
+
+const bundleName = "rootCA-bundle.pem"
+
+// envVar is an environment variable for -env, set to the local CA or, if
+// bundle is true, to the bundle that includes the system roots.
+type envVar struct {
+	name   string
+	bundle bool
+}
+
+var envVars = []envVar{
+	{"NODE_EXTRA_CA_CERTS", false}, // Node.js
+	{"DENO_CERT", false},           // Deno
+	{"SSL_CERT_FILE", true},        // OpenSSL, Go, Ruby, Python
+	{"REQUESTS_CA_BUNDLE", true},   // Python requests
+	{"CURL_CA_BUNDLE", true},       // curl
+	{"GIT_SSL_CAINFO", true},       // Git
+	{"PIP_CERT", true},             // pip
+	{"CARGO_HTTP_CAINFO", true},    // Cargo
+	{"AWS_CA_BUNDLE", true},        // AWS CLI and SDKs
+}
+
+// systemBundles are the usual locations of the system roots as a PEM file,
+// the same ones the crypto/x509 package looks for.
+var systemBundles = []string{
+	"/etc/ssl/certs/ca-certificates.crt",                // Debian, Ubuntu, Gentoo, Alpine
+	"/etc/pki/tls/certs/ca-bundle.crt",                  // Fedora, RHEL 6
+	"/etc/ssl/ca-bundle.pem",                            // openSUSE
+	"/etc/pki/tls/cacert.pem",                           // OpenELEC
+	"/etc/pki/ca-trust/extracted/pem/tls-ca-bundle.pem", // CentOS, RHEL 7
+	"/etc/ssl/cert.pem",                                 // OpenBSD, macOS
+	"/usr/local/etc/ssl/cert.pem",                       // FreeBSD
+	"/usr/local/share/certs/ca-root-nss.crt",            // FreeBSD
+}
+
+// printEnv prints the environment variables that make common runtimes trust
+// the local CA as shell commands, or writes them to path as a Docker env file.
+func (m *mkcert) printEnv(path string) {
+	rootFile := filepath.Join(m.CAROOT, rootName)
+	bundleFile := m.writeBundle()
+
+	values := make(map[string]string)
+	for _, v := range envVars {
+		values[v.name] = rootFile
+		if v.bundle {
+			values[v.name] = bundleFile
+		}
+	}
+
+	if m.jsonOutput {
+		out, err := json.MarshalIndent(values, "", "  ")
+		fatalIfErr(err, "failed to encode JSON")
+		fmt.Println(string(out))
+		return
+	}
+
+	if path != "" {
+		buf := &bytes.Buffer{}
+		fmt.Fprintf(buf, "# Generated by mkcert to trust the local CA %q.\n", m.caCert.Subject.CommonName)
+		for _, v := range envVars {
+			fmt.Fprintf(buf, "%s=%s\n", v.name, values[v.name])
+		}
+		fatalIfErr(writeOutput(path, buf.Bytes(), 0644), "failed to save the environment file")
+		log.Printf("The environment file is at %q, use it with \"docker run --env-file\" or \"env_file\" in Compose ✅\n\n", path)
+		return
+	}
+
+	shell := envShell()
+	for _, v := range envVars {
+		switch shell {
+		case "fish":
+			fmt.Printf("set -gx %s '%s';\n", v.name, strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(values[v.name]))
+		case "powershell":
+			fmt.Printf("$env:%s = '%s'\n", v.name, strings.Replace(values[v.name], "'", "''", -1))
+		default:
+			fmt.Printf("export %s=%s\n", v.name, shellQuote(values[v.name]))
+		}
+	}
+	if !term.IsTerminal(int(os.Stdout.Fd())) {
+		return
+	}
+	switch shell {
+	case "fish":
+		log.Printf("Run \"mkcert -env | source\" to set them in the current shell 👈")
+	case "powershell":
+		log.Printf("Run \"mkcert -env | Out-String | Invoke-Expression\" to set them in the current session 👈")
+	default:
+		log.Printf("Run 'eval \"$(mkcert -env)\"' to set them in the current shell 👈")
+	}
+}
+
+// writeBundle saves the system roots followed by the local CA to the CAROOT,
+// and returns its path.
+func (m *mkcert) writeBundle() string {
+	defer m.lockCAROOT()()
+
+	bundle := &bytes.Buffer{}
+	for _, path := range systemBundles {
+		system, err := ioutil.ReadFile(path)
+		if err == nil && len(system) > 0 {
+			verbosef("Using the system roots in %s", path)
+			bundle.Write(system)
+			if !bytes.HasSuffix(system, []byte("\n")) {
+				bundle.WriteByte('\n')
+			}
+			break
+		}
+	}
+	if bundle.Len() == 0 {
+		log.Printf("Note: the system roots were not found, so with the variables set to %q only the local CA will be trusted ⚠️", bundleName)
+	}
+	fmt.Fprintf(bundle, "# mkcert local CA %q\n", m.caCert.Subject.CommonName)
+	pem.Encode(bundle, &pem.Block{Type: "CERTIFICATE", Bytes: m.caCert.Raw})
+
+	bundleFile := filepath.Join(m.CAROOT, bundleName)
+	fatalIfErr(writeFileAtomic(bundleFile, bundle.Bytes(), 0644), "failed to save the CA bundle")
+	return bundleFile
+}
+
+// envShell guesses the syntax of the shell that will read the output of
+// -env: "fish", "powershell" or "sh".
+func envShell() string {
+	switch filepath.Base(os.Getenv("SHELL")) {
+	case "fish":
+		return "fish"
+	case "pwsh", "pwsh.exe":
+		return "powershell"
+	}
+	if runtime.GOOS == "windows" && os.Getenv("SHELL") == "" {
+		return "powershell"
+	}
+	return "sh"
+}
+
+// shellQuote quotes s for POSIX shells.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
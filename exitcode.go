@@ -0,0 +1,70 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"log"
+	"os"
+)
+
+// The exit codes of mkcert, documented under "Exit codes" in the -help
+// output. Wrappers rely on them, so they must not change.
+const (
+	exitFailure        = 1 // any other error
+	exitUsage          = 2 // unknown flags, or invalid flag values
+	exitInvalidHost    = 3 // a name is not a valid hostname, IP, URL or email
+	exitCSRInvalid     = 4 // a CSR can't be read or parsed
+	exitCAMissing      = 5 // there is no local CA, or its key is missing
+	exitInstallFailed  = 6 // -install failed for all the trust stores
+	exitPartialInstall = 7 // -install only succeeded for some trust stores
+)
+
+// exitCode is the exit code of fatalIfErr and fatalIfCmdErr, which depends
+// on the operation in progress.
+var exitCode = exitFailure
+
+// fatalLogCode is the -log-format json code of the errors of fatalIfErr and
+// fatalIfCmdErr, which also depends on the operation in progress.
+var fatalLogCode = "error"
+
+// storesInstalled and storesFailed count the trust stores that -install
+// installed the local CA in (or found it already in), and the ones it failed
+// to install it in, without stopping.
+var storesInstalled, storesFailed int
+
+// exitf logs an error message and exits with code.
+func exitf(code int, format string, v ...interface{}) {
+	log.Printf(format, v...)
+	os.Exit(code)
+}
+
+// exitIfErr is fatalIfErr with a specific exit code.
+func exitIfErr(err error, code int, msg string) {
+	if err != nil {
+		exitf(code, "ERROR: %s: %s", msg, err)
+	}
+}
+
+// storeInstalled records a trust store the local CA is installed in, so that
+// errors from now on are reported as a partial installation.
+func storeInstalled() {
+	storesInstalled++
+	if exitCode == exitInstallFailed {
+		exitCode = exitPartialInstall
+	}
+}
+
+// installExitCode returns the exit code for the outcome of -install, or 0
+// if it didn't fail for any trust store.
+func installExitCode() int {
+	switch {
+	case storesFailed == 0:
+		return 0
+	case storesInstalled == 0:
+		return exitInstallFailed
+	default:
+		return exitPartialInstall
+	}
+}
@@ -0,0 +1,124 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// certExpiry is the -status result for a certificate.
+type certExpiry struct {
+	Path     string    `json:"path"`
+	Serial   string    `json:"serial"`
+	Hosts    []string  `json:"hosts"`
+	NotAfter time.Time `json:"not_after"`
+	DaysLeft int       `json:"days_left"`
+	Status   string    `json:"status"` // "ok", "expiring" or "expired"
+}
+
+// certExtensions are the files that -status looks into when scanning a
+// directory.
+var certExtensions = map[string]bool{
+	".pem": true, ".crt": true, ".cer": true, ".der": true, ".p12": true, ".pfx": true, ".p7b": true, ".p7c": true,
+}
+
+// expiryStatus reports the certificates that expire within -renew-days,
+// among the current ones in the index, or the ones issued by the local CA
+// found in dirs.
+func (m *mkcert) expiryStatus(dirs []string) {
+	var certs []certExpiry
+	if len(dirs) == 0 {
+		for _, c := range m.currentCerts(nil) {
+			path := c.CertFile
+			if c.PKCS12 {
+				path = c.P12File
+			}
+			certs = append(certs, certExpiry{Path: path, Serial: c.Serial, Hosts: c.Hosts, NotAfter: c.NotAfter})
+		}
+	}
+	for _, dir := range dirs {
+		err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() || !certExtensions[strings.ToLower(filepath.Ext(path))] {
+				return nil
+			}
+			data, err := ioutil.ReadFile(path)
+			if err != nil {
+				verbosef("Skipping %s: %s", path, err)
+				return nil
+			}
+			parsed, err := parseCertificates(data)
+			if err != nil {
+				verbosef("Skipping %s: %s", path, err)
+				return nil
+			}
+			leaf := parsed[0]
+			if leaf.IsCA || !m.issuedByLocalCA(leaf) {
+				return nil
+			}
+			abs, _ := filepath.Abs(path)
+			certs = append(certs, certExpiry{Path: abs, Serial: serialString(leaf),
+				Hosts: certificateHosts(leaf), NotAfter: leaf.NotAfter.UTC()})
+			return nil
+		})
+		fatalIfErr(err, "failed to scan "+dir)
+	}
+
+	var expiring int
+	for i := range certs {
+		c := &certs[i]
+		c.DaysLeft = int(time.Until(c.NotAfter).Hours() / 24)
+		switch {
+		case time.Now().After(c.NotAfter):
+			c.Status = "expired"
+			expiring++
+		case c.DaysLeft < m.renewDays:
+			c.Status = "expiring"
+			expiring++
+		default:
+			c.Status = "ok"
+		}
+	}
+	sort.SliceStable(certs, func(i, j int) bool { return certs[i].NotAfter.Before(certs[j].NotAfter) })
+
+	if m.jsonOutput {
+		if certs == nil {
+			certs = []certExpiry{}
+		}
+		out, err := json.MarshalIndent(certs, "", "  ")
+		fatalIfErr(err, "failed to encode JSON")
+		fmt.Println(string(out))
+		return
+	}
+
+	if expiring == 0 {
+		log.Printf("None of the %d certificates expire in the next %d days ✅", len(certs), m.renewDays)
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "STATUS\tEXPIRES\tNAMES\tPATH")
+	for _, c := range certs {
+		if c.Status == "ok" {
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", c.Status, c.NotAfter.Local().Format("2006-01-02"), strings.Join(c.Hosts, ","), c.Path)
+	}
+	w.Flush()
+	log.Printf("\n%d of the %d certificates expire in the next %d days or already expired ⚠️", expiring, len(certs), m.renewDays)
+	if len(dirs) == 0 {
+		log.Printf(`Run "mkcert -watch" to renew them automatically 👈`)
+	}
+}
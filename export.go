@@ -0,0 +1,64 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"log"
+	"path/filepath"
+	"strings"
+
+	pkcs12 "software.sslmate.com/src/go-pkcs12"
+)
+
+// exportFormats maps file extensions to -export-format values.
+var exportFormats = map[string]string{
+	".pem": "pem", ".crt": "pem",
+	".der": "der", ".cer": "der",
+	".p7b": "p7b", ".p7c": "p7b",
+	".p12": "p12", ".pfx": "p12",
+}
+
+// exportCA writes the local CA certificate to m.exportPath, in the format
+// selected by -export-format or by the file extension. Only the certificate is
+// exported, never the key.
+func (m *mkcert) exportCA() {
+	format := m.exportFormat
+	if format == "" {
+		format = exportFormats[strings.ToLower(filepath.Ext(m.exportPath))]
+	}
+	if format == "" {
+		format = "pem"
+	}
+
+	var data []byte
+	var err error
+	switch format {
+	case "pem":
+		data = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: m.caCert.Raw})
+	case "der":
+		data = m.caCert.Raw
+	case "p7b":
+		data, err = certsOnlyPKCS7([]*x509.Certificate{m.caCert})
+		fatalIfErr(err, "failed to generate PKCS#7")
+	case "p12":
+		password := "changeit"
+		if m.keyPassword != "" {
+			password = m.keyPassword
+		}
+		data, err = pkcs12.EncodeTrustStore(rand.Reader, []*x509.Certificate{m.caCert}, password)
+		fatalIfErr(err, "failed to generate PKCS#12")
+	default:
+		log.Fatalf("ERROR: unknown -export-format %q, use pem, der, p7b or p12", format)
+	}
+	fatalIfErr(writeOutput(m.exportPath, data, 0644), "failed to save the CA certificate")
+
+	log.Printf("The local CA certificate (%s) is at %s ✅", strings.ToUpper(format), outputName(m.exportPath))
+	if format == "p12" && m.keyPassword == "" {
+		log.Printf("The PKCS#12 password is the often hardcoded default \"changeit\" ℹ️")
+	}
+}
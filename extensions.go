@@ -0,0 +1,252 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// stringsFlag is a flag.Value that can be set multiple times.
+type stringsFlag []string
+
+func (f *stringsFlag) String() string { return strings.Join(*f, " ") }
+
+func (f *stringsFlag) Set(v string) error {
+	*f = append(*f, v)
+	return nil
+}
+
+// parseExtension parses an -ext or -root-ext value, in the format
+// "OID=[critical,]hex:VALUE" or "OID=[critical,]base64:VALUE", where VALUE is
+// the DER encoding of the extension value.
+func parseExtension(spec string) (pkix.Extension, error) {
+	var ext pkix.Extension
+	oidString, value, ok := strings.Cut(spec, "=")
+	if !ok {
+		return ext, fmt.Errorf("%q is not in the OID=[critical,]ENCODING:VALUE format", spec)
+	}
+	var err error
+	if ext.Id, err = parseOID(oidString); err != nil {
+		return ext, err
+	}
+
+	if v := strings.TrimPrefix(value, "critical,"); v != value {
+		ext.Critical, value = true, v
+	}
+	encoding, data, _ := strings.Cut(value, ":")
+	switch encoding {
+	case "hex":
+		ext.Value, err = hex.DecodeString(strings.Replace(data, ":", "", -1))
+	case "base64":
+		ext.Value, err = base64.StdEncoding.DecodeString(data)
+	default:
+		return ext, fmt.Errorf("unknown encoding %q in %q, expected \"hex\" or \"base64\"", encoding, spec)
+	}
+	if err != nil {
+		return ext, fmt.Errorf("invalid value in %q: %s", spec, err)
+	}
+	var raw asn1.RawValue
+	if rest, err := asn1.Unmarshal(ext.Value, &raw); err != nil || len(rest) != 0 {
+		return ext, fmt.Errorf("the value in %q is not a single DER element", spec)
+	}
+	return ext, nil
+}
+
+// parseOID parses a dotted OID like "1.3.6.1.5.5.7.3.1".
+func parseOID(s string) (asn1.ObjectIdentifier, error) {
+	var oid asn1.ObjectIdentifier
+	for _, arc := range strings.Split(strings.TrimSpace(s), ".") {
+		n, err := strconv.Atoi(arc)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("%q is not a valid OID", s)
+		}
+		oid = append(oid, n)
+	}
+	if len(oid) < 2 {
+		return nil, fmt.Errorf("%q is not a valid OID", s)
+	}
+	return oid, nil
+}
+
+func parseExtensions(specs []string) ([]pkix.Extension, error) {
+	var exts []pkix.Extension
+	for _, spec := range specs {
+		ext, err := parseExtension(spec)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range exts {
+			if e.Id.Equal(ext.Id) {
+				return nil, fmt.Errorf("the extension %s is set more than once", ext.Id)
+			}
+		}
+		exts = append(exts, ext)
+	}
+	return exts, nil
+}
+
+var (
+	oidExtensionSubjectAltName      = asn1.ObjectIdentifier{2, 5, 29, 17}
+	oidExtensionTLSFeature          = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 24}
+	oidExtensionCertificatePolicies = asn1.ObjectIdentifier{2, 5, 29, 32}
+	oidPolicyQualifierCPS           = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 2, 1}
+)
+
+// mustStapleExtension is the TLS Feature extension requesting the
+// status_request feature (5), from RFC 7633.
+var mustStapleExtension = pkix.Extension{
+	Id: oidExtensionTLSFeature, Value: []byte{0x30, 0x03, 0x02, 0x01, 0x05},
+}
+
+// extKeyUsageFlagNames maps the (lowercased) -eku names to the usages.
+var extKeyUsageFlagNames = map[string]x509.ExtKeyUsage{
+	"serverauth":      x509.ExtKeyUsageServerAuth,
+	"clientauth":      x509.ExtKeyUsageClientAuth,
+	"emailprotection": x509.ExtKeyUsageEmailProtection,
+	"codesigning":     x509.ExtKeyUsageCodeSigning,
+	"ocspsigning":     x509.ExtKeyUsageOCSPSigning,
+	"timestamping":    x509.ExtKeyUsageTimeStamping,
+	"ipsecendsystem":  x509.ExtKeyUsageIPSECEndSystem,
+	"ipsectunnel":     x509.ExtKeyUsageIPSECTunnel,
+	"ipsecuser":       x509.ExtKeyUsageIPSECUser,
+	"any":             x509.ExtKeyUsageAny,
+}
+
+// keyUsageFlagNames maps the (lowercased) -key-usage names to the usages.
+var keyUsageFlagNames = map[string]x509.KeyUsage{
+	"digitalsignature":  x509.KeyUsageDigitalSignature,
+	"contentcommitment": x509.KeyUsageContentCommitment,
+	"nonrepudiation":    x509.KeyUsageContentCommitment,
+	"keyencipherment":   x509.KeyUsageKeyEncipherment,
+	"dataencipherment":  x509.KeyUsageDataEncipherment,
+	"keyagreement":      x509.KeyUsageKeyAgreement,
+	"encipheronly":      x509.KeyUsageEncipherOnly,
+	"decipheronly":      x509.KeyUsageDecipherOnly,
+}
+
+// parseKeyUsage parses a -key-usage value, a comma-separated list of usage
+// names.
+func parseKeyUsage(spec string) (x509.KeyUsage, error) {
+	var ku x509.KeyUsage
+	for _, name := range strings.Split(spec, ",") {
+		u, ok := keyUsageFlagNames[strings.ToLower(strings.TrimSpace(name))]
+		if !ok {
+			return 0, fmt.Errorf("%q is not a known key usage", name)
+		}
+		ku |= u
+	}
+	if ku&(x509.KeyUsageEncipherOnly|x509.KeyUsageDecipherOnly) != 0 && ku&x509.KeyUsageKeyAgreement == 0 {
+		return 0, fmt.Errorf("encipherOnly and decipherOnly require keyAgreement")
+	}
+	return ku, nil
+}
+
+// parseExtKeyUsages parses an -eku value, a comma-separated list of usage
+// names and OIDs.
+func parseExtKeyUsages(spec string) ([]x509.ExtKeyUsage, []asn1.ObjectIdentifier, error) {
+	var usages []x509.ExtKeyUsage
+	var oids []asn1.ObjectIdentifier
+	for _, name := range strings.Split(spec, ",") {
+		if u, ok := extKeyUsageFlagNames[strings.ToLower(strings.TrimSpace(name))]; ok {
+			usages = append(usages, u)
+			continue
+		}
+		oid, err := parseOID(name)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%q is neither a known extended key usage nor an OID", name)
+		}
+		oids = append(oids, oid)
+	}
+	return usages, oids, nil
+}
+
+type policyInformation struct {
+	PolicyIdentifier asn1.ObjectIdentifier
+	PolicyQualifiers []policyQualifierInfo `asn1:"optional"`
+}
+
+type policyQualifierInfo struct {
+	PolicyQualifierID asn1.ObjectIdentifier
+	Qualifier         string `asn1:"ia5"`
+}
+
+// parsePolicies returns a Certificate Policies extension for the -policy or
+// -root-policy values, in the format "OID" or "OID=CPS_URI", or nil if there
+// are none. crypto/x509 can only encode the OIDs, without a CPS URI.
+func parsePolicies(specs []string) (*pkix.Extension, error) {
+	if len(specs) == 0 {
+		return nil, nil
+	}
+	var policies []policyInformation
+	for _, spec := range specs {
+		oidString, cps, hasCPS := strings.Cut(spec, "=")
+		oid, err := parseOID(oidString)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range policies {
+			if p.PolicyIdentifier.Equal(oid) {
+				return nil, fmt.Errorf("the policy %s is set more than once", oid)
+			}
+		}
+		policy := policyInformation{PolicyIdentifier: oid}
+		if hasCPS {
+			if u, err := url.Parse(cps); err != nil || u.Scheme == "" || u.Host == "" {
+				return nil, fmt.Errorf("%q is not a valid CPS URI", cps)
+			}
+			policy.PolicyQualifiers = []policyQualifierInfo{{oidPolicyQualifierCPS, cps}}
+		}
+		policies = append(policies, policy)
+	}
+	value, err := asn1.Marshal(policies)
+	if err != nil {
+		return nil, err
+	}
+	return &pkix.Extension{Id: oidExtensionCertificatePolicies, Value: value}, nil
+}
+
+// withoutExtension returns exts without any extension of type id.
+func withoutExtension(exts []pkix.Extension, id asn1.ObjectIdentifier) []pkix.Extension {
+	var res []pkix.Extension
+	for _, ext := range exts {
+		if !ext.Id.Equal(id) {
+			res = append(res, ext)
+		}
+	}
+	return res
+}
+
+// findExtension returns the first extension of type id in exts, or nil.
+func findExtension(exts []pkix.Extension, id asn1.ObjectIdentifier) *pkix.Extension {
+	for i := range exts {
+		if exts[i].Id.Equal(id) {
+			return &exts[i]
+		}
+	}
+	return nil
+}
+
+// hasOtherNameSAN reports whether the SAN extension ext has any otherName
+// entries, like UPNs, which crypto/x509 drops when parsing it.
+func hasOtherNameSAN(ext *pkix.Extension) bool {
+	var names []asn1.RawValue
+	if rest, err := asn1.Unmarshal(ext.Value, &names); err != nil || len(rest) != 0 {
+		return false
+	}
+	for _, name := range names {
+		if name.Class == asn1.ClassContextSpecific && name.Tag == 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,110 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"log"
+	"strings"
+	"time"
+)
+
+var (
+	oidExtensionKeyUsage    = asn1.ObjectIdentifier{2, 5, 29, 15}
+	oidExtensionExtKeyUsage = asn1.ObjectIdentifier{2, 5, 29, 37}
+
+	extKeyUsageOIDs = map[x509.ExtKeyUsage]asn1.ObjectIdentifier{
+		x509.ExtKeyUsageAny:             {2, 5, 29, 37, 0},
+		x509.ExtKeyUsageServerAuth:      {1, 3, 6, 1, 5, 5, 7, 3, 1},
+		x509.ExtKeyUsageClientAuth:      {1, 3, 6, 1, 5, 5, 7, 3, 2},
+		x509.ExtKeyUsageCodeSigning:     {1, 3, 6, 1, 5, 5, 7, 3, 3},
+		x509.ExtKeyUsageEmailProtection: {1, 3, 6, 1, 5, 5, 7, 3, 4},
+		x509.ExtKeyUsageIPSECEndSystem:  {1, 3, 6, 1, 5, 5, 7, 3, 5},
+		x509.ExtKeyUsageIPSECTunnel:     {1, 3, 6, 1, 5, 5, 7, 3, 6},
+		x509.ExtKeyUsageIPSECUser:       {1, 3, 6, 1, 5, 5, 7, 3, 7},
+		x509.ExtKeyUsageTimeStamping:    {1, 3, 6, 1, 5, 5, 7, 3, 8},
+		x509.ExtKeyUsageOCSPSigning:     {1, 3, 6, 1, 5, 5, 7, 3, 9},
+	}
+)
+
+// marshalKeyUsage encodes ku as the value of a Key Usage extension, a BIT
+// STRING where digitalSignature is the first (most significant) bit.
+func marshalKeyUsage(ku x509.KeyUsage) ([]byte, error) {
+	var bits asn1.BitString
+	bits.Bytes = make([]byte, 2)
+	for i := 0; i < 9; i++ {
+		if ku&(1<<i) != 0 {
+			bits.Bytes[i/8] |= 0x80 >> (i % 8)
+			bits.BitLength = i + 1
+		}
+	}
+	bits.Bytes = bits.Bytes[:(bits.BitLength+7)/8]
+	return asn1.Marshal(bits)
+}
+
+// makeCSR generates a key and a CSR for hosts, with the same names, subject
+// and usages that mkcert would put in a certificate, to be signed elsewhere.
+func (m *mkcert) makeCSR(hosts []string) {
+	priv, err := m.generateKey(false)
+	fatalIfErr(err, "failed to generate certificate key")
+
+	// The template is only used for the names and usages, so no validity.
+	tpl := m.leafTemplate(hosts, time.Time{})
+	req := &x509.CertificateRequest{
+		Subject:        tpl.Subject,
+		DNSNames:       tpl.DNSNames,
+		IPAddresses:    tpl.IPAddresses,
+		EmailAddresses: tpl.EmailAddresses,
+		URIs:           tpl.URIs,
+	}
+
+	// x509.CreateCertificateRequest doesn't encode usages, so do it here.
+	ku, err := marshalKeyUsage(tpl.KeyUsage)
+	fatalIfErr(err, "failed to encode key usage")
+	req.ExtraExtensions = append(req.ExtraExtensions, pkix.Extension{Id: oidExtensionKeyUsage, Critical: true, Value: ku})
+	var ekus []asn1.ObjectIdentifier
+	for _, u := range tpl.ExtKeyUsage {
+		ekus = append(ekus, extKeyUsageOIDs[u])
+	}
+	ekus = append(ekus, tpl.UnknownExtKeyUsage...)
+	eku, err := asn1.Marshal(ekus)
+	fatalIfErr(err, "failed to encode extended key usage")
+	req.ExtraExtensions = append(req.ExtraExtensions, pkix.Extension{Id: oidExtensionExtKeyUsage, Value: eku})
+	req.ExtraExtensions = append(req.ExtraExtensions, m.leafExts...)
+
+	csr, err := x509.CreateCertificateRequest(rand.Reader, req, priv)
+	fatalIfErr(err, "failed to generate CSR")
+
+	certFile, keyFile, _ := m.fileNames(hosts)
+	csrFile := certFile
+	if m.certFile == "" && !m.stdout {
+		csrFile = strings.TrimSuffix(certFile, ".pem") + ".csr"
+	}
+	m.checkOverwrite("", csrFile, keyFile)
+
+	privDER, err := x509.MarshalPKCS8PrivateKey(priv)
+	fatalIfErr(err, "failed to encode certificate key")
+	privPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privDER})
+	if m.keyPassword != "" {
+		encDER, err := encryptPKCS8(privDER, m.keyPassword)
+		fatalIfErr(err, "failed to encrypt certificate key")
+		privPEM = pem.EncodeToMemory(&pem.Block{Type: "ENCRYPTED PRIVATE KEY", Bytes: encDER})
+	}
+
+	err = writeOutput(csrFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr}), 0644)
+	fatalIfErr(err, "failed to save CSR")
+	err = writeOutput(keyFile, privPEM, 0600)
+	fatalIfErr(err, "failed to save certificate key")
+
+	log.Printf("\nCreated a new CSR for the following names 📝")
+	for _, h := range hosts {
+		logCode("cert_name", " - %q", h)
+	}
+	log.Printf("\nThe CSR is at %s and the key at %s ✅\n\n", outputName(csrFile), outputName(keyFile))
+}
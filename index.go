@@ -0,0 +1,234 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const indexName = "index.json"
+
+// issuedCert is an entry of the index of issued certificates kept in CAROOT.
+// Paths are absolute, so that the index can be used from any directory.
+type issuedCert struct {
+	Serial   string    `json:"serial"`
+	Hosts    []string  `json:"hosts"`
+	IssuedAt time.Time `json:"issued_at"`
+	NotAfter time.Time `json:"not_after"`
+
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+
+	CertFile string `json:"cert_file,omitempty"`
+	KeyFile  string `json:"key_file,omitempty"`
+	P12File  string `json:"p12_file,omitempty"`
+	CSRFile  string `json:"csr_file,omitempty"`
+	// FromCSR is set for the certificates issued for a CSR, whose key mkcert
+	// doesn't have. CSRFile is empty if the CSR was read from stdin.
+	FromCSR bool `json:"from_csr,omitempty"`
+
+	Client bool `json:"client,omitempty"`
+	ECDSA  bool `json:"ecdsa,omitempty"`
+	PKCS12 bool `json:"pkcs12,omitempty"`
+	P7B    bool `json:"p7b,omitempty"`
+
+	P12Chain string `json:"p12_chain,omitempty"`
+	P12Name  string `json:"p12_name,omitempty"`
+	Profile  string `json:"profile,omitempty"`
+	ReuseKey bool   `json:"reuse_key,omitempty"`
+	// KeyEncrypted is set if the key or PKCS#12 file is encrypted with the
+	// -key-pass password, which is not stored.
+	KeyEncrypted bool `json:"key_encrypted,omitempty"`
+
+	// Validity is the lifetime of the certificate, if it was set with
+	// -validity or -valid-until, as a time.Duration string.
+	Validity           string                  `json:"validity,omitempty"`
+	SigAlg             string                  `json:"sig_alg,omitempty"`
+	KeyUsage           x509.KeyUsage           `json:"key_usage,omitempty"`
+	ExtKeyUsage        []x509.ExtKeyUsage      `json:"ext_key_usage,omitempty"`
+	UnknownExtKeyUsage []asn1.ObjectIdentifier `json:"unknown_ext_key_usage,omitempty"`
+	UPNs               []string                `json:"upns,omitempty"`
+	// Extensions are the ones set with -ext, -policy and -must-staple.
+	Extensions     []pkix.Extension `json:"extensions,omitempty"`
+	SubjectCN      string           `json:"subject_cn,omitempty"`
+	SubjectOrg     string           `json:"subject_org,omitempty"`
+	SubjectOU      string           `json:"subject_ou,omitempty"`
+	SubjectCountry string           `json:"subject_country,omitempty"`
+	OCSPURL        string           `json:"ocsp_url,omitempty"`
+	CRLURL         string           `json:"crl_url,omitempty"`
+	IssuerURL      string           `json:"issuer_url,omitempty"`
+	Intermediate   string           `json:"intermediate,omitempty"`
+}
+
+// serialString formats a certificate serial number the way it's stored in
+// the index and accepted on the command line.
+func serialString(cert *x509.Certificate) string {
+	return fmt.Sprintf("%X", cert.SerialNumber)
+}
+
+func (m *mkcert) loadIndex() []issuedCert {
+	index, err := m.readIndex()
+	if err != nil {
+		exitf(exitCode, "ERROR: %s", err)
+	}
+	return index
+}
+
+func (m *mkcert) saveIndex(index []issuedCert) {
+	if err := m.writeIndex(index); err != nil {
+		exitf(exitCode, "ERROR: %s", err)
+	}
+}
+
+// readIndex is like loadIndex, but returns an error instead of exiting.
+func (m *mkcert) readIndex() ([]issuedCert, error) {
+	data, err := ioutil.ReadFile(filepath.Join(m.CAROOT, indexName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the certificate index: %w", err)
+	}
+	var index []issuedCert
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("failed to parse the certificate index: %w", err)
+	}
+	return index, nil
+}
+
+// writeIndex is like saveIndex, but returns an error instead of exiting.
+func (m *mkcert) writeIndex(index []issuedCert) error {
+	data, err := json.MarshalIndent(index, "", "\t")
+	if err != nil {
+		return fmt.Errorf("failed to encode the certificate index: %w", err)
+	}
+	verbosef("Updating %s", filepath.Join(m.CAROOT, indexName))
+	err = writeFileAtomic(filepath.Join(m.CAROOT, indexName), append(data, '\n'), 0644)
+	if err != nil {
+		return fmt.Errorf("failed to save the certificate index: %w", err)
+	}
+	return nil
+}
+
+// recordIssued adds cert to the index, along with the files it was saved to
+// and the options needed to issue it again.
+func (m *mkcert) recordIssued(cert *x509.Certificate, certFile, keyFile, p12File string) error {
+	return m.appendIndex(m.issuedEntry(cert, certFile, keyFile, p12File))
+}
+
+// appendIndex adds entry to the index, with the CAROOT locked.
+func (m *mkcert) appendIndex(entry issuedCert) error {
+	unlock, err := m.acquireCAROOTLock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	index, err := m.readIndex()
+	if err != nil {
+		return err
+	}
+	return m.writeIndex(append(index, entry))
+}
+
+// issuedEntry returns the index entry for cert.
+func (m *mkcert) issuedEntry(cert *x509.Certificate, certFile, keyFile, p12File string) issuedCert {
+	entry := issuedCert{
+		Serial:   serialString(cert),
+		Hosts:    certificateHosts(cert),
+		IssuedAt: time.Now().UTC().Truncate(time.Second),
+		NotAfter: cert.NotAfter.UTC(),
+		CertFile: absPath(certFile),
+		KeyFile:  absPath(keyFile),
+		P12File:  absPath(p12File),
+		CSRFile:  absPath(m.csrPath),
+		FromCSR:  m.csrPath != "",
+		Client:   m.client,
+		ECDSA:    m.ecdsa,
+		PKCS12:   m.pkcs12,
+		P7B:      m.p7b,
+		P12Name:  m.p12Name,
+		Profile:  m.profile,
+		ReuseKey: m.reuseKey || m.keyIn != "",
+
+		KeyEncrypted:       m.keyPassword != "",
+		SigAlg:             m.sigAlg,
+		KeyUsage:           m.keyUsage,
+		ExtKeyUsage:        m.extKeyUsage,
+		UnknownExtKeyUsage: m.unknownExtKeyUsage,
+		UPNs:               m.upns,
+		Extensions:         m.leafExts,
+		SubjectCN:          m.subjectCN,
+		SubjectOrg:         m.subjectOrg,
+		SubjectOU:          m.subjectOU,
+		SubjectCountry:     m.subjectCountry,
+		OCSPURL:            m.ocspURL,
+		CRLURL:             m.crlURL,
+		IssuerURL:          m.issuerURL,
+		Intermediate:       m.interPath,
+	}
+	if pathExists(m.interPath) {
+		entry.Intermediate = absPath(m.interPath)
+	}
+	if m.pkcs12 && m.p12Chain != "full" {
+		entry.P12Chain = m.p12Chain
+	}
+	switch {
+	case m.validity != 0:
+		entry.Validity = m.validity.String()
+	case !m.validUntil.IsZero():
+		// A fixed date would be in the past when the certificate is renewed.
+		entry.Validity = time.Until(cert.NotAfter).Round(time.Minute).String()
+	}
+	return entry
+}
+
+func absPath(path string) string {
+	if path == "" || path == "-" {
+		return ""
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	return abs
+}
+
+func (m *mkcert) listIssued() {
+	index := m.loadIndex()
+	if len(index) == 0 {
+		log.Printf("No certificates have been issued by the local CA at %q yet.", m.CAROOT)
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SERIAL\tEXPIRES\tNAMES\tPATH")
+	for _, c := range index {
+		expires := "unknown"
+		if !c.NotAfter.IsZero() {
+			expires = c.NotAfter.Local().Format("2006-01-02")
+		}
+		if c.RevokedAt != nil {
+			expires += " (revoked)"
+		} else if !c.NotAfter.IsZero() && time.Now().After(c.NotAfter) {
+			expires += " (expired)"
+		}
+		path := c.CertFile
+		if c.PKCS12 {
+			path = c.P12File
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", c.Serial, expires, strings.Join(c.Hosts, ","), path)
+	}
+	w.Flush()
+}
@@ -0,0 +1,243 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	pkcs12 "software.sslmate.com/src/go-pkcs12"
+)
+
+func (m *mkcert) inspect() {
+	data, err := ioutil.ReadFile(m.inspectPath)
+	fatalIfErr(err, "failed to read the certificate")
+	certs, err := parseCertificates(data)
+	fatalIfErr(err, "failed to parse the certificate")
+
+	for i, cert := range certs {
+		if i > 0 {
+			fmt.Println()
+		}
+		m.printCertificate(cert)
+	}
+}
+
+// parseCertificates extracts all certificates from a PEM, DER or PKCS #12
+// file. PKCS #12 files are tried with the password mkcert uses by default and
+// with an empty password.
+func parseCertificates(data []byte) ([]*x509.Certificate, error) {
+	if bytes.Contains(data, []byte("-----BEGIN")) {
+		var certs []*x509.Certificate
+		for {
+			var block *pem.Block
+			block, data = pem.Decode(data)
+			if block == nil {
+				break
+			}
+			if block.Type == "PKCS7" {
+				p7, err := parsePKCS7Certificates(block.Bytes)
+				if err != nil {
+					return nil, err
+				}
+				certs = append(certs, p7...)
+				continue
+			}
+			if block.Type != "CERTIFICATE" {
+				continue
+			}
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			certs = append(certs, cert)
+		}
+		if len(certs) == 0 {
+			return nil, errors.New("no CERTIFICATE blocks found")
+		}
+		return certs, nil
+	}
+
+	if certs, err := x509.ParseCertificates(data); err == nil && len(certs) > 0 {
+		return certs, nil
+	}
+	if certs, err := parsePKCS7Certificates(data); err == nil && len(certs) > 0 {
+		return certs, nil
+	}
+
+	for _, password := range []string{"changeit", ""} {
+		_, cert, caCerts, err := pkcs12.DecodeChain(data, password)
+		if err == nil {
+			return append([]*x509.Certificate{cert}, caCerts...), nil
+		}
+		if err != pkcs12.ErrIncorrectPassword {
+			break
+		}
+	}
+	if certs, err := pkcs12.DecodeTrustStore(data, "changeit"); err == nil && len(certs) > 0 {
+		return certs, nil
+	}
+
+	return nil, errors.New("unrecognized format, expected PEM, DER, PKCS #7 or PKCS #12")
+}
+
+func (m *mkcert) printCertificate(cert *x509.Certificate) {
+	fmt.Printf("Subject:     %s\n", cert.Subject)
+	issuer := cert.Issuer.String()
+	if m.caCert != nil && cert.CheckSignatureFrom(m.caCert) == nil {
+		issuer += " (this mkcert local CA)"
+	} else if m.interCert != nil && cert.CheckSignatureFrom(m.interCert) == nil {
+		issuer += " (this mkcert intermediate CA)"
+	} else if bytes.Equal(cert.RawIssuer, cert.RawSubject) && cert.CheckSignatureFrom(cert) == nil {
+		issuer += " (self-signed)"
+	}
+	fmt.Printf("Issuer:      %s\n", issuer)
+	fmt.Printf("Serial:      %X\n", cert.SerialNumber)
+	fmt.Printf("Not before:  %s\n", cert.NotBefore.Local().Format("2 January 2006 15:04:05 MST"))
+	fmt.Printf("Not after:   %s (%s)\n", cert.NotAfter.Local().Format("2 January 2006 15:04:05 MST"), expiryDescription(cert.NotAfter))
+	fmt.Printf("Public key:  %s\n", publicKeyDescription(cert.PublicKey))
+
+	if cert.BasicConstraintsValid && cert.IsCA {
+		fmt.Printf("CA:          true\n")
+	}
+	if hosts := certificateHosts(cert); len(hosts) > 0 {
+		fmt.Printf("Names:\n")
+		for _, h := range hosts {
+			fmt.Printf(" - %q\n", h)
+		}
+	}
+	if ku := keyUsageNames(cert.KeyUsage); len(ku) > 0 {
+		fmt.Printf("Key usage:   %s\n", strings.Join(ku, ", "))
+	}
+	if eku := extKeyUsageNames(cert); len(eku) > 0 {
+		fmt.Printf("Ext. usage:  %s\n", strings.Join(eku, ", "))
+	}
+	fmt.Printf("SHA-256:     %s\n", fingerprint(cert.Raw))
+	fmt.Printf("SPKI hash:   %s\n", spkiHash(cert))
+}
+
+// certificateHosts returns the SANs of cert in the same string form that
+// mkcert accepts them on the command line.
+func certificateHosts(cert *x509.Certificate) []string {
+	var hosts []string
+	hosts = append(hosts, cert.DNSNames...)
+	hosts = append(hosts, cert.EmailAddresses...)
+	for _, ip := range cert.IPAddresses {
+		hosts = append(hosts, ip.String())
+	}
+	for _, uri := range cert.URIs {
+		hosts = append(hosts, uri.String())
+	}
+	return hosts
+}
+
+// fingerprint returns the SHA-256 hash of data as colon-separated uppercase
+// hex, the format used by browsers and openssl.
+func fingerprint(data []byte) string {
+	sum := sha256.Sum256(data)
+	hex := make([]string, len(sum))
+	for i, b := range sum {
+		hex[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(hex, ":")
+}
+
+// spkiHash returns the base64 SHA-256 hash of the public key of cert, the
+// format of HPKP and of most pinning configurations (pin-sha256).
+func spkiHash(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func expiryDescription(notAfter time.Time) string {
+	days := int(time.Until(notAfter).Hours() / 24)
+	switch {
+	case time.Now().After(notAfter):
+		return "expired"
+	case days == 0:
+		return "expires today"
+	case days == 1:
+		return "expires in 1 day"
+	default:
+		return fmt.Sprintf("expires in %d days", days)
+	}
+}
+
+func publicKeyDescription(pub interface{}) string {
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		return fmt.Sprintf("RSA %d", pub.N.BitLen())
+	case *ecdsa.PublicKey:
+		return "ECDSA " + pub.Curve.Params().Name
+	case ed25519.PublicKey:
+		return "Ed25519"
+	default:
+		return "unknown"
+	}
+}
+
+var keyUsages = []struct {
+	usage x509.KeyUsage
+	name  string
+}{
+	{x509.KeyUsageDigitalSignature, "Digital Signature"},
+	{x509.KeyUsageContentCommitment, "Content Commitment"},
+	{x509.KeyUsageKeyEncipherment, "Key Encipherment"},
+	{x509.KeyUsageDataEncipherment, "Data Encipherment"},
+	{x509.KeyUsageKeyAgreement, "Key Agreement"},
+	{x509.KeyUsageCertSign, "Certificate Sign"},
+	{x509.KeyUsageCRLSign, "CRL Sign"},
+	{x509.KeyUsageEncipherOnly, "Encipher Only"},
+	{x509.KeyUsageDecipherOnly, "Decipher Only"},
+}
+
+func keyUsageNames(ku x509.KeyUsage) []string {
+	var names []string
+	for _, u := range keyUsages {
+		if ku&u.usage != 0 {
+			names = append(names, u.name)
+		}
+	}
+	return names
+}
+
+var extKeyUsages = map[x509.ExtKeyUsage]string{
+	x509.ExtKeyUsageAny:             "Any",
+	x509.ExtKeyUsageServerAuth:      "Server Authentication",
+	x509.ExtKeyUsageClientAuth:      "Client Authentication",
+	x509.ExtKeyUsageCodeSigning:     "Code Signing",
+	x509.ExtKeyUsageEmailProtection: "Email Protection",
+	x509.ExtKeyUsageIPSECEndSystem:  "IPSec End System",
+	x509.ExtKeyUsageIPSECTunnel:     "IPSec Tunnel",
+	x509.ExtKeyUsageIPSECUser:       "IPSec User",
+	x509.ExtKeyUsageTimeStamping:    "Time Stamping",
+	x509.ExtKeyUsageOCSPSigning:     "OCSP Signing",
+}
+
+func extKeyUsageNames(cert *x509.Certificate) []string {
+	var names []string
+	for _, u := range cert.ExtKeyUsage {
+		if name, ok := extKeyUsages[u]; ok {
+			names = append(names, name)
+		} else {
+			names = append(names, fmt.Sprintf("unknown (%d)", u))
+		}
+	}
+	for _, oid := range cert.UnknownExtKeyUsage {
+		names = append(names, oid.String())
+	}
+	return names
+}
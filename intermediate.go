@@ -0,0 +1,279 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const interName = "intermediateCA.pem"
+const interKeyName = "intermediateCA-key.pem"
+const interDir = "intermediates"
+
+// safeNameRe matches the names of intermediates and CA profiles, which are
+// used as directory names in the CAROOT.
+var safeNameRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// newIntermediate creates an intermediate CA signed by the root, stored in
+// the CAROOT at "intermediates/NAME/".
+func (m *mkcert) newIntermediate() {
+	defer m.lockCAROOT()()
+	if m.caKey == nil {
+		fatalCode(exitFailure, "ca_key_missing", "ERROR: can't create an intermediate CA because the CA key (rootCA-key.pem) is missing")
+	}
+	if m.caCert.MaxPathLen == 0 && m.caCert.MaxPathLenZero {
+		log.Fatalln("ERROR: the local CA was created by an older version of mkcert and does not allow intermediates")
+	}
+	if !safeNameRe.MatchString(m.newInterName) {
+		log.Fatalf("ERROR: %q is not a valid intermediate name, use only letters, digits, dots, dashes and underscores", m.newInterName)
+	}
+	dir := filepath.Join(m.CAROOT, interDir, m.newInterName)
+	if pathExists(dir) {
+		log.Fatalf("ERROR: the intermediate CA %q already exists at %q", m.newInterName, dir)
+	}
+
+	priv, err := m.generateKey(true)
+	fatalIfErr(err, "failed to generate the intermediate CA key")
+	pub := priv.(crypto.Signer).Public()
+
+	// Intermediates last 5 years by default, but never longer than the root.
+	expiration := time.Now().AddDate(5, 0, 0)
+	if m.validity != 0 {
+		expiration = time.Now().Add(m.validity)
+	}
+	if !m.validUntil.IsZero() {
+		expiration = m.validUntil
+	}
+	if expiration.After(m.caCert.NotAfter) {
+		expiration = m.caCert.NotAfter
+	}
+	tpl := &x509.Certificate{
+		SerialNumber: randomSerialNumber(),
+		Subject: pkix.Name{
+			Country:            []string{"DE"},
+			Organization:       []string{userFullName},
+			OrganizationalUnit: []string{userAndHostname + " - mkcert"},
+			CommonName:         userFullName + " - " + m.newInterName + " Intermediate CA",
+		},
+
+		NotBefore: time.Now(), NotAfter: expiration,
+
+		KeyUsage: x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLen:            m.interPathLen,
+		MaxPathLenZero:        m.interPathLen == 0,
+
+		PermittedDNSDomainsCritical: len(m.interDomains) > 0 || len(m.interRanges) > 0,
+		PermittedDNSDomains:         m.interDomains,
+		PermittedIPRanges:           m.interRanges,
+	}
+	tpl.SignatureAlgorithm = m.signatureAlgorithm(m.caKey)
+	cert, err := x509.CreateCertificate(rand.Reader, tpl, m.caCert, pub, m.caKey)
+	fatalIfErr(err, "failed to generate the intermediate CA certificate")
+	privDER, err := x509.MarshalPKCS8PrivateKey(priv)
+	fatalIfErr(err, "failed to encode the intermediate CA key")
+
+	fatalIfErr(os.MkdirAll(dir, 0755), "failed to create the intermediate CA directory")
+	verbosef("Writing %s and %s", filepath.Join(dir, interKeyName), filepath.Join(dir, interName))
+	err = writeFileAtomic(filepath.Join(dir, interKeyName), pem.EncodeToMemory(
+		&pem.Block{Type: "PRIVATE KEY", Bytes: privDER}), 0400)
+	fatalIfErr(err, "failed to save the intermediate CA key")
+	err = writeFileAtomic(filepath.Join(dir, interName), pem.EncodeToMemory(
+		&pem.Block{Type: "CERTIFICATE", Bytes: cert}), 0644)
+	fatalIfErr(err, "failed to save the intermediate CA certificate")
+	interCert, err := x509.ParseCertificate(cert)
+	fatalIfErr(err, "failed to parse the intermediate CA certificate")
+	m.logIssuance("intermediate", interCert)
+
+	log.Printf("Created a new intermediate CA %q at \"%s\" 💥", m.newInterName, dir)
+	if m.interPathLen != 0 && m.caCert.MaxPathLen == 1 {
+		log.Printf("Warning: the local CA only allows one level of intermediates, so CAs signed by this one won't be trusted ⚠️")
+	}
+	log.Printf("Use it with \"mkcert -use-inter %s\" 👈", m.newInterName)
+}
+
+// listIntermediates prints the intermediate CAs stored in the CAROOT.
+func (m *mkcert) listIntermediates() {
+	dirs, err := ioutil.ReadDir(filepath.Join(m.CAROOT, interDir))
+	if err != nil && !os.IsNotExist(err) {
+		fatalIfErr(err, "failed to read the intermediates directory")
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	var found bool
+	for _, d := range dirs {
+		path := filepath.Join(m.CAROOT, interDir, d.Name(), interName)
+		if !d.IsDir() || !pathExists(path) {
+			continue
+		}
+		if !found {
+			fmt.Fprintln(w, "NAME\tEXPIRES\tSUBJECT")
+			found = true
+		}
+		expires, subject := "unknown", "unreadable"
+		if data, err := ioutil.ReadFile(path); err == nil {
+			if cert, _, err := parseCertAndKey(data); err == nil && cert != nil {
+				expires = cert.NotAfter.Local().Format("2006-01-02")
+				if time.Now().After(cert.NotAfter) {
+					expires += " (expired)"
+				}
+				subject = cert.Subject.CommonName
+			}
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", d.Name(), expires, subject)
+	}
+	if !found {
+		log.Printf("There are no intermediate CAs in %q, create one with \"mkcert -inter NAME\".", m.CAROOT)
+		return
+	}
+	w.Flush()
+}
+
+// loadIntermediate loads the intermediate CA selected with -use-inter, which
+// can be the name of one stored in the CAROOT or a path, or the one at
+// "intermediateCA.pem" in the CAROOT if there is one. When loaded, it signs
+// new certificates instead of the root, and is included in their chain.
+//
+// The key can be in the same file, or in a "-key.pem" file next to it.
+func (m *mkcert) loadIntermediate() {
+	path := m.interPath
+	if safeNameRe.MatchString(path) && !pathExists(path) {
+		path = filepath.Join(m.CAROOT, interDir, path, interName)
+		if !pathExists(path) {
+			log.Fatalf("ERROR: there is no intermediate CA named %q, see \"mkcert -list-inter\"", m.interPath)
+		}
+	}
+	if path == "" {
+		path = filepath.Join(m.CAROOT, interName)
+		if !pathExists(path) {
+			return
+		}
+	}
+
+	data, err := ioutil.ReadFile(path)
+	fatalIfErr(err, "failed to read the intermediate CA")
+	cert, key, err := parseCertAndKey(data)
+	fatalIfErr(err, "failed to parse the intermediate CA")
+	if cert == nil {
+		log.Fatalf("ERROR: %q does not contain a certificate", path)
+	}
+	if key == nil {
+		keyPath := strings.TrimSuffix(path, ".pem") + "-key.pem"
+		keyData, err := ioutil.ReadFile(keyPath)
+		fatalIfErr(err, "failed to read the intermediate CA key")
+		_, key, err = parseCertAndKey(keyData)
+		fatalIfErr(err, "failed to parse the intermediate CA key")
+		if key == nil {
+			log.Fatalf("ERROR: %q does not contain a private key", keyPath)
+		}
+	}
+
+	if !cert.BasicConstraintsValid || !cert.IsCA {
+		log.Fatalf("ERROR: %q is not a CA certificate", path)
+	}
+	if err := cert.CheckSignatureFrom(m.caCert); err != nil {
+		log.Fatalf("ERROR: %q was not issued by the local CA", path)
+	}
+	if m.caCert.MaxPathLen == 0 && m.caCert.MaxPathLenZero {
+		log.Fatalln("ERROR: the local CA was created by an older version of mkcert and does not allow intermediates")
+	}
+	pub, ok := key.(crypto.Signer)
+	if !ok || !pub.Public().(interface{ Equal(crypto.PublicKey) bool }).Equal(cert.PublicKey) {
+		log.Fatalf("ERROR: the intermediate CA key does not match the certificate in %q", path)
+	}
+
+	verbosef("Using the intermediate CA %q from %s", cert.Subject.CommonName, path)
+	m.interCert, m.interKey = cert, key
+}
+
+// parseCertAndKey returns the first certificate and private key in a PEM
+// file, either of which can be nil.
+func parseCertAndKey(data []byte) (*x509.Certificate, crypto.PrivateKey, error) {
+	var cert *x509.Certificate
+	var key crypto.PrivateKey
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		var err error
+		switch block.Type {
+		case "CERTIFICATE":
+			if cert == nil {
+				cert, err = x509.ParseCertificate(block.Bytes)
+			}
+		case "PRIVATE KEY":
+			key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+		case "RSA PRIVATE KEY":
+			key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+		case "EC PRIVATE KEY":
+			key, err = x509.ParseECPrivateKey(block.Bytes)
+		case "ENCRYPTED PRIVATE KEY":
+			err = errors.New("encrypted keys are not supported")
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return cert, key, nil
+}
+
+// issuer returns the certificate and key that sign new certificates, which
+// are the intermediate CA ones if in use, or the root ones otherwise.
+func (m *mkcert) issuer() (*x509.Certificate, crypto.PrivateKey) {
+	if m.interCert != nil {
+		return m.interCert, m.interKey
+	}
+	return m.caCert, m.caKey
+}
+
+// chainCerts returns the certificates between a new certificate and the
+// root, to be served along with it: the intermediate CA if in use, and the
+// cross-signed root if there is one (see rotateCA).
+func (m *mkcert) chainCerts() []*x509.Certificate {
+	var chain []*x509.Certificate
+	if m.interCert != nil {
+		chain = append(chain, m.interCert)
+	}
+	if m.crossCert != nil {
+		chain = append(chain, m.crossCert)
+	}
+	return chain
+}
+
+// chainPEM returns the PEM encoding of chainCerts.
+func (m *mkcert) chainPEM() []byte {
+	var chain []byte
+	for _, c := range m.chainCerts() {
+		chain = append(chain, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.Raw})...)
+	}
+	return chain
+}
+
+// issuedByLocalCA reports whether cert was signed by the root or by the
+// intermediate CA in use.
+func (m *mkcert) issuedByLocalCA(cert *x509.Certificate) bool {
+	if m.caCert != nil && cert.CheckSignatureFrom(m.caCert) == nil {
+		return true
+	}
+	return m.interCert != nil && cert.CheckSignatureFrom(m.interCert) == nil
+}
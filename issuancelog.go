@@ -0,0 +1,137 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const issuanceLogName = "issuance.log"
+
+// issuanceLogEntry is a line of the issuance log in the CAROOT, which
+// records everything the local CA signed. Unlike the index, which -revoke
+// and -reissue-all update, the log is only ever appended to, one JSON
+// object per line, so it also keeps what was signed by a CA that was since
+// rotated, and the certificates that were never saved to a file.
+type issuanceLogEntry struct {
+	Time time.Time `json:"time"`
+	// Kind is how the certificate was requested: "direct", "csr", "acme",
+	// "serve", "intermediate", "ocsp-responder", "cross-sign",
+	// "renew-root" or "ssh".
+	Kind    string   `json:"kind"`
+	Serial  string   `json:"serial"`
+	Subject string   `json:"subject,omitempty"`
+	Names   []string `json:"names,omitempty"`
+	CSRFile string   `json:"csr_file,omitempty"`
+	// User is the user that ran mkcert, and SudoUser the one that ran it
+	// with sudo, if any.
+	User     string `json:"user"`
+	SudoUser string `json:"sudo_user,omitempty"`
+}
+
+// logIssuance appends cert to the issuance log.
+func (m *mkcert) logIssuance(kind string, cert *x509.Certificate) {
+	entry := issuanceLogEntry{
+		Kind:    kind,
+		Serial:  serialString(cert),
+		Subject: cert.Subject.String(),
+		Names:   certificateHosts(cert),
+	}
+	if kind == "csr" {
+		entry.CSRFile = absPath(m.csrPath)
+	}
+	m.appendIssuanceLog(entry)
+}
+
+// appendIssuanceLog appends entry to the issuance log. The certificate was
+// already signed, so a failure is only a warning.
+func (m *mkcert) appendIssuanceLog(entry issuanceLogEntry) {
+	entry.Time = time.Now().UTC().Truncate(time.Second)
+	entry.User = userAndHostname
+	entry.SudoUser = os.Getenv("SUDO_USER")
+	line, err := json.Marshal(entry)
+	fatalIfErr(err, "failed to encode the issuance log entry")
+
+	path := filepath.Join(m.CAROOT, issuanceLogName)
+	verbosef("Appending to %s", path)
+	// A single O_APPEND write doesn't interleave with the ones of other
+	// mkcert invocations, so there is no need to lock the CAROOT.
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err == nil {
+		_, err = f.Write(append(line, '\n'))
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		log.Printf("Warning: failed to record the %s certificate %s in the issuance log: %s ⚠️", entry.Kind, entry.Serial, err)
+	}
+}
+
+func (m *mkcert) loadIssuanceLog() []issuanceLogEntry {
+	f, err := os.Open(filepath.Join(m.CAROOT, issuanceLogName))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	fatalIfErr(err, "failed to read the issuance log")
+	defer f.Close()
+	var entries []issuanceLogEntry
+	s := bufio.NewScanner(f)
+	s.Buffer(nil, 1<<20)
+	for n := 1; s.Scan(); n++ {
+		if len(strings.TrimSpace(s.Text())) == 0 {
+			continue
+		}
+		var entry issuanceLogEntry
+		if err := json.Unmarshal(s.Bytes(), &entry); err != nil {
+			log.Fatalf("ERROR: failed to parse line %d of the issuance log: %s", n, err)
+		}
+		entries = append(entries, entry)
+	}
+	fatalIfErr(s.Err(), "failed to read the issuance log")
+	return entries
+}
+
+// printIssuanceLog prints the issuance log as a table, or as JSON.
+func (m *mkcert) printIssuanceLog() {
+	entries := m.loadIssuanceLog()
+	if m.jsonOutput {
+		if entries == nil {
+			entries = []issuanceLogEntry{}
+		}
+		out, err := json.MarshalIndent(entries, "", "  ")
+		fatalIfErr(err, "failed to encode JSON")
+		fmt.Println(string(out))
+		return
+	}
+	if len(entries) == 0 {
+		log.Printf("The local CA at %q hasn't signed anything yet.", m.CAROOT)
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tKIND\tSERIAL\tUSER\tNAMES")
+	for _, e := range entries {
+		user := e.User
+		if e.SudoUser != "" {
+			user += " (sudo " + e.SudoUser + ")"
+		}
+		names := strings.Join(e.Names, ",")
+		if names == "" {
+			names = e.Subject
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", e.Time.Local().Format("2006-01-02 15:04:05"), e.Kind, e.Serial, user, names)
+	}
+	w.Flush()
+}
@@ -0,0 +1,379 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// javaTrustStore is a Java cacerts file, either in the JKS format (used by
+// Java 8 and, despite PKCS #12 becoming the default keystore type in Java 9,
+// by the cacerts of Java up to 17), or in the password-less PKCS #12 format
+// used since Java 18. The format is detected from the file contents. Only the
+// trusted certificate entries are parsed, and the others are preserved as
+// they are.
+type javaTrustStore struct {
+	jks bool
+
+	// For JKS, entries are the encoded entries. For PKCS #12, they are the
+	// SafeBags of the first unencrypted SafeContents, and other holds the rest
+	// of the AuthenticatedSafe.
+	entries [][]byte
+	certs   [][]byte // the DER certificate of each entry, or nil
+	other   []asn1.RawValue
+}
+
+var (
+	jksMagic    = []byte{0xfe, 0xed, 0xfe, 0xed}
+	jksWhitener = "Mighty Aphrodite"
+
+	oidCertBag         = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 3}
+	oidX509Certificate = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 22, 1}
+	oidFriendlyName    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 20}
+	oidJavaTrustStore  = asn1.ObjectIdentifier{2, 16, 840, 1, 113894, 746875, 1, 1}
+	oidAnyExtKeyUsage  = asn1.ObjectIdentifier{2, 5, 29, 37, 0}
+)
+
+var errUnsupportedTrustStore = errors.New("unsupported Java trust store format")
+
+type pfxPDU struct {
+	Version  int
+	AuthSafe pkcs7ContentInfo
+	MacData  asn1.RawValue `asn1:"optional"`
+}
+
+type certBag struct {
+	Id   asn1.ObjectIdentifier
+	Data asn1.RawValue // [0] EXPLICIT OCTET STRING
+}
+
+type safeBag struct {
+	Id         asn1.ObjectIdentifier
+	Value      asn1.RawValue    // [0] EXPLICIT
+	Attributes []pkcs7Attribute `asn1:"set,optional"`
+}
+
+func readJavaTrustStore(path, password string) (*javaTrustStore, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.HasPrefix(data, jksMagic) {
+		return parseJKS(data, password)
+	}
+	return parsePKCS12TrustStore(data)
+}
+
+func parseJKS(data []byte, password string) (*javaTrustStore, error) {
+	if len(data) < 12+sha1.Size {
+		return nil, errUnsupportedTrustStore
+	}
+	body, digest := data[:len(data)-sha1.Size], data[len(data)-sha1.Size:]
+	if !bytes.Equal(jksDigest(body, password), digest) {
+		return nil, errors.New("the Java trust store password is not " + password)
+	}
+	if binary.BigEndian.Uint32(body[4:]) != 2 {
+		return nil, errUnsupportedTrustStore
+	}
+	count := binary.BigEndian.Uint32(body[8:])
+	r := &jksReader{data: body, off: 12}
+
+	s := &javaTrustStore{jks: true}
+	for i := uint32(0); i < count && r.err == nil; i++ {
+		start := r.off
+		var cert []byte
+		switch r.uint32() {
+		case 1: // private key
+			r.utf()     // alias
+			r.skip(8)   // timestamp
+			r.bytes32() // encrypted key
+			for n := r.uint32(); n > 0 && r.err == nil; n-- {
+				r.utf() // certificate type
+				r.bytes32()
+			}
+		case 2: // trusted certificate
+			r.utf()   // alias
+			r.skip(8) // timestamp
+			r.utf()   // certificate type
+			cert = r.bytes32()
+		default:
+			return nil, errUnsupportedTrustStore
+		}
+		if r.err != nil {
+			break
+		}
+		s.entries = append(s.entries, body[start:r.off])
+		s.certs = append(s.certs, cert)
+	}
+	if r.err != nil || r.off != len(body) {
+		return nil, errUnsupportedTrustStore
+	}
+	return s, nil
+}
+
+// jksDigest is the integrity check of a JKS file, which covers the password.
+func jksDigest(body []byte, password string) []byte {
+	h := sha1.New()
+	for _, c := range utf16.Encode([]rune(password)) {
+		h.Write([]byte{byte(c >> 8), byte(c)})
+	}
+	h.Write([]byte(jksWhitener))
+	h.Write(body)
+	return h.Sum(nil)
+}
+
+type jksReader struct {
+	data []byte
+	off  int
+	err  error
+}
+
+func (r *jksReader) skip(n int) []byte {
+	if r.err != nil || n < 0 || len(r.data)-r.off < n {
+		r.err = errUnsupportedTrustStore
+		return nil
+	}
+	r.off += n
+	return r.data[r.off-n : r.off]
+}
+
+func (r *jksReader) uint32() uint32 {
+	if b := r.skip(4); b != nil {
+		return binary.BigEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (r *jksReader) utf() []byte {
+	if b := r.skip(2); b != nil {
+		return r.skip(int(binary.BigEndian.Uint16(b)))
+	}
+	return nil
+}
+
+func (r *jksReader) bytes32() []byte {
+	return r.skip(int(r.uint32()))
+}
+
+// parsePKCS12TrustStore parses the password-less PKCS #12 trust stores written
+// by Java 18 and later. Password protected ones are not supported.
+func parsePKCS12TrustStore(data []byte) (*javaTrustStore, error) {
+	var pfx pfxPDU
+	if rest, err := asn1.Unmarshal(data, &pfx); err != nil || len(rest) != 0 {
+		return nil, errUnsupportedTrustStore
+	}
+	if pfx.Version != 3 || len(pfx.MacData.FullBytes) != 0 || !pfx.AuthSafe.ContentType.Equal(oidData) {
+		return nil, errUnsupportedTrustStore
+	}
+	var authSafeDER []byte
+	if _, err := asn1.Unmarshal(pfx.AuthSafe.Content.Bytes, &authSafeDER); err != nil {
+		return nil, errUnsupportedTrustStore
+	}
+	var authSafe []asn1.RawValue
+	if _, err := asn1.Unmarshal(authSafeDER, &authSafe); err != nil {
+		return nil, errUnsupportedTrustStore
+	}
+
+	s := &javaTrustStore{}
+	var found bool
+	for _, raw := range authSafe {
+		var ci pkcs7ContentInfo
+		if _, err := asn1.Unmarshal(raw.FullBytes, &ci); err != nil {
+			return nil, errUnsupportedTrustStore
+		}
+		if found || !ci.ContentType.Equal(oidData) {
+			s.other = append(s.other, raw)
+			continue
+		}
+		found = true
+		var safeContentsDER []byte
+		var bags []asn1.RawValue
+		if _, err := asn1.Unmarshal(ci.Content.Bytes, &safeContentsDER); err != nil {
+			return nil, errUnsupportedTrustStore
+		}
+		if _, err := asn1.Unmarshal(safeContentsDER, &bags); err != nil {
+			return nil, errUnsupportedTrustStore
+		}
+		for _, b := range bags {
+			s.entries = append(s.entries, b.FullBytes)
+			s.certs = append(s.certs, bagCertificate(b.FullBytes))
+		}
+	}
+	if !found {
+		return nil, errUnsupportedTrustStore
+	}
+	return s, nil
+}
+
+// bagCertificate returns the DER certificate in a PKCS #12 CertBag, or nil.
+func bagCertificate(der []byte) []byte {
+	var bag safeBag
+	if _, err := asn1.Unmarshal(der, &bag); err != nil || !bag.Id.Equal(oidCertBag) {
+		return nil
+	}
+	var cb certBag
+	if _, err := asn1.Unmarshal(bag.Value.Bytes, &cb); err != nil || !cb.Id.Equal(oidX509Certificate) {
+		return nil
+	}
+	var cert []byte
+	if _, err := asn1.Unmarshal(cb.Data.Bytes, &cert); err != nil {
+		return nil
+	}
+	return cert
+}
+
+func (s *javaTrustStore) contains(cert []byte) bool {
+	for _, c := range s.certs {
+		if bytes.Equal(c, cert) {
+			return true
+		}
+	}
+	return false
+}
+
+// remove deletes the entries for cert, and reports whether there were any.
+func (s *javaTrustStore) remove(cert []byte) bool {
+	var entries, certs [][]byte
+	for i, c := range s.certs {
+		if !bytes.Equal(c, cert) {
+			entries = append(entries, s.entries[i])
+			certs = append(certs, c)
+		}
+	}
+	removed := len(entries) != len(s.entries)
+	s.entries, s.certs = entries, certs
+	return removed
+}
+
+// add appends a trusted certificate entry for cert.
+func (s *javaTrustStore) add(alias string, cert []byte) error {
+	var entry []byte
+	if s.jks {
+		// JKS aliases are case-insensitive, and stored lowercased.
+		entry = appendUint32(nil, 2)
+		entry = appendJKSUTF(entry, strings.ToLower(alias))
+		ts := uint64(time.Now().UnixNano() / 1e6)
+		entry = appendUint32(appendUint32(entry, uint32(ts>>32)), uint32(ts))
+		entry = appendJKSUTF(entry, "X.509")
+		entry = appendUint32(entry, uint32(len(cert)))
+		entry = append(entry, cert...)
+	} else {
+		certOctets, err := asn1.Marshal(cert)
+		if err != nil {
+			return err
+		}
+		cb, err := asn1.Marshal(certBag{Id: oidX509Certificate, Data: explicitTag(certOctets)})
+		if err != nil {
+			return err
+		}
+		nameDER, err := asn1.Marshal(asn1.RawValue{Tag: 30, Bytes: bmpString(alias)}) // BMPString
+		if err != nil {
+			return err
+		}
+		ekuDER, err := asn1.Marshal(oidAnyExtKeyUsage)
+		if err != nil {
+			return err
+		}
+		set := func(der []byte) asn1.RawValue {
+			return asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: der}
+		}
+		entry, err = asn1.Marshal(safeBag{
+			Id: oidCertBag, Value: explicitTag(cb),
+			Attributes: []pkcs7Attribute{
+				{Type: oidFriendlyName, Values: set(nameDER)},
+				{Type: oidJavaTrustStore, Values: set(ekuDER)},
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	s.entries = append(s.entries, entry)
+	s.certs = append(s.certs, cert)
+	return nil
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func appendJKSUTF(b []byte, s string) []byte {
+	b = append(b, byte(len(s)>>8), byte(len(s)))
+	return append(b, s...)
+}
+
+// marshal returns the encoded trust store.
+func (s *javaTrustStore) marshal(password string) ([]byte, error) {
+	if s.jks {
+		body := append([]byte{}, jksMagic...)
+		body = appendUint32(body, 2)
+		body = appendUint32(body, uint32(len(s.entries)))
+		for _, e := range s.entries {
+			body = append(body, e...)
+		}
+		return append(body, jksDigest(body, password)...), nil
+	}
+
+	var bags []asn1.RawValue
+	for _, e := range s.entries {
+		bags = append(bags, asn1.RawValue{FullBytes: e})
+	}
+	safeContents, err := asn1.Marshal(bags)
+	if err != nil {
+		return nil, err
+	}
+	ci, err := p12DataContentInfo(safeContents)
+	if err != nil {
+		return nil, err
+	}
+	authSafe, err := asn1.Marshal(append([]asn1.RawValue{{FullBytes: ci}}, s.other...))
+	if err != nil {
+		return nil, err
+	}
+	outer, err := p12DataContentInfo(authSafe)
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(struct {
+		Version  int
+		AuthSafe asn1.RawValue
+	}{3, asn1.RawValue{FullBytes: outer}})
+}
+
+// p12DataContentInfo wraps content in a ContentInfo of type data.
+func p12DataContentInfo(content []byte) ([]byte, error) {
+	octets, err := asn1.Marshal(content)
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(pkcs7ContentInfo{ContentType: oidData, Content: explicitTag(octets)})
+}
+
+// writeJavaTrustStore replaces the trust store at path, with sudo if needed.
+func writeJavaTrustStore(path string, data []byte) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(path, data, info.Mode().Perm())
+	if !os.IsPermission(err) {
+		return err
+	}
+	cmd := commandWithSudo("tee", path)
+	cmd.Stdin = bytes.NewReader(data)
+	if out, err := combinedOutput(cmd); err != nil {
+		return errors.New(err.Error() + ": " + string(out))
+	}
+	return nil
+}
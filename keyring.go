@@ -0,0 +1,128 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// With -keyring, the CA key is kept in the secret store of the OS instead
+// of rootCA-key.pem: the login Keychain on macOS, the Secret Service (like
+// GNOME Keyring or KWallet) elsewhere on Unix, and a DPAPI encrypted file
+// that only the current user can decrypt on Windows. The key URI is
+// "keyring:NAME", and the key is only read when something is signed.
+const keyringScheme = "keyring:"
+
+type keyringKey struct {
+	caroot, name string
+	pub          crypto.PublicKey
+
+	once   sync.Once
+	signer crypto.Signer
+	err    error
+}
+
+func openKeyringKey(caroot, uri string, pub crypto.PublicKey) (crypto.Signer, error) {
+	name := strings.TrimPrefix(uri, keyringScheme)
+	if name == "" || strings.ContainsAny(name, `/\ `) {
+		return nil, fmt.Errorf("invalid keyring key URI %q", uri)
+	}
+	return &keyringKey{caroot: caroot, name: name, pub: pub}, nil
+}
+
+func (k *keyringKey) Public() crypto.PublicKey {
+	return k.pub
+}
+
+func (k *keyringKey) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	k.once.Do(func() {
+		verbosef("Reading the CA key from %s", keyringDescription)
+		var der []byte
+		der, k.err = loadKeyringSecret(k.caroot, k.name)
+		if k.err != nil {
+			k.err = fmt.Errorf("failed to read the CA key from %s: %w", keyringDescription, k.err)
+			return
+		}
+		key, err := x509.ParsePKCS8PrivateKey(der)
+		if err != nil {
+			k.err = fmt.Errorf("failed to parse the CA key from %s: %w", keyringDescription, err)
+			return
+		}
+		k.signer = key.(crypto.Signer)
+	})
+	if k.err != nil {
+		return nil, k.err
+	}
+	return k.signer.Sign(rand, digest, opts)
+}
+
+// newKeyringName returns a random name for a new keyring secret, so that
+// rotated CAs and CA profiles don't overwrite each other's keys.
+func newKeyringName() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return "rootCA-" + hex.EncodeToString(b)
+}
+
+// saveKeyringKey saves the PKCS #8 CA key in the keyring, checks that it can
+// be read back, and writes its URI to the CAROOT.
+func (m *mkcert) saveKeyringKey(privDER []byte) {
+	name := newKeyringName()
+	err := saveKeyringSecret(m.CAROOT, name, privDER)
+	fatalIfErr(err, "failed to save the CA key in "+keyringDescription)
+	saved, err := loadKeyringSecret(m.CAROOT, name)
+	fatalIfErr(err, "failed to read the CA key back from "+keyringDescription)
+	if !bytes.Equal(saved, privDER) {
+		log.Fatalf("ERROR: the CA key read back from %s doesn't match", keyringDescription)
+	}
+	err = writeFileAtomic(filepath.Join(m.CAROOT, rootKeyURIName), []byte(keyringScheme+name+"\n"), 0600)
+	fatalIfErr(err, "failed to save the CA key URI")
+}
+
+// setupKeyring makes sure the local CA key is in the keyring, by creating a
+// new local CA with the key there, or by moving the key of the existing one.
+func (m *mkcert) setupKeyring() {
+	uri, _ := ioutil.ReadFile(filepath.Join(m.CAROOT, rootKeyURIName))
+	switch {
+	case !pathExists(filepath.Join(m.CAROOT, rootName)):
+		m.loadCA()
+	case bytes.HasPrefix(uri, []byte(keyringScheme)):
+		// Already set up.
+	case pathExists(filepath.Join(m.CAROOT, rootKeyName)):
+		m.moveKeyToKeyring()
+	default:
+		log.Fatalln("ERROR: the local CA key is not in the CAROOT, so it can't be moved to the keyring")
+	}
+}
+
+// moveKeyToKeyring saves the CA key in the keyring, and then deletes it
+// from the CAROOT.
+func (m *mkcert) moveKeyToKeyring() {
+	defer m.lockCAROOT()()
+	keyFile := filepath.Join(m.CAROOT, rootKeyName)
+	keyPEM, err := ioutil.ReadFile(keyFile)
+	fatalIfErr(err, "failed to read the CA key")
+	block, _ := pem.Decode(keyPEM)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		fatalIfErr(errors.New("unexpected content"), "failed to read the CA key")
+	}
+	m.saveKeyringKey(block.Bytes)
+	fatalIfErr(os.Remove(keyFile), "failed to delete the CA key")
+	log.Printf("The CA key was moved to %s, and deleted from the CAROOT ✅\n\n", keyringDescription)
+}
@@ -0,0 +1,46 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+const keyringDescription = "the login Keychain"
+
+// The key is a base64 generic password for the "mkcert" service, with the
+// command passed to "security -i" on stdin, to keep it out of the process
+// arguments. Its exit status doesn't reflect the command's, so saving is
+// checked by reading the key back.
+
+func saveKeyringSecret(caroot, name string, secret []byte) error {
+	cmd := exec.Command("security", "-i")
+	cmd.Stdin = strings.NewReader(fmt.Sprintf("add-generic-password -U -s mkcert -a %s -l %s -w %s\n",
+		name, "mkcert-"+name, base64.StdEncoding.EncodeToString(secret)))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("security failed: %v: %s", err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+func loadKeyringSecret(caroot, name string) ([]byte, error) {
+	out, err := exec.Command("security", "find-generic-password", "-s", "mkcert", "-a", name, "-w").Output()
+	if err != nil {
+		return nil, fmt.Errorf("security failed: %v", err)
+	}
+	return base64.StdEncoding.DecodeString(strings.TrimSpace(string(out)))
+}
+
+func deleteKeyringSecret(caroot, name string) error {
+	out, err := exec.Command("security", "delete-generic-password", "-s", "mkcert", "-a", name).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("security failed: %v: %s", err, bytes.TrimSpace(out))
+	}
+	return nil
+}
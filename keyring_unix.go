@@ -0,0 +1,60 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !darwin && !windows
+
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+const keyringDescription = "the Secret Service"
+
+// The Secret Service is used through secret-tool, from libsecret, with the
+// key in base64 as the secret with attributes service=mkcert and
+// account=NAME.
+
+func secretTool(stdin string, args ...string) ([]byte, error) {
+	verbosef("Running secret-tool %s", strings.Join(args, " "))
+	cmd := exec.Command("secret-tool", args...)
+	cmd.Stdin = strings.NewReader(stdin)
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	out, err := cmd.Output()
+	if errors.Is(err, exec.ErrNotFound) {
+		return nil, errors.New("secret-tool is not installed, install libsecret-tools to use -keyring")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("secret-tool failed: %v: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return out, nil
+}
+
+func saveKeyringSecret(caroot, name string, secret []byte) error {
+	_, err := secretTool(base64.StdEncoding.EncodeToString(secret),
+		"store", "--label", "mkcert CA key "+name, "service", "mkcert", "account", name)
+	return err
+}
+
+func loadKeyringSecret(caroot, name string) ([]byte, error) {
+	out, err := secretTool("", "lookup", "service", "mkcert", "account", name)
+	if err != nil {
+		return nil, err
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("there is no secret for %q", name)
+	}
+	return base64.StdEncoding.DecodeString(strings.TrimSpace(string(out)))
+}
+
+func deleteKeyringSecret(caroot, name string) error {
+	_, err := secretTool("", "clear", "service", "mkcert", "account", name)
+	return err
+}
@@ -0,0 +1,49 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+const keyringDescription = "DPAPI"
+
+// The key is encrypted with DPAPI for the current user, and saved in the
+// CAROOT as NAME.dpapi, which can't be decrypted by other users or on
+// other machines.
+
+func saveKeyringSecret(caroot, name string, secret []byte) error {
+	in := windows.DataBlob{Size: uint32(len(secret)), Data: &secret[0]}
+	var out windows.DataBlob
+	if err := windows.CryptProtectData(&in, nil, nil, 0, nil, windows.CRYPTPROTECT_UI_FORBIDDEN, &out); err != nil {
+		return err
+	}
+	defer windows.LocalFree(windows.Handle(unsafe.Pointer(out.Data)))
+	blob := unsafe.Slice(out.Data, out.Size)
+	return writeFileAtomic(filepath.Join(caroot, name+".dpapi"), blob, 0600)
+}
+
+func loadKeyringSecret(caroot, name string) ([]byte, error) {
+	blob, err := ioutil.ReadFile(filepath.Join(caroot, name+".dpapi"))
+	if err != nil {
+		return nil, err
+	}
+	in := windows.DataBlob{Size: uint32(len(blob)), Data: &blob[0]}
+	var out windows.DataBlob
+	if err := windows.CryptUnprotectData(&in, nil, nil, 0, nil, windows.CRYPTPROTECT_UI_FORBIDDEN, &out); err != nil {
+		return nil, err
+	}
+	defer windows.LocalFree(windows.Handle(unsafe.Pointer(out.Data)))
+	return append([]byte(nil), unsafe.Slice(out.Data, out.Size)...), nil
+}
+
+// deleteKeyringSecret does nothing, since the DPAPI blob is in the CAROOT.
+func deleteKeyringSecret(caroot, name string) error {
+	return nil
+}
@@ -0,0 +1,78 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+// kubernetesSecret prints a kubernetes.io/tls Secret manifest with the
+// certificate, key and local CA, or applies it with kubectl.
+func (m *mkcert) kubernetesSecret(hosts []string, leaf *x509.Certificate, certPEM, keyPEM []byte) {
+	name := m.secretName
+	if name == "" {
+		name = secretNameFor(hosts[0])
+	}
+
+	var manifest bytes.Buffer
+	fmt.Fprintf(&manifest, "apiVersion: v1\nkind: Secret\ntype: kubernetes.io/tls\nmetadata:\n")
+	fmt.Fprintf(&manifest, "  name: %s\n", name)
+	if m.namespace != "" {
+		fmt.Fprintf(&manifest, "  namespace: %s\n", m.namespace)
+	}
+	fmt.Fprintf(&manifest, "  annotations:\n    mkcert/hosts: %q\n", strings.Join(hosts, ","))
+	fmt.Fprintf(&manifest, "data:\n")
+	fmt.Fprintf(&manifest, "  tls.crt: %s\n", base64.StdEncoding.EncodeToString(certPEM))
+	fmt.Fprintf(&manifest, "  tls.key: %s\n", base64.StdEncoding.EncodeToString(keyPEM))
+	fmt.Fprintf(&manifest, "  ca.crt: %s\n", base64.StdEncoding.EncodeToString(
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: m.caCert.Raw})))
+
+	if m.kubectlApply {
+		cmd := exec.Command("kubectl", "apply", "-f", "-")
+		cmd.Stdin = &manifest
+		out, err := combinedOutput(cmd)
+		fatalIfCmdErr(err, "kubectl apply", out)
+	} else {
+		os.Stdout.Write(manifest.Bytes())
+	}
+	if err := m.recordIssued(leaf, "", "", ""); err != nil {
+		exitf(exitCode, "ERROR: %s", err)
+	}
+	m.logIssuance("direct", leaf)
+
+	m.printHosts(hosts)
+	target := name
+	if m.namespace != "" {
+		target = m.namespace + "/" + name
+	}
+	if m.kubectlApply {
+		log.Printf("\nThe Secret %q was applied with kubectl ✅\n\n", target)
+	} else {
+		log.Printf("\nThe manifest for the Secret %q was printed to standard output ✅\n\n", target)
+	}
+	logCode("cert_expiration", "It will expire on %s 🗓\n\n", leaf.NotAfter.Format("2 January 2006"))
+}
+
+var secretNameRe = regexp.MustCompile(`[^a-z0-9.-]+`)
+
+// secretNameFor derives a valid Secret name from a certificate name, like
+// "wildcard.example.test-tls" for "*.example.test".
+func secretNameFor(host string) string {
+	name := strings.Replace(strings.ToLower(host), "*", "wildcard", -1)
+	name = strings.Trim(secretNameRe.ReplaceAllString(name, "-"), ".-")
+	if len(name) > 248 {
+		name = name[:248]
+	}
+	return name + "-tls"
+}
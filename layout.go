@@ -0,0 +1,78 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// With -caddy DIR, certificates are saved in DIR as the certificate and key
+// bundles that the "load" subdirective of the Caddy tls directive reads, and
+// DIR/mkcert.caddy is a tls directive loading them, which site blocks can
+// import. Caddy picks the certificate matching each site, so new
+// certificates don't need any configuration change.
+//
+// With -traefik DIR, each certificate gets a dynamic configuration file in
+// DIR next to it, so pointing the Traefik file provider to DIR is enough.
+
+const caddySnippetName = "mkcert.caddy"
+
+// layoutDir returns the -caddy or -traefik directory, if any.
+func (m *mkcert) layoutDir() string {
+	if m.caddyDir != "" {
+		return m.caddyDir
+	}
+	return m.traefikDir
+}
+
+// layoutFileNames returns the paths of the certificate and key named
+// defaultName in the -caddy or -traefik directory.
+func (m *mkcert) layoutFileNames(defaultName string) (certFile, keyFile string) {
+	certFile = filepath.Join(m.layoutDir(), defaultName+".pem")
+	if m.caddyDir != "" {
+		return certFile, certFile
+	}
+	return certFile, filepath.Join(m.traefikDir, defaultName+"-key.pem")
+}
+
+// layoutConfigFile returns the path of the Caddy or Traefik configuration
+// for the certificate at certFile.
+func (m *mkcert) layoutConfigFile(certFile string) string {
+	if m.caddyDir != "" {
+		return filepath.Join(absPath(m.caddyDir), caddySnippetName)
+	}
+	return strings.TrimSuffix(absPath(certFile), ".pem") + ".yml"
+}
+
+// writeLayoutConfig writes the Caddy or Traefik configuration that loads the
+// certificate and key for hosts.
+func (m *mkcert) writeLayoutConfig(hosts []string, certFile, keyFile string) {
+	var config string
+	if m.caddyDir != "" {
+		config = fmt.Sprintf("# Generated by mkcert. Import this file in the site blocks that use the\n"+
+			"# certificates in this directory, and Caddy will pick the matching one.\n"+
+			"tls {\n\tload %s\n}\n", strconv.Quote(absPath(m.caddyDir)))
+	} else {
+		config = fmt.Sprintf("# Generated by mkcert for %s.\n"+
+			"tls:\n  certificates:\n    - certFile: %s\n      keyFile: %s\n",
+			strings.Join(hosts, ", "), strconv.Quote(absPath(certFile)), strconv.Quote(absPath(keyFile)))
+	}
+	err := writeOutput(m.layoutConfigFile(certFile), []byte(config), 0644)
+	fatalIfErr(err, "failed to save the configuration")
+}
+
+// printLayoutHint explains how to use the configuration for certFile.
+func (m *mkcert) printLayoutHint(certFile string) {
+	if m.caddyDir != "" {
+		log.Printf("Add \"import %s\" to the Caddyfile site blocks for these names 👈\n\n", m.layoutConfigFile(certFile))
+	} else {
+		log.Printf("The Traefik configuration is at %q, point the file provider directory to %q to use it 👈\n\n",
+			m.layoutConfigFile(certFile), absPath(m.traefikDir))
+	}
+}
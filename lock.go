@@ -0,0 +1,82 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// lockName is the file in CAROOT that concurrent mkcert invocations lock
+// while they create or modify the CA or the index. The lock is held by the
+// open file and released by the OS when the process exits, so a crashed run
+// can't leave it stuck.
+const lockName = "mkcert.lock"
+
+// lockCAROOT waits until no other mkcert is modifying the CAROOT, and returns
+// a function to release the lock.
+func (m *mkcert) lockCAROOT() (unlock func()) {
+	unlock, err := m.acquireCAROOTLock()
+	if err != nil {
+		exitf(exitCode, "ERROR: %s", err)
+	}
+	return unlock
+}
+
+// acquireCAROOTLock is like lockCAROOT, but returns an error instead of
+// exiting, for the long running servers.
+func (m *mkcert) acquireCAROOTLock() (unlock func(), err error) {
+	if m.vaultPath != "" {
+		return func() {}, nil
+	}
+	if err := os.MkdirAll(m.CAROOT, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create the CAROOT: %w", err)
+	}
+	path := filepath.Join(m.CAROOT, lockName)
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the CAROOT lock: %w", err)
+	}
+	locked, err := tryLockFile(f)
+	if err == nil && !locked {
+		log.Printf("Waiting for another mkcert to finish with %q ⏳", m.CAROOT)
+		err = lockFile(f)
+	}
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to lock the CAROOT: %w", err)
+	}
+	return func() { f.Close() }, nil
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames
+// it over path, so that readers, including concurrent mkcert invocations,
+// never see a partially written file.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name()) // no-op after a successful rename
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(perm); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
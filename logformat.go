@@ -0,0 +1,173 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode"
+
+	"golang.org/x/term"
+)
+
+// jsonLogWriter writes each log message as a JSON object on its own line,
+// for -log-format json. The level of a message is derived from its text, and
+// the code is the one it was logged with by logCode or fatalCode, or else a
+// generic one for its level: "error", "warning", "debug" or "message".
+type jsonLogWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+type logRecord struct {
+	Time    string `json:"time"`
+	Level   string `json:"level"` // error, warning, info or debug
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (w *jsonLogWriter) Write(p []byte) (int, error) {
+	raw := strings.TrimLeft(string(p), "\n")
+	if strings.TrimSpace(raw) == "" {
+		return len(p), nil
+	}
+
+	level, msg := "info", strings.TrimSpace(raw)
+	switch {
+	case strings.HasPrefix(msg, "ERROR: "):
+		level, msg = "error", strings.TrimPrefix(msg, "ERROR: ")
+	case strings.HasPrefix(msg, "Warning: "):
+		level, msg = "warning", strings.TrimPrefix(msg, "Warning: ")
+	case strings.HasPrefix(msg, "Note: "):
+		level, msg = "warning", strings.TrimPrefix(msg, "Note: ")
+	case strings.HasPrefix(raw, "  ") && !strings.HasPrefix(raw, " - "):
+		// verbosef indents its messages.
+		level = "debug"
+	}
+	msg = strings.TrimSpace(stripEmoji(msg))
+
+	code := level
+	if level == "info" {
+		code = "message"
+	}
+	if c, _ := pendingLogCode.Load().(codedMessage); c.code != "" && strings.TrimSuffix(c.msg, "\n") == strings.TrimSuffix(string(p), "\n") {
+		code = c.code
+	}
+
+	out, err := json.Marshal(logRecord{
+		Time:    time.Now().UTC().Format(time.RFC3339),
+		Level:   level,
+		Code:    code,
+		Message: msg,
+	})
+	if err != nil {
+		return 0, err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, err := w.w.Write(append(out, '\n')); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// codedMessage is a message being logged by logCode, and its code.
+type codedMessage struct{ code, msg string }
+
+var (
+	logCodeMu      sync.Mutex   // serializes logCode
+	pendingLogCode atomic.Value // codedMessage
+)
+
+// logCode logs a message like log.Printf, with code as its code in the
+// -log-format json output. Tools rely on the codes, so they must not change.
+func logCode(code, format string, v ...interface{}) {
+	msg := fmt.Sprintf(format, v...)
+	logCodeMu.Lock()
+	defer logCodeMu.Unlock()
+	pendingLogCode.Store(codedMessage{code, msg})
+	defer pendingLogCode.Store(codedMessage{})
+	log.Print(msg)
+}
+
+// fatalCode is logCode followed by os.Exit(exit), like exitf.
+func fatalCode(exit int, code, format string, v ...interface{}) {
+	logCode(code, format, v...)
+	os.Exit(exit)
+}
+
+// stripEmoji removes the emoji and the spaces before them from msg.
+func stripEmoji(msg string) string {
+	msg = strings.Map(func(r rune) rune {
+		if unicode.Is(unicode.So, r) || r == '\u2139' || r == '\ufe0f' || r == '\u200d' {
+			return -1
+		}
+		return r
+	}, msg)
+	lines := strings.Split(msg, "\n")
+	for i := range lines {
+		lines[i] = strings.TrimRight(lines[i], " ")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// noEmoji is set by -no-emoji.
+var noEmoji bool
+
+// emoji returns e, or alt with -no-emoji.
+func emoji(e, alt string) string {
+	if noEmoji {
+		return alt
+	}
+	return e
+}
+
+// textLogWriter writes the log messages as text, without emoji if noEmoji
+// is set, and with the ERROR, Warning and Note labels in color if color is.
+type textLogWriter struct {
+	w     io.Writer
+	color bool
+}
+
+var logLabelColors = []struct{ label, color string }{
+	{"ERROR:", "\x1b[1;31m"},
+	{"Warning:", "\x1b[1;33m"},
+	{"Note:", "\x1b[33m"},
+}
+
+func (w *textLogWriter) Write(p []byte) (int, error) {
+	msg := string(p)
+	if noEmoji {
+		msg = stripEmoji(msg)
+	}
+	if w.color {
+		for _, l := range logLabelColors {
+			if strings.HasPrefix(strings.TrimLeft(msg, "\n "), l.label) {
+				msg = strings.Replace(msg, l.label, l.color+l.label+"\x1b[0m", 1)
+				break
+			}
+		}
+	}
+	if _, err := io.WriteString(w.w, msg); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// useColors reports whether to color the messages printed to f, which is
+// only done for terminals, unless $NO_COLOR or -no-color are set.
+func useColors(f *os.File, noColor bool) bool {
+	if noColor || os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	return term.IsTerminal(int(f.Fd())) && enableColors(f)
+}
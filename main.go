@@ -6,10 +6,15 @@
 package main
 
 import (
+	"bytes"
 	"crypto"
 	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
 	"flag"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
 	"net"
 	"net/mail"
@@ -17,12 +22,16 @@ import (
 	"os"
 	"os/exec"
 	"os/user"
+	"path"
 	"path/filepath"
 	"regexp"
 	"runtime"
 	"runtime/debug"
 	"strings"
 	"sync"
+	"text/template"
+	"time"
+	"unicode/utf8"
 
 	"golang.org/x/net/idna"
 )
@@ -48,9 +57,95 @@ const shortUsage = `Usage of mkcert:
 
 const advancedUsage = `Advanced options:
 
+	-wizard
+	    Choose the names, key type, validity and files of a new certificate,
+	    and whether to install the local CA, by answering questions. What
+	    will be created is shown before doing it.
+
+	-hosts-file FILE
+	    Read the names to include in the certificate from FILE, one or
+	    more per line, in addition to any given as arguments. Lines
+	    starting with "#" are comments. Use "-" for stdin.
+
+	    A "-" argument is also replaced with the names read from stdin,
+	    like "kubectl get ingress -o jsonpath='{..host}' | mkcert -".
+
+	-allow-public
+	    Create certificates for names under public TLDs, like "example.dev",
+	    without asking for confirmation. Those are usually production
+	    domains, so otherwise mkcert warns and asks first, or fails if stdin
+	    is not a terminal. Names under .test, .localhost, .internal and
+	    other TLDs that aren't in the public suffix list are always allowed.
+
+	-short-names
+	    Also include the first label of each hostname, like "myapp" for
+	    "myapp.test", for clients that connect to the unqualified name
+	    thanks to a DNS search domain. Fully qualified names with a
+	    trailing dot, like "myapp.test.", are always accepted, and the
+	    dot is removed.
+
+	-batch FILE
+	    Generate all the certificates listed in a JSON manifest, like
+	    [{"hosts": ["api.test"], "cert_file": "certs/api.pem",
+	    "key_file": "certs/api-key.pem"}, {"csr": "web.csr"}]. Each entry
+	    can also set "ecdsa", "client", "pkcs12" and "p12_file". Relative
+	    paths are resolved against the directory of the manifest.
+
+	-compose FILE [SERVICE...]
+	    Generate a certificate for each service of a Docker Compose file,
+	    or for the listed ones, in "certs/SERVICE/cert.pem" and "key.pem"
+	    next to it, and print the volumes to add. The names are the
+	    service and container names, hostname, network aliases, the ones
+	    in Traefik Host rules, and any in a "mkcert.hosts" label. YAML
+	    files are read with "docker compose config".
+
 	-cert-file FILE, -key-file FILE, -p12-file FILE
 	    Customize the output paths.
 
+	-name-template TEMPLATE
+	    Customize the default output file names, before the ".pem",
+	    "-key.pem" or ".p12" suffix, with a Go template like
+	    "{{.FirstHost}}-{{.Date}}". The fields are FirstHost (with "*"
+	    as "_wildcard"), Hosts, Extra (the number of other names), Client
+	    and Date. The default is like "{{.FirstHost}}+{{.Extra}}".
+
+	-force
+	    Overwrite existing certificate and key files. By default, they are
+	    only replaced if the certificate expired, or with -reuse-key.
+
+	-stdout, -stdout-key
+	    Print the certificate (or PKCS #12 file) to standard output instead
+	    of saving it. The key is still saved to the key file, unless
+	    -stdout-key is also set, in which case it follows the certificate.
+
+	-archive FILE
+	    Save the certificate, key, local CA and a README with their
+	    fingerprints in a single ".zip", ".tar.gz" or ".tgz" file instead,
+	    to hand them over to someone else.
+
+	-json
+	    Print a JSON description of the generated certificate (names,
+	    serial, validity, fingerprints and paths) instead of the usual
+	    messages. Errors and warnings are still printed to standard error.
+
+	-profile postgres|mysql|mongodb
+	    Save the certificate and key with the names and formats that the
+	    database server expects ("server.crt" and "server.key", or
+	    "server-cert.pem" and a PKCS #1 "server-key.pem", or a combined
+	    "mongodb.pem"), along with the CA for client certificates, owned
+	    by the database user when running as root.
+
+	-caddy DIR, -traefik DIR
+	    Save the certificate and key in DIR along with the configuration
+	    that loads them: "mkcert.caddy" to import in the Caddyfile site
+	    blocks, or a Traefik dynamic configuration file for each
+	    certificate, for the file provider "directory" option.
+
+	-kubernetes [-namespace NS] [-secret-name NAME] [-kubectl-apply]
+	    Print a kubernetes.io/tls Secret manifest with the certificate,
+	    key and local CA instead of saving them, or apply it directly with
+	    -kubectl-apply. The name defaults to the first name plus "-tls".
+
 	-client
 	    Generate a certificate for client authentication.
 
@@ -61,21 +156,421 @@ const advancedUsage = `Advanced options:
 	    Generate a ".p12" PKCS #12 file, also know as a ".pfx" file,
 	    containing certificate and key for legacy applications.
 
-	-csr CSR
+	-p12-chain full|no-root|leaf, -p12-name NAME
+	    Choose which CA certificates follow the certificate in the -pkcs12
+	    file: the intermediate and local CAs (the default), only the
+	    intermediate, or none. -p12-name sets the friendlyName, which Java
+	    uses as the keystore alias and Windows shows as the certificate
+	    name.
+
+	-p7b
+	    Save the certificate, followed by the intermediate and local CAs,
+	    as a ".p7b" PKCS #7 bundle instead of PEM, for Windows and Java
+	    tools that import certificate chains in that format. The key is
+	    still saved as PEM.
+
+	-key-pass PASSWORD
+	    Encrypt the certificate key as a PKCS #8 "ENCRYPTED PRIVATE KEY",
+	    or the PKCS #12 file with -pkcs12. The password can be given as
+	    "env:VAR" or "file:PATH" to keep it off the command line.
+
+	-reuse-key
+	    Keep the existing key at the key file path (or in the PKCS #12
+	    file) and only issue a new certificate for it, instead of
+	    generating a new key. The key is generated if it doesn't exist.
+	    Renewals with -watch and -reissue-all also keep the key.
+
+	-key-in FILE
+	    Issue the certificate for the existing private key in FILE (PEM or
+	    DER, as PKCS #8, PKCS #1 or SEC 1) instead of generating one. The
+	    key is not copied, and -key-pass is its password if encrypted.
+
+	-from-cert FILE
+	    Copy the names, extended key usages and key type of the certificate
+	    in FILE, for example an expired one or one issued by another CA,
+	    into a new certificate from the local CA. Any names given as
+	    arguments are added to them.
+
+	-not-before DURATION|DATE
+	    Start the validity of the certificates in the past, by a duration
+	    like "1h" or at a date like "2024-01-02" or "2024-01-02T15:04:05Z",
+	    to tolerate machines whose clocks lag behind this one.
+
+	-valid-until DATE
+	    Make the certificates expire at a fixed date, like "2026-06-30"
+	    (until the end of that day) or "2026-06-30T12:00:00Z", instead of
+	    2 years and 3 months from now. It's capped at the expiration of
+	    the CA.
+
+	-validity DURATION
+	    Make the certificates valid for a duration like "90m" or "72h",
+	    instead of 2 years and 3 months, for example to test renewals and
+	    expiration handling with very short-lived certificates.
+
+	-sig-alg sha256|sha384|sha512|sha256-pss|sha384-pss|sha512-pss
+	    Sign the certificates (and a new local CA, or CRLs) with the given
+	    hash, using RSA-PSS instead of PKCS #1 v1.5 with the "-pss" ones,
+	    which require an RSA CA key. The default is SHA-256.
+
+	-strict
+	    Follow the RFC 5280 profile more strictly than most clients need,
+	    by adding a Subject Key Identifier to the certificates, which some
+	    strict validators and appliances require.
+
+	-eku USAGE[,...]
+	    Set the Extended Key Usages of the certificates, instead of the
+	    ones implied by the names and -client. USAGE is one of serverAuth,
+	    clientAuth, emailProtection, codeSigning, ocspSigning, timeStamping,
+	    ipsecEndSystem, ipsecTunnel, ipsecUser and any, or an OID.
+
+	-key-usage USAGE[,...]
+	    Set the Key Usage of the certificates, instead of digitalSignature
+	    and keyEncipherment. USAGE is one of digitalSignature,
+	    contentCommitment, keyEncipherment, dataEncipherment, keyAgreement,
+	    encipherOnly and decipherOnly.
+
+	-upn USER@DOMAIN
+	    Add a Microsoft User Principal Name (an otherName SAN) to the
+	    certificate, in addition to the names given as arguments, for
+	    example for smart card logon with -client, or with -eku and the
+	    smart card logon OID "1.3.6.1.4.1.311.20.2.2". It can be repeated.
+
+	-inter NAME [-inter-pathlen N] [-inter-constrain NAME[,...]]
+	    Create an intermediate CA signed by the local CA, stored in the
+	    CAROOT under "intermediates/NAME/". It can't sign other CAs unless
+	    -inter-pathlen is more than 0, or -1 for no limit, and can be
+	    limited to names like -root-constrain. It lasts 5 years, unless
+	    set with -validity or -valid-until.
+
+	-list-inter
+	    List the intermediate CAs stored in the CAROOT.
+
+	-use-inter NAME|FILE
+	    Sign certificates with an intermediate CA, instead of the root, and
+	    save them followed by the intermediate. NAME selects one created
+	    by -inter. A FILE must be issued by the local CA, and the key can
+	    be in it or in the "-key.pem" file next to it. An
+	    "intermediateCA.pem" file in the CAROOT is used automatically.
+
+	-gen-csr
+	    Generate a key and a CSR for the given names, with the same subject
+	    and usages as a certificate, but don't sign it. The CSR is saved
+	    as ".csr", or to the -cert-file path.
+
+	-csr CSR [CSR...]
 	    Generate a certificate based on the supplied CSR. Conflicts with
-	    all other flags and arguments except -install and -cert-file.
+	    all other flags and arguments except -install, -cert-file and -stdout.
+	    The CSR can be PEM or DER encoded, and "-" reads it from stdin.
+	    Any extra arguments are also signed, one certificate per CSR, and
+	    directories are expanded to the .csr and .req files they contain.
+
+	-add-san NAME
+	    Add a name to the certificates signed with -csr, on top of the ones
+	    requested by the CSR. It can be repeated.
+
+	-drop-sans
+	    Ignore the names requested by the CSR, and only use the -add-san ones.
+
+	-cn NAME, -org NAME, -ou NAME, -country CODE
+	    Override the Common Name, Organization, Organizational Unit, or
+	    Country of the subject of the certificates (or the one requested
+	    by the CSR, with -csr). The Common Name defaults to the first name.
+
+	-inspect FILE
+	    Print a summary of the certificates in a PEM, DER or PKCS #12
+	    file, including names, usages, validity and fingerprint.
+
+	-list
+	    List the certificates issued by the local CA, as recorded in the
+	    index kept in the CAROOT.
+
+	-log [-json]
+	    Show everything the CAROOT keys signed, with when, by which user,
+	    and how it was requested (directly, from a CSR, via ACME, ...), as
+	    recorded in the append-only "issuance.log" kept in the CAROOT.
+
+	-revoke [-force] SERIAL|FILE
+	    Mark a certificate issued by the local CA as revoked in the index.
+	    A serial that is not in the index, like for certificates issued
+	    before it existed, is only revoked with -force.
+
+	-gen-crl
+	    Write a CRL signed by the local CA, listing all revoked
+	    certificates, to "rootCA.crl" in the CAROOT.
+
+	-ocsp [-listen ADDR] [-ocsp-delegate]
+	    Run an OCSP responder for the certificates issued by the local CA,
+	    based on the index and revocations. Listens on ":8888" by default.
+	    With -ocsp-delegate, responses are signed by a short-lived
+	    delegated responder certificate instead of the CA itself.
+
+	-ios-profile FILE [-ios-sign FILE]
+	    Save an iOS (and macOS) configuration profile that installs the
+	    local CA, ready to AirDrop or email to a device. It's unsigned,
+	    unless -ios-sign points to a PEM file with a certificate chain and
+	    its key to sign it with.
+
+	-serve-ca [-listen ADDR]
+	    Run a temporary HTTP server to install the local CA on phones and
+	    tablets on the local network, as a PEM or DER certificate or an
+	    iOS configuration profile, and print a QR code linking to it.
+	    Listens on ":8088" by default. It also serves "rootCA.crl" once
+	    created with -gen-crl.
+
+	-ocsp-url URL, -crl-url URL, -issuer-url URL
+	    Embed an OCSP responder, a CRL distribution point, or a CA Issuers
+	    URL in the certificates, like "http://localhost:8888" for -ocsp,
+	    or "http://localhost:8088/rootCA.crl" and ".../rootCA.crt" for
+	    -serve-ca. Note that the CRL only covers certificates issued by the
+	    root, not by an intermediate CA.
+
+	-serve [-serve-dir DIR] [-listen ADDR] [NAME ...]
+	    Run an HTTPS server with a temporary certificate for the given names
+	    (by default "localhost", "127.0.0.1" and "::1"), to check that the
+	    local CA is trusted end-to-end. Serves DIR if set, or otherwise a
+	    page describing the connection. Listens on ":8443" by default.
+
+	-proxy FRONTEND=BACKEND[,...] [-listen ADDR]
+	    Run an HTTPS reverse proxy with a temporary certificate, forwarding
+	    each frontend host to a plaintext backend, for example
+	    "https://myapp.test=localhost:3000". Listens on ":8443" by default.
+
+	-acme [-listen ADDR] [-acme-allow NAME[,...]]
+	    Run an ACME server backed by the local CA, so that ACME clients
+	    like Caddy, Traefik or certbot can get certificates from it. The
+	    directory is at "https://localhost:14000/directory" by default.
+	    Challenges are approved automatically, so -acme-allow can limit
+	    the names to an allowlist, where "*.test" covers all of ".test".
+	    It only listens on localhost, unless -acme-allow is set. Names
+	    under public TLDs are rejected, unless -allow-public is set.
+
+	-ssh [-ssh-host] [-ssh-principals NAME[,...]] [-ssh-validity DURATION] [KEY.pub ...]
+	    Sign SSH public keys with a separate SSH CA kept in the CAROOT,
+	    saving the certificates as "KEY-cert.pub". User certificates are
+	    for the current user by default, host certificates need the host
+	    names as principals. They are valid for 24h by default.
+
+	-verify HOST[:PORT]
+	    Connect to a running HTTPS server (on port 443 by default), and
+	    check that its certificate chains to the local CA, is valid for
+	    HOST and is not expired. Exits with an error if it isn't.
+
+	-check CERT [-check-name NAME[,...]] [KEY]
+	    Check offline that the certificate in CERT chains to the local
+	    CA, is not expired, and is valid for each NAME, and that its key
+	    (in KEY, or in CERT itself) matches it. Exits with an error if
+	    any check fails, for example in CI.
+
+	-status [-renew-days N] [-json] [DIR ...]
+	    List the certificates that expire in the next N days (30 by
+	    default) or already expired, among the ones in the index of
+	    issued certificates, or the ones from the local CA found in DIR.
+	    With -json, all the certificates are listed with their status.
+
+	-reissue-all [-hook COMMAND] [FILE ...]
+	    Issue again all the current certificates in the index (or only
+	    the ones at the given paths), with the same names and options, to
+	    the same paths. Useful after -rotate-root, for example. Keys
+	    encrypted with -key-pass are only reissued if it's given again.
+	    Certificates for a CSR are reissued from the same CSR file, or
+	    skipped if the CSR was read from stdin.
+
+	-watch [-renew-days N] [-hook COMMAND] [FILE ...]
+	    Keep running and renew the certificates in the index (or only the
+	    ones at the given paths) N days before they expire, 30 by default,
+	    to the same paths. COMMAND is run through the shell after each
+	    renewal, for example to reload a server. Certificates with a key
+	    encrypted with -key-pass are only renewed if it's given again.
+
+	-quiet
+	    Only print errors.
+
+	-no-color, -no-emoji
+	    Don't highlight errors and warnings in color, which is otherwise
+	    done when printing to a terminal, unless $NO_COLOR is set. Don't
+	    print emoji, for logs and terminals that render them poorly.
+
+	-log-format text|json
+	    Print each message as a JSON object on its own line instead, with
+	    "time", "level" (error, warning, info or debug), "code" and
+	    "message" fields, for tools that parse the output. The code names
+	    messages like "store_not_installed" or "cert_saved", and is
+	    otherwise "message" or the level.
+
+	-verbose
+	    Also print the external commands that are run and the files that
+	    are written, to troubleshoot trust store issues.
+
+	-trust-status [-json]
+	    List the detected trust stores, including each NSS profile, Java
+	    trust store and device, and whether the local CA is installed in
+	    them. -stores limits the stores that are checked.
+
+	-env [FILE] [-json]
+	    Print the shell commands that set the environment variables which
+	    make Node.js, Deno, OpenSSL, Go, Python, curl, Git and other
+	    runtimes trust the local CA without the system trust store, like
+	    in containers. Variables that replace the default roots point to
+	    "rootCA-bundle.pem" in the CAROOT, which also has the system ones.
+	    With FILE, save them as a Docker env file instead.
+
+	-stores NAME[,...]
+	    Only install in (or uninstall from, or check) the given trust
+	    stores, like the TRUST_STORES environment variable, which it
+	    overrides. See TRUST_STORES for the options.
+
+	-user
+	    On macOS, install the local CA in the login keychain and trust it
+	    only for the current user, instead of system-wide, so that
+	    -install, -uninstall and -destroy don't need sudo. A CA installed
+	    with -user must also be uninstalled or destroyed with -user.
+
+	-no-sudo
+	    Never use sudo, doas or pkexec to edit the trust stores, and fail
+	    with an error instead when an operation needs root privileges.
 
 	-CAROOT
 	    Print the CA certificate and key storage location.
 
+	-backup FILE, -restore FILE
+	    Save the whole CAROOT, including the CA key, to a password encrypted
+	    archive, or restore one into an empty CAROOT (for example on a new
+	    machine, with -install). The password is asked interactively, or
+	    set with -key-pass.
+
+	-export-ca FILE [-export-format pem|der|p7b|p12]
+	    Save the local CA certificate (not its key) to FILE, in the given
+	    format or based on the extension, defaulting to PEM. PKCS #12 files
+	    use the -key-pass password, or "changeit". Use "-" for stdout.
+
+	-adopt-ca CERT [KEY]
+	    Import an existing CA certificate and its key (in CERT itself, or in
+	    KEY) as the local CA, instead of creating a new one. There must be no
+	    local CA in the CAROOT yet.
+
+	-rotate-root [-cross-sign]
+	    Replace the local CA with a new one, moving the old one to the
+	    "retired" folder in the CAROOT. With -cross-sign, the new CA is also
+	    cross-signed by the old one, and the cross certificate is included
+	    in the chain of new certificates, so they keep working where only
+	    the old CA is trusted until the new one is installed everywhere.
+
+	-renew-root
+	    Replace the local CA certificate with a new one for the same key and
+	    subject, valid for another ten years. Existing certificates keep
+	    working, but the renewed CA needs to be installed again.
+
+	-destroy
+	    Uninstall the local CA, and the retired ones, from all the trust
+	    stores, and delete it for good, overwriting its key and the index of
+	    issued certificates before removing the CAROOT. Keys in the keyring
+	    or a TPM are deleted too, while keys in PKCS#11 tokens are left
+	    there. It asks for confirmation, unless -force is set.
+
+	-audit [-fix]
+	    Check that the CA keys in the CAROOT are only accessible to the
+	    current user, and owned by them, that there are no stray copies of
+	    keys in the CAROOT, and that the keys of the issued certificates
+	    are not world readable. With -fix, restrict the permissions that
+	    are too broad and delete leftover temporary files.
+
+	-root-constrain NAME[,...]
+	    When creating a new local CA, limit it with X.509 Name Constraints
+	    to the given domains (including their subdomains), IP addresses
+	    and CIDR ranges, like "test,localhost,127.0.0.1,10.0.0.0/8".
+	    IP addresses are unrestricted unless at least one is listed.
+
+	-ca-key URI
+	    When creating a new local CA, keep its key in a PKCS#11 token, like
+	    an HSM or SoftHSM, instead of in the CAROOT, using the OpenSC
+	    pkcs11-tool (0.23 or later). URI selects the key, like "pkcs11:token=mkcert;
+	    object=rootCA?module-path=/usr/lib/softhsm/libsofthsm2.so", which
+	    is generated if missing (ECDSA with -ecdsa, RSA otherwise). The PIN
+	    is the URI pin-source (a file) or pin-value, $MKCERT_PKCS11_PIN, or
+	    asked by pkcs11-tool. The URI is saved as "rootCA-key.uri".
+
+	-yubikey SLOT [-pin-policy POLICY] [-touch-policy POLICY]
+	    Keep the local CA key in a PIV slot of a YubiKey, like 9c, using
+	    ykman. A new local CA gets a key generated in the slot, and the key
+	    of an existing one is moved there and deleted from the CAROOT (use
+	    -backup first to keep a copy). The key is then used like with
+	    -ca-key, so the PIN is $MKCERT_PKCS11_PIN or asked by pkcs11-tool.
+	    The policies are the ykman ones, like "-touch-policy always" to
+	    require a touch for every certificate.
+
+	-tpm
+	    When creating a new local CA, generate its key in the TPM, as a
+	    non-exportable key that can't be used on another machine even if
+	    the CAROOT is copied. On Linux this uses the tpm2-tools, and the
+	    key blobs that only this TPM can load are kept in the CAROOT. On
+	    Windows the key is in the Microsoft Platform Crypto Provider.
+	    -tpm is only supported on Linux and Windows: the macOS Secure
+	    Enclave is only reachable through the Security framework, which
+	    mkcert can't use without cgo. On macOS, use -yubikey or -ca-key.
+
+	-keyring
+	    Keep the local CA key in the secret store of the OS instead of in
+	    "rootCA-key.pem": the login Keychain on macOS, the Secret Service
+	    (with secret-tool) on Linux, and a DPAPI encrypted file on Windows.
+	    A new local CA is created with its key there, and the key of an
+	    existing one is moved there. It's only read to sign something.
+
+	-must-staple
+	    Add the OCSP Must-Staple (TLS Feature status_request) extension
+	    to the certificates, so clients that enforce it will reject
+	    connections without a stapled OCSP response. See -ocsp.
+
+	-ext OID=[critical,]hex:VALUE, -root-ext OID=[critical,]base64:VALUE
+	    Add a custom extension, with its DER value in hex or base64, to
+	    the certificates (or to a new local CA with -root-ext). They can
+	    be set multiple times, and replace any standard extension with
+	    the same OID. For example, "1.3.6.1.4.1.11129.2.4.3=critical,hex:0500"
+	    is the CT poison extension.
+
+	-policy OID[=CPS_URI], -root-policy OID[=CPS_URI]
+	    Add a certificate policy to the certificates (or to a new local CA
+	    with -root-policy), optionally with a CPS URI qualifier, like
+	    "2.23.140.1.2.1=https://example.com/cps". They can be repeated.
+
+	-ca NAME
+	    Use the CA profile NAME, an independent local CA stored in the
+	    CAROOT under "NAME/", for this and any other operation, including
+	    -install, -uninstall and -CAROOT.
+
 	$CAROOT (environment variable)
 	    Set the CA certificate and key storage location. (This allows
-	    maintaining multiple local CAs in parallel.)
+	    maintaining multiple local CAs in parallel.) A location like
+	    "vault://secret/mkcert" stores them in that HashiCorp Vault KV v2
+	    secret instead, using $VAULT_ADDR and $VAULT_TOKEN.
 
 	$TRUST_STORES (environment variable)
 	    A comma-separated list of trust stores to install the local
-	    root CA into. Options are: "system", "java" and "nss" (includes
-	    Firefox). Autodetected by default.
+	    root CA into. Options are: "system", "java", "nss" (includes
+	    Firefox and Thunderbird), "chrome" (the Chrome and Chromium
+	    enterprise policies), "wsl" (the Windows user store, from WSL),
+	    "android" (emulators and rooted devices connected with adb),
+	    "simulator" (booted iOS simulators), "kind" and "minikube" (the
+	    nodes of local Kubernetes clusters). Autodetected by default.
+
+	$MKCERT_* (environment variables)
+	    Set any option that is not given on the command line, like
+	    MKCERT_ECDSA=1, MKCERT_CERT_FILE=cert.pem or MKCERT_VALIDITY=720h.
+	    The name is the option in upper case, with underscores for dashes.
+	    Command line flags take precedence, empty variables are ignored,
+	    and repeatable options like -ext take one value per line. The
+	    variables only set the options that apply to the operation on the
+	    command line, and the operations like -install can't be set.
+
+Exit codes:
+
+	0  Success.
+	1  Any other error.
+	2  Unknown flags, or invalid flag values, also from MKCERT_* variables.
+	3  A name is not a valid hostname, IP, URL or email.
+	4  A CSR can't be read, or its signature is invalid.
+	5  There is no local CA, or its key is missing.
+	6  -install failed to install the local CA in any trust store.
+	7  -install installed the local CA in some trust stores, but not all.
 
 `
 
@@ -93,22 +588,148 @@ func main() {
 	var (
 		installFlag   = flag.Bool("install", false, "")
 		uninstallFlag = flag.Bool("uninstall", false, "")
+		userFlag      = flag.Bool("user", false, "")
+		trustStatFlag = flag.Bool("trust-status", false, "")
+		envFlag       = flag.Bool("env", false, "")
+		wizardFlag    = flag.Bool("wizard", false, "")
+		storesFlag    = flag.String("stores", "", "")
+		verifyFlag    = flag.String("verify", "", "")
+		checkFlag     = flag.String("check", "", "")
+		checkNameFlag = flag.String("check-name", "", "")
+		statusFlag    = flag.Bool("status", false, "")
+		reissueFlag   = flag.Bool("reissue-all", false, "")
+		destroyFlag   = flag.Bool("destroy", false, "")
+		auditFlag     = flag.Bool("audit", false, "")
+		logFlag       = flag.Bool("log", false, "")
+		fixFlag       = flag.Bool("fix", false, "")
 		pkcs12Flag    = flag.Bool("pkcs12", false, "")
+		p7bFlag       = flag.Bool("p7b", false, "")
+		archiveFlag   = flag.String("archive", "", "")
+		caddyFlag     = flag.String("caddy", "", "")
+		profileFlag   = flag.String("profile", "", "")
+		composeFlag   = flag.String("compose", "", "")
+		traefikFlag   = flag.String("traefik", "", "")
+		p12ChainFlag  = flag.String("p12-chain", "full", "")
+		p12NameFlag   = flag.String("p12-name", "", "")
 		ecdsaFlag     = flag.Bool("ecdsa", false, "")
 		clientFlag    = flag.Bool("client", false, "")
 		helpFlag      = flag.Bool("help", false, "")
 		carootFlag    = flag.Bool("CAROOT", false, "")
+		caFlag        = flag.String("ca", "", "")
+		constrainFlag = flag.String("root-constrain", "", "")
+		caKeyFlag     = flag.String("ca-key", "", "")
+		yubikeyFlag   = flag.String("yubikey", "", "")
+		pinPolicyFlag = flag.String("pin-policy", "", "")
+		touchPolFlag  = flag.String("touch-policy", "", "")
+		tpmFlag       = flag.Bool("tpm", false, "")
+		keyringFlag   = flag.Bool("keyring", false, "")
 		csrFlag       = flag.String("csr", "", "")
+		genCSRFlag    = flag.Bool("gen-csr", false, "")
+		dropSANsFlag  = flag.Bool("drop-sans", false, "")
+		stapleFlag    = flag.Bool("must-staple", false, "")
+		rotateFlag    = flag.Bool("rotate-root", false, "")
+		renewRootFlag = flag.Bool("renew-root", false, "")
+		backupFlag    = flag.String("backup", "", "")
+		restoreFlag   = flag.String("restore", "", "")
+		adoptFlag     = flag.String("adopt-ca", "", "")
+		exportFlag    = flag.String("export-ca", "", "")
+		exportFmtFlag = flag.String("export-format", "", "")
+		crossFlag     = flag.Bool("cross-sign", false, "")
+		cnFlag        = flag.String("cn", "", "")
+		orgFlag       = flag.String("org", "", "")
+		ouFlag        = flag.String("ou", "", "")
+		countryFlag   = flag.String("country", "", "")
+		ekuFlag       = flag.String("eku", "", "")
+		keyUsageFlag  = flag.String("key-usage", "", "")
+		inspectFlag   = flag.String("inspect", "", "")
+		listFlag      = flag.Bool("list", false, "")
+		revokeFlag    = flag.String("revoke", "", "")
+		genCRLFlag    = flag.Bool("gen-crl", false, "")
+		ocspFlag      = flag.Bool("ocsp", false, "")
+		ocspDelFlag   = flag.Bool("ocsp-delegate", false, "")
+		serveCAFlag   = flag.Bool("serve-ca", false, "")
+		ocspURLFlag   = flag.String("ocsp-url", "", "")
+		crlURLFlag    = flag.String("crl-url", "", "")
+		issuerURLFlag = flag.String("issuer-url", "", "")
+		iosProfFlag   = flag.String("ios-profile", "", "")
+		iosSignFlag   = flag.String("ios-sign", "", "")
+		listenFlag    = flag.String("listen", "", "")
+		watchFlag     = flag.Bool("watch", false, "")
+		renewDaysFlag = flag.Int("renew-days", 30, "")
+		hookFlag      = flag.String("hook", "", "")
+		serveFlag     = flag.Bool("serve", false, "")
+		serveDirFlag  = flag.String("serve-dir", "", "")
+		proxyFlag     = flag.String("proxy", "", "")
+		acmeFlag      = flag.Bool("acme", false, "")
+		acmeAllowFlag = flag.String("acme-allow", "", "")
+		interFlag     = flag.String("inter", "", "")
+		interPathFlag = flag.Int("inter-pathlen", 0, "")
+		interConsFlag = flag.String("inter-constrain", "", "")
+		listInterFlag = flag.Bool("list-inter", false, "")
+		useInterFlag  = flag.String("use-inter", "", "")
+		hostsFileFlag = flag.String("hosts-file", "", "")
+		allowPubFlag  = flag.Bool("allow-public", false, "")
+		shortFlag     = flag.Bool("short-names", false, "")
+		k8sFlag       = flag.Bool("kubernetes", false, "")
+		k8sApplyFlag  = flag.Bool("kubectl-apply", false, "")
+		namespaceFlag = flag.String("namespace", "", "")
+		secretFlag    = flag.String("secret-name", "", "")
+		batchFlag     = flag.String("batch", "", "")
+		sshFlag       = flag.Bool("ssh", false, "")
+		sshHostFlag   = flag.Bool("ssh-host", false, "")
+		sshPrincFlag  = flag.String("ssh-principals", "", "")
+		sshValidFlag  = flag.Duration("ssh-validity", 24*time.Hour, "")
 		certFileFlag  = flag.String("cert-file", "", "")
 		keyFileFlag   = flag.String("key-file", "", "")
 		p12FileFlag   = flag.String("p12-file", "", "")
+		nameTmplFlag  = flag.String("name-template", "", "")
+		forceFlag     = flag.Bool("force", false, "")
+		keyPassFlag   = flag.String("key-pass", "", "")
+		reuseKeyFlag  = flag.Bool("reuse-key", false, "")
+		keyInFlag     = flag.String("key-in", "", "")
+		fromCertFlag  = flag.String("from-cert", "", "")
+		notBeforeFlag = flag.String("not-before", "", "")
+		validUntFlag  = flag.String("valid-until", "", "")
+		validityFlag  = flag.Duration("validity", 0, "")
+		sigAlgFlag    = flag.String("sig-alg", "", "")
+		strictFlag    = flag.Bool("strict", false, "")
+		stdoutFlag    = flag.Bool("stdout", false, "")
+		stdoutKeyFlag = flag.Bool("stdout-key", false, "")
+		jsonFlag      = flag.Bool("json", false, "")
+		quietFlag     = flag.Bool("quiet", false, "")
+		verboseFlag   = flag.Bool("verbose", false, "")
+		logFmtFlag    = flag.String("log-format", "text", "")
+		noColorFlag   = flag.Bool("no-color", false, "")
+		noEmojiFlag   = flag.Bool("no-emoji", false, "")
+		noSudoFlag    = flag.Bool("no-sudo", false, "")
 		versionFlag   = flag.Bool("version", false, "")
 	)
+	var extFlag, rootExtFlag, addSANFlag, upnFlag, policyFlag, rootPolicyFlag stringsFlag
+	flag.Var(&extFlag, "ext", "")
+	flag.Var(&policyFlag, "policy", "")
+	flag.Var(&rootPolicyFlag, "root-policy", "")
+	flag.Var(&upnFlag, "upn", "")
+	flag.Var(&addSANFlag, "add-san", "")
+	flag.Var(&rootExtFlag, "root-ext", "")
 	flag.Usage = func() {
 		fmt.Fprint(flag.CommandLine.Output(), shortUsage)
-		fmt.Fprintln(flag.CommandLine.Output(), `For more options, run "mkcert -help".`)
+		fmt.Fprintln(flag.CommandLine.Output(), `For more options, run "mkcert -help". For the commands, run "mkcert help".`)
+	}
+	flag.CommandLine.Parse(subcommandArgs(os.Args[1:]))
+	// Only the flags on the command line select the operation, and are
+	// checked for conflicts. The environment can only set its options.
+	given := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { given[f.Name] = true })
+	applyEnvFlags(selectModes(given, flag.NArg()))
+	switch *logFmtFlag {
+	case "text":
+		noEmoji = *noEmojiFlag
+		log.SetOutput(&textLogWriter{w: os.Stderr, color: useColors(os.Stderr, *noColorFlag)})
+	case "json":
+		log.SetOutput(&jsonLogWriter{w: os.Stderr})
+	default:
+		exitf(exitUsage, "ERROR: unknown -log-format %q, use text or json", *logFmtFlag)
 	}
-	flag.Parse()
 	if *helpFlag {
 		fmt.Print(shortUsage)
 		fmt.Print(advancedUsage)
@@ -126,42 +747,516 @@ func main() {
 		fmt.Println("(unknown)")
 		return
 	}
+	if *caFlag != "" && (!safeNameRe.MatchString(*caFlag) || *caFlag == interDir) {
+		exitf(exitUsage, "ERROR: %q is not a valid CA profile name, use only letters, digits, dots, dashes and underscores", *caFlag)
+	}
+	leafExts, err := parseExtensions(extFlag)
+	exitIfErr(err, exitUsage, "invalid -ext value")
+	if *stapleFlag {
+		leafExts = append(withoutExtension(leafExts, oidExtensionTLSFeature), mustStapleExtension)
+	}
+	policies, err := parsePolicies(policyFlag)
+	exitIfErr(err, exitUsage, "invalid -policy value")
+	if policies != nil {
+		leafExts = append(withoutExtension(leafExts, oidExtensionCertificatePolicies), *policies)
+	}
+	rootExts, err := parseExtensions(rootExtFlag)
+	exitIfErr(err, exitUsage, "invalid -root-ext value")
+	rootPolicies, err := parsePolicies(rootPolicyFlag)
+	exitIfErr(err, exitUsage, "invalid -root-policy value")
+	if rootPolicies != nil {
+		rootExts = append(withoutExtension(rootExts, oidExtensionCertificatePolicies), *rootPolicies)
+	}
+	var rootDomains []string
+	var rootRanges []*net.IPNet
+	if *constrainFlag != "" {
+		var err error
+		rootDomains, rootRanges, err = parseNameConstraints(*constrainFlag)
+		exitIfErr(err, exitUsage, "invalid -root-constrain value")
+	}
+	if *interPathFlag < -1 {
+		exitf(exitUsage, "ERROR: -inter-pathlen must be -1 (unlimited) or more")
+	}
+	var interDomains []string
+	var interRanges []*net.IPNet
+	if *interConsFlag != "" {
+		var err error
+		interDomains, interRanges, err = parseNameConstraints(*interConsFlag)
+		exitIfErr(err, exitUsage, "invalid -inter-constrain value")
+	}
+	var nameTemplate *template.Template
+	if *nameTmplFlag != "" {
+		var err error
+		nameTemplate, err = template.New("name").Option("missingkey=error").Parse(*nameTmplFlag)
+		exitIfErr(err, exitUsage, "invalid -name-template")
+	}
+	modes := checkModes(given, flag.NArg())
 	if *carootFlag {
-		if *installFlag || *uninstallFlag {
-			log.Fatalln("ERROR: you can't set -[un]install and -CAROOT at the same time")
-		}
-		fmt.Println(getCAROOT())
+		fmt.Println(profileCAROOT(getCAROOT(), *caFlag))
 		return
 	}
-	if *installFlag && *uninstallFlag {
-		log.Fatalln("ERROR: you can't set -install and -uninstall at the same time")
+	var checkNames []string
+	if *checkNameFlag != "" {
+		checkNames = strings.Split(*checkNameFlag, ",")
+	}
+	if *storesFlag != "" {
+		for _, store := range strings.Split(*storesFlag, ",") {
+			var known bool
+			for _, s := range knownTrustStores {
+				known = known || s == store
+			}
+			if !known {
+				exitf(exitUsage, "ERROR: unknown trust store %q, the options are %s", store, strings.Join(knownTrustStores, ", "))
+			}
+		}
+		trustStores = *storesFlag
+	}
+	if *tpmFlag && runtime.GOOS != "linux" && runtime.GOOS != "windows" {
+		log.Fatalln("ERROR: -tpm is only supported on Linux and Windows, use -yubikey or -ca-key instead")
+	}
+	if *userFlag && runtime.GOOS != "darwin" {
+		log.Fatalln("ERROR: -user is only supported on macOS (on Windows the current user store is always used)")
+	}
+	var proxyRoutes []proxyRoute
+	if *proxyFlag != "" {
+		var err error
+		proxyRoutes, err = parseProxyRoutes(*proxyFlag)
+		exitIfErr(err, exitUsage, "invalid -proxy value")
+	}
+	var acmeAllow []string
+	if *acmeAllowFlag != "" {
+		acmeAllow = strings.Split(*acmeAllowFlag, ",")
+	}
+	if *sshValidFlag <= 0 {
+		exitf(exitUsage, "ERROR: -ssh-validity must be positive")
+	}
+	var sshPrincipals []string
+	if *sshPrincFlag != "" {
+		sshPrincipals = strings.Split(*sshPrincFlag, ",")
+	}
+	if *iosSignFlag != "" && *iosProfFlag == "" {
+		log.Fatalln("ERROR: -ios-sign can only be used with -ios-profile")
+	}
+	for _, u := range []string{*ocspURLFlag, *crlURLFlag, *issuerURLFlag} {
+		if u == "" {
+			continue
+		}
+		if parsed, err := url.Parse(u); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			exitf(exitUsage, "ERROR: %q is not a valid HTTP URL", u)
+		}
+	}
+	if (*tpmFlag || *keyringFlag) && (*caKeyFlag != "" || *yubikeyFlag != "") || *tpmFlag && *keyringFlag {
+		log.Fatalln("ERROR: only one of -keyring, -tpm, -yubikey and -ca-key can be used")
+	}
+	if *caKeyFlag != "" && !strings.HasPrefix(*caKeyFlag, pkcs11Scheme) {
+		exitf(exitUsage, "ERROR: -ca-key must be a PKCS#11 URI like \"pkcs11:token=mkcert;object=rootCA\"")
+	}
+	caKeyURI := *caKeyFlag
+	if *yubikeyFlag != "" {
+		if *batchFlag != "" {
+			log.Fatalln("ERROR: -yubikey can't be combined with -batch")
+		}
+		var err error
+		caKeyURI, err = yubikeyURI(*yubikeyFlag)
+		exitIfErr(err, exitUsage, "invalid -yubikey slot")
+	}
+	if (*pinPolicyFlag != "" || *touchPolFlag != "") && *yubikeyFlag == "" {
+		log.Fatalln("ERROR: -pin-policy and -touch-policy can only be used with -yubikey")
+	}
+	if !validPolicy(*pinPolicyFlag, "default", "never", "once", "always") {
+		exitf(exitUsage, "ERROR: -pin-policy must be one of default, never, once or always")
+	}
+	if !validPolicy(*touchPolFlag, "default", "never", "always", "cached") {
+		exitf(exitUsage, "ERROR: -touch-policy must be one of default, never, always or cached")
 	}
-	if *csrFlag != "" && (*pkcs12Flag || *ecdsaFlag || *clientFlag) {
-		log.Fatalln("ERROR: can only combine -csr with -install and -cert-file")
+	if *renewDaysFlag < 1 {
+		exitf(exitUsage, "ERROR: -renew-days must be at least 1")
 	}
-	if *csrFlag != "" && flag.NArg() != 0 {
-		log.Fatalln("ERROR: can't specify extra arguments when using -csr")
+	if *p12ChainFlag != "full" && *p12ChainFlag != "no-root" && *p12ChainFlag != "leaf" {
+		exitf(exitUsage, "ERROR: unknown -p12-chain %q, use full, no-root or leaf", *p12ChainFlag)
+	}
+	if (*p12ChainFlag != "full" || *p12NameFlag != "") && !*pkcs12Flag {
+		log.Fatalln("ERROR: -p12-chain and -p12-name only apply to -pkcs12")
+	}
+	if _, ok := dbProfiles[*profileFlag]; *profileFlag != "" && !ok {
+		exitf(exitUsage, "ERROR: unknown -profile %q, use postgres, mysql or mongodb", *profileFlag)
+	}
+	if *profileFlag != "" && (*pkcs12Flag || *p7bFlag || *archiveFlag != "" || *caddyFlag != "" || *traefikFlag != "" || *composeFlag != "" || *k8sFlag || *batchFlag != "" || *stdoutFlag || *keyPassFlag != "" || *clientFlag) {
+		log.Fatalln("ERROR: -profile can't be combined with -pkcs12, -p7b, -archive, -caddy, -traefik, -compose, -kubernetes, -batch, -stdout, -key-pass or -client")
+	}
+	if *composeFlag != "" && (*certFileFlag != "" || *keyFileFlag != "" || *p12FileFlag != "" || *pkcs12Flag || *p7bFlag || *archiveFlag != "" || *caddyFlag != "" || *traefikFlag != "" || *k8sFlag || *batchFlag != "" || *stdoutFlag || *keyInFlag != "" || *jsonFlag || *fromCertFlag != "" || *hostsFileFlag != "") {
+		log.Fatalln("ERROR: -compose chooses the names and output files, and can't be combined with -cert-file, -key-file, -p12-file, -pkcs12, -p7b, -archive, -caddy, -traefik, -kubernetes, -batch, -stdout, -key-in, -json, -from-cert or -hosts-file")
+	}
+	if *caddyFlag != "" && *traefikFlag != "" {
+		log.Fatalln("ERROR: only one of -caddy and -traefik can be used")
+	}
+	if (*caddyFlag != "" || *traefikFlag != "") && (*certFileFlag != "" || *keyFileFlag != "" || *pkcs12Flag || *p7bFlag || *archiveFlag != "" || *k8sFlag || *batchFlag != "" || *stdoutFlag || *keyInFlag != "" || *keyPassFlag != "") {
+		log.Fatalln("ERROR: -caddy and -traefik choose the output files, and can't be combined with -cert-file, -key-file, -pkcs12, -p7b, -archive, -kubernetes, -batch, -stdout, -key-in or -key-pass")
+	}
+	if *archiveFlag != "" && !isArchiveName(*archiveFlag) {
+		exitf(exitUsage, "ERROR: the -archive file name must end in .zip, .tar.gz or .tgz")
+	}
+	if *archiveFlag != "" && (*pkcs12Flag || *p7bFlag || *k8sFlag || *batchFlag != "" || *stdoutFlag || *reuseKeyFlag || (*certFileFlag != "" && *certFileFlag == *keyFileFlag)) {
+		log.Fatalln("ERROR: -archive can't be combined with -pkcs12, -p7b, -kubernetes, -batch, -stdout, -reuse-key, or the same -cert-file and -key-file")
+	}
+	if *p7bFlag && (*pkcs12Flag || *k8sFlag || *stdoutKeyFlag) {
+		log.Fatalln("ERROR: -p7b only applies to certificate files, and can't be combined with -pkcs12, -kubernetes or -stdout-key")
+	}
+	if *p7bFlag && *certFileFlag != "" && *certFileFlag == *keyFileFlag {
+		log.Fatalln("ERROR: -p7b can't save the key in the same file as the certificate")
+	}
+	if *stdoutKeyFlag && !*stdoutFlag {
+		log.Fatalln("ERROR: -stdout-key can only be used with -stdout")
+	}
+	if *stdoutFlag && (*certFileFlag != "" || *p12FileFlag != "" || (*stdoutKeyFlag && *keyFileFlag != "")) {
+		log.Fatalln("ERROR: can't set -stdout and output file paths at the same time")
+	}
+	if *jsonFlag && *stdoutFlag {
+		log.Fatalln("ERROR: can't set -json and -stdout at the same time")
+	}
+	if *quietFlag && *verboseFlag {
+		log.Fatalln("ERROR: you can't set -quiet and -verbose at the same time")
+	}
+	switch {
+	case *quietFlag:
+		log.SetOutput(logFilter{w: log.Writer()})
+	case *jsonFlag:
+		log.SetOutput(logFilter{w: log.Writer(), warnings: true})
+	}
+	verbose = *verboseFlag
+	noSudo = *noSudoFlag
+	if *countryFlag != "" && !regexp.MustCompile(`^[A-Za-z]{2}$`).MatchString(*countryFlag) {
+		exitf(exitUsage, "ERROR: -country must be a two-letter ISO 3166 country code, like \"US\"")
+	}
+	if *dropSANsFlag && len(addSANFlag) == 0 {
+		log.Fatalln("ERROR: -drop-sans requires the replacement names to be set with -add-san")
+	}
+	if *reuseKeyFlag && (*k8sFlag || *stdoutKeyFlag) {
+		log.Fatalln("ERROR: -reuse-key can't be combined with -kubernetes and -stdout-key")
+	}
+	if *keyInFlag != "" && (*batchFlag != "" || *reuseKeyFlag || *keyFileFlag != "" || *stdoutKeyFlag) {
+		log.Fatalln("ERROR: -key-in can't be combined with -batch, -reuse-key, -key-file and -stdout-key")
+	}
+	if *fromCertFlag != "" && (*batchFlag != "" || *clientFlag) {
+		log.Fatalln("ERROR: -from-cert can't be combined with -batch and -client")
+	}
+	var notBefore time.Time
+	if *notBeforeFlag != "" {
+		if d, err := time.ParseDuration(*notBeforeFlag); err == nil {
+			if d < 0 {
+				exitf(exitUsage, "ERROR: -not-before must be a positive duration, like \"1h\", to start in the past")
+			}
+			notBefore = time.Now().Add(-d)
+		} else {
+			notBefore, err = parseTime(*notBeforeFlag)
+			exitIfErr(err, exitUsage, "invalid -not-before, expected a duration like \"1h\" or a date")
+		}
+	}
+	if *validityFlag < 0 || (*validityFlag > 0 && *validityFlag < time.Minute) {
+		exitf(exitUsage, "ERROR: -validity must be at least one minute")
+	}
+	if *validityFlag != 0 && *validUntFlag != "" {
+		log.Fatalln("ERROR: can't set -validity and -valid-until at the same time")
+	}
+	if _, ok := signatureAlgorithms[strings.ToLower(*sigAlgFlag)]; *sigAlgFlag != "" && !ok {
+		exitf(exitUsage, "ERROR: unknown -sig-alg %q, use sha256, sha384, sha512, sha256-pss, sha384-pss or sha512-pss", *sigAlgFlag)
+	}
+	if *ekuFlag != "" && *clientFlag {
+		log.Fatalln("ERROR: can't set -eku and -client at the same time, add clientAuth to -eku instead")
+	}
+	var extKeyUsage []x509.ExtKeyUsage
+	var unknownExtKeyUsage []asn1.ObjectIdentifier
+	if *ekuFlag != "" {
+		var err error
+		extKeyUsage, unknownExtKeyUsage, err = parseExtKeyUsages(*ekuFlag)
+		exitIfErr(err, exitUsage, "invalid -eku")
+	}
+	for _, upn := range upnFlag {
+		if user, domain, ok := strings.Cut(upn, "@"); !ok || user == "" || domain == "" {
+			exitf(exitUsage, "ERROR: %q is not a valid User Principal Name, expected user@domain", upn)
+		}
+	}
+	var keyUsage x509.KeyUsage
+	if *keyUsageFlag != "" {
+		var err error
+		keyUsage, err = parseKeyUsage(*keyUsageFlag)
+		exitIfErr(err, exitUsage, "invalid -key-usage")
+	}
+	var validUntil time.Time
+	if *validUntFlag != "" {
+		var err error
+		validUntil, err = parseTime(*validUntFlag)
+		exitIfErr(err, exitUsage, "invalid -valid-until, expected a date")
+		if !strings.Contains(*validUntFlag, "T") {
+			// A date is valid until the end of that day.
+			validUntil = validUntil.AddDate(0, 0, 1).Add(-time.Second)
+		}
+		if validUntil.Before(time.Now()) || (!notBefore.IsZero() && validUntil.Before(notBefore)) {
+			exitf(exitUsage, "ERROR: -valid-until must be in the future")
+		}
+	}
+	var keyPassword string
+	if *keyPassFlag != "" {
+		var err error
+		keyPassword, err = resolvePassword(*keyPassFlag)
+		fatalIfErr(err, "failed to read the -key-pass password")
+		if keyPassword == "" {
+			log.Fatalln("ERROR: the -key-pass password is empty")
+		}
+	}
+	if *batchFlag != "" && (*hostsFileFlag != "" || flag.NArg() != 0) {
+		log.Fatalln("ERROR: -batch reads the names from the manifest, and can't be combined with names or -hosts-file")
+	}
+	if *batchFlag != "" && (*certFileFlag != "" || *keyFileFlag != "" || *p12FileFlag != "" || *stdoutFlag) {
+		log.Fatalln("ERROR: the output paths of -batch are set in the manifest")
+	}
+	if *k8sFlag && (*batchFlag != "" || *pkcs12Flag || *keyPassFlag != "" || *stdoutFlag || *jsonFlag || *certFileFlag != "" || *keyFileFlag != "" || *p12FileFlag != "") {
+		log.Fatalln("ERROR: -kubernetes can't be combined with -batch, -pkcs12, -key-pass, -stdout, -json and the output file options")
+	}
+	if (*k8sApplyFlag || *namespaceFlag != "" || *secretFlag != "") && !*k8sFlag {
+		log.Fatalln("ERROR: -kubectl-apply, -namespace and -secret-name can only be used with -kubernetes")
+	}
+	if *k8sApplyFlag && !binaryExists("kubectl") {
+		log.Fatalln("ERROR: -kubectl-apply requires kubectl")
+	}
+	args := flag.Args()
+	stdinHosts := false
+	for _, arg := range args {
+		stdinHosts = stdinHosts || arg == "-"
+	}
+	if stdinHosts && !modesAllow(modes, "hosts-file") {
+		log.Fatalln("ERROR: \"-\" can only be used when generating certificates")
+	}
+	if *hostsFileFlag == "-" && stdinHosts {
+		log.Fatalln("ERROR: the names can only be read from stdin once")
+	}
+	if stdinHosts {
+		hosts, err := readHostsFile("-")
+		fatalIfErr(err, "failed to read the names from stdin")
+		var expanded []string
+		for _, arg := range args {
+			if arg == "-" {
+				expanded = append(expanded, hosts...)
+			} else {
+				expanded = append(expanded, arg)
+			}
+		}
+		args = expanded
+	}
+	if *hostsFileFlag != "" {
+		hosts, err := readHostsFile(*hostsFileFlag)
+		fatalIfErr(err, "failed to read the -hosts-file")
+		args = append(args, hosts...)
 	}
 	(&mkcert{
-		installMode: *installFlag, uninstallMode: *uninstallFlag, csrPath: *csrFlag,
-		pkcs12: *pkcs12Flag, ecdsa: *ecdsaFlag, client: *clientFlag,
-		certFile: *certFileFlag, keyFile: *keyFileFlag, p12File: *p12FileFlag,
-	}).Run(flag.Args())
+		installMode: *installFlag, uninstallMode: *uninstallFlag, userTrust: *userFlag, csrPath: *csrFlag,
+		trustStatus: *trustStatFlag, envMode: *envFlag, wizard: *wizardFlag, verifyAddr: *verifyFlag,
+		checkPath: *checkFlag, checkNames: checkNames, statusMode: *statusFlag, reissueAll: *reissueFlag, destroyMode: *destroyFlag, auditMode: *auditFlag, auditFix: *fixFlag, issuanceLog: *logFlag,
+		inspectPath: *inspectFlag, listMode: *listFlag,
+		revokeTarget: *revokeFlag, genCRL: *genCRLFlag,
+		ocspMode: *ocspFlag, ocspDelegate: *ocspDelFlag, listenAddr: *listenFlag,
+		ocspURL: *ocspURLFlag, crlURL: *crlURLFlag, issuerURL: *issuerURLFlag,
+		watchMode: *watchFlag, renewDays: *renewDaysFlag, hook: *hookFlag,
+		serveMode: *serveFlag, serveDir: *serveDirFlag, proxyRoutes: proxyRoutes,
+		acmeMode: *acmeFlag, acmeAllow: acmeAllow, serveCAMode: *serveCAFlag,
+		iosProfile: *iosProfFlag, iosSign: *iosSignFlag,
+		sshMode: *sshFlag, sshHost: *sshHostFlag,
+		sshPrincipals: sshPrincipals, sshValidity: *sshValidFlag,
+		pkcs12: *pkcs12Flag, p7b: *p7bFlag, archivePath: *archiveFlag, caddyDir: *caddyFlag, profile: *profileFlag, traefikDir: *traefikFlag, ecdsa: *ecdsaFlag, client: *clientFlag, p12Chain: *p12ChainFlag, p12Name: *p12NameFlag,
+		certFile: *certFileFlag, keyFile: *keyFileFlag, p12File: *p12FileFlag, nameTemplate: nameTemplate, force: *forceFlag,
+		keyPassword: keyPassword, stdout: *stdoutFlag, stdoutKey: *stdoutKeyFlag, reuseKey: *reuseKeyFlag, keyIn: *keyInFlag,
+		jsonOutput: *jsonFlag, interPath: *useInterFlag, fromCert: *fromCertFlag, notBefore: notBefore, validUntil: validUntil, validity: *validityFlag,
+		newInterName: *interFlag, listInter: *listInterFlag, caProfile: *caFlag,
+		interPathLen: *interPathFlag, interDomains: interDomains, interRanges: interRanges,
+		rootDomains: rootDomains, rootRanges: rootRanges, batchPath: *batchFlag, composePath: *composeFlag, sigAlg: strings.ToLower(*sigAlgFlag), strict: *strictFlag,
+		genCSR: *genCSRFlag, allowPublic: *allowPubFlag, shortNames: *shortFlag, caKeyURI: caKeyURI,
+		yubikeySlot: strings.ToLower(*yubikeyFlag), pinPolicy: *pinPolicyFlag, touchPolicy: *touchPolFlag, tpm: *tpmFlag, keyring: *keyringFlag, kubernetes: *k8sFlag, kubectlApply: *k8sApplyFlag, namespace: *namespaceFlag, secretName: *secretFlag,
+		leafExts: leafExts, rootExts: rootExts, extKeyUsage: extKeyUsage, unknownExtKeyUsage: unknownExtKeyUsage, keyUsage: keyUsage, upns: upnFlag,
+		addSANs: addSANFlag, dropSANs: *dropSANsFlag, rotateRoot: *rotateFlag, crossSign: *crossFlag, renewRoot: *renewRootFlag,
+		backupPath: *backupFlag, restorePath: *restoreFlag,
+		adoptPath: *adoptFlag, exportPath: *exportFlag, exportFormat: strings.ToLower(*exportFmtFlag),
+		subjectCN: *cnFlag, subjectOrg: *orgFlag, subjectOU: *ouFlag, subjectCountry: strings.ToUpper(*countryFlag),
+	}).Run(args)
+	if code := installExitCode(); code != 0 {
+		os.Exit(code)
+	}
+}
+
+// envFlagPrefix starts the environment variables that set the flags, like
+// MKCERT_CERT_FILE for -cert-file.
+const envFlagPrefix = "MKCERT_"
+
+// applyEnvFlags sets the options that were not given on the command line
+// from the MKCERT_* environment variables, if they apply to modes. Empty
+// variables are ignored, and the repeatable flags take one value per line.
+//
+// The operations, like -install or -destroy, can't be set from the
+// environment, or a variable left set would change what every later
+// invocation does.
+func applyEnvFlags(modes []*subcommand) {
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	flag.VisitAll(func(f *flag.Flag) {
+		// This also skips MKCERT_CAROOT, which would be too easy to confuse
+		// with $CAROOT.
+		if f.Name == "help" || isOperationFlag(f.Name) || !modesAllow(modes, f.Name) {
+			return
+		}
+		name := envFlagPrefix + strings.ToUpper(strings.Replace(f.Name, "-", "_", -1))
+		value := os.Getenv(name)
+		if set[f.Name] || value == "" {
+			return
+		}
+		values := []string{value}
+		if _, ok := f.Value.(*stringsFlag); ok {
+			values = strings.Split(strings.TrimSpace(value), "\n")
+		}
+		for _, v := range values {
+			if err := flag.Set(f.Name, strings.TrimSpace(v)); err != nil {
+				exitf(exitUsage, "ERROR: invalid %s value %q: %s", name, v, err)
+			}
+		}
+	})
+}
+
+// readHostsFile returns the names listed in path, or in the standard input if
+// path is "-", ignoring blank lines and comments starting with "#".
+func readHostsFile(path string) ([]string, error) {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		data, err = ioutil.ReadFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var hosts []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		hosts = append(hosts, strings.Fields(line)...)
+	}
+	if len(hosts) == 0 {
+		if path == "-" {
+			return nil, fmt.Errorf("no names found in stdin")
+		}
+		return nil, fmt.Errorf("no names found in %q", path)
+	}
+	return hosts, nil
+}
+
+// parseTime parses a date like "2006-01-02" in the local time zone, or an
+// RFC 3339 timestamp like "2006-01-02T15:04:05Z".
+func parseTime(value string) (time.Time, error) {
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, value)
 }
 
 const rootName = "rootCA.pem"
 const rootKeyName = "rootCA-key.pem"
 
+// rootKeyURIName replaces rootKeyName for a CA key kept outside the CAROOT,
+// and contains its URI.
+const rootKeyURIName = "rootCA-key.uri"
+
 type mkcert struct {
 	installMode, uninstallMode bool
-	pkcs12, ecdsa, client      bool
+	userTrust, trustStatus     bool
+	envMode, wizard            bool
+	statusMode, reissueAll     bool
+	destroyMode                bool
+	auditMode, auditFix        bool
+	issuanceLog                bool
+	listMode, genCRL           bool
+	ocspMode, ocspDelegate     bool
+	ocspURL, crlURL, issuerURL string
+	listenAddr                 string
+	watchMode                  bool
+	renewDays                  int
+	hook                       string
+	serveMode                  bool
+	serveCAMode                bool
+	iosProfile, iosSign        string
+	serveDir                   string
+	proxyRoutes                []proxyRoute
+	acmeMode                   bool
+	acmeAllow                  []string
+	sshMode, sshHost           bool
+	sshPrincipals              []string
+	sshValidity                time.Duration
+	pkcs12, p7b, ecdsa, client bool
+	p12Chain, p12Name          string
+	archivePath                string
+	caddyDir, traefikDir       string
+	profile                    string
+	stdout, stdoutKey          bool
+	jsonOutput                 bool
 	keyFile, certFile, p12File string
+	nameTemplate               *template.Template
+	force                      bool
+	keyPassword                string
+	reuseKey                   bool
+	keyIn                      string
+	fromCert                   string
+	allowPublic                bool
+	shortNames                 bool
+	caKeyURI                   string
+	yubikeySlot                string
+	pinPolicy, touchPolicy     string
+	tpm, keyring               bool
+	notBefore, validUntil      time.Time
+	validity                   time.Duration
+	sigAlg                     string
+	strict                     bool
+	keyUsage                   x509.KeyUsage
+	extKeyUsage                []x509.ExtKeyUsage
+	unknownExtKeyUsage         []asn1.ObjectIdentifier
+	interPath                  string
+	newInterName               string
+	interPathLen               int
+	interDomains               []string
+	interRanges                []*net.IPNet
+	caProfile                  string
+	batchPath                  string
+	composePath                string
+	genCSR                     bool
+	kubernetes, kubectlApply   bool
+	namespace, secretName      string
+	rootDomains                []string
+	rootRanges                 []*net.IPNet
+	leafExts, rootExts         []pkix.Extension
+	addSANs                    []string
+	upns                       []string
+	dropSANs                   bool
+	rotateRoot, crossSign      bool
+	renewRoot                  bool
+	backupPath, restorePath    string
+	adoptPath                  string
+	exportPath, exportFormat   string
+	subjectCN, subjectOrg      string
+	subjectOU, subjectCountry  string
+	listInter                  bool
 	csrPath                    string
+	inspectPath                string
+	revokeTarget               string
+	verifyAddr                 string
+	checkPath                  string
+	checkNames                 []string
+	vaultPath                  string
 
 	CAROOT string
 	caCert *x509.Certificate
 	caKey  crypto.PrivateKey
 
+	crossCert *x509.Certificate
+	interCert *x509.Certificate
+	interKey  crypto.PrivateKey
+
 	// The system cert pool is only loaded once. After installing the root, checks
 	// will keep failing until the next execution. TODO: maybe execve?
 	// https://github.com/golang/go/issues/24540 (thanks, myself)
@@ -173,11 +1268,212 @@ func (m *mkcert) Run(args []string) {
 	if m.CAROOT == "" {
 		log.Fatalln("ERROR: failed to find the default CA location, set one as the CAROOT env var")
 	}
+	if strings.HasPrefix(m.CAROOT, vaultScheme) {
+		m.vaultPath = path.Join(strings.TrimPrefix(m.CAROOT, vaultScheme), m.caProfile)
+		var err error
+		m.CAROOT, err = vaultCacheDir(m.vaultPath)
+		fatalIfErr(err, "failed to find a cache directory for the Vault CA")
+	} else {
+		m.CAROOT = profileCAROOT(m.CAROOT, m.caProfile)
+	}
 	fatalIfErr(os.MkdirAll(m.CAROOT, 0755), "failed to create the CAROOT")
+
+	if (m.backupPath != "" || m.restorePath != "") && m.vaultPath != "" {
+		log.Fatalln("ERROR: -backup and -restore are not supported with a Vault CAROOT")
+	}
+	if (m.caKeyURI != "" || m.tpm || m.keyring) && m.vaultPath != "" {
+		log.Fatalln("ERROR: -ca-key, -yubikey, -tpm and -keyring are not supported with a Vault CAROOT")
+	}
+	m.checkTPMOption()
+	if m.yubikeySlot != "" {
+		m.setupYubiKey()
+		if len(args) == 0 && !m.installMode {
+			return
+		}
+	}
+	if m.keyring && !m.rotateRoot {
+		m.setupKeyring()
+		if len(args) == 0 && !m.installMode {
+			return
+		}
+	}
+	if m.caKeyURI != "" && !m.rotateRoot && pathExists(filepath.Join(m.CAROOT, rootName)) {
+		// Allow MKCERT_CA_KEY to stay set after the CA is created.
+		uri, _ := ioutil.ReadFile(filepath.Join(m.CAROOT, rootKeyURIName))
+		if strings.TrimSpace(string(uri)) != m.caKeyURI {
+			log.Fatalln("ERROR: -ca-key only applies when creating a new local CA, and there is already one; use -rotate-root to replace it")
+		}
+	}
+	if m.destroyMode {
+		m.destroyCA()
+		return
+	}
+	if m.auditMode {
+		m.auditCAROOT()
+		return
+	}
+	if m.backupPath != "" {
+		m.backup()
+		return
+	}
+	if m.restorePath != "" {
+		m.restore()
+		if !m.installMode {
+			return
+		}
+	}
+
+	if m.wizard {
+		m.runWizard()
+		return
+	}
+
+	if m.inspectPath != "" {
+		// Don't create a new CA just to inspect a file, but use the existing
+		// one to recognize certificates it issued.
+		if pathExists(filepath.Join(m.CAROOT, rootName)) {
+			m.loadCA()
+			m.loadIntermediate()
+		}
+		m.inspect()
+		return
+	}
+	if m.listMode {
+		m.listIssued()
+		return
+	}
+	if m.issuanceLog {
+		m.printIssuanceLog()
+		return
+	}
+	if m.listInter {
+		m.listIntermediates()
+		return
+	}
+	if m.genCSR {
+		if len(args) == 0 {
+			flag.Usage()
+			return
+		}
+		args = validateHosts(m.withShortNames(args))
+		m.checkPublicDomains(args)
+		m.makeCSR(args)
+		return
+	}
+	if m.sshMode {
+		m.signSSHKeys(args)
+		return
+	}
+
+	if m.trustStatus || m.envMode || m.verifyAddr != "" || m.checkPath != "" || m.statusMode {
+		if m.vaultPath == "" && !pathExists(filepath.Join(m.CAROOT, rootName)) {
+			fatalCode(exitCAMissing, "ca_missing", "ERROR: there is no local CA in %q, run \"mkcert -install\" to create one", m.CAROOT)
+		}
+		m.loadCA()
+		switch {
+		case m.trustStatus:
+			m.printTrustStatus()
+		case m.envMode:
+			var path string
+			if len(args) > 0 {
+				path = args[0]
+			}
+			m.printEnv(path)
+		case m.verifyAddr != "":
+			m.loadIntermediate()
+			m.verifyServer()
+		case m.statusMode:
+			m.loadIntermediate()
+			m.expiryStatus(args)
+		default:
+			m.loadIntermediate()
+			var keyPath string
+			if len(args) > 0 {
+				keyPath = args[0]
+			}
+			m.checkFiles(keyPath)
+		}
+		return
+	}
+
+	if (len(m.rootDomains) > 0 || len(m.rootRanges) > 0 || len(m.rootExts) > 0) && !m.rotateRoot && pathExists(filepath.Join(m.CAROOT, rootName)) {
+		log.Fatalf("ERROR: -root-constrain, -root-ext and -root-policy only apply to new CAs, and there is already one in %q", m.CAROOT)
+	}
+	if m.adoptPath != "" {
+		var keyPath string
+		if len(args) > 0 {
+			keyPath = args[0]
+		}
+		m.adoptCA(m.adoptPath, keyPath)
+		if !m.installMode {
+			return
+		}
+	}
+	if (m.rotateRoot || m.renewRoot) && m.vaultPath == "" && !pathExists(filepath.Join(m.CAROOT, rootName)) {
+		fatalCode(exitCAMissing, "ca_missing", "ERROR: there is no local CA to rotate or renew in %q", m.CAROOT)
+	}
 	m.loadCA()
+	if m.exportPath != "" {
+		m.exportCA()
+		return
+	}
+	if m.rotateRoot {
+		m.rotateCA()
+		if !m.installMode {
+			return
+		}
+	}
+	if m.renewRoot {
+		m.renewCA()
+		if !m.installMode {
+			return
+		}
+	}
+	if m.newInterName != "" {
+		m.newIntermediate()
+		return
+	}
+	m.loadIntermediate()
+
+	if m.revokeTarget != "" || m.genCRL {
+		if m.revokeTarget != "" {
+			m.revoke()
+		}
+		if m.genCRL {
+			m.generateCRL()
+		}
+		return
+	}
+	if m.ocspMode {
+		m.serveOCSP()
+		return
+	}
+	if m.serveCAMode {
+		m.serveCA()
+		return
+	}
+	if m.iosProfile != "" && !m.installMode {
+		m.writeIOSProfile()
+		return
+	}
+	if m.watchMode {
+		m.watch(args)
+		return
+	}
+	if m.reissueAll {
+		m.reissueCerts(args)
+		return
+	}
+	if m.acmeMode {
+		m.serveACME()
+		return
+	}
 
 	if m.installMode {
 		m.install()
+		if m.iosProfile != "" {
+			m.writeIOSProfile()
+		}
 		if len(args) == 0 {
 			return
 		}
@@ -188,53 +1484,174 @@ func (m *mkcert) Run(args []string) {
 		var warning bool
 		if storeEnabled("system") && !m.checkPlatform() {
 			warning = true
-			log.Println("Note: the local CA is not installed in the system trust store.")
+			logCode("store_not_installed", "Note: the local CA is not installed in the system trust store.")
 		}
 		if storeEnabled("nss") && hasNSS && CertutilInstallHelp != "" && !m.checkNSS() {
 			warning = true
-			log.Printf("Note: the local CA is not installed in the %s trust store.", NSSBrowsers)
+			logCode("store_not_installed", "Note: the local CA is not installed in the %s trust store.", NSSBrowsers)
 		}
 		if storeEnabled("java") && hasJava && !m.checkJava() {
 			warning = true
-			log.Println("Note: the local CA is not installed in the Java trust store.")
+			logCode("store_not_installed", "Note: the local CA is not installed in the Java trust store.")
 		}
 		if warning {
-			log.Println("Run \"mkcert -install\" for certificates to be trusted automatically ⚠️")
+			logCode("install_hint", "Run \"mkcert -install\" for certificates to be trusted automatically ⚠️")
 		}
 	}
 
 	if m.csrPath != "" {
-		m.makeCertFromCSR()
+		paths := expandCSRPaths(append([]string{m.csrPath}, args...))
+		if len(paths) > 1 && m.certFile != "" {
+			log.Fatalln("ERROR: can't set -cert-file when signing more than one CSR")
+		}
+		for _, path := range paths {
+			m.csrPath = path
+			m.makeCertFromCSR()
+		}
+		return
+	}
+	if m.batchPath != "" {
+		m.makeBatch()
 		return
 	}
+	if m.composePath != "" {
+		m.makeComposeCerts(args)
+		return
+	}
+
+	if m.serveMode && len(args) == 0 {
+		args = []string{"localhost", "127.0.0.1", "::1"}
+	}
+	if len(m.proxyRoutes) > 0 {
+		for _, route := range m.proxyRoutes {
+			args = append(args, route.host)
+		}
+	}
+
+	if m.fromCert != "" {
+		args = append(m.cloneCert(m.fromCert), args...)
+	}
 
 	if len(args) == 0 {
 		flag.Usage()
 		return
 	}
 
-	hostnameRegexp := regexp.MustCompile(`(?i)^(\*\.)?[0-9a-z_-]([0-9a-z._-]*[0-9a-z_-])?$`)
-	for i, name := range args {
-		if ip := net.ParseIP(name); ip != nil {
-			continue
+	args = validateHosts(m.withShortNames(args))
+	m.checkPublicDomains(args)
+
+	if m.serveMode {
+		m.serve(args)
+		return
+	}
+	if len(m.proxyRoutes) > 0 {
+		m.proxy(args)
+		return
+	}
+
+	m.makeCert(args)
+}
+
+var hostnameRegexp = regexp.MustCompile(`(?i)^(\*\.)?[0-9a-z_-]([0-9a-z._-]*[0-9a-z_-])?$`)
+
+// validateHosts checks that hosts are all valid hostnames, IPs, URLs or
+// emails, and returns them with internationalized hostnames converted to
+// punycode and without duplicates, warning about the ones that overlap.
+func validateHosts(hosts []string) []string {
+	var result []string
+	seen := make(map[string]string) // sanKey to the name as given
+	for _, name := range hosts {
+		host, err := normalizeHost(name)
+		if err != nil {
+			fatalCode(exitInvalidHost, "invalid_host", "ERROR: %s", err)
 		}
-		if email, err := mail.ParseAddress(name); err == nil && email.Address == name {
-			continue
+		prev, ok := seen[sanKey(host)]
+		switch {
+		case !ok:
+			seen[sanKey(host)] = name
+			result = append(result, host)
+		case prev == name:
+			verbosef("Ignoring the repeated name %q", name)
+		case net.ParseIP(host) != nil:
+			logCode("duplicate_name", "Warning: %q and %q are the same IP address, it will only be included once ⚠️", prev, name)
+		case !isASCII(prev) || !isASCII(name):
+			logCode("duplicate_name", "Warning: %q and %q are the Unicode and punycode forms of the same name, it will only be included once ⚠️", prev, name)
+		default:
+			logCode("duplicate_name", "Warning: %q and %q are the same name, it will only be included once ⚠️", prev, name)
 		}
-		if uriName, err := url.Parse(name); err == nil && uriName.Scheme != "" && uriName.Host != "" {
+	}
+	for _, host := range result {
+		i := strings.Index(host, ".")
+		if strings.HasPrefix(host, "*.") || i <= 0 || net.ParseIP(host) != nil {
 			continue
 		}
-		punycode, err := idna.ToASCII(name)
-		if err != nil {
-			log.Fatalf("ERROR: %q is not a valid hostname, IP, URL or email: %s", name, err)
+		if wildcard, ok := seen[sanKey("*"+host[i:])]; ok {
+			logCode("duplicate_name", "Warning: %q is already covered by the wildcard %q ⚠️", seen[sanKey(host)], wildcard)
+		}
+	}
+	return result
+}
+
+// withShortNames returns hosts followed, with -short-names, by the first
+// label of each hostname, like "myapp" for "myapp.test.", for clients that
+// connect to the unqualified name thanks to a DNS search domain.
+func (m *mkcert) withShortNames(hosts []string) []string {
+	if !m.shortNames {
+		return hosts
+	}
+	result := append([]string{}, hosts...)
+	for _, h := range hosts {
+		label, rest, ok := strings.Cut(strings.TrimSuffix(h, "."), ".")
+		if !ok || label == "*" || rest == "" || net.ParseIP(h) != nil || strings.ContainsAny(h, "@:/") {
+			continue
 		}
-		args[i] = punycode
-		if !hostnameRegexp.MatchString(punycode) {
-			log.Fatalf("ERROR: %q is not a valid hostname, IP, URL or email", name)
+		result = append(result, label)
+	}
+	return result
+}
+
+// sanKey returns the form of a normalized host that is the same for all the
+// equivalent ones: IPs in canonical notation, and hostnames in lowercase.
+func sanKey(host string) string {
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.String()
+	}
+	if hostnameRegexp.MatchString(host) {
+		return strings.ToLower(host)
+	}
+	return host
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
 		}
 	}
+	return true
+}
 
-	m.makeCert(args)
+// normalizeHost checks that name is a valid hostname, IP, URL or email, and
+// returns it with internationalized hostnames converted to punycode, and
+// without the trailing dot of fully qualified ones like "myapp.test.".
+func normalizeHost(name string) (string, error) {
+	if ip := net.ParseIP(name); ip != nil {
+		return name, nil
+	}
+	if email, err := mail.ParseAddress(name); err == nil && email.Address == name {
+		return name, nil
+	}
+	if uriName, err := url.Parse(name); err == nil && uriName.Scheme != "" && uriName.Host != "" {
+		return name, nil
+	}
+	punycode, err := idna.ToASCII(strings.TrimSuffix(name, "."))
+	if err != nil {
+		return "", fmt.Errorf("%q is not a valid hostname, IP, URL or email: %s", name, err)
+	}
+	if !hostnameRegexp.MatchString(punycode) {
+		return "", fmt.Errorf("%q is not a valid hostname, IP, URL or email", name)
+	}
+	return punycode, nil
 }
 
 func getCAROOT() string {
@@ -264,47 +1681,98 @@ func getCAROOT() string {
 	return filepath.Join(dir, "mkcert")
 }
 
+// profileCAROOT returns the location of the CA profile selected with -ca,
+// which is a directory inside the CAROOT, or the CAROOT itself by default.
+func profileCAROOT(caroot, profile string) string {
+	if profile == "" || caroot == "" {
+		return caroot
+	}
+	return filepath.Join(caroot, profile)
+}
+
 func (m *mkcert) install() {
+	exitCode, fatalLogCode = exitInstallFailed, "store_install_failed"
+	defer func() { exitCode, fatalLogCode = exitFailure, "error" }()
+
 	if storeEnabled("system") {
 		if m.checkPlatform() {
-			log.Print("The local CA is already installed in the system trust store! 👍")
+			logCode("store_already_installed", "The local CA is already installed in the system trust store! 👍")
+			storeInstalled()
 		} else {
 			if m.installPlatform() {
-				log.Print("The local CA is now installed in the system trust store! ⚡️")
+				logCode("store_installed", "The local CA is now installed in the system trust store! ⚡️")
+				storeInstalled()
+			} else {
+				storesFailed++
 			}
 			m.ignoreCheckFailure = true // TODO: replace with a check for a successful install
 		}
 	}
 	if storeEnabled("nss") && hasNSS {
 		if m.checkNSS() {
-			log.Printf("The local CA is already installed in the %s trust store! 👍", NSSBrowsers)
+			logCode("store_already_installed", "The local CA is already installed in the %s trust store! 👍", NSSBrowsers)
+			storeInstalled()
 		} else {
 			if hasCertutil && m.installNSS() {
-				log.Printf("The local CA is now installed in the %s trust store (requires browser restart)! 🦊", NSSBrowsers)
+				logCode("store_installed", "The local CA is now installed in the %s trust store (requires browser restart)! 🦊", NSSBrowsers)
+				storeInstalled()
+			} else if hasCertutil {
+				storesFailed++
 			} else if CertutilInstallHelp == "" {
 				log.Printf(`Note: %s support is not available on your platform. ℹ️`, NSSBrowsers)
-			} else if !hasCertutil {
+			} else {
 				log.Printf(`Warning: "certutil" is not available, so the CA can't be automatically installed in %s! ⚠️`, NSSBrowsers)
 				log.Printf(`Install "certutil" with "%s" and re-run "mkcert -install" 👈`, CertutilInstallHelp)
+				storesFailed++
 			}
 		}
 	}
+	if chromePolicyEnabled() {
+		if m.checkChrome() {
+			logCode("store_already_installed", "The local CA is already installed in the Chrome enterprise policies! 👍")
+		} else {
+			m.installChrome()
+			logCode("store_installed", "The local CA is now installed in the Chrome enterprise policies (requires browser restart)! 🌐")
+		}
+		storeInstalled()
+	}
 	if storeEnabled("java") && hasJava {
 		if m.checkJava() {
-			log.Println("The local CA is already installed in Java's trust store! 👍")
+			logCode("store_already_installed", "The local CA is already installed in Java's trust store! 👍")
+			storeInstalled()
 		} else {
-			if hasKeytool {
+			if canEditJava() {
 				m.installJava()
-				log.Println("The local CA is now installed in Java's trust store! ☕️")
+				logCode("store_installed", "The local CA is now installed in Java's trust store! ☕️")
+				storeInstalled()
 			} else {
-				log.Println(`Warning: "keytool" is not available, so the CA can't be automatically installed in Java's trust store! ⚠️`)
+				log.Println(`Warning: "keytool" is not available and the format of Java's trust store is not supported, so the CA can't be automatically installed in it! ⚠️`)
+				storesFailed++
 			}
 		}
 	}
+	if storeEnabled("wsl") && hasWSL {
+		m.installWSL()
+	}
+	if storeEnabled("android") && hasADB {
+		m.installAndroid()
+	}
+	if storeEnabled("simulator") && hasSimctl {
+		m.installSimulators()
+	}
+	if storeEnabled("kind") && hasKind {
+		m.installKind()
+	}
+	if storeEnabled("minikube") && hasMinikube {
+		m.installMinikube()
+	}
 	log.Print("")
 }
 
 func (m *mkcert) uninstall() {
+	fatalLogCode = "store_uninstall_failed"
+	defer func() { fatalLogCode = "error" }()
+
 	if storeEnabled("nss") && hasNSS {
 		if hasCertutil {
 			m.uninstallNSS()
@@ -315,20 +1783,38 @@ func (m *mkcert) uninstall() {
 			log.Print("")
 		}
 	}
+	if storeEnabled("chrome") && hasChromePolicy {
+		m.uninstallChrome()
+	}
 	if storeEnabled("java") && hasJava {
-		if hasKeytool {
+		if canEditJava() {
 			m.uninstallJava()
 		} else {
 			log.Print("")
-			log.Println(`Warning: "keytool" is not available, so the CA can't be automatically uninstalled from Java's trust store (if it was ever installed)! ⚠️`)
+			log.Println(`Warning: "keytool" is not available and the format of Java's trust store is not supported, so the CA can't be automatically uninstalled from it (if it was ever installed)! ⚠️`)
 			log.Print("")
 		}
 	}
+	if storeEnabled("wsl") && hasWSL {
+		m.uninstallWSL()
+	}
+	if storeEnabled("android") && hasADB {
+		m.uninstallAndroid()
+	}
+	if storeEnabled("simulator") && hasSimctl {
+		m.uninstallSimulators()
+	}
+	if storeEnabled("kind") && hasKind {
+		m.uninstallKind()
+	}
+	if storeEnabled("minikube") && hasMinikube {
+		m.uninstallMinikube()
+	}
 	if storeEnabled("system") && m.uninstallPlatform() {
-		log.Print("The local CA is now uninstalled from the system trust store(s)! 👋")
+		logCode("store_uninstalled", "The local CA is now uninstalled from the system trust store(s)! 👋")
 		log.Print("")
 	} else if storeEnabled("nss") && hasCertutil {
-		log.Printf("The local CA is now uninstalled from the %s trust store(s)! 👋", NSSBrowsers)
+		logCode("store_uninstalled", "The local CA is now uninstalled from the %s trust store(s)! 👋", NSSBrowsers)
 		log.Print("")
 	}
 }
@@ -342,12 +1828,17 @@ func (m *mkcert) checkPlatform() bool {
 	return err == nil
 }
 
+// trustStores is the list of trust stores selected with -stores or
+// TRUST_STORES, or empty to use all the available ones.
+var trustStores = os.Getenv("TRUST_STORES")
+
+var knownTrustStores = []string{"system", "java", "nss", "chrome", "wsl", "android", "simulator", "kind", "minikube"}
+
 func storeEnabled(name string) bool {
-	stores := os.Getenv("TRUST_STORES")
-	if stores == "" {
+	if trustStores == "" {
 		return true
 	}
-	for _, store := range strings.Split(stores, ",") {
+	for _, store := range strings.Split(trustStores, ",") {
 		if store == name {
 			return true
 		}
@@ -355,15 +1846,48 @@ func storeEnabled(name string) bool {
 	return false
 }
 
+// logFilter drops log messages other than errors and, optionally, warnings.
+type logFilter struct {
+	w        io.Writer
+	warnings bool
+}
+
+func (f logFilter) Write(p []byte) (int, error) {
+	msg := bytes.TrimLeft(p, "\n")
+	if bytes.HasPrefix(msg, []byte("ERROR")) || (f.warnings && bytes.HasPrefix(msg, []byte("Warning"))) {
+		return f.w.Write(p)
+	}
+	return len(p), nil
+}
+
+var verbose bool
+
+// verbosef logs a message only if -verbose is set.
+func verbosef(format string, v ...interface{}) {
+	if verbose {
+		log.Printf("  "+format, v...)
+	}
+}
+
+// combinedOutput is cmd.CombinedOutput, but logs the command with -verbose.
+func combinedOutput(cmd *exec.Cmd) ([]byte, error) {
+	verbosef("Running %q", cmd.Args)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		verbosef("Command failed: %s", err)
+	}
+	return out, err
+}
+
 func fatalIfErr(err error, msg string) {
 	if err != nil {
-		log.Fatalf("ERROR: %s: %s", msg, err)
+		fatalCode(exitCode, fatalLogCode, "ERROR: %s: %s", msg, err)
 	}
 }
 
 func fatalIfCmdErr(err error, cmd string, out []byte) {
 	if err != nil {
-		log.Fatalf("ERROR: failed to execute \"%s\": %s\n\n%s\n", cmd, err, out)
+		fatalCode(exitCode, fatalLogCode, "ERROR: failed to execute \"%s\": %s\n\n%s\n", cmd, err, out)
 	}
 }
 
@@ -379,15 +1903,28 @@ func binaryExists(name string) bool {
 
 var sudoWarningOnce sync.Once
 
+// noSudo is set by -no-sudo.
+var noSudo bool
+
+// commandWithSudo returns a command that runs cmd as root, with sudo or, if
+// it's not available, with doas (Alpine, OpenBSD) or pkexec (polkit).
 func commandWithSudo(cmd ...string) *exec.Cmd {
 	if u, err := user.Current(); err == nil && u.Uid == "0" {
 		return exec.Command(cmd[0], cmd[1:]...)
 	}
-	if !binaryExists("sudo") {
-		sudoWarningOnce.Do(func() {
-			log.Println(`Warning: "sudo" is not available, and mkcert is not running as root. The (un)install operation might fail. ⚠️`)
-		})
-		return exec.Command(cmd[0], cmd[1:]...)
+	if noSudo {
+		exitf(exitCode, "ERROR: running %q requires root privileges, but -no-sudo is set; re-run mkcert as root, or limit the trust stores with -stores", strings.Join(cmd, " "))
+	}
+	switch {
+	case binaryExists("sudo"):
+		return exec.Command("sudo", append([]string{"--prompt=Sudo password:", "--"}, cmd...)...)
+	case binaryExists("doas"):
+		return exec.Command("doas", append([]string{"--"}, cmd...)...)
+	case binaryExists("pkexec"):
+		return exec.Command("pkexec", cmd...)
 	}
-	return exec.Command("sudo", append([]string{"--prompt=Sudo password:", "--"}, cmd...)...)
+	sudoWarningOnce.Do(func() {
+		log.Println(`Warning: "sudo", "doas" and "pkexec" are not available, and mkcert is not running as root. The (un)install operation might fail. ⚠️`)
+	})
+	return exec.Command(cmd[0], cmd[1:]...)
 }
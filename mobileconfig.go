@@ -0,0 +1,99 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"log"
+
+	"howett.net/plist"
+)
+
+// mobileConfig is an Apple configuration profile, the format iOS and macOS
+// use to install root certificates.
+type mobileConfig struct {
+	PayloadContent     []mobileConfigPayload
+	PayloadDisplayName string
+	PayloadDescription string
+	PayloadIdentifier  string
+	PayloadType        string
+	PayloadUUID        string
+	PayloadVersion     int
+}
+
+type mobileConfigPayload struct {
+	PayloadCertificateFileName string
+	PayloadContent             []byte
+	PayloadDisplayName         string
+	PayloadIdentifier          string
+	PayloadType                string
+	PayloadUUID                string
+	PayloadVersion             int
+}
+
+// mobileConfig returns an unsigned configuration profile that installs the
+// local CA. The identifiers are derived from the CA certificate, so that
+// installing the profile again replaces it instead of adding a copy.
+func (m *mkcert) mobileConfig() []byte {
+	id := "io.mkcert.ca." + m.caCert.SerialNumber.String()
+	profile := mobileConfig{
+		PayloadContent: []mobileConfigPayload{{
+			PayloadCertificateFileName: rootName,
+			PayloadContent:             m.caCert.Raw,
+			PayloadDisplayName:         m.caCert.Subject.CommonName,
+			PayloadIdentifier:          id + ".root",
+			PayloadType:                "com.apple.security.root",
+			PayloadUUID:                profileUUID(m.caCert.Raw, "root"),
+			PayloadVersion:             1,
+		}},
+		PayloadDisplayName: "mkcert development CA",
+		PayloadDescription: "Trust the mkcert local CA " + m.caCert.Subject.CommonName + " for development certificates.",
+		PayloadIdentifier:  id,
+		PayloadType:        "Configuration",
+		PayloadUUID:        profileUUID(m.caCert.Raw, "profile"),
+		PayloadVersion:     1,
+	}
+	data, err := plist.MarshalIndent(profile, plist.XMLFormat, "\t")
+	fatalIfErr(err, "failed to encode the configuration profile")
+	return data
+}
+
+// profileUUID returns a version 4 style UUID derived from data and label.
+func profileUUID(data []byte, label string) string {
+	h := sha256.Sum256(append([]byte(label+":"), data...))
+	h[6] = h[6]&0x0f | 0x40
+	h[8] = h[8]&0x3f | 0x80
+	return fmt.Sprintf("%X-%X-%X-%X-%X", h[0:4], h[4:6], h[6:8], h[8:10], h[10:16])
+}
+
+// writeIOSProfile saves the configuration profile for the local CA to
+// m.iosProfile, signed with the certificate and key at m.iosSign if set.
+func (m *mkcert) writeIOSProfile() {
+	profile := m.mobileConfig()
+	if m.iosSign != "" {
+		data, err := ioutil.ReadFile(m.iosSign)
+		fatalIfErr(err, "failed to read the signing certificate")
+		certs, err := parseCertificates(data)
+		fatalIfErr(err, "failed to parse the signing certificate")
+		_, key, err := parseCertAndKey(data)
+		fatalIfErr(err, "failed to parse the signing key")
+		if key == nil {
+			log.Fatalf("ERROR: %q must contain the signing key after the certificate", m.iosSign)
+		}
+		profile, err = signPKCS7(profile, certs, key)
+		fatalIfErr(err, "failed to sign the configuration profile")
+	}
+	fatalIfErr(writeOutput(m.iosProfile, profile, 0644), "failed to save the configuration profile")
+
+	if m.iosSign != "" {
+		log.Printf("The signed iOS configuration profile is at %s ✅\n\n", outputName(m.iosProfile))
+	} else {
+		log.Printf("The iOS configuration profile is at %s ✅\n\n", outputName(m.iosProfile))
+	}
+	log.Printf("AirDrop or email it to the device, install it from Settings, and then")
+	log.Printf("enable full trust in Settings › General › About › Certificate Trust Settings 👈\n\n")
+}
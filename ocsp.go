@@ -0,0 +1,211 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// ocspResponder answers OCSP requests for certificates issued by the local
+// CA, based on the revocation status recorded in the index.
+type ocspResponder struct {
+	m *mkcert
+
+	issuerKeyHashes map[crypto.Hash][]byte
+
+	// responderCert and responderKey are the delegated responder, or nil and
+	// the CA key if responses are signed directly by the CA.
+	responderCert *x509.Certificate
+	responderKey  crypto.Signer
+}
+
+func (m *mkcert) serveOCSP() {
+	if m.caKey == nil {
+		fatalCode(exitFailure, "ca_key_missing", "ERROR: can't sign OCSP responses because the CA key (rootCA-key.pem) is missing")
+	}
+
+	r := &ocspResponder{m: m, responderKey: m.caKey.(crypto.Signer)}
+
+	var spki struct {
+		Algorithm        pkix.AlgorithmIdentifier
+		SubjectPublicKey asn1.BitString
+	}
+	_, err := asn1.Unmarshal(m.caCert.RawSubjectPublicKeyInfo, &spki)
+	fatalIfErr(err, "failed to decode the CA public key")
+	r.issuerKeyHashes = make(map[crypto.Hash][]byte)
+	for _, h := range []crypto.Hash{crypto.SHA1, crypto.SHA256, crypto.SHA384, crypto.SHA512} {
+		hh := h.New()
+		hh.Write(spki.SubjectPublicKey.Bytes)
+		r.issuerKeyHashes[h] = hh.Sum(nil)
+	}
+
+	if m.ocspDelegate {
+		r.responderCert, r.responderKey = m.newOCSPResponder()
+		log.Printf("Signing OCSP responses with a delegated responder certificate valid until %s ℹ️",
+			r.responderCert.NotAfter.Format("2 January 2006"))
+	}
+
+	addr := m.listenAddr
+	if addr == "" {
+		addr = ":8888"
+	}
+	log.Printf("Serving OCSP responses for the local CA on %s 📡", addr)
+	fatalIfErr(http.ListenAndServe(addr, r), "failed to run the OCSP responder")
+}
+
+// newOCSPResponder generates a short lived certificate with the OCSPSigning
+// EKU, which clients accept for responses about certificates issued by the CA.
+func (m *mkcert) newOCSPResponder() (*x509.Certificate, crypto.Signer) {
+	priv, err := m.generateKey(false)
+	fatalIfErr(err, "failed to generate the OCSP responder key")
+
+	tpl := &x509.Certificate{
+		SerialNumber: randomSerialNumber(),
+		Subject: pkix.Name{
+			Organization:       []string{userFullName},
+			OrganizationalUnit: []string{userAndHostname + " - mkcert"},
+			CommonName:         userFullName + " - OCSP Responder",
+		},
+
+		NotBefore: time.Now(), NotAfter: time.Now().AddDate(0, 0, 7),
+
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageOCSPSigning},
+
+		// id-pkix-ocsp-nocheck, so clients don't try to check the status of
+		// the responder itself. See RFC 6960, Section 4.2.2.2.1.
+		ExtraExtensions: []pkix.Extension{{
+			Id:    asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 5},
+			Value: asn1.NullBytes,
+		}},
+	}
+
+	tpl.SignatureAlgorithm = m.signatureAlgorithm(m.caKey)
+	m.applyStrictProfile(tpl, priv.(crypto.Signer).Public(), m.caCert)
+	cert, err := x509.CreateCertificate(rand.Reader, tpl, m.caCert, priv.(crypto.Signer).Public(), m.caKey)
+	fatalIfErr(err, "failed to generate the OCSP responder certificate")
+	responderCert, err := x509.ParseCertificate(cert)
+	fatalIfErr(err, "failed to parse the OCSP responder certificate")
+	m.logIssuance("ocsp-responder", responderCert)
+	return responderCert, priv.(crypto.Signer)
+}
+
+func (r *ocspResponder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	var reqBytes []byte
+	switch req.Method {
+	case http.MethodGet:
+		path := strings.TrimPrefix(req.URL.Path, "/")
+		if unescaped, err := url.PathUnescape(path); err == nil {
+			path = unescaped
+		}
+		b, err := base64.StdEncoding.DecodeString(path)
+		if err != nil {
+			http.Error(w, "invalid OCSP request encoding", http.StatusBadRequest)
+			return
+		}
+		reqBytes = b
+	case http.MethodPost:
+		b, err := ioutil.ReadAll(http.MaxBytesReader(w, req.Body, 10000))
+		if err != nil {
+			http.Error(w, "failed to read OCSP request", http.StatusBadRequest)
+			return
+		}
+		reqBytes = b
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/ocsp-response")
+
+	ocspReq, err := ocsp.ParseRequest(reqBytes)
+	if err != nil {
+		w.Write(ocsp.MalformedRequestErrorResponse)
+		return
+	}
+	if !bytes.Equal(ocspReq.IssuerKeyHash, r.issuerKeyHashes[ocspReq.HashAlgorithm]) {
+		w.Write(ocsp.UnauthorizedErrorResponse)
+		return
+	}
+
+	resp, err := r.respond(ocspReq)
+	if err != nil {
+		log.Printf("ERROR: failed to create OCSP response: %s", err)
+		w.Write(ocsp.InternalErrorErrorResponse)
+		return
+	}
+	w.Write(resp)
+}
+
+func (r *ocspResponder) respond(req *ocsp.Request) ([]byte, error) {
+	now := time.Now().UTC().Truncate(time.Minute)
+	tpl := ocsp.Response{
+		Status:       ocsp.Unknown,
+		SerialNumber: req.SerialNumber,
+		ThisUpdate:   now,
+		NextUpdate:   now.Add(24 * time.Hour),
+		IssuerHash:   req.HashAlgorithm,
+	}
+
+	serial := strings.ToUpper(req.SerialNumber.Text(16))
+	// The index is reloaded on every request, so that revocations made while
+	// the responder is running are picked up.
+	index, err := r.m.readIndex()
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range index {
+		if c.Serial != serial {
+			continue
+		}
+		if c.RevokedAt != nil {
+			tpl.Status = ocsp.Revoked
+			tpl.RevokedAt = *c.RevokedAt
+			tpl.RevocationReason = ocsp.Unspecified
+		} else {
+			tpl.Status = ocsp.Good
+		}
+		break
+	}
+
+	log.Printf("OCSP request for serial %s: %s", serial, ocspStatusName(tpl.Status))
+
+	if r.responderCert != nil {
+		tpl.Certificate = r.responderCert
+	}
+	return ocsp.CreateResponse(r.m.caCert, r.responderCertOrCA(), tpl, r.responderKey)
+}
+
+func (r *ocspResponder) responderCertOrCA() *x509.Certificate {
+	if r.responderCert != nil {
+		return r.responderCert
+	}
+	return r.m.caCert
+}
+
+func ocspStatusName(status int) string {
+	switch status {
+	case ocsp.Good:
+		return "good"
+	case ocsp.Revoked:
+		return "revoked"
+	default:
+		return "unknown"
+	}
+}
@@ -0,0 +1,288 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// A CA key in a PKCS#11 token, like an HSM or SoftHSM, is identified by a
+// PKCS#11 URI (RFC 7512) like
+//
+//	pkcs11:token=mkcert;object=rootCA?module-path=/usr/lib/softhsm/libsofthsm2.so
+//
+// and used through the OpenSC pkcs11-tool, so the key never leaves the
+// token. The PIN is the pin-value or pin-source of the URI, or
+// $MKCERT_PKCS11_PIN, and otherwise pkcs11-tool asks for it.
+const pkcs11Scheme = "pkcs11:"
+
+type pkcs11Key struct {
+	module, token, object, id, slot string
+	pin                             string
+	pub                             crypto.PublicKey
+}
+
+// parsePKCS11URI parses the attributes of uri that select a key.
+func parsePKCS11URI(uri string) (*pkcs11Key, error) {
+	if !strings.HasPrefix(uri, pkcs11Scheme) {
+		return nil, fmt.Errorf("%q is not a PKCS#11 URI", uri)
+	}
+	path, query, _ := strings.Cut(strings.TrimPrefix(uri, pkcs11Scheme), "?")
+	k := &pkcs11Key{}
+	attrs := func(s, sep string, set func(name, value string) error) error {
+		for _, attr := range strings.Split(s, sep) {
+			if attr == "" {
+				continue
+			}
+			name, value, _ := strings.Cut(attr, "=")
+			value, err := url.PathUnescape(value)
+			if err != nil {
+				return fmt.Errorf("invalid %s attribute in %q: %s", name, uri, err)
+			}
+			if err := set(name, value); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	err := attrs(path, ";", func(name, value string) error {
+		switch name {
+		case "token":
+			k.token = value
+		case "object":
+			k.object = value
+		case "id":
+			k.id = hex.EncodeToString([]byte(value))
+		case "slot-id":
+			k.slot = value
+		case "type":
+			if value != "private" {
+				return fmt.Errorf("the object in %q must be a private key", uri)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = attrs(query, "&", func(name, value string) error {
+		switch name {
+		case "module-path":
+			k.module = value
+		case "pin-value":
+			k.pin = value
+		case "pin-source":
+			pin, err := ioutil.ReadFile(strings.TrimPrefix(value, "file:"))
+			if err != nil {
+				return fmt.Errorf("failed to read the PIN: %s", err)
+			}
+			k.pin = strings.TrimSpace(string(pin))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if k.object == "" && k.id == "" {
+		return nil, fmt.Errorf("%q must select the key with an object or id attribute", uri)
+	}
+	if k.pin == "" {
+		k.pin = os.Getenv(pkcs11PINEnv)
+	}
+	return k, nil
+}
+
+// args returns the pkcs11-tool arguments that select the token and key.
+func (k *pkcs11Key) args(login bool) []string {
+	var args []string
+	if k.module != "" {
+		args = append(args, "--module", k.module)
+	}
+	if k.slot != "" {
+		args = append(args, "--slot", k.slot)
+	}
+	if k.token != "" {
+		args = append(args, "--token-label", k.token)
+	}
+	if k.id != "" {
+		args = append(args, "--id", k.id)
+	}
+	if k.object != "" {
+		args = append(args, "--label", k.object)
+	}
+	if login {
+		args = append(args, "--login")
+		if k.pin != "" {
+			// Not the PIN itself, which other users could see in the
+			// process list. It's passed to run in the environment.
+			args = append(args, "--pin", "env:"+pkcs11PINEnv)
+		}
+	}
+	return args
+}
+
+// pkcs11PINEnv is the environment variable pkcs11-tool reads the PIN from.
+const pkcs11PINEnv = "MKCERT_PKCS11_PIN"
+
+// run runs pkcs11-tool, letting it ask for the PIN on the terminal if needed.
+func (k *pkcs11Key) run(args ...string) error {
+	cmd := exec.Command("pkcs11-tool", args...)
+	if k.pin != "" {
+		cmd.Env = append(os.Environ(), pkcs11PINEnv+"="+k.pin)
+	}
+	verbosef("Running pkcs11-tool %s", strings.Join(args, " "))
+	out := &bytes.Buffer{}
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, out, out
+	if err := cmd.Run(); err != nil {
+		if errors.Is(err, exec.ErrNotFound) {
+			return errors.New("pkcs11-tool is not installed, install OpenSC to use a PKCS#11 CA key")
+		}
+		return fmt.Errorf("pkcs11-tool failed: %s\n\n%s", err, bytes.TrimSpace(out.Bytes()))
+	}
+	return nil
+}
+
+// readPublicKey reads the public key of the key pair from the token.
+func (k *pkcs11Key) readPublicKey() (crypto.PublicKey, error) {
+	dir, err := ioutil.TempDir("", "mkcert-pkcs11")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	outFile := filepath.Join(dir, "pub.der")
+	args := append(k.args(false), "--read-object", "--type", "pubkey", "--output-file", outFile)
+	if err := k.run(args...); err != nil {
+		return nil, err
+	}
+	der, err := ioutil.ReadFile(outFile)
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParsePKIXPublicKey(der)
+}
+
+// openPKCS11Key returns a crypto.Signer for the key at uri, whose public key
+// is pub, like that of the CA certificate.
+func openPKCS11Key(uri string, pub crypto.PublicKey) (crypto.Signer, error) {
+	k, err := parsePKCS11URI(uri)
+	if err != nil {
+		return nil, err
+	}
+	k.pub = pub
+	return k, nil
+}
+
+// newPKCS11Key returns the key at uri for a new CA, generating it in the
+// token if it doesn't exist yet, as an ECDSA P-256 key with -ecdsa and as
+// an RSA 3072 key otherwise.
+func (m *mkcert) newPKCS11Key(uri string) (crypto.Signer, error) {
+	k, err := parsePKCS11URI(uri)
+	if err != nil {
+		return nil, err
+	}
+	if k.pub, err = k.readPublicKey(); err == nil {
+		verbosef("Using the existing key in the PKCS#11 token")
+		return k, nil
+	}
+	verbosef("Generating a new key in the PKCS#11 token: %s", err)
+	keyType := "rsa:3072"
+	if m.ecdsa {
+		keyType = "EC:prime256v1"
+	}
+	args := append(k.args(true), "--keypairgen", "--key-type", keyType, "--usage-sign")
+	if err := k.run(args...); err != nil {
+		return nil, err
+	}
+	if k.pub, err = k.readPublicKey(); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+func (k *pkcs11Key) Public() crypto.PublicKey {
+	return k.pub
+}
+
+var hashOIDs = map[crypto.Hash]asn1.ObjectIdentifier{
+	crypto.SHA1:   oidSHA1,
+	crypto.SHA256: oidSHA256,
+	crypto.SHA384: {2, 16, 840, 1, 101, 3, 4, 2, 2},
+	crypto.SHA512: {2, 16, 840, 1, 101, 3, 4, 2, 3},
+}
+
+var hashNames = map[crypto.Hash]string{
+	crypto.SHA1:   "SHA-1",
+	crypto.SHA256: "SHA256",
+	crypto.SHA384: "SHA384",
+	crypto.SHA512: "SHA512",
+}
+
+// Sign signs digest in the token. The mechanisms take the digest as input,
+// so that the hash is never computed by the token.
+func (k *pkcs11Key) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	hash := opts.HashFunc()
+	if _, ok := hashNames[hash]; !ok {
+		return nil, fmt.Errorf("unsupported hash for a PKCS#11 key: %v", hash)
+	}
+	input := digest
+	var mechanism []string
+	switch pub := k.pub.(type) {
+	case *ecdsa.PublicKey:
+		mechanism = []string{"--mechanism", "ECDSA", "--signature-format", "openssl"}
+	case *rsa.PublicKey:
+		if pss, ok := opts.(*rsa.PSSOptions); ok {
+			saltLength := pss.SaltLength
+			if saltLength == rsa.PSSSaltLengthEqualsHash || saltLength == rsa.PSSSaltLengthAuto {
+				saltLength = hash.Size()
+			}
+			mechanism = []string{"--mechanism", "RSA-PKCS-PSS", "--hash-algorithm", hashNames[hash],
+				"--mgf", "MGF1-" + hashNames[hash], "--salt-len", fmt.Sprint(saltLength)}
+			break
+		}
+		// RSA-PKCS only pads the input, which must be the DigestInfo.
+		var err error
+		input, err = asn1.Marshal(struct {
+			Algorithm pkix.AlgorithmIdentifier
+			Digest    []byte
+		}{pkix.AlgorithmIdentifier{Algorithm: hashOIDs[hash], Parameters: asn1.NullRawValue}, digest})
+		if err != nil {
+			return nil, err
+		}
+		mechanism = []string{"--mechanism", "RSA-PKCS"}
+	default:
+		return nil, fmt.Errorf("unsupported PKCS#11 key type %T", pub)
+	}
+
+	dir, err := ioutil.TempDir("", "mkcert-pkcs11")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	inFile, outFile := filepath.Join(dir, "digest"), filepath.Join(dir, "signature")
+	if err := ioutil.WriteFile(inFile, input, 0600); err != nil {
+		return nil, err
+	}
+	args := append(k.args(true), "--sign", "--input-file", inFile, "--output-file", outFile)
+	if err := k.run(append(args, mechanism...)...); err != nil {
+		return nil, err
+	}
+	return ioutil.ReadFile(outFile)
+}
@@ -0,0 +1,217 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"unicode/utf16"
+)
+
+// go-pkcs12 can't set the friendlyName attribute, which Java uses as the
+// keystore alias and Windows shows in the certificate manager, so
+// encodePKCS12 builds the PFX for -p12-name itself, with the same legacy
+// algorithms that go-pkcs12 uses for the key and the MAC. The certificates
+// are not encrypted, like with "openssl pkcs12 -export -certpbe NONE".
+
+var (
+	oidPKCS8ShroudedKeyBag = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 2}
+	oidLocalKeyID          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 21}
+	oidPBEWithSHA3DES      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 1, 3}
+	oidSHA1                = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+)
+
+const pkcs12Iterations = 2048
+
+type pkcs12MacData struct {
+	Mac struct {
+		Algorithm pkix.AlgorithmIdentifier
+		Digest    []byte
+	}
+	MacSalt    []byte
+	Iterations int
+}
+
+type pkcs12PBEParams struct {
+	Salt       []byte
+	Iterations int
+}
+
+// encodePKCS12 returns a PFX with key and the certificate chain certs,
+// starting with the one for key, which is labeled with friendlyName.
+func encodePKCS12(key interface{}, certs []*x509.Certificate, password, friendlyName string) ([]byte, error) {
+	bmpPassword := bmpString(password + "\x00")
+
+	localKeyID := sha1.Sum(certs[0].Raw)
+	attrs, err := pkcs12Attributes(friendlyName, localKeyID[:])
+	if err != nil {
+		return nil, err
+	}
+
+	var certBags []safeBag
+	for i, c := range certs {
+		certOctets, err := asn1.Marshal(c.Raw)
+		if err != nil {
+			return nil, err
+		}
+		cb, err := asn1.Marshal(certBag{Id: oidX509Certificate, Data: explicitTag(certOctets)})
+		if err != nil {
+			return nil, err
+		}
+		bag := safeBag{Id: oidCertBag, Value: explicitTag(cb)}
+		if i == 0 {
+			bag.Attributes = attrs
+		}
+		certBags = append(certBags, bag)
+	}
+
+	privDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	salt := make([]byte, 8)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	block, err := des.NewTripleDESCipher(pkcs12KDF(bmpPassword, salt, 1, 24))
+	if err != nil {
+		return nil, err
+	}
+	padding := block.BlockSize() - len(privDER)%block.BlockSize()
+	plaintext := append(append([]byte{}, privDER...), bytes.Repeat([]byte{byte(padding)}, padding)...)
+	encrypted := make([]byte, len(plaintext))
+	cipher.NewCBCEncrypter(block, pkcs12KDF(bmpPassword, salt, 2, 8)).CryptBlocks(encrypted, plaintext)
+	params, err := asn1.Marshal(pkcs12PBEParams{Salt: salt, Iterations: pkcs12Iterations})
+	if err != nil {
+		return nil, err
+	}
+	keyBag, err := asn1.Marshal(encryptedPrivateKeyInfo{
+		EncryptionAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidPBEWithSHA3DES, Parameters: asn1.RawValue{FullBytes: params}},
+		EncryptedData:       encrypted,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Like go-pkcs12 and OpenSSL, put the certificates and the key in two
+	// separate SafeContents, which is what some parsers expect.
+	var authSafeContents []asn1.RawValue
+	for _, bags := range [][]safeBag{certBags, {{Id: oidPKCS8ShroudedKeyBag, Value: explicitTag(keyBag), Attributes: attrs}}} {
+		safeContents, err := asn1.Marshal(bags)
+		if err != nil {
+			return nil, err
+		}
+		ci, err := p12DataContentInfo(safeContents)
+		if err != nil {
+			return nil, err
+		}
+		authSafeContents = append(authSafeContents, asn1.RawValue{FullBytes: ci})
+	}
+	authSafe, err := asn1.Marshal(authSafeContents)
+	if err != nil {
+		return nil, err
+	}
+	outer, err := p12DataContentInfo(authSafe)
+	if err != nil {
+		return nil, err
+	}
+
+	var macData pkcs12MacData
+	macData.MacSalt = make([]byte, 8)
+	if _, err := rand.Read(macData.MacSalt); err != nil {
+		return nil, err
+	}
+	macData.Iterations = pkcs12Iterations
+	mac := hmac.New(sha1.New, pkcs12KDF(bmpPassword, macData.MacSalt, 3, sha1.Size))
+	mac.Write(authSafe)
+	macData.Mac.Algorithm = pkix.AlgorithmIdentifier{Algorithm: oidSHA1, Parameters: asn1.NullRawValue}
+	macData.Mac.Digest = mac.Sum(nil)
+	return asn1.Marshal(struct {
+		Version  int
+		AuthSafe asn1.RawValue
+		MacData  pkcs12MacData
+	}{3, asn1.RawValue{FullBytes: outer}, macData})
+}
+
+// pkcs12Attributes returns the friendlyName and localKeyId bag attributes.
+func pkcs12Attributes(friendlyName string, localKeyID []byte) ([]pkcs7Attribute, error) {
+	nameDER, err := asn1.Marshal(asn1.RawValue{Tag: 30, Bytes: bmpString(friendlyName)}) // BMPString
+	if err != nil {
+		return nil, err
+	}
+	keyIDDER, err := asn1.Marshal(localKeyID)
+	if err != nil {
+		return nil, err
+	}
+	set := func(der []byte) asn1.RawValue {
+		return asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: der}
+	}
+	return []pkcs7Attribute{
+		{Type: oidFriendlyName, Values: set(nameDER)},
+		{Type: oidLocalKeyID, Values: set(keyIDDER)},
+	}, nil
+}
+
+// bmpString returns s encoded as UTF-16 big-endian.
+func bmpString(s string) []byte {
+	var b []byte
+	for _, c := range utf16.Encode([]rune(s)) {
+		b = append(b, byte(c>>8), byte(c))
+	}
+	return b
+}
+
+// pkcs12KDF derives size bytes of key material of the given type (1 for
+// encryption keys, 2 for IVs and 3 for MAC keys) with the SHA-1 based KDF of
+// RFC 7292, Appendix B.2.
+func pkcs12KDF(password, salt []byte, id byte, size int) []byte {
+	const v = 64
+	fill := func(b []byte) []byte {
+		if len(b) == 0 {
+			return nil
+		}
+		out := make([]byte, (len(b)+v-1)/v*v)
+		for i := range out {
+			out[i] = b[i%len(b)]
+		}
+		return out
+	}
+	D := bytes.Repeat([]byte{id}, v)
+	I := append(fill(salt), fill(password)...)
+
+	var out []byte
+	for len(out) < size {
+		h := sha1.Sum(append(D, I...))
+		A := h[:]
+		for i := 1; i < pkcs12Iterations; i++ {
+			h = sha1.Sum(A)
+			A = h[:]
+		}
+		out = append(out, A...)
+
+		// Ij = (Ij + B + 1) mod 2^(v*8), for each v-byte block of I.
+		B := new(big.Int).SetBytes(fill(A)[:v])
+		B.Add(B, big.NewInt(1))
+		for j := 0; j < len(I); j += v {
+			Ij := new(big.Int).SetBytes(I[j : j+v])
+			Ij.Add(Ij, B)
+			b := Ij.Bytes()
+			if len(b) > v {
+				b = b[len(b)-v:]
+			}
+			copy(I[j:j+v], make([]byte, v))
+			copy(I[j+v-len(b):j+v], b)
+		}
+	}
+	return out[:size]
+}
@@ -0,0 +1,227 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"math/big"
+	"sort"
+	"time"
+)
+
+var (
+	oidData          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidSignedData    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidContentType   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidMessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidSigningTime   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 5}
+	oidSHA256        = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidRSA           = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	oidECDSASHA256   = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+)
+
+type pkcs7ContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue // [0] EXPLICIT, see explicitTag
+}
+
+type pkcs7AlgorithmIdentifier struct {
+	Algorithm  asn1.ObjectIdentifier
+	Parameters asn1.RawValue `asn1:"optional"`
+}
+
+type pkcs7SignedData struct {
+	Version          int
+	DigestAlgorithms []pkcs7AlgorithmIdentifier `asn1:"set"`
+	ContentInfo      pkcs7ContentInfo
+	Certificates     asn1.RawValue     `asn1:"optional"`
+	SignerInfos      []pkcs7SignerInfo `asn1:"set"`
+}
+
+type pkcs7IssuerAndSerial struct {
+	Issuer asn1.RawValue
+	Serial *big.Int
+}
+
+type pkcs7SignerInfo struct {
+	Version                   int
+	IssuerAndSerialNumber     pkcs7IssuerAndSerial
+	DigestAlgorithm           pkcs7AlgorithmIdentifier
+	AuthenticatedAttributes   asn1.RawValue
+	DigestEncryptionAlgorithm pkcs7AlgorithmIdentifier
+	EncryptedDigest           []byte
+}
+
+type pkcs7Attribute struct {
+	Type   asn1.ObjectIdentifier
+	Values asn1.RawValue
+}
+
+// signPKCS7 returns a DER PKCS #7 SignedData structure embedding content,
+// signed by key with the certificate chain certs, starting with the signer.
+func signPKCS7(content []byte, certs []*x509.Certificate, key crypto.PrivateKey) ([]byte, error) {
+	signer, ok := key.(crypto.Signer)
+	if !ok || len(certs) == 0 {
+		return nil, errors.New("missing signing certificate or key")
+	}
+	sha256Alg := pkcs7AlgorithmIdentifier{Algorithm: oidSHA256, Parameters: asn1.NullRawValue}
+	var sigAlg pkcs7AlgorithmIdentifier
+	switch signer.Public().(type) {
+	case *rsa.PublicKey:
+		sigAlg = pkcs7AlgorithmIdentifier{Algorithm: oidRSA, Parameters: asn1.NullRawValue}
+	case *ecdsa.PublicKey:
+		sigAlg = pkcs7AlgorithmIdentifier{Algorithm: oidECDSASHA256}
+	default:
+		return nil, errors.New("only RSA and ECDSA keys can sign")
+	}
+
+	contentDER, err := asn1.Marshal(content)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256(content)
+	attrs, err := pkcs7Attributes(
+		[]asn1.ObjectIdentifier{oidContentType, oidMessageDigest, oidSigningTime},
+		[]interface{}{oidData, digest[:], time.Now().UTC()})
+	if err != nil {
+		return nil, err
+	}
+	// The signature covers the attributes encoded as a SET, while they are
+	// stored with the context-specific [0] tag.
+	attrsDigest := sha256.Sum256(append(asn1Header(0x31, len(attrs)), attrs...))
+	signature, err := signer.Sign(rand.Reader, attrsDigest[:], crypto.SHA256)
+	if err != nil {
+		return nil, err
+	}
+
+	var certsDER []byte
+	for _, c := range certs {
+		certsDER = append(certsDER, c.Raw...)
+	}
+	signedData := pkcs7SignedData{
+		Version:          1,
+		DigestAlgorithms: []pkcs7AlgorithmIdentifier{sha256Alg},
+		ContentInfo: pkcs7ContentInfo{
+			ContentType: oidData,
+			Content:     explicitTag(contentDER),
+		},
+		Certificates: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: certsDER},
+		SignerInfos: []pkcs7SignerInfo{{
+			Version: 1,
+			IssuerAndSerialNumber: pkcs7IssuerAndSerial{
+				Issuer: asn1.RawValue{FullBytes: certs[0].RawIssuer},
+				Serial: certs[0].SerialNumber,
+			},
+			DigestAlgorithm:           sha256Alg,
+			AuthenticatedAttributes:   asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: attrs},
+			DigestEncryptionAlgorithm: sigAlg,
+			EncryptedDigest:           signature,
+		}},
+	}
+	inner, err := asn1.Marshal(signedData)
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(pkcs7ContentInfo{
+		ContentType: oidSignedData,
+		Content:     explicitTag(inner),
+	})
+}
+
+// pkcs7Attributes returns the DER encoding of the contents of a SET OF
+// Attribute with a single value each, sorted as required by DER.
+func pkcs7Attributes(ids []asn1.ObjectIdentifier, values []interface{}) ([]byte, error) {
+	var encoded [][]byte
+	for i, id := range ids {
+		value, err := asn1.Marshal(values[i])
+		if err != nil {
+			return nil, err
+		}
+		attr, err := asn1.Marshal(pkcs7Attribute{
+			Type:   id,
+			Values: asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: value},
+		})
+		if err != nil {
+			return nil, err
+		}
+		encoded = append(encoded, attr)
+	}
+	sort.Slice(encoded, func(i, j int) bool { return bytes.Compare(encoded[i], encoded[j]) < 0 })
+	return bytes.Join(encoded, nil), nil
+}
+
+func explicitTag(der []byte) asn1.RawValue {
+	return asn1.RawValue{FullBytes: append(asn1Header(0xa0, len(der)), der...)}
+}
+
+// asn1Header returns a DER tag and length header.
+func asn1Header(tag byte, length int) []byte {
+	if length < 0x80 {
+		return []byte{tag, byte(length)}
+	}
+	var l []byte
+	for n := length; n > 0; n >>= 8 {
+		l = append([]byte{byte(n)}, l...)
+	}
+	return append([]byte{tag, 0x80 | byte(len(l))}, l...)
+}
+
+// certsOnlyPKCS7 returns a DER degenerate PKCS #7 SignedData structure with
+// no content and no signers, used as a container for certs (a .p7b file).
+func certsOnlyPKCS7(certs []*x509.Certificate) ([]byte, error) {
+	var certsDER []byte
+	for _, c := range certs {
+		certsDER = append(certsDER, c.Raw...)
+	}
+	inner, err := asn1.Marshal(struct {
+		Version          int
+		DigestAlgorithms []pkcs7AlgorithmIdentifier `asn1:"set"`
+		ContentInfo      struct{ ContentType asn1.ObjectIdentifier }
+		Certificates     asn1.RawValue
+		SignerInfos      []pkcs7SignerInfo `asn1:"set"`
+	}{
+		Version:      1,
+		ContentInfo:  struct{ ContentType asn1.ObjectIdentifier }{oidData},
+		Certificates: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: certsDER},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(pkcs7ContentInfo{
+		ContentType: oidSignedData,
+		Content:     explicitTag(inner),
+	})
+}
+
+// parsePKCS7Certificates returns the certificates in a DER PKCS #7
+// SignedData structure, like a .p7b file.
+func parsePKCS7Certificates(der []byte) ([]*x509.Certificate, error) {
+	var info pkcs7ContentInfo
+	if rest, err := asn1.Unmarshal(der, &info); err != nil {
+		return nil, err
+	} else if len(rest) > 0 || !info.ContentType.Equal(oidSignedData) {
+		return nil, errors.New("not a PKCS #7 SignedData structure")
+	}
+	var signedData struct {
+		Version          int
+		DigestAlgorithms asn1.RawValue
+		ContentInfo      asn1.RawValue
+		Certificates     asn1.RawValue `asn1:"optional,tag:0"`
+		CRLs             asn1.RawValue `asn1:"optional,tag:1"`
+		SignerInfos      asn1.RawValue
+	}
+	if _, err := asn1.Unmarshal(info.Content.Bytes, &signedData); err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificates(signedData.Certificates.Bytes)
+}
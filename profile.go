@@ -0,0 +1,119 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"os"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"strconv"
+)
+
+// dbProfile describes the files a database server expects for -profile.
+type dbProfile struct {
+	certName, keyName string // the same for a combined certificate and key
+	caName            string // the CA to verify client certificates
+	users             []string
+	// traditionalKey selects the PKCS #1 (or SEC 1) key format.
+	traditionalKey bool
+	config         string // printed with the certificate, key and CA paths
+}
+
+var dbProfiles = map[string]dbProfile{
+	"postgres": {
+		certName: "server.crt", keyName: "server.key", caName: "root.crt",
+		users:  []string{"postgres"},
+		config: "postgresql.conf:\n\n\tssl = on\n\tssl_cert_file = '%s'\n\tssl_key_file = '%s'\n\tssl_ca_file = '%s'\n",
+	},
+	// MySQL builds linked against yaSSL only read PKCS #1 RSA keys.
+	"mysql": {
+		certName: "server-cert.pem", keyName: "server-key.pem", caName: "ca.pem",
+		users: []string{"mysql"}, traditionalKey: true,
+		config: "my.cnf:\n\n\t[mysqld]\n\tssl_cert = %s\n\tssl_key = %s\n\tssl_ca = %s\n",
+	},
+	"mongodb": {
+		certName: "mongodb.pem", keyName: "mongodb.pem", caName: "ca.pem",
+		users:  []string{"mongodb", "mongod"},
+		config: "mongod.conf:\n\n\tnet:\n\t  tls:\n\t    mode: requireTLS\n\t    certificateKeyFile: %s\n\t    CAFile: %[3]s\n",
+	},
+}
+
+// profileFileNames returns the default certificate and key paths for the
+// -profile preset.
+func (m *mkcert) profileFileNames() (certFile, keyFile string) {
+	p := dbProfiles[m.profile]
+	return "./" + p.certName, "./" + p.keyName
+}
+
+// profileCAFile returns the path of the client CA file, next to certFile.
+func (m *mkcert) profileCAFile(certFile string) string {
+	return filepath.Join(filepath.Dir(certFile), dbProfiles[m.profile].caName)
+}
+
+// traditionalKeyPEM encodes an RSA key as PKCS #1 and an ECDSA one as SEC 1,
+// the formats that predate PKCS #8.
+func traditionalKeyPEM(priv interface{}) ([]byte, error) {
+	switch k := priv.(type) {
+	case *rsa.PrivateKey:
+		return pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(k)}), nil
+	case *ecdsa.PrivateKey:
+		der, err := x509.MarshalECPrivateKey(k)
+		if err != nil {
+			return nil, err
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), nil
+	}
+	return nil, fmt.Errorf("unsupported key type %T", priv)
+}
+
+// finishProfile writes the client CA file for the -profile preset, and
+// hands the files over to the database user if possible.
+func (m *mkcert) finishProfile(certFile, keyFile string) {
+	p := dbProfiles[m.profile]
+	caFile := m.profileCAFile(certFile)
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: m.caCert.Raw})
+	if m.interCert != nil {
+		caPEM = append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: m.interCert.Raw}), caPEM...)
+	}
+	fatalIfErr(writeOutput(caFile, caPEM, 0644), "failed to save the client CA file")
+
+	if runtime.GOOS == "windows" {
+		return
+	}
+	var u *user.User
+	for _, name := range p.users {
+		if found, err := user.Lookup(name); err == nil {
+			u = found
+			break
+		}
+	}
+	if u != nil && u.Uid == strconv.Itoa(os.Geteuid()) {
+		return
+	}
+	if u == nil || os.Geteuid() != 0 {
+		log.Printf("Note: the key must be owned by the %s user, and only readable by it ⚠️", p.users[0])
+		return
+	}
+	uid, _ := strconv.Atoi(u.Uid)
+	gid, _ := strconv.Atoi(u.Gid)
+	for _, path := range []string{certFile, keyFile, caFile} {
+		fatalIfErr(os.Chown(path, uid, gid), "failed to change the owner of "+path)
+	}
+	verbosef("Changed the owner of the files to %s", u.Username)
+}
+
+// printProfileConfig prints the database configuration using the files.
+func (m *mkcert) printProfileConfig(certFile, keyFile string) {
+	caFile := m.profileCAFile(certFile)
+	log.Printf("The CA for client certificates is at %q 🔐\n\n", caFile)
+	log.Printf("Use them in "+dbProfiles[m.profile].config+"\n", absPath(certFile), absPath(keyFile), absPath(caFile))
+}
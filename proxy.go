@@ -0,0 +1,121 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+)
+
+// proxyRoute forwards TLS connections for host to a plaintext backend.
+type proxyRoute struct {
+	host    string
+	backend *url.URL
+}
+
+// parseProxyRoutes parses a comma-separated list of FRONTEND=BACKEND pairs,
+// like "https://myapp.test=localhost:3000". The frontend scheme and port, if
+// any, are ignored, as all routes are served on the -listen address.
+func parseProxyRoutes(spec string) ([]proxyRoute, error) {
+	var routes []proxyRoute
+	seen := make(map[string]string) // sanKey to the frontend as given
+	for _, pair := range strings.Split(spec, ",") {
+		frontend, backend, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || frontend == "" || backend == "" {
+			return nil, fmt.Errorf("%q is not in the FRONTEND=BACKEND format", pair)
+		}
+
+		host := strings.TrimPrefix(strings.TrimPrefix(frontend, "https://"), "http://")
+		host = strings.TrimSuffix(host, "/")
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+		host, err := normalizeHost(host)
+		if err != nil {
+			return nil, err
+		}
+		if prev, ok := seen[sanKey(host)]; ok {
+			return nil, fmt.Errorf("%q and %q are the same frontend", prev, frontend)
+		}
+		seen[sanKey(host)] = frontend
+
+		if !strings.Contains(backend, "://") {
+			backend = "http://" + backend
+		}
+		backendURL, err := url.Parse(backend)
+		if err != nil || backendURL.Host == "" {
+			return nil, fmt.Errorf("%q is not a valid backend address", backend)
+		}
+
+		routes = append(routes, proxyRoute{host: host, backend: backendURL})
+	}
+	return routes, nil
+}
+
+// proxy terminates TLS with a temporary certificate for hosts, the route
+// hosts after validation, and forwards requests to the backend matching the
+// Host header.
+func (m *mkcert) proxy(hosts []string) {
+	backends := make(map[string]*httputil.ReverseProxy) // by sanKey
+	for _, route := range m.proxyRoutes {
+		rp := httputil.NewSingleHostReverseProxy(route.backend)
+		director := rp.Director
+		rp.Director = func(r *http.Request) {
+			director(r)
+			r.Header.Set("X-Forwarded-Proto", "https")
+			r.Header.Set("X-Forwarded-Host", r.Host)
+		}
+		backends[sanKey(route.host)] = rp
+	}
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+		if h, err := normalizeHost(host); err == nil {
+			host = h
+		}
+		rp, ok := backends[sanKey(host)]
+		if !ok && len(backends) == 1 {
+			for _, only := range backends {
+				rp, ok = only, true
+			}
+		}
+		if !ok {
+			http.Error(w, fmt.Sprintf("mkcert: no backend configured for %q", host), http.StatusBadGateway)
+			return
+		}
+		rp.ServeHTTP(w, r)
+	})
+
+	addr := m.listenAddr
+	if addr == "" {
+		addr = ":8443"
+	}
+	srv := &http.Server{
+		Addr:    addr,
+		Handler: handler,
+		TLSConfig: &tls.Config{
+			Certificates: []tls.Certificate{m.tlsCertificate(hosts)},
+		},
+	}
+
+	m.printHosts(hosts)
+	log.Printf("")
+	for _, route := range m.proxyRoutes {
+		log.Printf("Proxying %s to %s 🔀", serveURL(route.host, addr), route.backend)
+	}
+	log.Printf("")
+	fatalIfErr(srv.ListenAndServeTLS("", ""), "failed to run the HTTPS proxy")
+}
@@ -0,0 +1,73 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"log"
+	"net"
+	"os"
+	"strings"
+
+	"golang.org/x/net/publicsuffix"
+	"golang.org/x/term"
+)
+
+// reservedDomains are under public TLDs, but reserved for documentation and
+// local use by RFC 2606 and RFC 8375.
+var reservedDomains = []string{"example.com", "example.net", "example.org", "home.arpa"}
+
+// publicDomains returns the hostnames in hosts that are under a real public
+// TLD, like "example.dev", rather than one for development like ".test",
+// ".localhost" or ".internal", which aren't in the public suffix list.
+func publicDomains(hosts []string) []string {
+	var public []string
+	for _, h := range hosts {
+		if net.ParseIP(h) != nil || strings.Contains(h, "@") || strings.Contains(h, ":") {
+			continue
+		}
+		name := strings.ToLower(strings.TrimPrefix(h, "*."))
+		labels := strings.Split(name, ".")
+		if len(labels) < 2 || isReservedDomain(name) {
+			continue
+		}
+		tld := labels[len(labels)-1]
+		if _, icann := publicsuffix.PublicSuffix(tld); icann {
+			public = append(public, h)
+		}
+	}
+	return public
+}
+
+func isReservedDomain(name string) bool {
+	for _, d := range reservedDomains {
+		if name == d || strings.HasSuffix(name, "."+d) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPublicDomains warns about the hosts that are real public domains,
+// and unless -allow-public is set asks for confirmation, or fails if it
+// can't because stdin is not a terminal.
+func (m *mkcert) checkPublicDomains(hosts []string) {
+	public := publicDomains(hosts)
+	if len(public) == 0 || m.allowPublic {
+		return
+	}
+	for _, h := range public {
+		log.Printf("Warning: %q is a public domain, and the certificate will only be trusted where the local CA is installed ⚠️", h)
+	}
+	log.Printf("Use a name under .test, .localhost or .internal for development, or -allow-public if this is intended.\n\n")
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		log.Fatalln("ERROR: refusing to create a certificate for a public domain without -allow-public")
+	}
+	w := &wizard{in: bufio.NewReader(os.Stdin)}
+	if !w.confirm("Create the certificate anyway?", false) {
+		log.Fatalln("ERROR: nothing was created")
+	}
+	log.Println()
+}
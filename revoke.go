@@ -0,0 +1,155 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const crlName = "rootCA.crl"
+
+// revoke marks the certificate identified by m.revokeTarget, either a path
+// to a certificate file or a hex serial number, as revoked in the index.
+func (m *mkcert) revoke() {
+	serial, fromFile := m.revokeTarget, pathExists(m.revokeTarget)
+	if fromFile {
+		data, err := ioutil.ReadFile(m.revokeTarget)
+		fatalIfErr(err, "failed to read the certificate")
+		certs, err := parseCertificates(data)
+		fatalIfErr(err, "failed to parse the certificate")
+		if !m.issuedByLocalCA(certs[0]) {
+			log.Fatalf("ERROR: %q was not issued by the local CA", m.revokeTarget)
+		}
+		serial = serialString(certs[0])
+	} else {
+		// Accept any case, leading zeroes and colons, and normalize to the
+		// serialString format used in the index.
+		n, ok := new(big.Int).SetString(strings.Replace(serial, ":", "", -1), 16)
+		if !ok || n.Sign() < 0 {
+			log.Fatalf("ERROR: %q is neither a certificate file nor a hex serial number", m.revokeTarget)
+		}
+		serial = fmt.Sprintf("%X", n)
+	}
+
+	// A certificate file is checked above to be from the local CA, but a
+	// serial missing from the index is more likely a typo than a certificate
+	// issued before the index existed.
+	revokedAt, err := m.revokeSerial(serial, fromFile || m.force)
+	if err == errAlreadyRevoked {
+		log.Printf("The certificate with serial %s was already revoked on %s ℹ️", serial, revokedAt.Local().Format("2 January 2006"))
+		return
+	}
+	if err == errUnknownSerial {
+		log.Fatalf("ERROR: no certificate with serial %s is in the index, use -force to revoke it anyway", serial)
+	}
+	if err != nil {
+		exitf(exitCode, "ERROR: %s", err)
+	}
+
+	log.Printf("The certificate with serial %s is now revoked ⛔️", serial)
+	if !m.genCRL {
+		log.Printf("Run \"mkcert -gen-crl\" to publish an updated CRL 👈")
+	}
+}
+
+var (
+	errAlreadyRevoked = errors.New("the certificate was already revoked")
+	errUnknownSerial  = errors.New("the certificate is not in the index")
+)
+
+// revokeSerial marks the certificate with the given normalized hex serial as
+// revoked in the index, and returns when it was revoked. If it already was,
+// it returns the original time and errAlreadyRevoked. If it's not in the
+// index, it's added as revoked if allowUnknown is set, for certificates
+// issued before the index existed, and errUnknownSerial is returned otherwise.
+func (m *mkcert) revokeSerial(serial string, allowUnknown bool) (time.Time, error) {
+	now := time.Now().UTC().Truncate(time.Second)
+	unlock, err := m.acquireCAROOTLock()
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer unlock()
+	index, err := m.readIndex()
+	if err != nil {
+		return time.Time{}, err
+	}
+	found := false
+	for i := range index {
+		if index[i].Serial != serial {
+			continue
+		}
+		found = true
+		if index[i].RevokedAt != nil {
+			return *index[i].RevokedAt, errAlreadyRevoked
+		}
+		index[i].RevokedAt = &now
+	}
+	if !found {
+		if !allowUnknown {
+			return time.Time{}, errUnknownSerial
+		}
+		index = append(index, issuedCert{Serial: serial, RevokedAt: &now})
+	}
+	return now, m.writeIndex(index)
+}
+
+// generateCRL writes a CRL signed by the local CA listing all the revoked
+// certificates in the index.
+func (m *mkcert) generateCRL() {
+	if m.caKey == nil {
+		fatalCode(exitFailure, "ca_key_missing", "ERROR: can't sign a CRL because the CA key (rootCA-key.pem) is missing")
+	}
+	if m.caCert.KeyUsage&x509.KeyUsageCRLSign == 0 {
+		log.Fatalln("ERROR: the local CA was created by an older version of mkcert and is not allowed to sign CRLs")
+	}
+
+	var revoked []pkix.RevokedCertificate
+	for _, c := range m.loadIndex() {
+		if c.RevokedAt == nil {
+			continue
+		}
+		serial, ok := new(big.Int).SetString(c.Serial, 16)
+		if !ok {
+			log.Fatalf("ERROR: invalid serial number %q in the certificate index", c.Serial)
+		}
+		revoked = append(revoked, pkix.RevokedCertificate{
+			SerialNumber: serial, RevocationTime: *c.RevokedAt,
+		})
+	}
+
+	now := time.Now()
+	tpl := &x509.RevocationList{
+		// CRL numbers must increase monotonically, and seconds are good enough
+		// for a local CA that doesn't issue CRLs concurrently.
+		Number:              big.NewInt(now.Unix()),
+		ThisUpdate:          now,
+		NextUpdate:          now.AddDate(0, 0, 30),
+		RevokedCertificates: revoked,
+	}
+	tpl.SignatureAlgorithm = m.signatureAlgorithm(m.caKey)
+	crl, err := x509.CreateRevocationList(rand.Reader, tpl, m.caCert, m.caKey.(crypto.Signer))
+	fatalIfErr(err, "failed to generate CRL")
+
+	crlFile := filepath.Join(m.CAROOT, crlName)
+	verbosef("Writing %s", crlFile)
+	err = writeFileAtomic(crlFile, pem.EncodeToMemory(
+		&pem.Block{Type: "X509 CRL", Bytes: crl}), 0644)
+	fatalIfErr(err, "failed to save CRL")
+
+	log.Printf("The CRL listing %d revoked certificate(s) is at \"%s\" ✅", len(revoked), crlFile)
+	log.Printf("It must be updated before %s 🗓", tpl.NextUpdate.Format("2 January 2006"))
+}
@@ -0,0 +1,156 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const crossName = "rootCA-cross.pem"
+const retiredDir = "retired"
+
+// rotateCA replaces the local CA with a new one, moving the old certificate
+// and key to "retired/" in the CAROOT.
+//
+// With -cross-sign, the new root is also cross-signed by the old one, and the
+// cross certificate follows new certificates in their chain, so that clients
+// that only trust the old root keep accepting them during the transition.
+func (m *mkcert) rotateCA() {
+	defer m.lockCAROOT()()
+	if m.vaultPath != "" {
+		log.Fatalln("ERROR: -rotate-root is not supported with a Vault CAROOT")
+	}
+	if m.caKey == nil {
+		fatalCode(exitFailure, "ca_key_missing", "ERROR: can't rotate the local CA because the CA key (rootCA-key.pem) is missing")
+	}
+	oldCert, oldKey := m.caCert, m.caKey
+
+	dir := filepath.Join(m.CAROOT, retiredDir)
+	fatalIfErr(os.MkdirAll(dir, 0755), "failed to create the retired CA directory")
+	prefix := filepath.Join(dir, "rootCA-"+oldCert.SerialNumber.Text(16))
+	keyName, retiredKey := rootKeyName, prefix+"-key.pem"
+	if pathExists(filepath.Join(m.CAROOT, rootKeyURIName)) {
+		keyName, retiredKey = rootKeyURIName, prefix+"-key.uri"
+	}
+	verbosef("Moving the old CA to %s.pem and %s", prefix, retiredKey)
+	fatalIfErr(os.Rename(filepath.Join(m.CAROOT, keyName), retiredKey), "failed to retire the CA key")
+	fatalIfErr(os.Rename(filepath.Join(m.CAROOT, rootName), prefix+".pem"), "failed to retire the CA certificate")
+	if err := os.Remove(filepath.Join(m.CAROOT, crossName)); err != nil && !os.IsNotExist(err) {
+		fatalIfErr(err, "failed to remove the old cross-signed certificate")
+	}
+	m.crossCert = nil
+
+	m.newCA()
+	m.loadCA()
+	log.Printf("The old local CA was moved to \"%s.pem\" 🗄", prefix)
+
+	if pathExists(filepath.Join(m.CAROOT, interName)) || pathExists(filepath.Join(m.CAROOT, interDir)) {
+		log.Printf("Note: the intermediate CAs were issued by the old local CA, and need to be recreated with -inter ⚠️")
+	}
+
+	if !m.installMode {
+		logCode("install_hint", "Run \"mkcert -install\" to trust the new local CA ⚠️")
+	}
+	if !m.crossSign {
+		log.Printf("Certificates issued by the old local CA will stop working where it's uninstalled.\n\n")
+		return
+	}
+
+	tpl := *m.caCert
+	tpl.SerialNumber = randomSerialNumber()
+	// The old and new roots usually have the same subject, so Go wouldn't
+	// add an Authority Key Identifier on its own, but path building needs it.
+	tpl.AuthorityKeyId = oldCert.SubjectKeyId
+	if tpl.NotAfter.After(oldCert.NotAfter) {
+		tpl.NotAfter = oldCert.NotAfter
+	}
+	tpl.SignatureAlgorithm = m.signatureAlgorithm(oldKey)
+	cross, err := x509.CreateCertificate(rand.Reader, &tpl, oldCert, m.caCert.PublicKey, oldKey)
+	fatalIfErr(err, "failed to cross-sign the new CA certificate")
+	crossFile := filepath.Join(m.CAROOT, crossName)
+	verbosef("Writing %s", crossFile)
+	err = writeFileAtomic(crossFile, pem.EncodeToMemory(
+		&pem.Block{Type: "CERTIFICATE", Bytes: cross}), 0644)
+	fatalIfErr(err, "failed to save the cross-signed certificate")
+	m.crossCert, err = x509.ParseCertificate(cross)
+	fatalIfErr(err, "failed to parse the cross-signed certificate")
+	m.logIssuance("cross-sign", m.crossCert)
+
+	log.Printf("The new local CA was cross-signed by the old one at \"%s\" 🔗", crossFile)
+	log.Printf("New certificates include it in their chain, so they are also accepted where only the old CA is trusted.")
+	log.Printf("Once the new CA is trusted everywhere, delete it to stop sending it ✨\n\n")
+}
+
+// loadCrossCert loads the cross-signed certificate for the local CA, if there
+// is one matching it in the CAROOT.
+func (m *mkcert) loadCrossCert() {
+	data, err := ioutil.ReadFile(filepath.Join(m.CAROOT, crossName))
+	if os.IsNotExist(err) {
+		return
+	}
+	fatalIfErr(err, "failed to read the cross-signed certificate")
+	cert, _, err := parseCertAndKey(data)
+	fatalIfErr(err, "failed to parse the cross-signed certificate")
+	if cert == nil || !bytes.Equal(cert.RawSubjectPublicKeyInfo, m.caCert.RawSubjectPublicKeyInfo) {
+		log.Printf("Warning: ignoring %q, which is not a cross-signed certificate for the local CA ⚠️", crossName)
+		return
+	}
+	m.crossCert = cert
+}
+
+// renewCA replaces the local CA certificate with a new one for the same key,
+// subject and extensions, valid for another ten years. Unlike rotateCA, the
+// certificates it already issued keep working, and only the new CA
+// certificate needs to be installed.
+func (m *mkcert) renewCA() {
+	defer m.lockCAROOT()()
+	if m.vaultPath != "" {
+		log.Fatalln("ERROR: -renew-root is not supported with a Vault CAROOT")
+	}
+	if m.caKey == nil {
+		fatalCode(exitFailure, "ca_key_missing", "ERROR: can't renew the local CA because the CA key (rootCA-key.pem) is missing")
+	}
+	oldCert := m.caCert
+
+	tpl := *oldCert
+	tpl.SerialNumber = randomSerialNumber()
+	tpl.NotBefore, tpl.NotAfter = time.Now(), time.Now().AddDate(10, 0, 0)
+	// Copy all the extensions verbatim, including any -root-ext ones.
+	tpl.ExtraExtensions = oldCert.Extensions
+	if m.sigAlg != "" {
+		tpl.SignatureAlgorithm = m.signatureAlgorithm(m.caKey)
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, &tpl, &tpl, oldCert.PublicKey, m.caKey)
+	fatalIfErr(err, "failed to generate CA certificate")
+
+	dir := filepath.Join(m.CAROOT, retiredDir)
+	fatalIfErr(os.MkdirAll(dir, 0755), "failed to create the retired CA directory")
+	oldFile := filepath.Join(dir, "rootCA-"+oldCert.SerialNumber.Text(16)+".pem")
+	verbosef("Writing %s", oldFile)
+	err = writeFileAtomic(oldFile, pem.EncodeToMemory(
+		&pem.Block{Type: "CERTIFICATE", Bytes: oldCert.Raw}), 0644)
+	fatalIfErr(err, "failed to save the old CA certificate")
+	verbosef("Writing %s", filepath.Join(m.CAROOT, rootName))
+	err = writeFileAtomic(filepath.Join(m.CAROOT, rootName), pem.EncodeToMemory(
+		&pem.Block{Type: "CERTIFICATE", Bytes: cert}), 0644)
+	fatalIfErr(err, "failed to save CA certificate")
+	m.caCert, err = x509.ParseCertificate(cert)
+	fatalIfErr(err, "failed to parse the CA certificate")
+	m.logIssuance("renew-root", m.caCert)
+
+	log.Printf("Renewed the local CA, which now expires on %s 💥", m.caCert.NotAfter.Format("2 January 2006"))
+	log.Printf("The previous certificate was saved to \"%s\", existing certificates stay valid.", oldFile)
+	if !m.installMode {
+		logCode("install_hint", "Run \"mkcert -install\" to trust the renewed local CA ⚠️\n\n")
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"time"
+)
+
+// tlsCertificate issues a certificate for hosts that is only kept in memory,
+// for the servers started by mkcert itself. It's not recorded in the index.
+func (m *mkcert) tlsCertificate(hosts []string) tls.Certificate {
+	issuerCert, issuerKey := m.issuer()
+	if issuerKey == nil {
+		fatalCode(exitFailure, "ca_key_missing", "ERROR: can't create new certificates because the CA key (rootCA-key.pem) is missing")
+	}
+
+	priv, err := m.generateKey(false)
+	fatalIfErr(err, "failed to generate certificate key")
+
+	tpl := m.leafTemplate(hosts, time.Now().AddDate(0, 0, 7))
+	tpl.SignatureAlgorithm = m.signatureAlgorithm(issuerKey)
+	m.applyStrictProfile(tpl, priv.(crypto.Signer).Public(), issuerCert)
+	cert, err := x509.CreateCertificate(rand.Reader, tpl, issuerCert, priv.(crypto.Signer).Public(), issuerKey)
+	fatalIfErr(err, "failed to generate certificate")
+	leaf, err := x509.ParseCertificate(cert)
+	fatalIfErr(err, "failed to parse generated certificate")
+	m.logIssuance("serve", leaf)
+
+	chain := [][]byte{cert}
+	for _, c := range append(m.chainCerts(), m.caCert) {
+		chain = append(chain, c.Raw)
+	}
+	return tls.Certificate{
+		Certificate: chain,
+		PrivateKey:  priv,
+		Leaf:        leaf,
+	}
+}
+
+// serve runs an HTTPS server for hosts, serving m.serveDir if set, or
+// otherwise a page describing the TLS connection.
+func (m *mkcert) serve(hosts []string) {
+	cert := m.tlsCertificate(hosts)
+
+	var handler http.Handler = http.HandlerFunc(echoHandler)
+	if m.serveDir != "" {
+		handler = http.FileServer(http.Dir(m.serveDir))
+	}
+
+	addr := m.listenAddr
+	if addr == "" {
+		addr = ":8443"
+	}
+	srv := &http.Server{
+		Addr:    addr,
+		Handler: handler,
+		TLSConfig: &tls.Config{
+			Certificates: []tls.Certificate{cert},
+		},
+	}
+
+	m.printHosts(hosts)
+	log.Printf("\nServing HTTPS on %s, try %s 🌐\n\n", addr, serveURL(hosts[0], addr))
+	fatalIfErr(srv.ListenAndServeTLS("", ""), "failed to run the HTTPS server")
+}
+
+func serveURL(host, addr string) string {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		port = "8443"
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.To4() == nil {
+		host = "[" + host + "]"
+	}
+	if port == "443" {
+		return "https://" + host + "/"
+	}
+	return "https://" + host + ":" + port + "/"
+}
+
+func echoHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintf(w, "Hello from mkcert! If your browser didn't complain, the local CA is trusted 🎉\n\n")
+	fmt.Fprintf(w, "Host:         %s\n", r.Host)
+	fmt.Fprintf(w, "Remote:       %s\n", r.RemoteAddr)
+	if r.TLS != nil {
+		fmt.Fprintf(w, "Server name:  %s\n", r.TLS.ServerName)
+		fmt.Fprintf(w, "TLS version:  %s\n", tlsVersionName(r.TLS.Version))
+		fmt.Fprintf(w, "Cipher suite: %s\n", tls.CipherSuiteName(r.TLS.CipherSuite))
+		fmt.Fprintf(w, "Protocol:     %s\n", r.Proto)
+	}
+}
+
+func tlsVersionName(v uint16) string {
+	switch v {
+	case tls.VersionTLS10:
+		return "TLS 1.0"
+	case tls.VersionTLS11:
+		return "TLS 1.1"
+	case tls.VersionTLS12:
+		return "TLS 1.2"
+	case tls.VersionTLS13:
+		return "TLS 1.3"
+	default:
+		return fmt.Sprintf("0x%04x", v)
+	}
+}
@@ -0,0 +1,149 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/pem"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"rsc.io/qr"
+)
+
+// serveCA runs a plain HTTP server that lets devices on the local network
+// download the local CA, and prints a QR code pointing to it.
+func (m *mkcert) serveCA() {
+	addr := m.listenAddr
+	if addr == "" {
+		addr = ":8088"
+	}
+	_, port, err := net.SplitHostPort(addr)
+	fatalIfErr(err, "invalid -listen address")
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: m.caCert.Raw})
+	files := map[string]struct {
+		contentType string
+		data        []byte
+	}{
+		"/rootCA.pem":          {"application/x-pem-file", certPEM},
+		"/rootCA.crt":          {"application/x-x509-ca-cert", m.caCert.Raw},
+		"/rootCA.mobileconfig": {"application/x-apple-aspen-config", m.mobileConfig()},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/"+crlName {
+			// Read the CRL on each request, to pick up new -gen-crl runs.
+			crl, err := ioutil.ReadFile(filepath.Join(m.CAROOT, crlName))
+			if err != nil {
+				http.NotFound(w, r)
+				return
+			}
+			if block, _ := pem.Decode(crl); block != nil {
+				crl = block.Bytes
+			}
+			log.Printf("Sending %s to %s", crlName, r.RemoteAddr)
+			w.Header().Set("Content-Type", "application/pkix-crl")
+			w.Write(crl)
+			return
+		}
+		if f, ok := files[r.URL.Path]; ok {
+			log.Printf("Sending %s to %s", strings.TrimPrefix(r.URL.Path, "/"), r.RemoteAddr)
+			w.Header().Set("Content-Type", f.contentType)
+			w.Write(f.data)
+			return
+		}
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprintf(w, caPageTemplate, html.EscapeString(m.caCert.Subject.CommonName))
+	})
+
+	var urls []string
+	for _, ip := range localIPs() {
+		urls = append(urls, "http://"+net.JoinHostPort(ip, port)+"/")
+	}
+	if len(urls) == 0 {
+		urls = []string{"http://localhost:" + port + "/"}
+	}
+
+	log.Printf("Serving the local CA on %s 📲", addr)
+	log.Printf("Open one of these on the device to install it:\n")
+	for _, u := range urls {
+		log.Printf("\t%s", u)
+	}
+	if code, err := qr.Encode(urls[0], qr.M); err == nil {
+		log.Printf("\nOr scan this QR code for %s\n\n%s", urls[0], terminalQR(code))
+	}
+	log.Printf("Only share it on a network you trust, and stop the server when done ⚠️\n\n")
+	fatalIfErr(http.ListenAndServe(addr, mux), "failed to run the HTTP server")
+}
+
+const caPageTemplate = `<!DOCTYPE html>
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>mkcert local CA</title>
+<h1>%s</h1>
+<ul>
+<li><a href="/rootCA.mobileconfig">iOS and macOS profile</a> (then enable it in Settings › General › About › Certificate Trust Settings)
+<li><a href="/rootCA.crt">Android and Windows certificate</a> (DER)
+<li><a href="/rootCA.pem">PEM certificate</a>
+</ul>
+`
+
+// localIPs returns the non-loopback IPv4 addresses of the machine, which
+// are the ones other devices on the LAN can most likely reach.
+func localIPs() []string {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil
+	}
+	var ips []string
+	for _, a := range addrs {
+		ipNet, ok := a.(*net.IPNet)
+		if !ok || ipNet.IP.IsLoopback() || ipNet.IP.To4() == nil || ipNet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		ips = append(ips, ipNet.IP.String())
+	}
+	return ips
+}
+
+// terminalQR renders code with half block characters, two modules per line,
+// with explicit colors so that it scans on both light and dark terminals.
+func terminalQR(code *qr.Code) string {
+	const quiet = 2
+	light := func(x, y int) bool {
+		if x < 0 || y < 0 || x >= code.Size || y >= code.Size {
+			return true
+		}
+		return !code.Black(x, y)
+	}
+	var b strings.Builder
+	for y := -quiet; y < code.Size+quiet; y += 2 {
+		b.WriteString("\t\x1b[97;40m")
+		for x := -quiet; x < code.Size+quiet; x++ {
+			switch top, bottom := light(x, y), light(x, y+1); {
+			case top && bottom:
+				b.WriteString("█")
+			case top:
+				b.WriteString("▀")
+			case bottom:
+				b.WriteString("▄")
+			default:
+				b.WriteString(" ")
+			}
+		}
+		b.WriteString("\x1b[0m\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,157 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"io/ioutil"
+	"log"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const sshCAName = "sshCA.pub"
+const sshCAKeyName = "sshCA-key.pem"
+
+// loadSSHCA returns the SSH CA signer, creating a new Ed25519 key in the
+// CAROOT if there isn't one yet. The SSH CA is separate from the X.509 one,
+// as OpenSSH can't use X.509 certificates.
+func (m *mkcert) loadSSHCA() ssh.Signer {
+	keyFile := filepath.Join(m.CAROOT, sshCAKeyName)
+	if !pathExists(keyFile) {
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		fatalIfErr(err, "failed to generate the SSH CA key")
+		privDER, err := x509.MarshalPKCS8PrivateKey(priv)
+		fatalIfErr(err, "failed to encode the SSH CA key")
+		signer, err := ssh.NewSignerFromKey(priv)
+		fatalIfErr(err, "failed to load the SSH CA key")
+
+		pubFile := filepath.Join(m.CAROOT, sshCAName)
+		verbosef("Writing %s and %s", keyFile, pubFile)
+		err = writeFileAtomic(keyFile, pem.EncodeToMemory(
+			&pem.Block{Type: "PRIVATE KEY", Bytes: privDER}), 0400)
+		fatalIfErr(err, "failed to save the SSH CA key")
+		err = writeFileAtomic(pubFile, sshAuthorizedKey(signer.PublicKey(), "mkcert SSH CA "+userAndHostname), 0644)
+		fatalIfErr(err, "failed to save the SSH CA public key")
+
+		log.Printf("Created a new local SSH CA 💥\n")
+		return signer
+	}
+
+	keyPEM, err := ioutil.ReadFile(keyFile)
+	fatalIfErr(err, "failed to read the SSH CA key")
+	signer, err := ssh.ParsePrivateKey(keyPEM)
+	fatalIfErr(err, "failed to parse the SSH CA key")
+	return signer
+}
+
+// signSSHKeys issues an SSH certificate for each public key file in paths,
+// saved next to it as "NAME-cert.pub", like ssh-keygen does.
+func (m *mkcert) signSSHKeys(paths []string) {
+	ca := m.loadSSHCA()
+	caFile := filepath.Join(m.CAROOT, sshCAName)
+
+	if len(paths) == 0 {
+		log.Printf("The local SSH CA public key is at \"%s\" ✨\n\n", caFile)
+		m.printSSHTrustHelp(ca, caFile)
+		return
+	}
+
+	principals := m.sshPrincipals
+	if len(principals) == 0 {
+		if m.sshHost {
+			log.Fatalln("ERROR: host certificates need the host names to be set with -ssh-principals")
+		}
+		u, err := user.Current()
+		fatalIfErr(err, "failed to get the current user, set the principals with -ssh-principals")
+		principals = []string{u.Username}
+	}
+	if len(paths) > 1 && m.certFile != "" {
+		log.Fatalln("ERROR: can't set -cert-file when signing more than one SSH key")
+	}
+
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		fatalIfErr(err, "failed to read the SSH public key")
+		pub, comment, _, _, err := ssh.ParseAuthorizedKey(data)
+		fatalIfErr(err, "failed to parse the SSH public key")
+		if _, ok := pub.(*ssh.Certificate); ok {
+			log.Fatalf("ERROR: %q is already an SSH certificate, pass the public key instead", path)
+		}
+
+		var serial [8]byte
+		_, err = rand.Read(serial[:])
+		fatalIfErr(err, "failed to generate serial number")
+
+		now := time.Now()
+		cert := &ssh.Certificate{
+			Key:             pub,
+			Serial:          binary.BigEndian.Uint64(serial[:]),
+			CertType:        ssh.UserCert,
+			KeyId:           strings.Join(principals, ",") + " - mkcert " + userAndHostname,
+			ValidPrincipals: principals,
+			// Allow for some clock skew between the client and the server.
+			ValidAfter:  uint64(now.Add(-5 * time.Minute).Unix()),
+			ValidBefore: uint64(now.Add(m.sshValidity).Unix()),
+		}
+		if m.sshHost {
+			cert.CertType = ssh.HostCert
+		} else {
+			// The same default extensions as ssh-keygen.
+			cert.Permissions.Extensions = map[string]string{
+				"permit-X11-forwarding":   "",
+				"permit-agent-forwarding": "",
+				"permit-port-forwarding":  "",
+				"permit-pty":              "",
+				"permit-user-rc":          "",
+			}
+		}
+		fatalIfErr(cert.SignCert(rand.Reader, ca), "failed to sign the SSH certificate")
+		m.appendIssuanceLog(issuanceLogEntry{Kind: "ssh", Serial: strconv.FormatUint(cert.Serial, 10),
+			Subject: cert.KeyId, Names: principals})
+
+		certFile := m.certFile
+		if certFile == "" {
+			certFile = strings.TrimSuffix(path, ".pub") + "-cert.pub"
+		}
+		err = writeOutput(certFile, sshAuthorizedKey(cert, comment), 0644)
+		fatalIfErr(err, "failed to save the SSH certificate")
+
+		kind := "user"
+		if m.sshHost {
+			kind = "host"
+		}
+		log.Printf("\nCreated a new SSH %s certificate valid for %q 📜", kind, principals)
+		logCode("cert_saved", "The certificate is at %s and it will expire on %s 🗓\n\n",
+			outputName(certFile), now.Add(m.sshValidity).Format("2 January 2006 15:04"))
+	}
+
+	m.printSSHTrustHelp(ca, caFile)
+}
+
+func (m *mkcert) printSSHTrustHelp(ca ssh.Signer, caFile string) {
+	if m.sshHost {
+		log.Printf("To trust it, add this line to the clients' ~/.ssh/known_hosts:\n\n\t@cert-authority * %s", sshAuthorizedKey(ca.PublicKey(), ""))
+		return
+	}
+	log.Printf("To trust it, add this line to the server's sshd_config:\n\n\tTrustedUserCAKeys %s\n\n", caFile)
+}
+
+func sshAuthorizedKey(pub ssh.PublicKey, comment string) []byte {
+	line := strings.TrimSuffix(string(ssh.MarshalAuthorizedKey(pub)), "\n")
+	if comment != "" {
+		line += " " + comment
+	}
+	return []byte(line + "\n")
+}
@@ -0,0 +1,126 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+)
+
+// trustStatus is a detected trust store, and whether the local CA is
+// installed in it. Installed is nil if that can't be checked.
+type trustStatus struct {
+	Store     string `json:"store"`
+	Location  string `json:"location,omitempty"`
+	Installed *bool  `json:"installed"`
+	Note      string `json:"note,omitempty"`
+}
+
+// trustStatuses checks every detected trust store, including each NSS
+// profile, Java installation and device separately. Like -install, it only
+// considers the stores selected by -stores or TRUST_STORES.
+func (m *mkcert) trustStatuses() []trustStatus {
+	var statuses []trustStatus
+	add := func(store, location string, installed bool) {
+		statuses = append(statuses, trustStatus{Store: store, Location: location, Installed: &installed})
+	}
+	unknown := func(store, location, note string) {
+		statuses = append(statuses, trustStatus{Store: store, Location: location, Note: note})
+	}
+
+	if storeEnabled("system") {
+		add("system", "", m.checkPlatform())
+	}
+	if storeEnabled("nss") && hasNSS {
+		m.forEachNSSProfile(func(profile string) {
+			dir := strings.TrimPrefix(strings.TrimPrefix(profile, "sql:"), "dbm:")
+			if hasCertutil {
+				add("nss", dir, m.checkNSSProfile(profile))
+			} else {
+				unknown("nss", dir, `"certutil" is not available`)
+			}
+		})
+	}
+	if storeEnabled("chrome") && hasChromePolicy {
+		for _, target := range chromePolicyTargets() {
+			location := target
+			if !strings.HasPrefix(target, `HKCU\`) {
+				location = filepath.Join(target, chromePolicyName)
+			}
+			_, ok := readChromeCertificates(target)[base64.StdEncoding.EncodeToString(m.caCert.Raw)]
+			add("chrome", location, ok)
+		}
+	}
+	if storeEnabled("java") && hasJava {
+		if canEditJava() {
+			add("java", cacertsPath, m.checkJava())
+		} else {
+			unknown("java", cacertsPath, `"keytool" is not available`)
+		}
+	}
+	if storeEnabled("wsl") && hasWSL {
+		add("wsl", `CurrentUser\Root`, m.checkWSL())
+	}
+	if storeEnabled("android") && hasADB {
+		for _, serial := range androidDevices() {
+			add("android", serial, m.checkAndroid(serial))
+		}
+	}
+	if storeEnabled("simulator") && hasSimctl {
+		for _, sim := range bootedSimulators() {
+			unknown("simulator", sim.Name, "simctl can't list the trusted roots")
+		}
+	}
+	if storeEnabled("kind") && hasKind {
+		for _, node := range kindNodes() {
+			add("kind", node, m.checkKind(node))
+		}
+	}
+	if storeEnabled("minikube") && hasMinikube {
+		if dir := minikubeCertsDir(); dir != "" {
+			path := filepath.Join(dir, m.clusterCertName())
+			add("minikube", path, pathExists(path))
+		}
+	}
+	return statuses
+}
+
+// printTrustStatus prints the trust stores as a table, or as JSON.
+func (m *mkcert) printTrustStatus() {
+	statuses := m.trustStatuses()
+	if m.jsonOutput {
+		out, err := json.MarshalIndent(statuses, "", "  ")
+		fatalIfErr(err, "failed to encode JSON")
+		fmt.Println(string(out))
+		return
+	}
+
+	fmt.Printf("Local CA %q (%s)\n\n", m.caCert.Subject.CommonName, filepath.Join(m.CAROOT, rootName))
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "STORE\tLOCATION\tSTATUS")
+	for _, s := range statuses {
+		status := "not installed" + emoji(" ❌", "")
+		switch {
+		case s.Installed == nil:
+			status = "unknown (" + s.Note + ")"
+		case *s.Installed:
+			status = "installed" + emoji(" ✅", "")
+		}
+		location := s.Location
+		if location == "" {
+			location = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", s.Store, location, status)
+	}
+	w.Flush()
+	if len(statuses) == 0 {
+		fmt.Println("\nNo trust stores were detected.")
+	}
+}
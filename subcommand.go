@@ -0,0 +1,477 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// Subcommands like "mkcert install" or "mkcert ca rotate" are translated to
+// the equivalent flags, like -install or -rotate-root, which keep working on
+// their own. Each subcommand only accepts the options that apply to it, and
+// unlike the legacy interface, they can also follow the arguments.
+//
+// The subcommands are also the table of the operations of the legacy
+// interface: checkModes uses it to reject the flags that can't be combined.
+
+// subcommand is "mkcert NAME", an alias for flag.
+type subcommand struct {
+	name    string
+	args    string // the synopsis of the arguments
+	summary string
+	flag    string // the flag it stands for, if any
+	// value is set if the first argument is the value of flag.
+	value   bool
+	options [][]string
+	// with lists the other operation flags it can be combined with.
+	with []string
+	subs []*subcommand
+}
+
+var (
+	commonOptions = []string{"ca", "quiet", "verbose", "log-format", "no-color", "no-emoji", "help"}
+	// newCAOptions apply when a new local CA is created.
+	newCAOptions = []string{"root-constrain", "root-ext", "root-policy", "sig-alg", "ca-key",
+		"yubikey", "pin-policy", "touch-policy", "tpm", "keyring"}
+	certOptions = []string{"cert-file", "key-file", "p12-file", "name-template", "force",
+		"key-pass", "reuse-key", "key-in", "not-before", "valid-until", "validity", "sig-alg", "strict",
+		"stdout", "stdout-key", "json", "pkcs12", "p7b", "archive", "caddy", "traefik", "profile",
+		"p12-chain", "p12-name", "ecdsa", "client", "cn", "org", "ou", "country", "eku", "key-usage",
+		"ext", "policy", "upn", "must-staple", "ocsp-url", "crl-url",
+		"issuer-url", "use-inter", "kubernetes", "kubectl-apply", "namespace", "secret-name", "no-sudo",
+		"allow-public", "short-names"}
+	// serverCertOptions apply to the certificates that are used by mkcert
+	// instead of being saved.
+	serverCertOptions = except(certOptions, "stdout", "json", "pkcs12", "p7b", "archive", "caddy",
+		"traefik", "profile", "upn", "kubernetes")
+	trustOptions = []string{"user", "stores", "no-sudo"}
+)
+
+var subcommands = []*subcommand{
+	{name: "install", summary: "Install the local CA in the system trust store, creating it if needed.",
+		flag: "-install", options: [][]string{trustOptions, newCAOptions, {"ios-profile", "ios-sign"}}},
+	{name: "uninstall", summary: "Uninstall the local CA (but do not delete it).",
+		flag: "-uninstall", options: [][]string{trustOptions}},
+	{name: "trust", summary: "List the trust stores and whether the local CA is installed in them.",
+		flag: "-trust-status", options: [][]string{{"stores", "json"}}},
+	{name: "cert", args: "HOST...", summary: "Generate a certificate for the given names.",
+		options: [][]string{certOptions, newCAOptions, {"from-cert", "hosts-file", "batch", "compose"}}},
+	{name: "sign", args: "CSR...", summary: "Generate certificates for Certificate Signing Requests.",
+		flag: "-csr", value: true, with: []string{"-install"}, options: [][]string{
+			except(certOptions, "key-pass", "reuse-key", "key-in", "pkcs12", "archive", "caddy", "traefik",
+				"profile", "ecdsa", "client", "upn", "kubernetes"),
+			newCAOptions, {"add-san", "drop-sans"}}},
+	{name: "csr", args: "HOST...", summary: "Generate a key and a Certificate Signing Request for the names.",
+		flag: "-gen-csr", options: [][]string{
+			except(certOptions, "reuse-key", "key-in", "json", "pkcs12", "p12-file", "p7b", "archive",
+				"caddy", "traefik", "profile", "upn", "kubernetes"),
+			{"hosts-file"}}},
+	{name: "inspect", args: "FILE", summary: "Describe the certificates, keys or CSRs in FILE.",
+		flag: "-inspect", value: true, options: [][]string{{"json"}}},
+	{name: "list", summary: "List the certificates issued by the local CA.",
+		flag: "-list", options: [][]string{{"json"}}},
+	{name: "log", summary: "Show the log of everything the local CA signed.",
+		flag: "-log", options: [][]string{{"json"}}},
+	{name: "status", args: "[DIR...]", summary: "Show the expiration of the issued certificates.",
+		flag: "-status", options: [][]string{{"renew-days", "json", "use-inter"}}},
+	{name: "reissue", args: "[FILE...]", summary: "Reissue the certificates issued by the local CA.",
+		flag: "-reissue-all", options: [][]string{{"hook", "use-inter", "key-pass"}}},
+	{name: "watch", args: "[FILE...]", summary: "Keep renewing the certificates before they expire.",
+		flag: "-watch", options: [][]string{{"renew-days", "hook"}, except(certOptions, "kubernetes")}},
+	{name: "check", args: "CERT [KEY]", summary: "Check that a certificate and key are valid and match.",
+		flag: "-check", value: true, options: [][]string{{"check-name", "use-inter"}}},
+	{name: "verify", args: "HOST[:PORT]", summary: "Check the certificate served by HOST against the local CA.",
+		flag: "-verify", value: true},
+	{name: "revoke", args: "SERIAL|FILE", summary: "Revoke a certificate and update the CRL.",
+		flag: "-revoke", value: true, with: []string{"-gen-crl"}, options: [][]string{{"force", "sig-alg"}}},
+	{name: "serve", args: "[HOST...]", summary: "Serve a directory over HTTPS with a new certificate.",
+		flag: "-serve", with: []string{"-install"}, options: [][]string{{"serve-dir", "listen", "hosts-file"}, serverCertOptions}},
+	{name: "proxy", args: "FRONTEND=BACKEND[,...]", summary: "Run a TLS terminating reverse proxy.",
+		flag: "-proxy", value: true, with: []string{"-install"}, options: [][]string{{"listen"}, serverCertOptions}},
+	{name: "acme", summary: "Run an ACME server that issues certificates from the local CA.",
+		flag: "-acme", options: [][]string{{"listen", "acme-allow"}, serverCertOptions}},
+	{name: "ocsp", summary: "Run an OCSP responder for the local CA.",
+		flag: "-ocsp", options: [][]string{{"listen", "ocsp-delegate", "sig-alg"}}},
+	{name: "ssh", args: "[KEY.pub...]", summary: "Sign SSH public keys with the SSH CA.",
+		flag: "-ssh", options: [][]string{{"ssh-host", "ssh-principals", "ssh-validity", "cert-file", "stdout"}}},
+	{name: "env", args: "[FILE]", summary: "Print the variables that make runtimes trust the local CA.",
+		flag: "-env", options: [][]string{{"json"}}},
+	{name: "wizard", summary: "Generate a certificate by answering questions.",
+		flag: "-wizard", options: [][]string{{"stores", "force", "no-sudo", "allow-public"}}},
+	{name: "ca", summary: "Manage the local CA.", subs: []*subcommand{
+		{name: "path", summary: "Print the CA certificate and key storage location.", flag: "-CAROOT"},
+		{name: "rotate", summary: "Replace the local CA with a new one.",
+			flag: "-rotate-root", with: []string{"-install"},
+			options: [][]string{{"cross-sign"}, except(newCAOptions, "yubikey", "pin-policy", "touch-policy")}},
+		{name: "renew", summary: "Renew the local CA certificate for the same key.",
+			flag: "-renew-root", with: []string{"-install"}, options: [][]string{{"sig-alg"}}},
+		{name: "export", args: "FILE", summary: "Save the local CA certificate to FILE.",
+			flag: "-export-ca", value: true, options: [][]string{{"export-format", "key-pass"}}},
+		{name: "adopt", args: "CERT [KEY]", summary: "Import an existing CA as the local CA.",
+			flag: "-adopt-ca", value: true, with: []string{"-install"}},
+		{name: "backup", args: "FILE", summary: "Save the whole CAROOT to an encrypted archive.",
+			flag: "-backup", value: true, options: [][]string{{"key-pass"}}},
+		{name: "restore", args: "FILE", summary: "Restore a -backup archive into an empty CAROOT.",
+			flag: "-restore", value: true, with: []string{"-install"}, options: [][]string{{"key-pass"}}},
+		{name: "destroy", summary: "Uninstall the local CA and delete it for good.",
+			flag: "-destroy", options: [][]string{{"force"}, trustOptions}},
+		{name: "audit", summary: "Check the permissions of the CAROOT and the issued keys.",
+			flag: "-audit", options: [][]string{{"fix"}}},
+		{name: "crl", summary: "Generate the CRL of the revoked certificates.",
+			flag: "-gen-crl", options: [][]string{{"sig-alg"}}},
+		{name: "serve", summary: "Serve the local CA certificate and CRL over HTTP.",
+			flag: "-serve-ca", options: [][]string{{"listen"}}},
+		{name: "inter", args: "NAME", summary: "Create an intermediate CA signed by the local CA.",
+			flag: "-inter", value: true, options: [][]string{{"inter-pathlen", "inter-constrain", "sig-alg"}}},
+		{name: "inters", summary: "List the intermediate CAs.", flag: "-list-inter"},
+		{name: "ios-profile", args: "FILE", summary: "Save an iOS configuration profile that installs the local CA.",
+			flag: "-ios-profile", value: true, with: []string{"-install"}, options: [][]string{{"ios-sign"}}},
+	}},
+	{name: "version", summary: "Print the mkcert version.", flag: "-version"},
+}
+
+// except returns options without names.
+func except(options []string, names ...string) []string {
+	var result []string
+	for _, name := range options {
+		if !contains(names, name) {
+			result = append(result, name)
+		}
+	}
+	return result
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// allows reports whether the option name can be used with cmd.
+func (cmd *subcommand) allows(name string) bool {
+	for _, group := range append(cmd.options, commonOptions) {
+		if contains(group, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// maxArgs returns how many arguments cmd takes after the value of its flag,
+// or -1 if there is no limit.
+func (cmd *subcommand) maxArgs() int {
+	words := strings.Fields(cmd.args)
+	for _, word := range words {
+		// "CSR..." is repeated, "FRONTEND=BACKEND[,...]" is a single value.
+		if strings.Contains(word, "...") && !strings.Contains(word, ",...") {
+			return -1
+		}
+	}
+	if cmd.value {
+		return len(words) - 1
+	}
+	return len(words)
+}
+
+// describe names cmd in error messages.
+func (cmd *subcommand) describe() string {
+	if cmd.flag == "" {
+		return "new certificates"
+	}
+	return cmd.flag
+}
+
+// operations returns the subcommands in cmds that stand for an operation,
+// including the ones of their subcommands.
+func operations(cmds []*subcommand) []*subcommand {
+	var ops []*subcommand
+	for _, cmd := range cmds {
+		if cmd.subs != nil {
+			ops = append(ops, operations(cmd.subs)...)
+			continue
+		}
+		ops = append(ops, cmd)
+	}
+	return ops
+}
+
+// isOperationFlag reports whether name is the flag of an operation, like
+// "install", rather than one of its options.
+func isOperationFlag(name string) bool {
+	for _, cmd := range operations(subcommands) {
+		if cmd.flag == "-"+name {
+			return true
+		}
+	}
+	return false
+}
+
+// selectModes returns the operations selected by the flags given on the
+// command line, by name. Without an operation flag, or with only -install
+// and names or certificate options, a certificate is generated.
+func selectModes(given map[string]bool, nargs int) []*subcommand {
+	var modes []*subcommand
+	var install, cert *subcommand
+	for _, cmd := range operations(subcommands) {
+		switch {
+		case cmd.flag == "":
+			cert = cmd
+		case given[strings.TrimPrefix(cmd.flag, "-")]:
+			modes = append(modes, cmd)
+			if cmd.flag == "-install" {
+				install = cmd
+			}
+		}
+	}
+	if len(modes) == 0 {
+		return []*subcommand{cert}
+	}
+	if len(modes) == 1 && modes[0] == install {
+		needsCert := nargs > 0
+		for name := range given {
+			needsCert = needsCert || !isOperationFlag(name) && !install.allows(name) && cert.allows(name)
+		}
+		if needsCert {
+			modes = append(modes, cert)
+		}
+	}
+	return modes
+}
+
+// modesAllow reports whether the option name applies to modes. When -install
+// is combined with another operation, the option must apply to that
+// operation, or to the installation of the local CA.
+func modesAllow(modes []*subcommand, name string) bool {
+	return rejectingMode(modes, name) == nil
+}
+
+func rejectingMode(modes []*subcommand, name string) *subcommand {
+	var install *subcommand
+	for _, mode := range modes {
+		if mode.flag == "-install" {
+			install = mode
+		}
+	}
+	for _, mode := range modes {
+		if mode == install && len(modes) > 1 {
+			continue
+		}
+		if !mode.allows(name) && !(install != nil && install.allows(name) && !contains(newCAOptions, name)) {
+			return mode
+		}
+	}
+	return nil
+}
+
+// checkModes checks that the flags given on the command line, by name, and
+// the number of arguments are a valid combination of operations and
+// options, and returns the operations.
+func checkModes(given map[string]bool, nargs int) []*subcommand {
+	modes := selectModes(given, nargs)
+	for i, a := range modes {
+		for _, b := range modes[i+1:] {
+			if a.flag != "" && b.flag != "" && !contains(a.with, b.flag) && !contains(b.with, a.flag) {
+				log.Fatalf("ERROR: %s can't be combined with %s", a.describe(), b.describe())
+			}
+		}
+	}
+
+	for name := range given {
+		mode := rejectingMode(modes, name)
+		if isOperationFlag(name) || mode == nil {
+			continue
+		}
+		var owners []string
+		for _, cmd := range operations(subcommands) {
+			if cmd.allows(name) {
+				owners = append(owners, cmd.describe())
+			}
+		}
+		switch {
+		case len(owners) == 1:
+			log.Fatalf("ERROR: -%s can only be used with %s", name, owners[0])
+		case len(owners) == 2:
+			log.Fatalf("ERROR: -%s can only be used with %s and %s", name, owners[0], owners[1])
+		case mode.flag == "":
+			log.Fatalf("ERROR: -%s can't be used when generating certificates", name)
+		default:
+			log.Fatalf("ERROR: -%s can't be used with %s", name, mode.describe())
+		}
+	}
+
+	for _, mode := range modes {
+		if mode.flag == "-install" && len(modes) > 1 {
+			continue
+		}
+		switch max := mode.maxArgs(); {
+		case max < 0 || nargs <= max:
+		case max == 0:
+			log.Fatalf("ERROR: %s doesn't take arguments", mode.describe())
+		case max == 1:
+			log.Fatalf("ERROR: %s takes at most one argument", mode.describe())
+		default:
+			log.Fatalf("ERROR: %s takes at most %d arguments", mode.describe(), max)
+		}
+	}
+	return modes
+}
+
+func findSubcommand(subs []*subcommand, name string) *subcommand {
+	for _, s := range subs {
+		if s.name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+// subcommandArgs translates args starting with a subcommand into the flags
+// they stand for. Other args are returned unchanged.
+func subcommandArgs(args []string) []string {
+	if len(args) > 0 && args[0] == "help" {
+		if len(args) == 1 {
+			printSubcommands(os.Stdout)
+			os.Exit(0)
+		}
+		if findSubcommand(subcommands, args[1]) == nil {
+			log.Fatalf("ERROR: unknown command %q, run \"mkcert help\" for the list", args[1])
+		}
+		return subcommandArgs(append(args[1:], "-help"))
+	}
+	if len(args) == 0 || findSubcommand(subcommands, args[0]) == nil {
+		return args
+	}
+	path := "mkcert " + args[0]
+	cmd := findSubcommand(subcommands, args[0])
+	args = args[1:]
+	for cmd.subs != nil {
+		var sub *subcommand
+		if len(args) > 0 {
+			sub = findSubcommand(cmd.subs, args[0])
+		}
+		if sub == nil {
+			if len(args) > 0 && strings.TrimLeft(args[0], "-") == "help" {
+				printSubcommandHelp(os.Stdout, path, cmd)
+				os.Exit(0)
+			}
+			printSubcommandHelp(os.Stderr, path, cmd)
+			os.Exit(2)
+		}
+		path += " " + sub.name
+		cmd, args = sub, args[1:]
+	}
+
+	var flags, positional []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			positional = append(positional, args[i+1:]...)
+			break
+		}
+		if len(arg) < 2 || arg[0] != '-' {
+			positional = append(positional, arg)
+			continue
+		}
+		name := strings.TrimLeft(arg, "-")
+		hasValue := strings.Contains(name, "=")
+		if hasValue {
+			name = name[:strings.Index(name, "=")]
+		}
+		if name == "help" || name == "h" {
+			printSubcommandHelp(os.Stdout, path, cmd)
+			os.Exit(0)
+		}
+		f := flag.CommandLine.Lookup(name)
+		if f == nil {
+			// Let the flag package report it.
+			flags = append(flags, arg)
+			continue
+		}
+		if !cmd.allows(name) {
+			log.Fatalf("ERROR: -%s can't be used with %q, see \"%s -help\"", name, path, path)
+		}
+		flags = append(flags, arg)
+		if b, ok := f.Value.(interface{ IsBoolFlag() bool }); !hasValue && !(ok && b.IsBoolFlag()) && i+1 < len(args) {
+			i++
+			flags = append(flags, args[i])
+		}
+	}
+
+	var out []string
+	if cmd.flag != "" {
+		out = append(out, cmd.flag)
+	}
+	if cmd.value {
+		if len(positional) == 0 {
+			printSubcommandHelp(os.Stderr, path, cmd)
+			os.Exit(2)
+		}
+		out, positional = append(out, positional[0]), positional[1:]
+	}
+	out = append(out, flags...)
+	return append(append(out, "--"), positional...)
+}
+
+// printSubcommands lists the subcommands, for "mkcert help".
+func printSubcommands(w io.Writer) {
+	fmt.Fprint(w, "Usage: mkcert COMMAND [options] [arguments]\n\nCommands:\n\n")
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, cmd := range subcommands {
+		if cmd.subs == nil {
+			fmt.Fprintf(tw, "\t%s\t%s\n", strings.TrimSpace(cmd.name+" "+cmd.args), cmd.summary)
+			continue
+		}
+		for _, sub := range cmd.subs {
+			fmt.Fprintf(tw, "\t%s\t%s\n", strings.TrimSpace(cmd.name+" "+sub.name+" "+sub.args), sub.summary)
+		}
+	}
+	tw.Flush()
+	fmt.Fprint(w, "\nRun \"mkcert help COMMAND\" for the options of a command, and \"mkcert -help\"\nfor their descriptions. The flags like \"mkcert -install\" also keep working.\n")
+}
+
+// printSubcommandHelp describes the subcommand at path and its options.
+func printSubcommandHelp(w io.Writer, path string, cmd *subcommand) {
+	if cmd.subs != nil {
+		fmt.Fprintf(w, "Usage: %s COMMAND\n\n%s\n\nCommands:\n\n", path, cmd.summary)
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		for _, sub := range cmd.subs {
+			fmt.Fprintf(tw, "\t%s\t%s\n", strings.TrimSpace(sub.name+" "+sub.args), sub.summary)
+		}
+		tw.Flush()
+		return
+	}
+	fmt.Fprintf(w, "Usage: %s [options] %s\n\n%s\n", path, cmd.args, cmd.summary)
+	seen := map[string]bool{"help": true}
+	var names []string
+	for _, group := range append(cmd.options, commonOptions) {
+		for _, name := range group {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, "-"+name)
+			}
+		}
+	}
+	sort.Strings(names)
+	fmt.Fprintf(w, "\nOptions (see \"mkcert -help\"):\n\n")
+	line := "\t"
+	for _, name := range names {
+		if len(line)+len(name) > 72 {
+			fmt.Fprintln(w, strings.TrimRight(line, " "))
+			line = "\t"
+		}
+		line += name + " "
+	}
+	fmt.Fprintln(w, strings.TrimRight(line, " "))
+}
@@ -0,0 +1,52 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"strings"
+)
+
+// With -tpm, the CA key is generated by the TPM as a non-exportable key, so
+// it can't be used on another machine even if the CAROOT is copied. The key
+// URI is "tpm:NAME", where NAME is the key name in the Windows Platform
+// Crypto Provider, or the name of the key blobs in the CAROOT on Linux,
+// which only the TPM that created them can load.
+const tpmScheme = "tpm:"
+
+// newTPMKeyName returns a random name for a new TPM key, so that rotated
+// CAs and CA profiles don't overwrite each other's keys.
+func newTPMKeyName() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return "mkcert-rootCA-" + hex.EncodeToString(b)
+}
+
+// checkTPMOption fails if -tpm is set for an existing local CA whose key is
+// not in the TPM, since it only applies to new ones.
+func (m *mkcert) checkTPMOption() {
+	if !m.tpm || m.rotateRoot || !pathExists(filepath.Join(m.CAROOT, rootName)) {
+		return
+	}
+	// Allow MKCERT_TPM to stay set after the CA is created.
+	uri, _ := ioutil.ReadFile(filepath.Join(m.CAROOT, rootKeyURIName))
+	if !strings.HasPrefix(string(uri), tpmScheme) {
+		log.Fatalln("ERROR: -tpm only applies when creating a new local CA, and there is already one; use -rotate-root to replace it")
+	}
+}
+
+// tpmKeyName returns the NAME of a "tpm:NAME" URI.
+func tpmKeyName(uri string) (string, error) {
+	name := strings.TrimPrefix(uri, tpmScheme)
+	if name == "" || strings.ContainsAny(name, `/\`) {
+		return "", fmt.Errorf("invalid TPM key URI %q", uri)
+	}
+	return name, nil
+}
@@ -0,0 +1,169 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// On Linux, the TPM is used through the tpm2-tools. The key is created
+// under the default owner hierarchy primary key, which the TPM derives again
+// on every use, and is saved in the CAROOT as the NAME.pub and NAME.priv
+// blobs, the private one encrypted by the TPM for itself.
+
+type tpmKey struct {
+	pubFile, privFile string
+	pub               crypto.PublicKey
+}
+
+func tpm2(args ...string) error {
+	verbosef("Running %s", strings.Join(args, " "))
+	out, err := exec.Command(args[0], args[1:]...).CombinedOutput()
+	if errors.Is(err, exec.ErrNotFound) {
+		return errors.New("the tpm2-tools are not installed, install them to use -tpm")
+	}
+	if err != nil {
+		return fmt.Errorf("%s failed: %s\n\n%s", args[0], err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+// createPrimary derives the primary key, and returns the path of its context
+// in dir.
+func createPrimary(dir string) (string, error) {
+	primary := filepath.Join(dir, "primary.ctx")
+	return primary, tpm2("tpm2_createprimary", "-Q", "-C", "o", "-g", "sha256", "-G", "ecc", "-c", primary)
+}
+
+// load loads the key in the TPM, and returns the path of its context in dir.
+func (k *tpmKey) load(dir string) (string, error) {
+	primary, err := createPrimary(dir)
+	if err != nil {
+		return "", err
+	}
+	key := filepath.Join(dir, "key.ctx")
+	if err := tpm2("tpm2_load", "-Q", "-C", primary, "-u", k.pubFile, "-r", k.privFile, "-c", key); err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+func newTPMKey(caroot, name string, ec bool) (crypto.Signer, error) {
+	dir, err := ioutil.TempDir("", "mkcert-tpm")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	k := &tpmKey{pubFile: filepath.Join(caroot, name+".pub"), privFile: filepath.Join(caroot, name+".priv")}
+
+	primary, err := createPrimary(dir)
+	if err != nil {
+		return nil, err
+	}
+	alg := "rsa2048"
+	if ec {
+		alg = "ecc256"
+	}
+	err = tpm2("tpm2_create", "-Q", "-C", primary, "-G", alg,
+		"-a", "fixedtpm|fixedparent|sensitivedataorigin|userwithauth|sign",
+		"-u", k.pubFile, "-r", k.privFile)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := k.load(dir)
+	if err != nil {
+		return nil, err
+	}
+	pubFile := filepath.Join(dir, "pub.pem")
+	if err := tpm2("tpm2_readpublic", "-Q", "-c", key, "-f", "pem", "-o", pubFile); err != nil {
+		return nil, err
+	}
+	pubPEM, err := ioutil.ReadFile(pubFile)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(pubPEM)
+	if block == nil {
+		return nil, errors.New("tpm2_readpublic returned an invalid public key")
+	}
+	if k.pub, err = x509.ParsePKIXPublicKey(block.Bytes); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+func openTPMKey(caroot, name string, pub crypto.PublicKey) (crypto.Signer, error) {
+	k := &tpmKey{pubFile: filepath.Join(caroot, name+".pub"), privFile: filepath.Join(caroot, name+".priv"), pub: pub}
+	if !pathExists(k.privFile) {
+		return nil, fmt.Errorf("the TPM key blob %s is missing", k.privFile)
+	}
+	return k, nil
+}
+
+func (k *tpmKey) Public() crypto.PublicKey {
+	return k.pub
+}
+
+var tpmHashNames = map[crypto.Hash]string{
+	crypto.SHA1: "sha1", crypto.SHA256: "sha256", crypto.SHA384: "sha384", crypto.SHA512: "sha512",
+}
+
+func (k *tpmKey) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	hash, ok := tpmHashNames[opts.HashFunc()]
+	if !ok {
+		return nil, fmt.Errorf("unsupported hash for a TPM key: %v", opts.HashFunc())
+	}
+	var scheme string
+	switch k.pub.(type) {
+	case *ecdsa.PublicKey:
+		scheme = "ecdsa"
+	case *rsa.PublicKey:
+		if _, ok := opts.(*rsa.PSSOptions); ok {
+			return nil, errors.New("RSA-PSS signatures are not supported with a TPM key")
+		}
+		scheme = "rsassa"
+	default:
+		return nil, fmt.Errorf("unsupported TPM key type %T", k.pub)
+	}
+
+	dir, err := ioutil.TempDir("", "mkcert-tpm")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	key, err := k.load(dir)
+	if err != nil {
+		return nil, err
+	}
+	in, out := filepath.Join(dir, "digest"), filepath.Join(dir, "signature")
+	if err := ioutil.WriteFile(in, digest, 0600); err != nil {
+		return nil, err
+	}
+	// The plain format is DER for ECDSA, like crypto.Signer returns.
+	if err := tpm2("tpm2_sign", "-Q", "-c", key, "-g", hash, "-s", scheme, "-d", "-f", "plain", "-o", out, in); err != nil {
+		return nil, err
+	}
+	return ioutil.ReadFile(out)
+}
+
+// deleteTPMKey does nothing, since the key blobs are in the CAROOT, and the
+// key only exists in the TPM while it's loaded.
+func deleteTPMKey(caroot, name string) error {
+	return nil
+}
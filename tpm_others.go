@@ -0,0 +1,28 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !linux && !windows
+
+package main
+
+import (
+	"crypto"
+	"errors"
+)
+
+// The macOS Secure Enclave is only reachable through the Security framework,
+// which would require cgo, so only Linux and Windows support -tpm, and main
+// rejects it elsewhere.
+
+func newTPMKey(caroot, name string, ec bool) (crypto.Signer, error) {
+	return nil, errors.New("-tpm is only supported on Linux and Windows")
+}
+
+func openTPMKey(caroot, name string, pub crypto.PublicKey) (crypto.Signer, error) {
+	return nil, errors.New("-tpm is only supported on Linux and Windows")
+}
+
+func deleteTPMKey(caroot, name string) error {
+	return nil
+}
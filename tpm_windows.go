@@ -0,0 +1,234 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// On Windows, the TPM is used through the Microsoft Platform Crypto Provider
+// of CNG, where the key is persisted under its name for the current user.
+
+var (
+	ncrypt                        = windows.NewLazySystemDLL("ncrypt.dll")
+	procNCryptOpenStorageProvider = ncrypt.NewProc("NCryptOpenStorageProvider")
+	procNCryptCreatePersistedKey  = ncrypt.NewProc("NCryptCreatePersistedKey")
+	procNCryptFinalizeKey         = ncrypt.NewProc("NCryptFinalizeKey")
+	procNCryptOpenKey             = ncrypt.NewProc("NCryptOpenKey")
+	procNCryptExportKey           = ncrypt.NewProc("NCryptExportKey")
+	procNCryptSignHash            = ncrypt.NewProc("NCryptSignHash")
+	procNCryptDeleteKey           = ncrypt.NewProc("NCryptDeleteKey")
+	procNCryptFreeObject          = ncrypt.NewProc("NCryptFreeObject")
+)
+
+const (
+	bcryptPadPKCS1        = 0x2
+	bcryptECDSAPublicP256 = 0x31534345 // ECS1
+	bcryptRSAPublicMagic  = 0x31415352 // RSA1
+)
+
+// ncryptCall calls an NCrypt function, which returns a SECURITY_STATUS.
+// Like with LazyProc.Call, the pointers passed as arguments stay valid.
+//
+//go:uintptrescapes
+func ncryptCall(proc *windows.LazyProc, args ...uintptr) error {
+	if r, _, _ := proc.Call(args...); r != 0 {
+		return fmt.Errorf("%s failed: %w", proc.Name, windows.Errno(r))
+	}
+	return nil
+}
+
+func utf16Ptr(s string) *uint16 {
+	p, _ := windows.UTF16PtrFromString(s)
+	return p
+}
+
+// bcryptPKCS1PaddingInfo is a BCRYPT_PKCS1_PADDING_INFO.
+type bcryptPKCS1PaddingInfo struct {
+	algID *uint16
+}
+
+type tpmKey struct {
+	name string
+	pub  crypto.PublicKey
+}
+
+// openProvider opens the Platform Crypto Provider, which fails without a TPM.
+func openProvider() (uintptr, error) {
+	var provider uintptr
+	err := ncryptCall(procNCryptOpenStorageProvider, uintptr(unsafe.Pointer(&provider)),
+		uintptr(unsafe.Pointer(utf16Ptr("Microsoft Platform Crypto Provider"))), 0)
+	if err != nil {
+		return 0, fmt.Errorf("the TPM is not available: %w", err)
+	}
+	return provider, nil
+}
+
+// withKey calls f with the handle of the key.
+func (k *tpmKey) withKey(f func(key uintptr) error) error {
+	provider, err := openProvider()
+	if err != nil {
+		return err
+	}
+	defer procNCryptFreeObject.Call(provider)
+	var key uintptr
+	if err := ncryptCall(procNCryptOpenKey, provider, uintptr(unsafe.Pointer(&key)), uintptr(unsafe.Pointer(utf16Ptr(k.name))), 0, 0); err != nil {
+		return err
+	}
+	defer procNCryptFreeObject.Call(key)
+	return f(key)
+}
+
+func newTPMKey(caroot, name string, ec bool) (crypto.Signer, error) {
+	provider, err := openProvider()
+	if err != nil {
+		return nil, err
+	}
+	defer procNCryptFreeObject.Call(provider)
+	alg := "RSA"
+	if ec {
+		alg = "ECDSA_P256"
+	}
+	var key uintptr
+	err = ncryptCall(procNCryptCreatePersistedKey, provider, uintptr(unsafe.Pointer(&key)), uintptr(unsafe.Pointer(utf16Ptr(alg))), uintptr(unsafe.Pointer(utf16Ptr(name))), 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer procNCryptFreeObject.Call(key)
+	if err := ncryptCall(procNCryptFinalizeKey, key, 0); err != nil {
+		return nil, err
+	}
+
+	blobType := "RSAPUBLICBLOB"
+	if ec {
+		blobType = "ECCPUBLICBLOB"
+	}
+	var size uint32
+	if err := ncryptCall(procNCryptExportKey, key, 0, uintptr(unsafe.Pointer(utf16Ptr(blobType))), 0, 0, 0, uintptr(unsafe.Pointer(&size)), 0); err != nil {
+		return nil, err
+	}
+	blob := make([]byte, size)
+	if err := ncryptCall(procNCryptExportKey, key, 0, uintptr(unsafe.Pointer(utf16Ptr(blobType))), 0,
+		uintptr(unsafe.Pointer(&blob[0])), uintptr(size), uintptr(unsafe.Pointer(&size)), 0); err != nil {
+		return nil, err
+	}
+	pub, err := parsePublicBlob(blob[:size])
+	if err != nil {
+		return nil, err
+	}
+	return &tpmKey{name: name, pub: pub}, nil
+}
+
+// parsePublicBlob parses a BCRYPT_ECCKEY_BLOB or BCRYPT_RSAKEY_BLOB.
+func parsePublicBlob(blob []byte) (crypto.PublicKey, error) {
+	if len(blob) < 8 {
+		return nil, errors.New("invalid public key blob")
+	}
+	switch binary.LittleEndian.Uint32(blob) {
+	case bcryptECDSAPublicP256:
+		n := int(binary.LittleEndian.Uint32(blob[4:]))
+		if len(blob) != 8+2*n {
+			return nil, errors.New("invalid ECDSA public key blob")
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(),
+			X: new(big.Int).SetBytes(blob[8 : 8+n]), Y: new(big.Int).SetBytes(blob[8+n:])}, nil
+	case bcryptRSAPublicMagic:
+		if len(blob) < 24 {
+			return nil, errors.New("invalid RSA public key blob")
+		}
+		e := int(binary.LittleEndian.Uint32(blob[8:]))
+		n := int(binary.LittleEndian.Uint32(blob[12:]))
+		if len(blob) < 24+e+n {
+			return nil, errors.New("invalid RSA public key blob")
+		}
+		return &rsa.PublicKey{E: int(new(big.Int).SetBytes(blob[24 : 24+e]).Int64()),
+			N: new(big.Int).SetBytes(blob[24+e : 24+e+n])}, nil
+	}
+	return nil, errors.New("unsupported public key blob")
+}
+
+func openTPMKey(caroot, name string, pub crypto.PublicKey) (crypto.Signer, error) {
+	return &tpmKey{name: name, pub: pub}, nil
+}
+
+// deleteTPMKey deletes the persisted key from the Platform Crypto Provider.
+func deleteTPMKey(caroot, name string) error {
+	provider, err := openProvider()
+	if err != nil {
+		return err
+	}
+	defer procNCryptFreeObject.Call(provider)
+	var key uintptr
+	if err := ncryptCall(procNCryptOpenKey, provider, uintptr(unsafe.Pointer(&key)), uintptr(unsafe.Pointer(utf16Ptr(name))), 0, 0); err != nil {
+		return err
+	}
+	// NCryptDeleteKey also frees the key handle.
+	return ncryptCall(procNCryptDeleteKey, key, 0)
+}
+
+func (k *tpmKey) Public() crypto.PublicKey {
+	return k.pub
+}
+
+var tpmHashNames = map[crypto.Hash]string{
+	crypto.SHA1: "SHA1", crypto.SHA256: "SHA256", crypto.SHA384: "SHA384", crypto.SHA512: "SHA512",
+}
+
+func (k *tpmKey) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	hash, ok := tpmHashNames[opts.HashFunc()]
+	if !ok {
+		return nil, fmt.Errorf("unsupported hash for a TPM key: %v", opts.HashFunc())
+	}
+	var padding *bcryptPKCS1PaddingInfo
+	var flags uintptr
+	switch k.pub.(type) {
+	case *ecdsa.PublicKey:
+	case *rsa.PublicKey:
+		if _, ok := opts.(*rsa.PSSOptions); ok {
+			return nil, errors.New("RSA-PSS signatures are not supported with a TPM key")
+		}
+		padding = &bcryptPKCS1PaddingInfo{algID: utf16Ptr(hash)}
+		flags = bcryptPadPKCS1
+	default:
+		return nil, fmt.Errorf("unsupported TPM key type %T", k.pub)
+	}
+
+	var sig []byte
+	err := k.withKey(func(key uintptr) error {
+		var size uint32
+		if err := ncryptCall(procNCryptSignHash, key, uintptr(unsafe.Pointer(padding)), uintptr(unsafe.Pointer(&digest[0])), uintptr(len(digest)),
+			0, 0, uintptr(unsafe.Pointer(&size)), flags); err != nil {
+			return err
+		}
+		sig = make([]byte, size)
+		if err := ncryptCall(procNCryptSignHash, key, uintptr(unsafe.Pointer(padding)), uintptr(unsafe.Pointer(&digest[0])), uintptr(len(digest)),
+			uintptr(unsafe.Pointer(&sig[0])), uintptr(size), uintptr(unsafe.Pointer(&size)), flags); err != nil {
+			return err
+		}
+		sig = sig[:size]
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := k.pub.(*ecdsa.PublicKey); ok {
+		// CNG returns r and s concatenated, crypto.Signer returns them in DER.
+		r, s := new(big.Int).SetBytes(sig[:len(sig)/2]), new(big.Int).SetBytes(sig[len(sig)/2:])
+		return asn1.Marshal(struct{ R, S *big.Int }{r, s})
+	}
+	return sig, nil
+}
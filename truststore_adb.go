@@ -0,0 +1,119 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// The Android system store is a directory of PEM files named after the
+// OpenSSL "old" subject hash. It can only be modified on emulators and
+// rooted devices where "adb root" and "adb remount" work. (Emulators must be
+// started with -writable-system.)
+const androidCertsDir = "/system/etc/security/cacerts"
+
+var hasADB = binaryExists("adb")
+
+// androidDevices returns the serials of the connected devices and emulators.
+func androidDevices() []string {
+	out, err := combinedOutput(exec.Command("adb", "devices"))
+	if err != nil {
+		return nil
+	}
+	var serials []string
+	for _, line := range strings.Split(string(out), "\n")[1:] {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[1] == "device" {
+			serials = append(serials, fields[0])
+		}
+	}
+	return serials
+}
+
+func (m *mkcert) androidCertPath() string {
+	h := md5.Sum(m.caCert.RawSubject)
+	return fmt.Sprintf("%s/%08x.0", androidCertsDir, binary.LittleEndian.Uint32(h[:4]))
+}
+
+func (m *mkcert) checkAndroid(serial string) bool {
+	out, err := combinedOutput(exec.Command("adb", "-s", serial, "shell", "cat", m.androidCertPath()))
+	if err != nil {
+		return false
+	}
+	block, _ := pem.Decode(bytes.Replace(out, []byte("\r\n"), []byte("\n"), -1))
+	return block != nil && bytes.Equal(block.Bytes, m.caCert.Raw)
+}
+
+// installAndroid installs the local CA in all the connected devices.
+func (m *mkcert) installAndroid() {
+	tmp, err := ioutil.TempFile("", "mkcert-android")
+	fatalIfErr(err, "failed to create temporary file")
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: m.caCert.Raw}))
+	fatalIfErr(err, "failed to write temporary file")
+	fatalIfErr(tmp.Close(), "failed to write temporary file")
+
+	for _, serial := range androidDevices() {
+		if m.checkAndroid(serial) {
+			logCode("store_already_installed", "The local CA is already installed in the Android device %q! 👍", serial)
+			storeInstalled()
+			continue
+		}
+		if !androidRemount(serial) {
+			storesFailed++
+			continue
+		}
+		path := m.androidCertPath()
+		if out, err := combinedOutput(exec.Command("adb", "-s", serial, "push", tmp.Name(), path)); err != nil {
+			logCode("store_install_failed", "Warning: failed to push the local CA to the Android device %q: %s ⚠️\n\n%s", serial, err, out)
+			storesFailed++
+			continue
+		}
+		combinedOutput(exec.Command("adb", "-s", serial, "shell", "chmod", "644", path))
+		logCode("store_installed", "The local CA is now installed in the Android device %q (requires reboot)! 🤖", serial)
+		storeInstalled()
+	}
+}
+
+func (m *mkcert) uninstallAndroid() {
+	for _, serial := range androidDevices() {
+		if !m.checkAndroid(serial) || !androidRemount(serial) {
+			continue
+		}
+		out, err := combinedOutput(exec.Command("adb", "-s", serial, "shell", "rm", m.androidCertPath()))
+		if err != nil {
+			logCode("store_uninstall_failed", "Warning: failed to remove the local CA from the Android device %q: %s ⚠️\n\n%s", serial, err, out)
+			continue
+		}
+		logCode("store_uninstalled", "The local CA is now uninstalled from the Android device %q! 👋", serial)
+	}
+}
+
+// androidRemount restarts adbd as root and makes the system partition
+// writable, which only works on emulators and rooted devices.
+func androidRemount(serial string) bool {
+	out, err := combinedOutput(exec.Command("adb", "-s", serial, "root"))
+	if err != nil || bytes.Contains(out, []byte("cannot run as root")) {
+		log.Printf("Warning: the Android device %q is not rooted, so the local CA can't be installed in its system store ⚠️", serial)
+		log.Printf("Install it as a user certificate from Settings instead, see \"mkcert -serve-ca\" 👈")
+		return false
+	}
+	combinedOutput(exec.Command("adb", "-s", serial, "wait-for-device"))
+	if out, err := combinedOutput(exec.Command("adb", "-s", serial, "remount")); err != nil {
+		logCode("store_install_failed", "Warning: failed to remount the system partition of the Android device %q: %s ⚠️\n\n%s", serial, err, out)
+		log.Printf("Emulators must be started with \"-writable-system\" 👈")
+		return false
+	}
+	return true
+}
@@ -0,0 +1,109 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build freebsd || openbsd
+
+package main
+
+import (
+	"bytes"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+var (
+	FirefoxProfiles     = []string{os.Getenv("HOME") + "/.mozilla/firefox/*"}
+	ThunderbirdProfiles = []string{os.Getenv("HOME") + "/.thunderbird/*"}
+	NSSBrowsers         = "Firefox, Thunderbird and/or Chromium"
+	CertutilInstallHelp = map[string]string{
+		"freebsd": "pkg install nss",
+		"openbsd": "pkg_add nss",
+	}[runtime.GOOS]
+)
+
+// On FreeBSD, certctl builds /etc/ssl/certs from the trusted certificates
+// directories, which include /usr/local/etc/ssl/certs for local additions.
+// OpenBSD only has the /etc/ssl/cert.pem bundle, which we append to.
+const (
+	freeBSDTrustFilename = "/usr/local/etc/ssl/certs/%s.pem"
+	openBSDBundle        = "/etc/ssl/cert.pem"
+)
+
+func (m *mkcert) installPlatform() bool {
+	cert, err := ioutil.ReadFile(filepath.Join(m.CAROOT, rootName))
+	fatalIfErr(err, "failed to read root certificate")
+
+	if runtime.GOOS == "openbsd" {
+		bundle, err := ioutil.ReadFile(openBSDBundle)
+		fatalIfErr(err, "failed to read the system trust store")
+		if bytes.Contains(bundle, m.openBSDBundleEntry()) {
+			return true
+		}
+		cmd := commandWithSudo("tee", "-a", openBSDBundle)
+		cmd.Stdin = bytes.NewReader(m.openBSDBundleEntry())
+		out, err := combinedOutput(cmd)
+		fatalIfCmdErr(err, "tee -a", out)
+		return true
+	}
+
+	if !binaryExists("certctl") {
+		log.Printf("Installing to the system store requires certctl (FreeBSD 12.2 or later) 😣 but %s will still work.", NSSBrowsers)
+		log.Printf("You can also manually install the root certificate at %q.", filepath.Join(m.CAROOT, rootName))
+		return false
+	}
+	path := m.freeBSDTrustFilename()
+	cmd := commandWithSudo("mkdir", "-p", filepath.Dir(path))
+	out, err := combinedOutput(cmd)
+	fatalIfCmdErr(err, "mkdir", out)
+	cmd = commandWithSudo("tee", path)
+	cmd.Stdin = bytes.NewReader(cert)
+	out, err = combinedOutput(cmd)
+	fatalIfCmdErr(err, "tee", out)
+
+	out, err = combinedOutput(commandWithSudo("certctl", "rehash"))
+	fatalIfCmdErr(err, "certctl rehash", out)
+	return true
+}
+
+func (m *mkcert) uninstallPlatform() bool {
+	if runtime.GOOS == "openbsd" {
+		bundle, err := ioutil.ReadFile(openBSDBundle)
+		fatalIfErr(err, "failed to read the system trust store")
+		entry := m.openBSDBundleEntry()
+		if !bytes.Contains(bundle, entry) {
+			return true
+		}
+		cmd := commandWithSudo("tee", openBSDBundle)
+		cmd.Stdin = bytes.NewReader(bytes.Replace(bundle, entry, nil, -1))
+		out, err := combinedOutput(cmd)
+		fatalIfCmdErr(err, "tee", out)
+		return true
+	}
+
+	if !binaryExists("certctl") {
+		return false
+	}
+	out, err := combinedOutput(commandWithSudo("rm", "-f", m.freeBSDTrustFilename()))
+	fatalIfCmdErr(err, "rm", out)
+	out, err = combinedOutput(commandWithSudo("certctl", "rehash"))
+	fatalIfCmdErr(err, "certctl rehash", out)
+	return true
+}
+
+func (m *mkcert) freeBSDTrustFilename() string {
+	return fmt.Sprintf(freeBSDTrustFilename, strings.Replace(m.caUniqueName(), " ", "_", -1))
+}
+
+// openBSDBundleEntry is the text appended to the OpenBSD bundle, and removed
+// verbatim on uninstall.
+func (m *mkcert) openBSDBundleEntry() []byte {
+	entry := []byte("\n# " + m.caUniqueName() + "\n")
+	return append(entry, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: m.caCert.Raw})...)
+}
@@ -0,0 +1,196 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Chrome and Chromium trust the certificates in the CACertificates enterprise
+// policy, which on Linux is read from the JSON files in their managed policy
+// directory, and on Windows from the registry.
+//
+// This is useful where NSS can't be used. On Linux it's the fallback when
+// certutil is not available, otherwise it must be selected with -stores or
+// TRUST_STORES.
+
+const chromePolicyName = "mkcert.json"
+
+var (
+	hasChromePolicy bool
+
+	// chromePolicyDirs maps the Linux policy directories to the paths that
+	// indicate that the respective browser is installed.
+	chromePolicyDirs = map[string][]string{
+		"/etc/opt/chrome/policies/managed": {"/opt/google/chrome", "/usr/bin/google-chrome", "/usr/bin/google-chrome-stable"},
+		"/etc/chromium/policies/managed":   {"/usr/bin/chromium", "/usr/bin/chromium-browser", "/snap/chromium"},
+	}
+
+	chromeRegistryKeys = []string{
+		`HKCU\Software\Policies\Google\Chrome\CACertificates`,
+		`HKCU\Software\Policies\Chromium\CACertificates`,
+	}
+)
+
+func init() {
+	hasChromePolicy = len(chromePolicyTargets()) > 0
+}
+
+// chromePolicyTargets returns the policy directories or registry keys of the
+// installed browsers.
+func chromePolicyTargets() []string {
+	switch runtime.GOOS {
+	case "windows":
+		return chromeRegistryKeys
+	case "linux":
+		var dirs []string
+		for dir, paths := range chromePolicyDirs {
+			for _, path := range paths {
+				if pathExists(path) {
+					dirs = append(dirs, dir)
+					break
+				}
+			}
+		}
+		return dirs
+	}
+	return nil
+}
+
+// chromePolicyEnabled reports whether -install should use the Chrome policies.
+func chromePolicyEnabled() bool {
+	if !hasChromePolicy || !storeEnabled("chrome") {
+		return false
+	}
+	return trustStores != "" || (runtime.GOOS == "linux" && !hasCertutil)
+}
+
+func (m *mkcert) checkChrome() bool {
+	caB64 := base64.StdEncoding.EncodeToString(m.caCert.Raw)
+	for _, target := range chromePolicyTargets() {
+		if _, ok := readChromeCertificates(target)[caB64]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func (m *mkcert) installChrome() {
+	caB64 := base64.StdEncoding.EncodeToString(m.caCert.Raw)
+	for _, target := range chromePolicyTargets() {
+		certs := readChromeCertificates(target)
+		if _, ok := certs[caB64]; ok {
+			continue
+		}
+		if runtime.GOOS == "windows" {
+			next := 1
+			for _, name := range certs {
+				if n, err := strconv.Atoi(name); err == nil && n >= next {
+					next = n + 1
+				}
+			}
+			out, err := combinedOutput(exec.Command("reg", "add", target,
+				"/v", strconv.Itoa(next), "/t", "REG_SZ", "/d", caB64, "/f"))
+			fatalIfCmdErr(err, "reg add", out)
+			continue
+		}
+		certs[caB64] = ""
+		writeChromePolicy(target, certs)
+	}
+}
+
+func (m *mkcert) uninstallChrome() {
+	caB64 := base64.StdEncoding.EncodeToString(m.caCert.Raw)
+	var removed bool
+	for _, target := range chromePolicyTargets() {
+		certs := readChromeCertificates(target)
+		name, ok := certs[caB64]
+		if !ok {
+			continue
+		}
+		removed = true
+		if runtime.GOOS == "windows" {
+			out, err := combinedOutput(exec.Command("reg", "delete", target, "/v", name, "/f"))
+			fatalIfCmdErr(err, "reg delete", out)
+			continue
+		}
+		delete(certs, caB64)
+		writeChromePolicy(target, certs)
+	}
+	if removed {
+		logCode("store_uninstalled", "The local CA is now uninstalled from the Chrome enterprise policies! 👋")
+	}
+}
+
+// readChromeCertificates returns the base64 certificates in the
+// CACertificates policy, mapped to their registry value names on Windows.
+func readChromeCertificates(target string) map[string]string {
+	certs := map[string]string{}
+	if runtime.GOOS == "windows" {
+		// A missing key is not an error, there are just no certificates.
+		out, _ := exec.Command("reg", "query", target).Output()
+		for _, line := range strings.Split(string(out), "\n") {
+			if f := strings.Fields(line); len(f) == 3 && f[1] == "REG_SZ" {
+				certs[f[2]] = f[0]
+			}
+		}
+		return certs
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(target, chromePolicyName))
+	if os.IsNotExist(err) {
+		return certs
+	}
+	fatalIfErr(err, "failed to read the Chrome policies")
+	var policy struct{ CACertificates []string }
+	fatalIfErr(json.Unmarshal(data, &policy), "failed to parse the Chrome policies")
+	for _, c := range policy.CACertificates {
+		certs[c] = ""
+	}
+	return certs
+}
+
+// writeChromePolicy replaces the mkcert policy file in dir, or removes it if
+// there are no certificates left.
+func writeChromePolicy(dir string, certs map[string]string) {
+	path := filepath.Join(dir, chromePolicyName)
+	if len(certs) == 0 {
+		out, err := combinedOutput(commandWithSudo("rm", "-f", path))
+		fatalIfCmdErr(err, "rm", out)
+		return
+	}
+	policy := struct {
+		CACertificates []string
+	}{}
+	for c := range certs {
+		policy.CACertificates = append(policy.CACertificates, c)
+	}
+	sort.Strings(policy.CACertificates)
+	data, err := json.MarshalIndent(policy, "", "  ")
+	fatalIfErr(err, "failed to encode the Chrome policies")
+	writeFileWithSudo(path, append(data, '\n'))
+}
+
+// writeFileWithSudo creates or replaces a file in a system location, such as
+// a policy directory, along with its parent directories.
+func writeFileWithSudo(path string, data []byte) {
+	cmd := commandWithSudo("mkdir", "-p", filepath.Dir(path))
+	out, err := combinedOutput(cmd)
+	fatalIfCmdErr(err, "mkdir", out)
+	cmd = commandWithSudo("tee", path)
+	cmd.Stdin = bytes.NewReader(data)
+	out, err = combinedOutput(cmd)
+	fatalIfCmdErr(err, "tee", out)
+}
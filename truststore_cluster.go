@@ -0,0 +1,138 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/pem"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	hasKind     = binaryExists("kind") && binaryExists("docker")
+	hasMinikube = binaryExists("minikube")
+)
+
+// clusterCertName is safe to use in shell commands, unlike caUniqueName.
+func (m *mkcert) clusterCertName() string {
+	return "mkcert_rootCA_" + m.caCert.SerialNumber.String() + ".crt"
+}
+
+// kindNodes returns the node containers of all the kind clusters.
+func kindNodes() []string {
+	out, err := exec.Command("kind", "get", "clusters").Output()
+	if err != nil {
+		verbosef("Failed to list the kind clusters: %s", err)
+		return nil
+	}
+	var nodes []string
+	for _, cluster := range strings.Fields(string(out)) {
+		out, err := exec.Command("kind", "get", "nodes", "--name", cluster).Output()
+		if err != nil {
+			verbosef("Failed to list the nodes of the kind cluster %q: %s", cluster, err)
+			continue
+		}
+		nodes = append(nodes, strings.Fields(string(out))...)
+	}
+	return nodes
+}
+
+// installKind adds the local CA to the system store of each kind node, and
+// restarts containerd so that image pulls pick it up.
+func (m *mkcert) installKind() {
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: m.caCert.Raw})
+	path := "/usr/local/share/ca-certificates/" + m.clusterCertName()
+	for _, node := range kindNodes() {
+		if m.checkKind(node) {
+			logCode("store_already_installed", "The local CA is already installed in the kind node %q! 👍", node)
+			storeInstalled()
+			continue
+		}
+		cmd := exec.Command("docker", "exec", "-i", node, "sh", "-c",
+			"cat > "+path+" && update-ca-certificates && systemctl restart containerd")
+		cmd.Stdin = bytes.NewReader(certPEM)
+		if out, err := combinedOutput(cmd); err != nil {
+			logCode("store_install_failed", "Warning: failed to install the local CA in the kind node %q: %s ⚠️\n\n%s", node, err, out)
+			storesFailed++
+			continue
+		}
+		logCode("store_installed", "The local CA is now installed in the kind node %q! 🚢", node)
+		storeInstalled()
+	}
+}
+
+func (m *mkcert) checkKind(node string) bool {
+	path := "/usr/local/share/ca-certificates/" + m.clusterCertName()
+	_, err := combinedOutput(exec.Command("docker", "exec", node, "test", "-f", path))
+	return err == nil
+}
+
+func (m *mkcert) uninstallKind() {
+	path := "/usr/local/share/ca-certificates/" + m.clusterCertName()
+	for _, node := range kindNodes() {
+		cmd := exec.Command("docker", "exec", node, "sh", "-c",
+			"test -f "+path+" && rm "+path+" && update-ca-certificates --fresh && systemctl restart containerd")
+		if _, err := combinedOutput(cmd); err != nil {
+			continue // not installed
+		}
+		logCode("store_uninstalled", "The local CA is now uninstalled from the kind node %q! 👋", node)
+	}
+}
+
+// minikubeCertsDir is where minikube looks for extra CA certificates to
+// install in its nodes when they start.
+func minikubeCertsDir() string {
+	home := os.Getenv("MINIKUBE_HOME")
+	if home == "" {
+		userHome, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		home = filepath.Join(userHome, ".minikube")
+	} else if filepath.Base(home) != ".minikube" {
+		home = filepath.Join(home, ".minikube")
+	}
+	return filepath.Join(home, "certs")
+}
+
+// installMinikube adds the local CA to the minikube certificates, which are
+// copied to the nodes by "minikube start --embed-certs".
+func (m *mkcert) installMinikube() {
+	dir := minikubeCertsDir()
+	if dir == "" || !pathExists(filepath.Dir(dir)) {
+		return
+	}
+	path := filepath.Join(dir, m.clusterCertName())
+	if pathExists(path) {
+		logCode("store_already_installed", "The local CA is already installed in the minikube certificates! 👍")
+		storeInstalled()
+		return
+	}
+	fatalIfErr(os.MkdirAll(dir, 0755), "failed to create the minikube certificates directory")
+	verbosef("Writing %s", path)
+	err := ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: m.caCert.Raw}), 0644)
+	fatalIfErr(err, "failed to save the local CA to the minikube certificates")
+	logCode("store_installed", "The local CA is now installed in the minikube certificates! 🚢")
+	storeInstalled()
+	log.Print(`Run "minikube start --embed-certs" to copy it to the nodes 👈`)
+}
+
+func (m *mkcert) uninstallMinikube() {
+	dir := minikubeCertsDir()
+	if dir == "" {
+		return
+	}
+	path := filepath.Join(dir, m.clusterCertName())
+	if !pathExists(path) {
+		return
+	}
+	fatalIfErr(os.Remove(path), "failed to remove the local CA from the minikube certificates")
+	logCode("store_uninstalled", "The local CA is now uninstalled from the minikube certificates, it will be removed from the nodes when they are recreated! 👋")
+}
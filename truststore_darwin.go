@@ -5,11 +5,13 @@
 package main
 
 import (
-	"bytes"
-	"encoding/asn1"
+	"crypto/sha1"
+	"crypto/sha256"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
+	"os/exec"
 	"path/filepath"
 
 	"howett.net/plist"
@@ -17,10 +19,34 @@ import (
 
 var (
 	FirefoxProfiles     = []string{os.Getenv("HOME") + "/Library/Application Support/Firefox/Profiles/*"}
+	ThunderbirdProfiles = []string{os.Getenv("HOME") + "/Library/Thunderbird/Profiles/*"}
 	CertutilInstallHelp = "brew install nss"
-	NSSBrowsers         = "Firefox"
+	NSSBrowsers         = "Firefox and/or Thunderbird"
 )
 
+const systemKeychain = "/Library/Keychains/System.keychain"
+
+// security runs the security tool with sudo on the System keychain and the
+// admin trust domain, or with -user on the login keychain and the user trust
+// domain, which doesn't need sudo. Callers pass the system arguments ("-d"
+// and systemKeychain), which are dropped or replaced for -user.
+func (m *mkcert) security(args ...string) *exec.Cmd {
+	var cmd []string
+	for _, arg := range args {
+		switch {
+		case arg == "-d" && m.userTrust:
+			continue
+		case arg == systemKeychain && m.userTrust:
+			arg = filepath.Join(os.Getenv("HOME"), "Library", "Keychains", "login.keychain-db")
+		}
+		cmd = append(cmd, arg)
+	}
+	if m.userTrust {
+		return exec.Command("security", cmd...)
+	}
+	return commandWithSudo(append([]string{"security"}, cmd...)...)
+}
+
 // https://github.com/golang/go/issues/24652#issuecomment-399826583
 var trustSettings []interface{}
 var _, _ = plist.Unmarshal(trustSettingsData, &trustSettings)
@@ -50,8 +76,8 @@ var trustSettingsData = []byte(`
 `)
 
 func (m *mkcert) installPlatform() bool {
-	cmd := commandWithSudo("security", "add-trusted-cert", "-d", "-k", "/Library/Keychains/System.keychain", filepath.Join(m.CAROOT, rootName))
-	out, err := cmd.CombinedOutput()
+	cmd := m.security("add-trusted-cert", "-d", "-k", systemKeychain, filepath.Join(m.CAROOT, rootName))
+	out, err := combinedOutput(cmd)
 	fatalIfCmdErr(err, "security add-trusted-cert", out)
 
 	// Make trustSettings explicit, as older Go does not know the defaults.
@@ -61,8 +87,8 @@ func (m *mkcert) installPlatform() bool {
 	fatalIfErr(err, "failed to create temp file")
 	defer os.Remove(plistFile.Name())
 
-	cmd = commandWithSudo("security", "trust-settings-export", "-d", plistFile.Name())
-	out, err = cmd.CombinedOutput()
+	cmd = m.security("trust-settings-export", "-d", plistFile.Name())
+	out, err = combinedOutput(cmd)
 	fatalIfCmdErr(err, "security trust-settings-export", out)
 
 	plistData, err := ioutil.ReadFile(plistFile.Name())
@@ -71,41 +97,41 @@ func (m *mkcert) installPlatform() bool {
 	_, err = plist.Unmarshal(plistData, &plistRoot)
 	fatalIfErr(err, "failed to parse trust settings")
 
-	rootSubjectASN1, _ := asn1.Marshal(m.caCert.Subject.ToRDNSequence())
-
-	if plistRoot["trustVersion"].(uint64) != 1 {
+	if version, ok := plistRoot["trustVersion"].(uint64); !ok || version != 1 {
 		log.Fatalln("ERROR: unsupported trust settings version:", plistRoot["trustVersion"])
 	}
-	trustList := plistRoot["trustList"].(map[string]interface{})
-	for key := range trustList {
-		entry := trustList[key].(map[string]interface{})
-		if _, ok := entry["issuerName"]; !ok {
-			continue
-		}
-		issuerName := entry["issuerName"].([]byte)
-		if !bytes.Equal(rootSubjectASN1, issuerName) {
-			continue
-		}
-		entry["trustSettings"] = trustSettings
-		break
+	// The trust list is keyed by the SHA-1 fingerprint of each certificate,
+	// which unlike the subject is unique to this CA.
+	trustList, _ := plistRoot["trustList"].(map[string]interface{})
+	entry, ok := trustList[fmt.Sprintf("%X", sha1.Sum(m.caCert.Raw))].(map[string]interface{})
+	if !ok {
+		log.Fatalln("ERROR: the local CA is missing from the exported trust settings")
 	}
+	entry["trustSettings"] = trustSettings
 
 	plistData, err = plist.MarshalIndent(plistRoot, plist.XMLFormat, "\t")
 	fatalIfErr(err, "failed to serialize trust settings")
 	err = ioutil.WriteFile(plistFile.Name(), plistData, 0600)
 	fatalIfErr(err, "failed to write trust settings")
 
-	cmd = commandWithSudo("security", "trust-settings-import", "-d", plistFile.Name())
-	out, err = cmd.CombinedOutput()
+	cmd = m.security("trust-settings-import", "-d", plistFile.Name())
+	out, err = combinedOutput(cmd)
 	fatalIfCmdErr(err, "security trust-settings-import", out)
 
 	return true
 }
 
 func (m *mkcert) uninstallPlatform() bool {
-	cmd := commandWithSudo("security", "remove-trusted-cert", "-d", filepath.Join(m.CAROOT, rootName))
-	out, err := cmd.CombinedOutput()
+	cmd := m.security("remove-trusted-cert", "-d", filepath.Join(m.CAROOT, rootName))
+	out, err := combinedOutput(cmd)
 	fatalIfCmdErr(err, "security remove-trusted-cert", out)
 
+	// remove-trusted-cert only removes the trust settings, so also delete the
+	// certificate from the keychain, identified by its SHA-256 fingerprint.
+	cmd = m.security("delete-certificate", "-Z", fmt.Sprintf("%X", sha256.Sum256(m.caCert.Raw)), systemKeychain)
+	if out, err := combinedOutput(cmd); err != nil {
+		verbosef("Failed to delete the local CA from the System keychain: %s\n%s", err, out)
+	}
+
 	return true
 }
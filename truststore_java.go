@@ -54,7 +54,20 @@ func init() {
 	}
 }
 
+// canEditJava reports whether the Java trust store can be updated, either
+// directly or with keytool.
+func canEditJava() bool {
+	if hasKeytool {
+		return true
+	}
+	_, err := readJavaTrustStore(cacertsPath, storePass)
+	return err == nil
+}
+
 func (m *mkcert) checkJava() bool {
+	if store, err := readJavaTrustStore(cacertsPath, storePass); err == nil {
+		return store.contains(m.caCert.Raw)
+	}
 	if !hasKeytool {
 		return false
 	}
@@ -67,7 +80,7 @@ func (m *mkcert) checkJava() bool {
 		return bytes.Contains(keytoolOutput, []byte(fp))
 	}
 
-	keytoolOutput, err := exec.Command(keytoolPath, "-list", "-keystore", cacertsPath, "-storepass", storePass).CombinedOutput()
+	keytoolOutput, err := combinedOutput(exec.Command(keytoolPath, "-list", "-keystore", cacertsPath, "-storepass", storePass))
 	fatalIfCmdErr(err, "keytool -list", keytoolOutput)
 	// keytool outputs SHA1 and SHA256 (Java 9+) certificates in uppercase hex
 	// with each octet pair delimitated by ":". Drop them from the keytool output
@@ -79,6 +92,12 @@ func (m *mkcert) checkJava() bool {
 }
 
 func (m *mkcert) installJava() {
+	if store, err := readJavaTrustStore(cacertsPath, storePass); err == nil {
+		fatalIfErr(store.add(m.caUniqueName(), m.caCert.Raw), "failed to add the CA to Java's trust store")
+		m.saveJavaTrustStore(store)
+		return
+	}
+
 	args := []string{
 		"-importcert", "-noprompt",
 		"-keystore", cacertsPath,
@@ -92,6 +111,13 @@ func (m *mkcert) installJava() {
 }
 
 func (m *mkcert) uninstallJava() {
+	if store, err := readJavaTrustStore(cacertsPath, storePass); err == nil {
+		if store.remove(m.caCert.Raw) {
+			m.saveJavaTrustStore(store)
+		}
+		return
+	}
+
 	args := []string{
 		"-delete",
 		"-alias", m.caUniqueName(),
@@ -105,10 +131,16 @@ func (m *mkcert) uninstallJava() {
 	fatalIfCmdErr(err, "keytool -delete", out)
 }
 
+func (m *mkcert) saveJavaTrustStore(store *javaTrustStore) {
+	data, err := store.marshal(storePass)
+	fatalIfErr(err, "failed to encode Java's trust store")
+	fatalIfErr(writeJavaTrustStore(cacertsPath, data), "failed to save Java's trust store")
+}
+
 // execKeytool will execute a "keytool" command and if needed re-execute
 // the command with commandWithSudo to work around file permissions.
 func execKeytool(cmd *exec.Cmd) ([]byte, error) {
-	out, err := cmd.CombinedOutput()
+	out, err := combinedOutput(cmd)
 	if err != nil && bytes.Contains(out, []byte("java.io.FileNotFoundException")) && runtime.GOOS != "windows" {
 		origArgs := cmd.Args[1:]
 		cmd = commandWithSudo(cmd.Path)
@@ -116,7 +148,7 @@ func execKeytool(cmd *exec.Cmd) ([]byte, error) {
 		cmd.Env = []string{
 			"JAVA_HOME=" + javaHome,
 		}
-		out, err = cmd.CombinedOutput()
+		out, err = combinedOutput(cmd)
 	}
 	return out, err
 }
@@ -16,12 +16,21 @@ import (
 
 var (
 	FirefoxProfiles = []string{os.Getenv("HOME") + "/.mozilla/firefox/*",
-		os.Getenv("HOME") + "/snap/firefox/common/.mozilla/firefox/*"}
-	NSSBrowsers = "Firefox and/or Chrome/Chromium"
+		os.Getenv("HOME") + "/snap/firefox/common/.mozilla/firefox/*",
+		os.Getenv("HOME") + "/.var/app/org.mozilla.firefox/.mozilla/firefox/*"}
+	ThunderbirdProfiles = []string{os.Getenv("HOME") + "/.thunderbird/*",
+		os.Getenv("HOME") + "/snap/thunderbird/common/.thunderbird/*",
+		os.Getenv("HOME") + "/.var/app/org.mozilla.Thunderbird/.thunderbird/*"}
+	NSSBrowsers = "Firefox, Thunderbird and/or Chrome/Chromium"
 
 	SystemTrustFilename string
 	SystemTrustCommand  []string
 	CertutilInstallHelp string
+
+	// useP11Kit is set when the anchors directory is not known, but p11-kit
+	// can store the local CA in its own trust store, which is read by
+	// GnuTLS and by the extracted bundles of the distribution.
+	useP11Kit bool
 )
 
 func init() {
@@ -45,6 +54,8 @@ func init() {
 	} else if pathExists("/usr/share/pki/trust/anchors") {
 		SystemTrustFilename = "/usr/share/pki/trust/anchors/%s.pem"
 		SystemTrustCommand = []string{"update-ca-certificates"}
+	} else if binaryExists("trust") {
+		useP11Kit = true
 	}
 }
 
@@ -53,6 +64,10 @@ func (m *mkcert) systemTrustFilename() string {
 }
 
 func (m *mkcert) installPlatform() bool {
+	if useP11Kit {
+		m.p11KitAnchor("--store")
+		return true
+	}
 	if SystemTrustCommand == nil {
 		log.Printf("Installing to the system store is not yet supported on this Linux 😣 but %s will still work.", NSSBrowsers)
 		log.Printf("You can also manually install the root certificate at %q.", filepath.Join(m.CAROOT, rootName))
@@ -64,36 +79,60 @@ func (m *mkcert) installPlatform() bool {
 
 	cmd := commandWithSudo("tee", m.systemTrustFilename())
 	cmd.Stdin = bytes.NewReader(cert)
-	out, err := cmd.CombinedOutput()
+	out, err := combinedOutput(cmd)
 	fatalIfCmdErr(err, "tee", out)
 
 	cmd = commandWithSudo(SystemTrustCommand...)
-	out, err = cmd.CombinedOutput()
+	out, err = combinedOutput(cmd)
 	fatalIfCmdErr(err, strings.Join(SystemTrustCommand, " "), out)
 
 	return true
 }
 
 func (m *mkcert) uninstallPlatform() bool {
+	if useP11Kit {
+		m.p11KitAnchor("--remove")
+		return true
+	}
 	if SystemTrustCommand == nil {
 		return false
 	}
 
 	cmd := commandWithSudo("rm", "-f", m.systemTrustFilename())
-	out, err := cmd.CombinedOutput()
+	out, err := combinedOutput(cmd)
 	fatalIfCmdErr(err, "rm", out)
 
 	// We used to install under non-unique filenames.
 	legacyFilename := fmt.Sprintf(SystemTrustFilename, "mkcert-rootCA")
 	if pathExists(legacyFilename) {
 		cmd := commandWithSudo("rm", "-f", legacyFilename)
-		out, err := cmd.CombinedOutput()
+		out, err := combinedOutput(cmd)
 		fatalIfCmdErr(err, "rm (legacy filename)", out)
 	}
 
 	cmd = commandWithSudo(SystemTrustCommand...)
-	out, err = cmd.CombinedOutput()
+	out, err = combinedOutput(cmd)
 	fatalIfCmdErr(err, strings.Join(SystemTrustCommand, " "), out)
 
 	return true
 }
+
+// p11KitAnchor adds or removes the local CA from the p11-kit trust store with
+// "trust anchor", and then updates the compatibility bundles.
+func (m *mkcert) p11KitAnchor(op string) {
+	cmd := commandWithSudo("trust", "anchor", op, filepath.Join(m.CAROOT, rootName))
+	out, err := combinedOutput(cmd)
+	if op == "--remove" && err != nil {
+		// trust fails if the certificate is not in the store.
+		verbosef("trust anchor --remove failed: %s\n%s", err, out)
+		return
+	}
+	fatalIfCmdErr(err, "trust anchor "+op, out)
+
+	// Some distributions don't implement extract-compat, and only need the
+	// p11-kit store, so a failure here is not fatal.
+	cmd = commandWithSudo("trust", "extract-compat")
+	if out, err := combinedOutput(cmd); err != nil {
+		verbosef("trust extract-compat failed: %s\n%s", err, out)
+	}
+}
@@ -28,16 +28,26 @@ var (
 		"/usr/bin/firefox-nightly",
 		"/usr/bin/firefox-developer-edition",
 		"/snap/firefox",
+		"/var/lib/flatpak/app/org.mozilla.firefox",
+		filepath.Join(os.Getenv("HOME"), ".local/share/flatpak/app/org.mozilla.firefox"),
 		"/Applications/Firefox.app",
 		"/Applications/FirefoxDeveloperEdition.app",
 		"/Applications/Firefox Developer Edition.app",
 		"/Applications/Firefox Nightly.app",
 		"C:\\Program Files\\Mozilla Firefox",
 	}
+	thunderbirdPaths = []string{
+		"/usr/bin/thunderbird",
+		"/snap/thunderbird",
+		"/var/lib/flatpak/app/org.mozilla.Thunderbird",
+		filepath.Join(os.Getenv("HOME"), ".local/share/flatpak/app/org.mozilla.Thunderbird"),
+		"/Applications/Thunderbird.app",
+		"C:\\Program Files\\Mozilla Thunderbird",
+	}
 )
 
 func init() {
-	allPaths := append(append([]string{}, nssDBs...), firefoxPaths...)
+	allPaths := append(append(append([]string{}, nssDBs...), firefoxPaths...), thunderbirdPaths...)
 	for _, path := range allPaths {
 		if pathExists(path) {
 			hasNSS = true
@@ -63,7 +73,7 @@ func init() {
 			}
 		}
 
-	case "linux":
+	case "linux", "freebsd", "openbsd":
 		if hasCertutil = binaryExists("certutil"); hasCertutil {
 			certutilPath, _ = exec.LookPath("certutil")
 		}
@@ -76,8 +86,7 @@ func (m *mkcert) checkNSS() bool {
 	}
 	success := true
 	if m.forEachNSSProfile(func(profile string) {
-		err := exec.Command(certutilPath, "-V", "-d", profile, "-u", "L", "-n", m.caUniqueName()).Run()
-		if err != nil {
+		if !m.checkNSSProfile(profile) {
 			success = false
 		}
 	}) == 0 {
@@ -86,6 +95,11 @@ func (m *mkcert) checkNSS() bool {
 	return success
 }
 
+func (m *mkcert) checkNSSProfile(profile string) bool {
+	_, err := combinedOutput(exec.Command(certutilPath, "-V", "-d", profile, "-u", "L", "-n", m.caUniqueName()))
+	return err == nil
+}
+
 func (m *mkcert) installNSS() bool {
 	if m.forEachNSSProfile(func(profile string) {
 		cmd := exec.Command(certutilPath, "-A", "-d", profile, "-t", "C,,", "-n", m.caUniqueName(), "-i", filepath.Join(m.CAROOT, rootName))
@@ -105,7 +119,7 @@ func (m *mkcert) installNSS() bool {
 
 func (m *mkcert) uninstallNSS() {
 	m.forEachNSSProfile(func(profile string) {
-		err := exec.Command(certutilPath, "-V", "-d", profile, "-u", "L", "-n", m.caUniqueName()).Run()
+		_, err := combinedOutput(exec.Command(certutilPath, "-V", "-d", profile, "-u", "L", "-n", m.caUniqueName()))
 		if err != nil {
 			return
 		}
@@ -118,12 +132,12 @@ func (m *mkcert) uninstallNSS() {
 // execCertutil will execute a "certutil" command and if needed re-execute
 // the command with commandWithSudo to work around file permissions.
 func execCertutil(cmd *exec.Cmd) ([]byte, error) {
-	out, err := cmd.CombinedOutput()
+	out, err := combinedOutput(cmd)
 	if err != nil && bytes.Contains(out, []byte("SEC_ERROR_READ_ONLY")) && runtime.GOOS != "windows" {
 		origArgs := cmd.Args[1:]
 		cmd = commandWithSudo(cmd.Path)
 		cmd.Args = append(cmd.Args, origArgs...)
-		out, err = cmd.CombinedOutput()
+		out, err = combinedOutput(cmd)
 	}
 	return out, err
 }
@@ -131,7 +145,7 @@ func execCertutil(cmd *exec.Cmd) ([]byte, error) {
 func (m *mkcert) forEachNSSProfile(f func(profile string)) (found int) {
 	var profiles []string
 	profiles = append(profiles, nssDBs...)
-	for _, ff := range FirefoxProfiles {
+	for _, ff := range append(append([]string{}, FirefoxProfiles...), ThunderbirdProfiles...) {
 		pp, _ := filepath.Glob(ff)
 		profiles = append(profiles, pp...)
 	}
@@ -0,0 +1,73 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+)
+
+var hasSimctl = runtime.GOOS == "darwin" && binaryExists("xcrun")
+
+type simulator struct {
+	UDID  string `json:"udid"`
+	Name  string `json:"name"`
+	State string `json:"state"`
+}
+
+// bootedSimulators returns the running iOS simulators. Simulators that are
+// not booted can't be modified, and will need a new -install.
+func bootedSimulators() []simulator {
+	out, err := exec.Command("xcrun", "simctl", "list", "devices", "booted", "--json").Output()
+	if err != nil {
+		verbosef("Failed to list the iOS simulators: %s", err)
+		return nil
+	}
+	var list struct {
+		Devices map[string][]simulator `json:"devices"`
+	}
+	if err := json.Unmarshal(out, &list); err != nil {
+		verbosef("Failed to parse the iOS simulators list: %s", err)
+		return nil
+	}
+	var booted []simulator
+	for _, devices := range list.Devices {
+		for _, d := range devices {
+			if d.State == "Booted" {
+				booted = append(booted, d)
+			}
+		}
+	}
+	return booted
+}
+
+func (m *mkcert) installSimulators() {
+	for _, sim := range bootedSimulators() {
+		out, err := combinedOutput(exec.Command("xcrun", "simctl", "keychain", sim.UDID, "add-root-cert", filepath.Join(m.CAROOT, rootName)))
+		if err != nil {
+			logCode("store_install_failed", "Warning: failed to install the local CA in the iOS simulator %q: %s ⚠️\n\n%s", sim.Name, err, out)
+			storesFailed++
+			continue
+		}
+		logCode("store_installed", "The local CA is now installed in the iOS simulator %q! 📱", sim.Name)
+		storeInstalled()
+	}
+}
+
+// uninstallSimulators resets the keychain of the booted simulators, as
+// simctl can't remove a single certificate.
+func (m *mkcert) uninstallSimulators() {
+	for _, sim := range bootedSimulators() {
+		out, err := combinedOutput(exec.Command("xcrun", "simctl", "keychain", sim.UDID, "reset"))
+		if err != nil {
+			logCode("store_uninstall_failed", "Warning: failed to reset the keychain of the iOS simulator %q: %s ⚠️\n\n%s", sim.Name, err, out)
+			continue
+		}
+		log.Printf("The keychain of the iOS simulator %q was reset, removing the local CA and any other added certificate! 👋", sim.Name)
+	}
+}
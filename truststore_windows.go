@@ -12,24 +12,16 @@ import (
 	"math/big"
 	"os"
 	"path/filepath"
-	"syscall"
 	"unsafe"
+
+	"golang.org/x/sys/windows"
 )
 
 var (
 	FirefoxProfiles     = []string{os.Getenv("USERPROFILE") + "\\AppData\\Roaming\\Mozilla\\Firefox\\Profiles"}
+	ThunderbirdProfiles = []string{os.Getenv("USERPROFILE") + "\\AppData\\Roaming\\Thunderbird\\Profiles\\*"}
 	CertutilInstallHelp = "" // certutil unsupported on Windows
-	NSSBrowsers         = "Firefox"
-)
-
-var (
-	modcrypt32                           = syscall.NewLazyDLL("crypt32.dll")
-	procCertAddEncodedCertificateToStore = modcrypt32.NewProc("CertAddEncodedCertificateToStore")
-	procCertCloseStore                   = modcrypt32.NewProc("CertCloseStore")
-	procCertDeleteCertificateFromStore   = modcrypt32.NewProc("CertDeleteCertificateFromStore")
-	procCertDuplicateCertificateContext  = modcrypt32.NewProc("CertDuplicateCertificateContext")
-	procCertEnumCertificatesInStore      = modcrypt32.NewProc("CertEnumCertificatesInStore")
-	procCertOpenSystemStoreW             = modcrypt32.NewProc("CertOpenSystemStoreW")
+	NSSBrowsers         = "Firefox and/or Thunderbird"
 )
 
 func (m *mkcert) installPlatform() bool {
@@ -66,69 +58,72 @@ func (m *mkcert) uninstallPlatform() bool {
 	return true
 }
 
-type windowsRootStore uintptr
+// windowsRootStore is a handle to the current user's Root system store, which
+// Windows uses as the source of trusted roots along with the machine one.
+type windowsRootStore windows.Handle
 
 func openWindowsRootStore() (windowsRootStore, error) {
-	rootStr, err := syscall.UTF16PtrFromString("ROOT")
+	name, err := windows.UTF16PtrFromString("ROOT")
 	if err != nil {
 		return 0, err
 	}
-	store, _, err := procCertOpenSystemStoreW.Call(0, uintptr(unsafe.Pointer(rootStr)))
-	if store != 0 {
-		return windowsRootStore(store), nil
+	store, err := windows.CertOpenStore(windows.CERT_STORE_PROV_SYSTEM_W, 0, 0,
+		windows.CERT_SYSTEM_STORE_CURRENT_USER, uintptr(unsafe.Pointer(name)))
+	if err != nil {
+		return 0, fmt.Errorf("failed to open windows root store: %w", err)
 	}
-	return 0, fmt.Errorf("failed to open windows root store: %v", err)
+	return windowsRootStore(store), nil
 }
 
 func (w windowsRootStore) close() error {
-	ret, _, err := procCertCloseStore.Call(uintptr(w), 0)
-	if ret != 0 {
-		return nil
+	if err := windows.CertCloseStore(windows.Handle(w), 0); err != nil {
+		return fmt.Errorf("failed to close windows root store: %w", err)
 	}
-	return fmt.Errorf("failed to close windows root store: %v", err)
+	return nil
 }
 
 func (w windowsRootStore) addCert(cert []byte) error {
+	ctx, err := windows.CertCreateCertificateContext(
+		windows.X509_ASN_ENCODING|windows.PKCS_7_ASN_ENCODING, &cert[0], uint32(len(cert)))
+	if err != nil {
+		return fmt.Errorf("failed parsing cert: %w", err)
+	}
+	defer windows.CertFreeCertificateContext(ctx)
 	// TODO: ok to always overwrite?
-	ret, _, err := procCertAddEncodedCertificateToStore.Call(
-		uintptr(w), // HCERTSTORE hCertStore
-		uintptr(syscall.X509_ASN_ENCODING|syscall.PKCS_7_ASN_ENCODING), // DWORD dwCertEncodingType
-		uintptr(unsafe.Pointer(&cert[0])),                              // const BYTE *pbCertEncoded
-		uintptr(len(cert)),                                             // DWORD cbCertEncoded
-		3,                                                              // DWORD dwAddDisposition (CERT_STORE_ADD_REPLACE_EXISTING is 3)
-		0,                                                              // PCCERT_CONTEXT *ppCertContext
-	)
-	if ret != 0 {
-		return nil
+	err = windows.CertAddCertificateContextToStore(windows.Handle(w), ctx,
+		windows.CERT_STORE_ADD_REPLACE_EXISTING, nil)
+	if err != nil {
+		return fmt.Errorf("failed adding cert: %w", err)
 	}
-	return fmt.Errorf("failed adding cert: %v", err)
+	return nil
 }
 
 func (w windowsRootStore) deleteCertsWithSerial(serial *big.Int) (bool, error) {
 	// Go over each, deleting the ones we find
-	var cert *syscall.CertContext
+	var cert *windows.CertContext
 	deletedAny := false
 	for {
 		// Next enum
-		certPtr, _, err := procCertEnumCertificatesInStore.Call(uintptr(w), uintptr(unsafe.Pointer(cert)))
-		if cert = (*syscall.CertContext)(unsafe.Pointer(certPtr)); cert == nil {
-			if errno, ok := err.(syscall.Errno); ok && errno == 0x80092004 {
+		var err error
+		cert, err = windows.CertEnumCertificatesInStore(windows.Handle(w), cert)
+		if cert == nil {
+			if errno, ok := err.(windows.Errno); ok && errno == windows.Errno(windows.CRYPT_E_NOT_FOUND) {
 				break
 			}
-			return deletedAny, fmt.Errorf("failed enumerating certs: %v", err)
+			return deletedAny, fmt.Errorf("failed enumerating certs: %w", err)
 		}
 		// Parse cert
-		certBytes := (*[1 << 20]byte)(unsafe.Pointer(cert.EncodedCert))[:cert.Length]
+		certBytes := unsafe.Slice(cert.EncodedCert, cert.Length)
 		parsedCert, err := x509.ParseCertificate(certBytes)
 		// We'll just ignore parse failures for now
 		if err == nil && parsedCert.SerialNumber != nil && parsedCert.SerialNumber.Cmp(serial) == 0 {
 			// Duplicate the context so it doesn't stop the enum when we delete it
-			dupCertPtr, _, err := procCertDuplicateCertificateContext.Call(uintptr(unsafe.Pointer(cert)))
-			if dupCertPtr == 0 {
-				return deletedAny, fmt.Errorf("failed duplicating context: %v", err)
+			dup := windows.CertDuplicateCertificateContext(cert)
+			if dup == nil {
+				return deletedAny, fmt.Errorf("failed duplicating context")
 			}
-			if ret, _, err := procCertDeleteCertificateFromStore.Call(dupCertPtr); ret == 0 {
-				return deletedAny, fmt.Errorf("failed deleting certificate: %v", err)
+			if err := windows.CertDeleteCertificateFromStore(dup); err != nil {
+				return deletedAny, fmt.Errorf("failed deleting certificate: %w", err)
 			}
 			deletedAny = true
 		}
@@ -0,0 +1,72 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// hasWSL is true when running inside the Windows Subsystem for Linux, with
+// interop enabled so that Windows binaries like certutil.exe can be run.
+var hasWSL = isWSL() && binaryExists("certutil.exe") && binaryExists("wslpath")
+
+func isWSL() bool {
+	if pathExists("/proc/sys/fs/binfmt_misc/WSLInterop") {
+		return true
+	}
+	version, err := ioutil.ReadFile("/proc/version")
+	return err == nil && strings.Contains(strings.ToLower(string(version)), "microsoft")
+}
+
+// windowsSerial is how certutil.exe identifies the local CA in a store.
+func (m *mkcert) windowsSerial() string {
+	return m.caCert.SerialNumber.Text(16)
+}
+
+func (m *mkcert) checkWSL() bool {
+	_, err := combinedOutput(exec.Command("certutil.exe", "-user", "-verifystore", "Root", m.windowsSerial()))
+	return err == nil
+}
+
+// installWSL adds the local CA to the Windows current user root store, which
+// is used by Chrome, Edge, and Firefox running on the Windows side.
+func (m *mkcert) installWSL() {
+	if m.checkWSL() {
+		logCode("store_already_installed", "The local CA is already installed in the Windows trust store! 👍")
+		storeInstalled()
+		return
+	}
+	out, err := exec.Command("wslpath", "-w", filepath.Join(m.CAROOT, rootName)).Output()
+	if err != nil {
+		logCode("store_install_failed", "Warning: failed to locate the local CA from Windows: %s ⚠️", err)
+		storesFailed++
+		return
+	}
+	log.Print("Note: Windows will ask to confirm the installation of the local CA ℹ️")
+	cmd := exec.Command("certutil.exe", "-user", "-addstore", "Root", strings.TrimSpace(string(out)))
+	if out, err := combinedOutput(cmd); err != nil {
+		logCode("store_install_failed", "Warning: failed to install the local CA in the Windows trust store: %s ⚠️\n\n%s", err, out)
+		storesFailed++
+		return
+	}
+	logCode("store_installed", "The local CA is now installed in the Windows trust store (requires browser restart)! 🪟")
+	storeInstalled()
+}
+
+func (m *mkcert) uninstallWSL() {
+	if !m.checkWSL() {
+		return
+	}
+	cmd := exec.Command("certutil.exe", "-user", "-delstore", "Root", m.windowsSerial())
+	if out, err := combinedOutput(cmd); err != nil {
+		logCode("store_uninstall_failed", "Warning: failed to uninstall the local CA from the Windows trust store: %s ⚠️\n\n%s", err, out)
+		return
+	}
+	logCode("store_uninstalled", "The local CA is now uninstalled from the Windows trust store! 👋")
+}
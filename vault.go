@@ -0,0 +1,172 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// A CAROOT like "vault://secret/mkcert" keeps the CA certificate and key in
+// the Vault KV version 2 secret "mkcert" of the "secret" mount, instead of
+// on disk. The server and token are the standard VAULT_ADDR and VAULT_TOKEN
+// (or ~/.vault-token). The rest of the CAROOT files, like the index, are
+// kept in a local cache directory.
+const vaultScheme = "vault://"
+
+// vaultCacheDir returns the local directory for the CAROOT files other than
+// the CA key, for the Vault secret at vaultPath.
+func vaultCacheDir(vaultPath string) (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "mkcert", "vault", filepath.FromSlash(vaultPath)), nil
+}
+
+type vaultSecret struct {
+	Certificate string `json:"certificate"`
+	PrivateKey  string `json:"private_key"`
+}
+
+// vaultRequest performs a request against the Vault KV v2 API for the secret
+// at m.vaultPath, returning nil for a missing secret.
+func (m *mkcert) vaultRequest(method string, body interface{}) ([]byte, error) {
+	addr := strings.TrimSuffix(os.Getenv("VAULT_ADDR"), "/")
+	if addr == "" {
+		return nil, errors.New("VAULT_ADDR is not set")
+	}
+	token := os.Getenv("VAULT_TOKEN")
+	if token == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			data, _ := ioutil.ReadFile(filepath.Join(home, ".vault-token"))
+			token = strings.TrimSpace(string(data))
+		}
+	}
+	if token == "" {
+		return nil, errors.New("VAULT_TOKEN is not set, and there is no ~/.vault-token")
+	}
+
+	mount, secret, ok := strings.Cut(m.vaultPath, "/")
+	if !ok || mount == "" || secret == "" {
+		return nil, fmt.Errorf("%q is not in the vault://MOUNT/PATH format", vaultScheme+m.vaultPath)
+	}
+	url := addr + "/v1/" + path.Join(mount, "data", secret)
+
+	var reqBody []byte
+	if body != nil {
+		var err error
+		if reqBody, err = json.Marshal(body); err != nil {
+			return nil, err
+		}
+	}
+	req, err := http.NewRequest(method, url, bytes.NewReader(reqBody))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	if ns := os.Getenv("VAULT_NAMESPACE"); ns != "" {
+		req.Header.Set("X-Vault-Namespace", ns)
+	}
+
+	client := http.DefaultClient
+	if caFile := os.Getenv("VAULT_CACERT"); caFile != "" {
+		caPEM, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		pool.AppendCertsFromPEM(caPEM)
+		client = &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}
+	}
+
+	verbosef("Requesting %s %s", method, url)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound && method == http.MethodGet {
+		return nil, nil
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("%s %s: %s: %s", method, url, resp.Status, bytes.TrimSpace(respBody))
+	}
+	return respBody, nil
+}
+
+// loadVaultCA loads the CA from Vault, or creates a new one and stores it
+// there. The certificate is also cached in the CAROOT, for the operations
+// that need it on disk, like -install.
+func (m *mkcert) loadVaultCA() {
+	body, err := m.vaultRequest(http.MethodGet, nil)
+	fatalIfErr(err, "failed to read the CA from Vault")
+	if body == nil {
+		m.newCA()
+		body, err = m.vaultRequest(http.MethodGet, nil)
+		fatalIfErr(err, "failed to read the CA from Vault")
+		if body == nil {
+			log.Fatalln("ERROR: the CA saved to Vault can't be read back")
+		}
+	} else if len(m.rootDomains) > 0 || len(m.rootRanges) > 0 || len(m.rootExts) > 0 {
+		log.Fatalf("ERROR: -root-constrain, -root-ext and -root-policy only apply to new CAs, and there is already one in %q", vaultScheme+m.vaultPath)
+	}
+
+	var resp struct {
+		Data struct {
+			Data vaultSecret `json:"data"`
+		} `json:"data"`
+	}
+	fatalIfErr(json.Unmarshal(body, &resp), "failed to parse the Vault response")
+	secret := resp.Data.Data
+
+	certDERBlock, _ := pem.Decode([]byte(secret.Certificate))
+	if certDERBlock == nil || certDERBlock.Type != "CERTIFICATE" {
+		log.Fatalln("ERROR: failed to read the CA certificate from Vault: unexpected content")
+	}
+	m.caCert, err = x509.ParseCertificate(certDERBlock.Bytes)
+	fatalIfErr(err, "failed to parse the CA certificate")
+
+	certFile := filepath.Join(m.CAROOT, rootName)
+	if cached, err := ioutil.ReadFile(certFile); err != nil || !bytes.Equal(cached, []byte(secret.Certificate)) {
+		verbosef("Writing %s", certFile)
+		fatalIfErr(writeFileAtomic(certFile, []byte(secret.Certificate), 0644), "failed to cache the CA certificate")
+	}
+
+	if secret.PrivateKey == "" {
+		return // keyless mode, where only -install works
+	}
+	keyDERBlock, _ := pem.Decode([]byte(secret.PrivateKey))
+	if keyDERBlock == nil || keyDERBlock.Type != "PRIVATE KEY" {
+		log.Fatalln("ERROR: failed to read the CA key from Vault: unexpected content")
+	}
+	m.caKey, err = x509.ParsePKCS8PrivateKey(keyDERBlock.Bytes)
+	fatalIfErr(err, "failed to parse the CA key")
+}
+
+func (m *mkcert) saveVaultCA(certPEM, keyPEM []byte) {
+	_, err := m.vaultRequest(http.MethodPost, map[string]interface{}{
+		"data": vaultSecret{Certificate: string(certPEM), PrivateKey: string(keyPEM)},
+		// Never overwrite an existing CA.
+		"options": map[string]interface{}{"cas": 0},
+	})
+	fatalIfErr(err, "failed to save the CA to Vault")
+}
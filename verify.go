@@ -0,0 +1,109 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+)
+
+// verifyServer connects to a TLS server, and checks that the certificate it
+// presents chains to the local CA, covers the host name, and is not expired.
+func (m *mkcert) verifyServer() {
+	addr := m.verifyAddr
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(strings.Trim(addr, "[]"), "443")
+	}
+	host, _, err := net.SplitHostPort(addr)
+	fatalIfErr(err, "invalid -verify address")
+
+	dialer := &tls.Dialer{
+		NetDialer: &net.Dialer{Timeout: 10 * time.Second},
+		Config: &tls.Config{
+			ServerName: host,
+			// The chain is checked below against the local CA only.
+			InsecureSkipVerify: true,
+		},
+	}
+	conn, err := dialer.Dial("tcp", addr)
+	fatalIfErr(err, "failed to connect to "+addr)
+	state := conn.(*tls.Conn).ConnectionState()
+	conn.Close()
+	leaf := state.PeerCertificates[0]
+
+	fmt.Printf("Connected to %s\n\n", addr)
+	fmt.Printf("Subject:     %s\n", leaf.Subject)
+	fmt.Printf("Issuer:      %s\n", leaf.Issuer)
+	fmt.Printf("Names:       %s\n\n", strings.Join(certificateHosts(leaf), ", "))
+
+	c := &checker{}
+	m.checkLeaf(c, leaf, state.PeerCertificates[1:], []string{host}, x509.ExtKeyUsageServerAuth)
+	if len(state.PeerCertificates) == 1 && m.interCert != nil && leaf.CheckSignatureFrom(m.interCert) == nil {
+		c.check(false, "The server doesn't send the intermediate CA, so clients can't build the chain")
+	}
+
+	if c.failed {
+		log.Fatalln("\nERROR: the server at", addr, "is not correctly set up with a certificate from the local CA")
+	}
+	log.Printf("\nThe server at %s is correctly set up with a certificate from the local CA 🎉\n\n", addr)
+}
+
+// checker prints the results of a series of checks, and records failures.
+type checker struct {
+	failed bool
+}
+
+func (c *checker) check(passed bool, format string, args ...interface{}) {
+	if passed {
+		fmt.Printf(emoji("✅", "[OK]")+" "+format+"\n", args...)
+	} else {
+		fmt.Printf(emoji("❌", "[FAIL]")+" "+format+"\n", args...)
+		c.failed = true
+	}
+}
+
+// checkLeaf checks that leaf chains to the local CA through intermediates for
+// usage, is valid for names, and is currently valid.
+func (m *mkcert) checkLeaf(c *checker, leaf *x509.Certificate, intermediates []*x509.Certificate, names []string, usage x509.ExtKeyUsage) {
+	roots := x509.NewCertPool()
+	roots.AddCert(m.caCert)
+	pool := x509.NewCertPool()
+	for _, cert := range intermediates {
+		pool.AddCert(cert)
+	}
+	_, err := leaf.Verify(x509.VerifyOptions{
+		Roots: roots, Intermediates: pool,
+		// The expiration is reported separately.
+		CurrentTime: leaf.NotBefore.Add(time.Second),
+		KeyUsages:   []x509.ExtKeyUsage{usage},
+	})
+	if err != nil {
+		c.check(false, "The certificate doesn't chain to the local CA: %s", err)
+	} else {
+		c.check(true, "The certificate chains to the local CA")
+	}
+
+	for _, name := range names {
+		if err := leaf.VerifyHostname(name); err != nil {
+			c.check(false, "The certificate is not valid for %q", name)
+		} else {
+			c.check(true, "The certificate is valid for %q", name)
+		}
+	}
+
+	switch now := time.Now(); {
+	case now.Before(leaf.NotBefore):
+		c.check(false, "The certificate is not valid before %s", leaf.NotBefore.Local().Format("2 January 2006 15:04:05 MST"))
+	case now.After(leaf.NotAfter):
+		c.check(false, "The certificate expired on %s", leaf.NotAfter.Local().Format("2 January 2006"))
+	default:
+		c.check(true, "The certificate is valid until %s (%s)", leaf.NotAfter.Local().Format("2 January 2006"), expiryDescription(leaf.NotAfter))
+	}
+}
@@ -0,0 +1,248 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+const watchInterval = time.Hour
+
+// watch runs forever, renewing the certificates in the index (or only the
+// ones at paths, if any) when they get close to expiring.
+func (m *mkcert) watch(paths []string) {
+	if _, key := m.issuer(); key == nil {
+		fatalCode(exitFailure, "ca_key_missing", "ERROR: can't renew certificates because the CA key (rootCA-key.pem) is missing")
+	}
+	log.Printf("Watching the certificates issued by the local CA, they will be renewed %d days before they expire 👀", m.renewDays)
+	for {
+		m.renewExpiring(paths)
+		time.Sleep(watchInterval)
+	}
+}
+
+func (m *mkcert) renewExpiring(paths []string) {
+	renewBefore := time.Duration(m.renewDays) * 24 * time.Hour
+	var renewed bool
+	for _, c := range m.currentCerts(paths) {
+		if time.Until(c.NotAfter) > renewBefore {
+			continue
+		}
+		log.Printf("Renewing the certificate for %q, which expires on %s 🔄", c.Hosts, c.NotAfter.Local().Format("2 January 2006"))
+		renewed = m.reissue(c) || renewed
+	}
+	if renewed && m.hook != "" {
+		m.runHook()
+	}
+}
+
+// reissueCerts issues again all the current certificates in the index (or only
+// the ones at paths, if any), for example after -rotate-root.
+func (m *mkcert) reissueCerts(paths []string) {
+	if _, key := m.issuer(); key == nil {
+		fatalCode(exitFailure, "ca_key_missing", "ERROR: can't reissue certificates because the CA key (rootCA-key.pem) is missing")
+	}
+	certs := m.currentCerts(paths)
+	if len(certs) == 0 {
+		log.Printf("There are no current certificates in the index of the local CA at %q to reissue.", m.CAROOT)
+		return
+	}
+	var reissued int
+	for _, c := range certs {
+		log.Printf("Reissuing the certificate for %q 🔄", c.Hosts)
+		if m.reissue(c) {
+			reissued++
+		}
+	}
+	if reissued > 0 && m.hook != "" {
+		m.runHook()
+	}
+	if reissued < len(certs) {
+		log.Fatalf("ERROR: reissued %d of the %d certificates, see the warnings above", reissued, len(certs))
+	}
+	log.Printf("Reissued %d certificates ✅", len(certs))
+}
+
+// currentCerts returns the index entries that are not revoked and whose
+// files still contain the certificate they were issued as, optionally
+// limited to the ones saved at paths.
+func (m *mkcert) currentCerts(paths []string) []issuedCert {
+	wanted := make(map[string]bool)
+	for _, p := range paths {
+		wanted[absPath(p)] = false
+	}
+
+	var current []issuedCert
+	for _, c := range m.loadIndex() {
+		path := c.CertFile
+		if c.PKCS12 {
+			path = c.P12File
+		}
+		if c.RevokedAt != nil || path == "" {
+			continue
+		}
+		if c.issuedForCSR() && c.CSRFile == "" {
+			log.Printf("Warning: the certificate at %q was issued for a CSR read from stdin, so it can't be renewed ⚠️", path)
+			continue
+		}
+		if _, ok := wanted[path]; len(paths) > 0 && !ok {
+			continue
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			verbosef("Skipping %s: %s", path, err)
+			continue
+		}
+		certs, err := parseCertificates(data)
+		if err != nil {
+			verbosef("Skipping %s: %s", path, err)
+			continue
+		}
+		if serialString(certs[0]) != c.Serial {
+			continue // replaced by a newer certificate
+		}
+		wanted[path] = true
+		current = append(current, c)
+	}
+
+	for p, found := range wanted {
+		if !found {
+			log.Printf("Warning: %q does not contain a certificate from the local CA index, so it can't be renewed ⚠️", p)
+		}
+	}
+	return current
+}
+
+// reissue issues a new certificate with the same names, options and output
+// paths as c, and reports whether it did. Failures are only warnings, so
+// that -watch keeps running and renews the other certificates.
+func (m *mkcert) reissue(c issuedCert) bool {
+	encrypted := c.KeyEncrypted || isEncryptedKeyFile(c.KeyFile)
+	if encrypted && m.keyPassword == "" {
+		log.Printf("Warning: the key of the certificate for %q is encrypted, so it can only be renewed with -key-pass ⚠️", c.Hosts)
+		return false
+	}
+	if c.issuedForCSR() && !pathExists(c.CSRFile) {
+		log.Printf("Warning: the CSR %q for %q is gone, so the certificate can't be renewed ⚠️", c.CSRFile, c.Hosts)
+		return false
+	}
+	err := catchFatal(func() error {
+		r := *m
+		r.certFile, r.keyFile, r.p12File = c.CertFile, c.KeyFile, c.P12File
+		r.client, r.ecdsa, r.pkcs12, r.p7b = c.Client, c.ECDSA, c.PKCS12, c.P7B
+		r.p12Chain, r.p12Name, r.profile = c.P12Chain, c.P12Name, c.Profile
+		if r.p12Chain == "" {
+			r.p12Chain = "full"
+		}
+		r.reuseKey = r.reuseKey || c.ReuseKey
+		r.force = true
+		r.allowPublic = true // the names were accepted when first issued
+		if !encrypted {
+			r.keyPassword = ""
+		}
+
+		r.validity, r.validUntil = 0, time.Time{}
+		if c.Validity != "" {
+			validity, err := time.ParseDuration(c.Validity)
+			if err != nil {
+				return fmt.Errorf("invalid validity in the certificate index: %w", err)
+			}
+			r.validity = validity
+		}
+		r.sigAlg, r.keyUsage, r.upns, r.leafExts = c.SigAlg, c.KeyUsage, c.UPNs, c.Extensions
+		r.extKeyUsage, r.unknownExtKeyUsage = c.ExtKeyUsage, c.UnknownExtKeyUsage
+		r.subjectCN, r.subjectOrg, r.subjectOU, r.subjectCountry = c.SubjectCN, c.SubjectOrg, c.SubjectOU, c.SubjectCountry
+		r.ocspURL, r.crlURL, r.issuerURL = c.OCSPURL, c.CRLURL, c.IssuerURL
+		if m.interPath == "" && c.Intermediate != "" {
+			// Unless -use-inter picks a new one.
+			r.interPath, r.interCert, r.interKey = c.Intermediate, nil, nil
+			r.loadIntermediate()
+		}
+
+		if c.issuedForCSR() {
+			r.csrPath = c.CSRFile
+			return r.issueCertFromCSR()
+		}
+		return r.issueCert(c.Hosts)
+	})
+	if err != nil {
+		log.Printf("Warning: failed to renew the certificate for %q: %s ⚠️", c.Hosts, err)
+		return false
+	}
+	return true
+}
+
+// isEncryptedKeyFile reports whether path is a PEM file with an encrypted
+// key, for the index entries from before KeyEncrypted was recorded.
+func isEncryptedKeyFile(path string) bool {
+	if path == "" {
+		return false
+	}
+	data, err := ioutil.ReadFile(path)
+	return err == nil && bytes.Contains(data, []byte("ENCRYPTED PRIVATE KEY-----"))
+}
+
+// issuedForCSR reports whether c was issued for a CSR rather than for a key
+// generated by mkcert. Older index entries only tell by the missing key.
+func (c issuedCert) issuedForCSR() bool {
+	return c.FromCSR || c.CSRFile != "" || !c.PKCS12 && c.KeyFile == ""
+}
+
+// catchFatal runs f, and returns its error, or the one that would have made
+// mkcert exit in the helpers that still log fatal errors. Those are the log
+// lines starting with "ERROR", like logFilter checks, which are not printed,
+// and f is interrupted by a panic before log.Fatal or exitf can exit. Other
+// panics are not recovered.
+func catchFatal(f func() error) (err error) {
+	w := log.Writer()
+	log.SetOutput(fatalCatcher{w})
+	defer func() {
+		log.SetOutput(w)
+		if r := recover(); r != nil {
+			fatal, ok := r.(caughtFatal)
+			if !ok {
+				panic(r)
+			}
+			err = errors.New(string(fatal))
+		}
+	}()
+	return f()
+}
+
+type caughtFatal string
+
+type fatalCatcher struct{ w io.Writer }
+
+func (c fatalCatcher) Write(p []byte) (int, error) {
+	msg := strings.TrimSpace(string(p))
+	if strings.HasPrefix(msg, "ERROR") {
+		panic(caughtFatal(strings.TrimPrefix(strings.TrimPrefix(msg, "ERROR"), ": ")))
+	}
+	return c.w.Write(p)
+}
+
+func (m *mkcert) runHook() {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", m.hook)
+	} else {
+		cmd = exec.Command("sh", "-c", m.hook)
+	}
+	out, err := combinedOutput(cmd)
+	if err != nil {
+		log.Printf("Warning: the renewal hook %q failed: %s ⚠️\n\n%s", m.hook, err, out)
+		return
+	}
+	log.Printf("Ran the renewal hook %q ✅", m.hook)
+}
@@ -0,0 +1,220 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// wizard asks questions on standard error and reads the answers from
+// standard input, one per line.
+type wizard struct {
+	in *bufio.Reader
+}
+
+// ask returns the answer to question, or def if it's empty.
+func (w *wizard) ask(question, def string) string {
+	if def != "" {
+		fmt.Fprintf(os.Stderr, "%s [%s]: ", question, def)
+	} else {
+		fmt.Fprintf(os.Stderr, "%s: ", question)
+	}
+	line, err := w.in.ReadString('\n')
+	if err == io.EOF && line == "" {
+		fmt.Fprintln(os.Stderr)
+		log.Fatalln("ERROR: the wizard was interrupted, nothing was created")
+	}
+	if err != nil && err != io.EOF {
+		fatalIfErr(err, "failed to read the answer")
+	}
+	if line = strings.TrimSpace(line); line != "" {
+		return line
+	}
+	return def
+}
+
+// confirm asks a yes or no question.
+func (w *wizard) confirm(question string, def bool) bool {
+	hint := "y/N"
+	if def {
+		hint = "Y/n"
+	}
+	for {
+		switch strings.ToLower(w.ask(question+" ("+hint+")", "")) {
+		case "":
+			return def
+		case "y", "yes":
+			return true
+		case "n", "no":
+			return false
+		}
+		fmt.Fprintln(os.Stderr, "Please answer yes or no.")
+	}
+}
+
+// choose asks to pick one of options by number, and returns its index.
+func (w *wizard) choose(question string, options []string) int {
+	fmt.Fprintf(os.Stderr, "%s\n", question)
+	for i, o := range options {
+		fmt.Fprintf(os.Stderr, "  %d) %s\n", i+1, o)
+	}
+	for {
+		n, err := strconv.Atoi(w.ask("Choice", "1"))
+		if err == nil && n >= 1 && n <= len(options) {
+			return n - 1
+		}
+		fmt.Fprintf(os.Stderr, "Please enter a number between 1 and %d.\n", len(options))
+	}
+}
+
+// runWizard walks through the options for a new certificate, shows what
+// will be created, and then creates it.
+func (m *mkcert) runWizard() {
+	w := &wizard{in: bufio.NewReader(os.Stdin)}
+	log.Printf("This wizard will create a locally-trusted certificate. Press Enter to accept the [defaults] 🧙\n\n")
+
+	newCA := m.vaultPath == "" && !pathExists(filepath.Join(m.CAROOT, rootName))
+	installed := false
+	if !newCA {
+		m.loadCA()
+		m.loadIntermediate()
+		installed = (!storeEnabled("system") || m.checkPlatform()) &&
+			(!storeEnabled("nss") || !hasNSS || CertutilInstallHelp == "" || m.checkNSS()) &&
+			(!storeEnabled("java") || !hasJava || m.checkJava())
+	}
+
+	var hosts []string
+	for len(hosts) == 0 {
+		answer := w.ask("Names for the certificate, separated by spaces", "localhost 127.0.0.1 ::1")
+		for _, name := range strings.Fields(strings.Replace(answer, ",", " ", -1)) {
+			host, err := normalizeHost(name)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Invalid name: %s.\n", err)
+				hosts = nil
+				break
+			}
+			hosts = append(hosts, host)
+		}
+		hosts = validateHosts(hosts)
+		if public := publicDomains(hosts); len(public) > 0 && !m.allowPublic {
+			fmt.Fprintf(os.Stderr, "Public domains like %s will only be trusted where the local CA is installed, use .test, .localhost or .internal for development.\n",
+				strings.Join(quoteAll(public), ", "))
+			if !w.confirm("Use them anyway?", false) {
+				hosts = nil
+			}
+		}
+	}
+	fmt.Fprintln(os.Stderr)
+
+	keyType := w.choose("Key type:", []string{
+		"RSA 2048 (the most compatible)",
+		"ECDSA P-256 (smaller and faster)",
+	})
+	m.ecdsa = keyType == 1
+	fmt.Fprintln(os.Stderr)
+
+	for {
+		answer := w.ask("Validity, as a number of days, a duration like \"72h\", or a date like \"2030-01-31\"", "2 years and 3 months")
+		if answer == "2 years and 3 months" {
+			break
+		}
+		if days, err := strconv.Atoi(strings.TrimSuffix(answer, "d")); err == nil && days > 0 {
+			m.validity = time.Duration(days) * 24 * time.Hour
+			break
+		}
+		if d, err := time.ParseDuration(answer); err == nil && d >= time.Minute {
+			m.validity = d
+			break
+		}
+		if t, err := parseTime(answer); err == nil && t.After(time.Now()) {
+			if !strings.Contains(answer, "T") {
+				t = t.AddDate(0, 0, 1).Add(-time.Second)
+			}
+			m.validUntil = t
+			break
+		}
+		fmt.Fprintln(os.Stderr, "Please enter a positive number of days, a duration of at least one minute, or a future date.")
+	}
+	fmt.Fprintln(os.Stderr)
+
+	certFile, keyFile, _ := m.fileNames(hosts)
+	m.certFile = w.ask("Certificate file", certFile)
+	m.keyFile = w.ask("Key file", keyFile)
+	if m.certFile == m.keyFile {
+		log.Fatalln("ERROR: the certificate and key files must be different")
+	}
+	for _, path := range []string{m.certFile, m.keyFile} {
+		if pathExists(path) && !m.force {
+			m.force = w.confirm(fmt.Sprintf("%q already exists, overwrite it?", path), false)
+			if !m.force {
+				log.Fatalln("ERROR: nothing was created, run the wizard again to choose other files")
+			}
+		}
+	}
+	fmt.Fprintln(os.Stderr)
+
+	install := false
+	anyStore := storeEnabled("system") || storeEnabled("nss") || storeEnabled("java")
+	if !installed && anyStore {
+		install = w.confirm("Install the local CA in the trust stores, so that browsers and tools trust the certificate? This might ask for your password", true)
+		fmt.Fprintln(os.Stderr)
+	}
+
+	log.Println("mkcert will:")
+	if newCA {
+		log.Printf(" - create a new local CA in %q", m.CAROOT)
+	}
+	if install {
+		log.Println(" - install the local CA in the trust stores")
+	}
+	expiration := time.Now().AddDate(2, 3, 0)
+	if m.validity != 0 {
+		expiration = time.Now().Add(m.validity)
+	}
+	if !m.validUntil.IsZero() {
+		expiration = m.validUntil
+	}
+	keyDescription := "RSA 2048"
+	if m.ecdsa {
+		keyDescription = "ECDSA P-256"
+	}
+	log.Printf(" - generate a certificate with an %s key for %s, valid until %s",
+		keyDescription, strings.Join(quoteAll(hosts), ", "), expiration.Format("2 January 2006"))
+	log.Printf(" - save the certificate at %q and the key at %q", m.certFile, m.keyFile)
+	if !install && !installed && anyStore {
+		log.Println("The local CA will not be installed, so the certificate will only be trusted where you install it manually.")
+	}
+	fmt.Fprintln(os.Stderr)
+	if !w.confirm("Proceed?", true) {
+		log.Fatalln("ERROR: nothing was created")
+	}
+	fmt.Fprintln(os.Stderr)
+
+	if newCA {
+		m.loadCA()
+		m.loadIntermediate()
+	}
+	if install {
+		m.install()
+	}
+	m.makeCert(hosts)
+}
+
+// quoteAll quotes each of s like %q.
+func quoteAll(s []string) []string {
+	var quoted []string
+	for _, v := range s {
+		quoted = append(quoted, strconv.Quote(v))
+	}
+	return quoted
+}
@@ -0,0 +1,195 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// With -yubikey, the CA key is generated in (or moved to) a PIV slot of a
+// YubiKey with ykman, and then used like any other PKCS#11 key, through
+// Yubico's libykcs11 module if installed, or the OpenSC one otherwise. Both
+// map the PIV slots to the same key ids.
+
+var yubikeySlotIDs = map[string]byte{"9a": 1, "9c": 2, "9d": 3, "9e": 4}
+
+func init() {
+	for i := 0; i < 20; i++ {
+		yubikeySlotIDs[fmt.Sprintf("%x", 0x82+i)] = byte(5 + i) // the retired key slots
+	}
+}
+
+var ykcs11Modules = map[string][]string{
+	"linux": {"/usr/lib/x86_64-linux-gnu/libykcs11.so", "/usr/lib/aarch64-linux-gnu/libykcs11.so",
+		"/usr/lib64/libykcs11.so", "/usr/lib/libykcs11.so", "/usr/local/lib/libykcs11.so"},
+	"darwin":  {"/opt/homebrew/lib/libykcs11.dylib", "/usr/local/lib/libykcs11.dylib"},
+	"windows": {`C:\Program Files\Yubico\Yubico PIV Tool\bin\libykcs11.dll`},
+}
+
+// yubikeyURI returns the PKCS#11 URI of the key in slot.
+func yubikeyURI(slot string) (string, error) {
+	id, ok := yubikeySlotIDs[strings.ToLower(slot)]
+	if !ok {
+		return "", fmt.Errorf("%q is not a PIV slot, like 9a, 9c, 9d, 9e or 82 to 95", slot)
+	}
+	uri := fmt.Sprintf("%sid=%%%02x", pkcs11Scheme, id)
+	for _, module := range ykcs11Modules[runtime.GOOS] {
+		if pathExists(module) {
+			uri += "?module-path=" + url.PathEscape(module)
+			break
+		}
+	}
+	return uri, nil
+}
+
+// ykman runs a ykman command, which might ask for the management key or
+// the PIN, and for a touch.
+func ykman(args ...string) error {
+	verbosef("Running ykman %s", strings.Join(args, " "))
+	cmd := exec.Command("ykman", args...)
+	out := &bytes.Buffer{}
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, out, os.Stderr
+	if err := cmd.Run(); err != nil {
+		if errors.Is(err, exec.ErrNotFound) {
+			return errors.New("ykman is not installed, install the YubiKey Manager CLI to use -yubikey")
+		}
+		return fmt.Errorf("ykman failed: %s\n\n%s", err, bytes.TrimSpace(out.Bytes()))
+	}
+	return nil
+}
+
+// policyArgs returns the ykman arguments for the -pin-policy and
+// -touch-policy options.
+func (m *mkcert) policyArgs() []string {
+	var args []string
+	if m.pinPolicy != "" {
+		args = append(args, "--pin-policy", strings.ToUpper(m.pinPolicy))
+	}
+	if m.touchPolicy != "" {
+		args = append(args, "--touch-policy", strings.ToUpper(m.touchPolicy))
+	}
+	return args
+}
+
+// setupYubiKey makes sure the local CA key is in the -yubikey slot, by
+// creating a new local CA with the key generated there, or by moving the
+// key of the existing one there.
+func (m *mkcert) setupYubiKey() {
+	uri, _ := ioutil.ReadFile(filepath.Join(m.CAROOT, rootKeyURIName))
+	switch {
+	case !pathExists(filepath.Join(m.CAROOT, rootName)):
+		m.loadCA()
+	case strings.TrimSpace(string(uri)) == m.caKeyURI:
+		// Already set up.
+	case pathExists(filepath.Join(m.CAROOT, rootKeyName)):
+		m.moveKeyToYubiKey()
+	default:
+		log.Fatalln("ERROR: the local CA key is not in the CAROOT, so it can't be moved to the YubiKey")
+	}
+}
+
+// newYubiKeyKey generates the key of a new CA in the -yubikey slot, as an
+// ECDSA P-256 key with -ecdsa and as an RSA 2048 key otherwise.
+func (m *mkcert) newYubiKeyKey() (crypto.Signer, error) {
+	dir, err := ioutil.TempDir("", "mkcert-yubikey")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	pubFile := filepath.Join(dir, "pub.pem")
+	algorithm := "RSA2048"
+	if m.ecdsa {
+		algorithm = "ECCP256"
+	}
+	log.Printf("Generating the CA key in the YubiKey slot %s, touch it if it blinks 👆", m.yubikeySlot)
+	args := append([]string{"piv", "keys", "generate", "--algorithm", algorithm}, m.policyArgs()...)
+	if err := ykman(append(args, m.yubikeySlot, pubFile)...); err != nil {
+		return nil, err
+	}
+	pubPEM, err := ioutil.ReadFile(pubFile)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(pubPEM)
+	if block == nil {
+		return nil, errors.New("ykman returned an invalid public key")
+	}
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	return openPKCS11Key(m.caKeyURI, pub)
+}
+
+// importYubiKeyCert stores the CA certificate in the -yubikey slot, where
+// PKCS#11 modules and other tools expect it next to the key.
+func (m *mkcert) importYubiKeyCert() {
+	fatalIfErr(ykman("piv", "certificates", "import", m.yubikeySlot, filepath.Join(m.CAROOT, rootName)),
+		"failed to import the CA certificate into the YubiKey")
+}
+
+// moveKeyToYubiKey imports the CA key into the -yubikey slot, checks that
+// it can sign there, and then deletes it from the CAROOT.
+func (m *mkcert) moveKeyToYubiKey() {
+	m.loadCA()
+	defer m.lockCAROOT()()
+	keyFile := filepath.Join(m.CAROOT, rootKeyName)
+
+	log.Printf("Moving the CA key to the YubiKey slot %s 🔑", m.yubikeySlot)
+	args := append([]string{"piv", "keys", "import"}, m.policyArgs()...)
+	fatalIfErr(ykman(append(args, m.yubikeySlot, keyFile)...), "failed to import the CA key into the YubiKey")
+	m.importYubiKeyCert()
+
+	log.Printf("Checking that the YubiKey can sign with it, touch it if it blinks 👆")
+	signer, err := openPKCS11Key(m.caKeyURI, m.caCert.PublicKey)
+	fatalIfErr(err, "failed to open the YubiKey key")
+	digest := make([]byte, sha256.Size)
+	rand.Read(digest)
+	sig, err := signer.Sign(rand.Reader, digest, crypto.SHA256)
+	fatalIfErr(err, "failed to sign with the YubiKey")
+	switch pub := m.caCert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		err = rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest, sig)
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(pub, digest, sig) {
+			err = errors.New("invalid signature")
+		}
+	}
+	fatalIfErr(err, "the YubiKey key doesn't match the CA certificate, the CA key was not deleted")
+
+	err = writeFileAtomic(filepath.Join(m.CAROOT, rootKeyURIName), []byte(m.caKeyURI+"\n"), 0600)
+	fatalIfErr(err, "failed to save the CA key URI")
+	fatalIfErr(os.Remove(keyFile), "failed to delete the CA key")
+	m.caKey = signer
+	log.Printf("The CA key is now only in the YubiKey, and was deleted from the CAROOT ✅\n\n")
+}
+
+// validPolicy reports whether value is valid for -pin-policy or
+// -touch-policy, with policies being the values ykman accepts.
+func validPolicy(value string, policies ...string) bool {
+	for _, p := range policies {
+		if strings.EqualFold(value, p) {
+			return true
+		}
+	}
+	return value == ""
+}